@@ -0,0 +1,267 @@
+// Package blockcache provides the caches of the blockstore of the node: a
+// bloom filter sized from the number of blocks, and a cache of block data.
+package blockcache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	bloom "github.com/ipfs/bbloom"
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	ipld "github.com/ipfs/go-ipld-format"
+	logging "github.com/ipfs/go-log"
+)
+
+var log = logging.Logger("blockcache")
+
+const (
+	// bits of the filter for every key it may hold, about 1% of false
+	// positives with 7 hashes
+	bloomBitsPerKey = 10
+	bloomHashes     = 7
+	// keys the filter may hold for every key counted, the repo grows until
+	// the next rebuild
+	bloomGrowth  = 2
+	bloomMinBits = 1 << 16
+)
+
+// BloomStat is the state of a bloom filter.
+type BloomStat struct {
+	// Active is false until the filter is first built.
+	Active bool
+	// Size is the size of the filter in bytes.
+	Size uint64
+	// Keys is the number of blocks when the filter was last built.
+	Keys uint64
+	// Builds is the number of times the filter was built.
+	Builds uint64
+	// Requests is the number of blocks looked up, Negatives the ones found
+	// missing without reading the datastore.
+	Requests  uint64
+	Negatives uint64
+}
+
+// Bloom is a blockstore answering that blocks are missing without reading
+// the datastore, using a bloom filter. The filter is sized from the number of
+// blocks when built, at startup, and built again every period as the repo
+// grows and blocks are removed.
+type Bloom struct {
+	blockstore.Blockstore
+	viewer blockstore.Viewer
+	period time.Duration
+
+	lk sync.RWMutex
+	// nil until first built
+	filter *bloom.Bloom
+	// the filter being built, keys put meanwhile are added to it
+	building *bloom.Bloom
+	bits     uint64
+	keys     uint64
+	builds   uint64
+
+	requests  uint64
+	negatives uint64
+}
+
+var _ blockstore.Blockstore = (*Bloom)(nil)
+var _ blockstore.Viewer = (*Bloom)(nil)
+
+// NewBloom returns a bloom filter in front of bs. The filter is built in the
+// background until ctx is done, every period if it is positive.
+func NewBloom(ctx context.Context, bs blockstore.Blockstore, period time.Duration) *Bloom {
+	b := &Bloom{
+		Blockstore: bs,
+		period:     period,
+	}
+	if v, ok := bs.(blockstore.Viewer); ok {
+		b.viewer = v
+	}
+	go b.run(ctx)
+	return b
+}
+
+func (b *Bloom) run(ctx context.Context) {
+	for {
+		if err := b.build(ctx); err != nil && ctx.Err() == nil {
+			log.Errorf("cannot build the bloom filter: %s", err)
+		}
+		if b.period <= 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(b.period):
+		}
+	}
+}
+
+// bloomBits returns the size in bits of a filter for n keys.
+func bloomBits(n uint64) uint64 {
+	want := n * bloomGrowth * bloomBitsPerKey
+	bits := uint64(bloomMinBits)
+	for bits < want {
+		bits <<= 1
+	}
+	return bits
+}
+
+// build counts the keys, then builds a filter of the right size. The current
+// filter is used meanwhile.
+func (b *Bloom) build(ctx context.Context) error {
+	keys, err := b.Blockstore.AllKeysChan(ctx)
+	if err != nil {
+		return err
+	}
+	var n uint64
+	for range keys {
+		n++
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	bits := bloomBits(n)
+	filter, err := bloom.New(float64(bits), bloomHashes)
+	if err != nil {
+		return err
+	}
+	b.lk.Lock()
+	b.building = filter
+	b.lk.Unlock()
+	defer func() {
+		b.lk.Lock()
+		b.building = nil
+		b.lk.Unlock()
+	}()
+
+	// blocks put once the keys are listed are added by add
+	keys, err = b.Blockstore.AllKeysChan(ctx)
+	if err != nil {
+		return err
+	}
+	for k := range keys {
+		filter.AddTS(k.Hash())
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	b.lk.Lock()
+	b.filter = filter
+	b.bits = bits
+	b.keys = n
+	b.builds++
+	b.lk.Unlock()
+	log.Infof("bloom filter of %s built for %d blocks", humanize.IBytes(bits/8), n)
+	return nil
+}
+
+// missing returns true if k is not in the blockstore, false if it may be.
+func (b *Bloom) missing(k cid.Cid) bool {
+	atomic.AddUint64(&b.requests, 1)
+	if !k.Defined() {
+		return false
+	}
+	b.lk.RLock()
+	filter := b.filter
+	b.lk.RUnlock()
+	if filter == nil || filter.HasTS(k.Hash()) {
+		return false
+	}
+	atomic.AddUint64(&b.negatives, 1)
+	return true
+}
+
+// add adds the key of a block written to the blockstore.
+func (b *Bloom) add(k cid.Cid) {
+	b.lk.RLock()
+	defer b.lk.RUnlock()
+	if b.filter != nil {
+		b.filter.AddTS(k.Hash())
+	}
+	if b.building != nil {
+		b.building.AddTS(k.Hash())
+	}
+}
+
+// Stat returns the state of the filter.
+func (b *Bloom) Stat() BloomStat {
+	b.lk.RLock()
+	defer b.lk.RUnlock()
+	return BloomStat{
+		Active:    b.filter != nil,
+		Size:      b.bits / 8,
+		Keys:      b.keys,
+		Builds:    b.builds,
+		Requests:  atomic.LoadUint64(&b.requests),
+		Negatives: atomic.LoadUint64(&b.negatives),
+	}
+}
+
+func (b *Bloom) Has(ctx context.Context, k cid.Cid) (bool, error) {
+	if b.missing(k) {
+		return false, nil
+	}
+	return b.Blockstore.Has(ctx, k)
+}
+
+func (b *Bloom) Get(ctx context.Context, k cid.Cid) (blocks.Block, error) {
+	if b.missing(k) {
+		return nil, ipld.ErrNotFound{Cid: k}
+	}
+	return b.Blockstore.Get(ctx, k)
+}
+
+func (b *Bloom) GetSize(ctx context.Context, k cid.Cid) (int, error) {
+	if b.missing(k) {
+		return -1, ipld.ErrNotFound{Cid: k}
+	}
+	return b.Blockstore.GetSize(ctx, k)
+}
+
+func (b *Bloom) View(ctx context.Context, k cid.Cid, callback func([]byte) error) error {
+	if b.missing(k) {
+		return ipld.ErrNotFound{Cid: k}
+	}
+	if b.viewer == nil {
+		blk, err := b.Blockstore.Get(ctx, k)
+		if err != nil {
+			return err
+		}
+		return callback(blk.RawData())
+	}
+	return b.viewer.View(ctx, k, callback)
+}
+
+func (b *Bloom) DeleteBlock(ctx context.Context, k cid.Cid) error {
+	if b.missing(k) {
+		return nil
+	}
+	return b.Blockstore.DeleteBlock(ctx, k)
+}
+
+// Put adds the key to the filter once the block is written, the filter must
+// not tell a block is missing while it is.
+func (b *Bloom) Put(ctx context.Context, blk blocks.Block) error {
+	if err := b.Blockstore.Put(ctx, blk); err != nil {
+		return err
+	}
+	b.add(blk.Cid())
+	return nil
+}
+
+func (b *Bloom) PutMany(ctx context.Context, blks []blocks.Block) error {
+	if err := b.Blockstore.PutMany(ctx, blks); err != nil {
+		return err
+	}
+	for _, blk := range blks {
+		b.add(blk.Cid())
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package blockcache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/stretchr/testify/require"
+)
+
+func newBlockstore(t *testing.T, n int) (blockstore.Blockstore, []blocks.Block) {
+	ctx := context.Background()
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	var blks []blocks.Block
+	for i := 0; i < n; i++ {
+		blk := blocks.NewBlock([]byte(fmt.Sprintf("block %d", i)))
+		require.NoError(t, bs.Put(ctx, blk))
+		blks = append(blks, blk)
+	}
+	return bs, blks
+}
+
+func TestBloomBits(t *testing.T) {
+	require.Equal(t, uint64(bloomMinBits), bloomBits(0))
+	require.Equal(t, uint64(32<<20), bloomBits(1_000_000))
+}
+
+func TestBloom(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bs, blks := newBlockstore(t, 3)
+
+	b := NewBloom(ctx, bs, 0)
+	require.Eventually(t, func() bool { return b.Stat().Active }, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, BloomStat{Active: true, Size: bloomMinBits / 8, Keys: 3, Builds: 1}, b.Stat())
+
+	missing := blocks.NewBlock([]byte("missing"))
+	has, err := b.Has(ctx, missing.Cid())
+	require.NoError(t, err)
+	require.False(t, has)
+	_, err = b.Get(ctx, missing.Cid())
+	require.True(t, ipld.IsNotFound(err))
+	require.Equal(t, uint64(2), b.Stat().Negatives)
+
+	for _, blk := range blks {
+		has, err := b.Has(ctx, blk.Cid())
+		require.NoError(t, err)
+		require.True(t, has)
+	}
+
+	require.NoError(t, b.Put(ctx, missing))
+	has, err = b.Has(ctx, missing.Cid())
+	require.NoError(t, err)
+	require.True(t, has)
+
+	// a rebuild drops the removed blocks
+	require.NoError(t, b.DeleteBlock(ctx, blks[0].Cid()))
+	require.NoError(t, b.build(ctx))
+	stat := b.Stat()
+	require.Equal(t, uint64(3), stat.Keys)
+	require.Equal(t, uint64(2), stat.Builds)
+	require.Equal(t, uint64(7), stat.Requests)
+}
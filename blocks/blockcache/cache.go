@@ -0,0 +1,216 @@
+package blockcache
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"sync"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+)
+
+// CacheStat is the state of a cache of block data.
+type CacheStat struct {
+	// Hits and Misses count the blocks read, from the cache or not.
+	Hits   uint64
+	Misses uint64
+	// Blocks and Size are the blocks in the cache.
+	Blocks  uint64
+	Size    uint64
+	MaxSize uint64
+}
+
+type cacheEntry struct {
+	hash string
+	data []byte
+}
+
+// Cache is a blockstore keeping the data of the last blocks read in memory,
+// up to a total size. Blocks are keyed by multihash, as in the blockstore.
+// Blocks written are not cached: adding data does not flush the blocks read
+// often. With HashOnRead, the blocks read from the cache are hashed again.
+type Cache struct {
+	blockstore.Blockstore
+	viewer  blockstore.Viewer
+	maxSize uint64
+
+	lk     sync.Mutex
+	rehash bool
+	lru    *list.List // of *cacheEntry, least recently used first
+	index  map[string]*list.Element
+	size   uint64
+
+	hits   uint64
+	misses uint64
+}
+
+var _ blockstore.Blockstore = (*Cache)(nil)
+var _ blockstore.Viewer = (*Cache)(nil)
+
+// NewCache returns a cache of maxSize bytes in front of bs.
+func NewCache(bs blockstore.Blockstore, maxSize uint64) *Cache {
+	c := &Cache{
+		Blockstore: bs,
+		maxSize:    maxSize,
+		lru:        list.New(),
+		index:      make(map[string]*list.Element),
+	}
+	if v, ok := bs.(blockstore.Viewer); ok {
+		c.viewer = v
+	}
+	return c
+}
+
+// get returns the data of k, if cached. With HashOnRead, data not matching
+// k is evicted.
+func (c *Cache) get(k cid.Cid) ([]byte, bool) {
+	c.lk.Lock()
+	el, ok := c.index[string(k.Hash())]
+	if !ok {
+		c.misses++
+		c.lk.Unlock()
+		return nil, false
+	}
+	c.lru.MoveToBack(el)
+	data := el.Value.(*cacheEntry).data
+	rehash := c.rehash
+	c.lk.Unlock()
+
+	if rehash && !validData(k, data) {
+		c.lk.Lock()
+		defer c.lk.Unlock()
+		// the entry may have been evicted meanwhile
+		if el, ok := c.index[string(k.Hash())]; ok {
+			c.remove(el)
+		}
+		c.misses++
+		return nil, false
+	}
+	c.lk.Lock()
+	c.hits++
+	c.lk.Unlock()
+	return data, true
+}
+
+// validData returns false if data does not hash to k.
+func validData(k cid.Cid, data []byte) bool {
+	chk, err := k.Prefix().Sum(data)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(chk.Hash(), k.Hash())
+}
+
+// add caches data, the least recently used blocks are evicted. Blocks larger
+// than an eighth of the cache are not cached, so that a single block does
+// not flush it.
+func (c *Cache) add(k cid.Cid, data []byte) {
+	if uint64(len(data)) > c.maxSize/8 {
+		return
+	}
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	h := string(k.Hash())
+	if _, ok := c.index[h]; ok {
+		return
+	}
+	c.index[h] = c.lru.PushBack(&cacheEntry{hash: h, data: data})
+	c.size += uint64(len(data))
+	for c.size > c.maxSize {
+		c.remove(c.lru.Front())
+	}
+}
+
+func (c *Cache) remove(el *list.Element) {
+	e := el.Value.(*cacheEntry)
+	c.lru.Remove(el)
+	delete(c.index, e.hash)
+	c.size -= uint64(len(e.data))
+}
+
+// Stat returns the state of the cache.
+func (c *Cache) Stat() CacheStat {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	return CacheStat{
+		Hits:    c.hits,
+		Misses:  c.misses,
+		Blocks:  uint64(c.lru.Len()),
+		Size:    c.size,
+		MaxSize: c.maxSize,
+	}
+}
+
+// HashOnRead sets whether the blocks read are hashed again, from the cache or
+// from the blockstore.
+func (c *Cache) HashOnRead(enabled bool) {
+	c.lk.Lock()
+	c.rehash = enabled
+	c.lk.Unlock()
+	c.Blockstore.HashOnRead(enabled)
+}
+
+func (c *Cache) Get(ctx context.Context, k cid.Cid) (blocks.Block, error) {
+	if data, ok := c.get(k); ok {
+		return blocks.NewBlockWithCid(data, k)
+	}
+	blk, err := c.Blockstore.Get(ctx, k)
+	if err != nil {
+		return nil, err
+	}
+	c.add(k, blk.RawData())
+	return blk, nil
+}
+
+func (c *Cache) View(ctx context.Context, k cid.Cid, callback func([]byte) error) error {
+	if data, ok := c.get(k); ok {
+		return callback(data)
+	}
+	if c.viewer == nil {
+		blk, err := c.Blockstore.Get(ctx, k)
+		if err != nil {
+			return err
+		}
+		c.add(k, blk.RawData())
+		return callback(blk.RawData())
+	}
+	return c.viewer.View(ctx, k, func(data []byte) error {
+		// data is only valid during the callback
+		c.add(k, append([]byte(nil), data...))
+		return callback(data)
+	})
+}
+
+func (c *Cache) Has(ctx context.Context, k cid.Cid) (bool, error) {
+	c.lk.Lock()
+	_, ok := c.index[string(k.Hash())]
+	c.lk.Unlock()
+	if ok {
+		return true, nil
+	}
+	return c.Blockstore.Has(ctx, k)
+}
+
+func (c *Cache) GetSize(ctx context.Context, k cid.Cid) (int, error) {
+	c.lk.Lock()
+	el, ok := c.index[string(k.Hash())]
+	c.lk.Unlock()
+	if ok {
+		return len(el.Value.(*cacheEntry).data), nil
+	}
+	return c.Blockstore.GetSize(ctx, k)
+}
+
+func (c *Cache) DeleteBlock(ctx context.Context, k cid.Cid) error {
+	if err := c.Blockstore.DeleteBlock(ctx, k); err != nil {
+		return err
+	}
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	if el, ok := c.index[string(k.Hash())]; ok {
+		c.remove(el)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package blockcache
+
+import (
+	"context"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCache(t *testing.T) {
+	ctx := context.Background()
+	bs, blks := newBlockstore(t, 10)
+	// "block N" is 7 bytes long, the cache holds 8 blocks
+	c := NewCache(bs, 56)
+
+	for _, blk := range blks {
+		got, err := c.Get(ctx, blk.Cid())
+		require.NoError(t, err)
+		require.Equal(t, blk.RawData(), got.RawData())
+	}
+	require.Equal(t, CacheStat{Misses: 10, Blocks: 8, Size: 56, MaxSize: 56}, c.Stat())
+
+	// the first blocks were evicted
+	_, err := c.Get(ctx, blks[0].Cid())
+	require.NoError(t, err)
+	require.Equal(t, uint64(11), c.Stat().Misses)
+
+	// blocks are cached by multihash, and returned with the CID asked for
+	last := blks[len(blks)-1]
+	v1 := cid.NewCidV1(cid.DagProtobuf, last.Cid().Hash())
+	got, err := c.Get(ctx, v1)
+	require.NoError(t, err)
+	require.Equal(t, v1, got.Cid())
+	err = c.View(ctx, last.Cid(), func(data []byte) error {
+		require.Equal(t, last.RawData(), data)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), c.Stat().Hits)
+
+	require.NoError(t, c.DeleteBlock(ctx, last.Cid()))
+	_, err = c.Get(ctx, last.Cid())
+	require.True(t, ipld.IsNotFound(err))
+
+	// blocks too large for the cache are not cached
+	large := blocks.NewBlock(make([]byte, 8))
+	require.NoError(t, c.Put(ctx, large))
+	_, err = c.Get(ctx, large.Cid())
+	require.NoError(t, err)
+	_, err = c.Get(ctx, large.Cid())
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), c.Stat().Hits)
+}
+
+func TestCacheHashOnRead(t *testing.T) {
+	ctx := context.Background()
+	bs, blks := newBlockstore(t, 1)
+	c := NewCache(bs, 56)
+	blk := blks[0]
+	_, err := c.Get(ctx, blk.Cid())
+	require.NoError(t, err)
+
+	// corrupted in memory
+	c.index[string(blk.Cid().Hash())].Value.(*cacheEntry).data = []byte("corrupt")
+	c.HashOnRead(true)
+	got, err := c.Get(ctx, blk.Cid())
+	require.NoError(t, err)
+	require.Equal(t, blk.RawData(), got.RawData())
+	require.Equal(t, CacheStat{Misses: 2, Blocks: 1, Size: 7, MaxSize: 56}, c.Stat())
+	err = c.View(ctx, blk.Cid(), func(data []byte) error {
+		require.Equal(t, blk.RawData(), data)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), c.Stat().Hits)
+}
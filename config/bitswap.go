@@ -0,0 +1,46 @@
+package config
+
+import "github.com/libp2p/go-libp2p-core/peer"
+
+// Bitswap configures the bitswap block exchange.
+type Bitswap struct {
+	// ServerEnabled controls whether blocks are served to other peers.
+	ServerEnabled Flag `json:",omitempty"`
+
+	// ClientEnabled controls whether missing blocks are fetched from other
+	// peers.
+	ClientEnabled Flag `json:",omitempty"`
+
+	// MaxOutstandingBytesPerPeer is the amount of data queued for a single
+	// peer before other peers are served, e.g. "1MiB". Zero disables the
+	// limit.
+	MaxOutstandingBytesPerPeer *OptionalString `json:",omitempty"`
+
+	// MaxWantlistSize is the number of blocks a single peer may ask for at
+	// once, further wants are ignored.
+	MaxWantlistSize *OptionalInteger `json:",omitempty"`
+
+	// ServeAllow, when not empty, only serves blocks to the listed peers.
+	ServeAllow []peer.ID `json:",omitempty"`
+
+	// ServeDeny never serves blocks to the listed peers.
+	ServeDeny []peer.ID `json:",omitempty"`
+
+	// PeerRateLimits throttles how fast a single peer is served.
+	PeerRateLimits BitswapPeerRateLimits
+}
+
+// BitswapPeerRateLimits throttles how fast blocks are served to a single
+// peer.
+type BitswapPeerRateLimits struct {
+	// BytesPerSecond is the bandwidth blocks are sent to a peer with, e.g.
+	// "10MiB".
+	BytesPerSecond *OptionalString `json:",omitempty"`
+
+	// WantsPerSecond is the number of new wants accepted from a peer every
+	// second.
+	WantsPerSecond *OptionalInteger `json:",omitempty"`
+
+	// Reciprocity raises the limits of peers that sent us data.
+	Reciprocity Flag `json:",omitempty"`
+}
@@ -14,22 +14,25 @@ import (
 
 // Config is used to load ipfs config files.
 type Config struct {
-	Identity  Identity  // local node's peer identity
-	Datastore Datastore // local node's storage
-	Addresses Addresses // local node's addresses
-	Mounts    Mounts    // local node's mount points
-	Discovery Discovery // local node's discovery mechanisms
-	Routing   Routing   // local node's routing settings
-	Ipns      Ipns      // Ipns settings
-	Bootstrap []string  // local nodes's bootstrap peer addresses
-	Gateway   Gateway   // local node's gateway server options
-	API       API       // local node's API settings
-	Swarm     SwarmConfig
-	AutoNAT   AutoNATConfig
-	Pubsub    PubsubConfig
-	Peering   Peering
-	DNS       DNS
-	Migration Migration
+	Identity      Identity  // local node's peer identity
+	Datastore     Datastore // local node's storage
+	Addresses     Addresses // local node's addresses
+	Mounts        Mounts    // local node's mount points
+	Discovery     Discovery // local node's discovery mechanisms
+	Routing       Routing   // local node's routing settings
+	Ipns          Ipns      // Ipns settings
+	Bootstrap     []string  // local nodes's bootstrap peer addresses
+	Gateway       Gateway   // local node's gateway server options
+	API           API       // local node's API settings
+	Swarm         SwarmConfig
+	Bitswap       Bitswap
+	HTTPRetrieval HTTPRetrieval
+	AutoNAT       AutoNATConfig
+	Pubsub        PubsubConfig
+	Peering       Peering
+	P2P           P2P
+	DNS           DNS
+	Migration     Migration
 
 	Provider     Provider
 	Reprovider   Reprovider
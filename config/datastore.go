@@ -23,6 +23,26 @@ type Datastore struct {
 
 	HashOnRead      bool
 	BloomFilterSize int
+
+	// BloomFilterAutoSize sizes the bloom filter of the blockstore from the
+	// number of blocks, instead of BloomFilterSize.
+	BloomFilterAutoSize Flag `json:",omitempty"`
+
+	// BloomFilterRebuildPeriod is the time between two rebuilds of a bloom
+	// filter sized from the number of blocks. Zero never rebuilds it.
+	BloomFilterRebuildPeriod *OptionalDuration `json:",omitempty"`
+
+	// BlockCacheSize is the size of the cache of block data in memory, e.g.
+	// "256MiB". Zero disables it.
+	BlockCacheSize *OptionalString `json:",omitempty"`
+
+	// ScrubInterval is the time between two checks of all the blocks by the
+	// daemon. Unset or zero disables the scrubber.
+	ScrubInterval *OptionalDuration `json:",omitempty"`
+
+	// ScrubRate is the amount of block data checked every second, e.g.
+	// "1MiB".
+	ScrubRate *OptionalString `json:",omitempty"`
 }
 
 // DataStorePath returns the default data store path given a configuration root
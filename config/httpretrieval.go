@@ -0,0 +1,21 @@
+package config
+
+// HTTPRetrieval configures fetching blocks from trustless HTTP gateways, in
+// addition to bitswap.
+type HTTPRetrieval struct {
+	// Gateways are the base URLs of the trustless gateways blocks are
+	// fetched from, e.g. "https://trustless-gateway.example.com". Fetching
+	// from gateways is disabled when empty.
+	Gateways []string `json:",omitempty"`
+
+	// FallbackDelay is how long bitswap is given to find a block before the
+	// gateways are asked. Zero races both.
+	FallbackDelay *OptionalDuration `json:",omitempty"`
+
+	// Timeout is how long a single gateway request may take.
+	Timeout *OptionalDuration `json:",omitempty"`
+
+	// MaxConcurrentRequests limits the gateway requests made at once for a
+	// single fetch.
+	MaxConcurrentRequests *OptionalInteger `json:",omitempty"`
+}
@@ -131,11 +131,12 @@ func addressesConfig() Addresses {
 // DefaultDatastoreConfig is an internal function exported to aid in testing.
 func DefaultDatastoreConfig() Datastore {
 	return Datastore{
-		StorageMax:         "10GB",
-		StorageGCWatermark: 90, // 90%
-		GCPeriod:           "1h",
-		BloomFilterSize:    0,
-		Spec:               flatfsSpec(),
+		StorageMax:          "10GB",
+		StorageGCWatermark:  90, // 90%
+		GCPeriod:            "1h",
+		BloomFilterSize:     0,
+		BloomFilterAutoSize: True,
+		Spec:                flatfsSpec(),
 	}
 }
 
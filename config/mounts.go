@@ -4,5 +4,16 @@ package config
 type Mounts struct {
 	IPFS           string
 	IPNS           string
+	MFS            string
 	FuseAllowOther bool
+
+	// ReadAhead is the number of blocks prefetched ahead of sequential reads
+	// of files on the IPFS mount.
+	ReadAhead *OptionalInteger `json:",omitempty"`
+	// CacheTTL is how long the attributes and the directory entries of the
+	// IPFS mount are cached by the kernel.
+	CacheTTL *OptionalDuration `json:",omitempty"`
+	// CacheSize is the number of nodes the IPFS mount caches the attributes,
+	// lookups and directory entries of.
+	CacheSize *OptionalInteger `json:",omitempty"`
 }
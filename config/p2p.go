@@ -0,0 +1,50 @@
+package config
+
+import "github.com/libp2p/go-libp2p-core/peer"
+
+// P2P configures the libp2p stream forwarding of 'ipfs p2p'.
+type P2P struct {
+	// ACLs are named access lists 'ipfs p2p listen --acl' can restrict
+	// incoming streams with.
+	ACLs map[string]P2PACL `json:",omitempty"`
+
+	// Listeners are the libp2p services registered when the daemon starts,
+	// as with 'ipfs p2p listen'.
+	Listeners []P2PListener `json:",omitempty"`
+
+	// Forwards are the local listeners forwarding to remote services
+	// registered when the daemon starts, as with 'ipfs p2p forward'.
+	Forwards []P2PForward `json:",omitempty"`
+}
+
+// P2PACL restricts the peers allowed to open streams to a p2p listener.
+type P2PACL struct {
+	// Allow, when not empty, only allows the listed peers.
+	Allow []peer.ID `json:",omitempty"`
+
+	// Deny rejects the listed peers, even if they are also allowed.
+	Deny []peer.ID `json:",omitempty"`
+}
+
+// P2PListener is a persisted 'ipfs p2p listen'.
+type P2PListener struct {
+	Protocol      string
+	TargetAddress string
+
+	AllowCustomProtocol bool `json:",omitempty"`
+	ReportPeerID        bool `json:",omitempty"`
+
+	// ACL names an access list in P2P.ACLs. Allow and Deny are merged into it.
+	ACL   string    `json:",omitempty"`
+	Allow []peer.ID `json:",omitempty"`
+	Deny  []peer.ID `json:",omitempty"`
+}
+
+// P2PForward is a persisted 'ipfs p2p forward'.
+type P2PForward struct {
+	Protocol      string
+	ListenAddress string
+	TargetAddress string
+
+	AllowCustomProtocol bool `json:",omitempty"`
+}
@@ -6,4 +6,12 @@ import "github.com/libp2p/go-libp2p-core/peer"
 type Peering struct {
 	// Peers lists the nodes to attempt to stay connected with.
 	Peers []peer.AddrInfo
+
+	// Groups lists sources of peers to stay connected with, resolved
+	// periodically: /dnsaddr/<domain> multiaddrs, or /ipns/ paths of JSON
+	// lists formatted like Peers.
+	Groups []string `json:",omitempty"`
+
+	// GroupsInterval is how often Groups are resolved again.
+	GroupsInterval *OptionalDuration `json:",omitempty"`
 }
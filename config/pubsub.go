@@ -11,4 +11,38 @@ type PubsubConfig struct {
 
 	// Enable pubsub (--enable-pubsub-experiment)
 	Enabled Flag `json:",omitempty"`
+
+	// Subscriptions lists the topics the daemon subscribes to on startup.
+	// Messages received on these topics are recorded in the repo and can be
+	// replayed with 'ipfs pubsub sub --since'.
+	Subscriptions []string `json:",omitempty"`
+
+	// HistorySize is the number of messages kept per managed subscription.
+	HistorySize *OptionalInteger `json:",omitempty"`
+
+	// TopicPolicies maps topic names to the policy messages on that topic
+	// must satisfy before being delivered or forwarded.
+	TopicPolicies map[string]PubsubTopicPolicy `json:",omitempty"`
+}
+
+// PubsubTopicPolicy restricts the messages accepted on a pubsub topic.
+type PubsubTopicPolicy struct {
+	// RequireSigning rejects unsigned messages. When signing is disabled,
+	// setting it on any topic makes the node sign its messages on all
+	// topics, since the signature policy applies to the whole router.
+	RequireSigning Flag `json:",omitempty"`
+
+	// AllowedPublishers, when not empty, only accepts messages authored by
+	// the listed peer IDs.
+	AllowedPublishers []string `json:",omitempty"`
+
+	// MaxMessageSize rejects messages with a larger payload (e.g. "64KiB").
+	MaxMessageSize *OptionalString `json:",omitempty"`
+
+	// PeerRateLimit is the number of messages per second accepted from each
+	// publisher. Messages above the limit are ignored.
+	PeerRateLimit *OptionalInteger `json:",omitempty"`
+
+	// Validators lists the names of validator plugins run on every message.
+	Validators []string `json:",omitempty"`
 }
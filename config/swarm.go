@@ -7,6 +7,10 @@ type SwarmConfig struct {
 	// dial or receive connections from.
 	AddrFilters []string
 
+	// ConnFilters specifies rules denying or limiting inbound connections by
+	// autonomous system, agent version or supported protocols.
+	ConnFilters ConnFilters
+
 	// DisableBandwidthMetrics disables recording of bandwidth metrics for a
 	// slight reduction in memory usage. You probably don't need to set this
 	// flag.
@@ -56,6 +60,40 @@ type SwarmConfig struct {
 	ResourceMgr ResourceMgr
 }
 
+// ConnFilters configures the filtering of inbound connections beyond the IP
+// ranges of AddrFilters.
+type ConnFilters struct {
+	// ASNDatabase is the path of an offline IP to ASN database, in the
+	// tab-separated format of iptoasn.com, used to match the ASNs of Rules.
+	ASNDatabase string `json:",omitempty"`
+
+	// Rules are applied to every inbound connection.
+	Rules []ConnFilterRule `json:",omitempty"`
+}
+
+const (
+	ConnFilterDeny  = "deny"  // close the matching connections
+	ConnFilterLimit = "limit" // keep at most Limit matching connections
+)
+
+// ConnFilterRule matches the inbound connections satisfying all its
+// conditions.
+type ConnFilterRule struct {
+	// Action is ConnFilterDeny or ConnFilterLimit.
+	Action string
+	// Limit is the number of matching connections kept by ConnFilterLimit.
+	Limit int `json:",omitempty"`
+
+	// ASNs matches the connections from these autonomous systems.
+	ASNs []uint32 `json:",omitempty"`
+	// AgentVersion matches the peers whose agent version matches this
+	// regular expression, once identified.
+	AgentVersion string `json:",omitempty"`
+	// MissingProtocols matches the peers that don't support one of these
+	// protocols, once identified.
+	MissingProtocols []string `json:",omitempty"`
+}
+
 type RelayClient struct {
 	// Enables the auto relay feature: will use relays if it is not publicly reachable.
 	Enabled Flag `json:",omitempty"`
@@ -141,6 +179,19 @@ type ResourceMgr struct {
 	// Enables the Network Resource Manager feature, default to on.
 	Enabled Flag                      `json:",omitempty"`
 	Limits  *rcmgr.BasicLimiterConfig `json:",omitempty"`
+
+	// MaxMemory is the memory libp2p may use in total, e.g. "4GB", the
+	// default limits scale with it when set. Defaults to an eighth of the
+	// system memory, from 1GiB to 4GiB.
+	MaxMemory *OptionalString `json:",omitempty"`
+	// MaxFileDescriptors is the number of file descriptors libp2p may use.
+	// Defaults to half of the file descriptor limit of the process.
+	MaxFileDescriptors *OptionalInteger `json:",omitempty"`
+
+	// Allowlist is a list of multiaddr patterns, like /ip4/10.0.0.0/ipcidr/8
+	// or /p2p/<id>, of the peers whose connections are accounted in the
+	// separate allowlist scope instead of the regular limits.
+	Allowlist []string
 }
 
 const (
@@ -149,4 +200,5 @@ const (
 	ResourceMgrServiceScopePrefix  = "svc:"
 	ResourceMgrProtocolScopePrefix = "proto:"
 	ResourceMgrPeerScopePrefix     = "peer:"
+	ResourceMgrAllowlistScope      = "allowlist" // system scope of Swarm.ResourceMgr.Allowlist
 )
@@ -3,15 +3,18 @@ package commands
 import (
 	"fmt"
 	"io"
+	"time"
 
 	cmdenv "github.com/ipfs/kubo/core/commands/cmdenv"
 	e "github.com/ipfs/kubo/core/commands/e"
+	"github.com/ipfs/kubo/exchange/policy"
 
 	humanize "github.com/dustin/go-humanize"
 	bitswap "github.com/ipfs/go-bitswap"
 	decision "github.com/ipfs/go-bitswap/decision"
 	cidutil "github.com/ipfs/go-cidutil"
 	cmds "github.com/ipfs/go-ipfs-cmds"
+	exchange "github.com/ipfs/go-ipfs-exchange-interface"
 	peer "github.com/libp2p/go-libp2p-core/peer"
 )
 
@@ -33,6 +36,21 @@ const (
 	peerOptionName = "peer"
 )
 
+// bitswapFromExchange returns the bitswap instance behind the exchange, if
+// any, looking through the exchanges wrapping it.
+func bitswapFromExchange(ex exchange.Interface) (*bitswap.Bitswap, bool) {
+	for {
+		if bs, ok := ex.(*bitswap.Bitswap); ok {
+			return bs, true
+		}
+		w, ok := ex.(interface{ Unwrap() exchange.Interface })
+		if !ok {
+			return nil, false
+		}
+		ex = w.Unwrap()
+	}
+}
+
 var showWantlistCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline: "Show blocks currently on the wantlist.",
@@ -53,7 +71,7 @@ Print out all blocks currently on the bitswap wantlist for the local peer.`,
 			return ErrNotOnline
 		}
 
-		bs, ok := nd.Exchange.(*bitswap.Bitswap)
+		bs, ok := bitswapFromExchange(nd.Exchange)
 		if !ok {
 			return e.TypeErr(bs, nd.Exchange)
 		}
@@ -87,6 +105,14 @@ Print out all blocks currently on the bitswap wantlist for the local peer.`,
 	},
 }
 
+// BitswapStatOutput is the output of 'ipfs bitswap stat'.
+type BitswapStatOutput struct {
+	bitswap.Stat
+
+	// Throttled lists the peers held back by Bitswap.PeerRateLimits.
+	Throttled []policy.ThrottledPeer `json:",omitempty"`
+}
+
 const (
 	bitswapVerboseOptionName = "verbose"
 	bitswapHumanOptionName   = "human"
@@ -101,7 +127,7 @@ var bitswapStatCmd = &cmds.Command{
 		cmds.BoolOption(bitswapVerboseOptionName, "v", "Print extra information"),
 		cmds.BoolOption(bitswapHumanOptionName, "Print sizes in human readable format (e.g., 1K 234M 2G)"),
 	},
-	Type: bitswap.Stat{},
+	Type: BitswapStatOutput{},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		nd, err := cmdenv.GetNode(env)
 		if err != nil {
@@ -112,7 +138,7 @@ var bitswapStatCmd = &cmds.Command{
 			return cmds.Errorf(cmds.ErrClient, ErrNotOnline.Error())
 		}
 
-		bs, ok := nd.Exchange.(*bitswap.Bitswap)
+		bs, ok := bitswapFromExchange(nd.Exchange)
 		if !ok {
 			return e.TypeErr(bs, nd.Exchange)
 		}
@@ -122,10 +148,14 @@ var bitswapStatCmd = &cmds.Command{
 			return err
 		}
 
-		return cmds.EmitOnce(res, st)
+		out := &BitswapStatOutput{Stat: *st}
+		if nd.BitswapLimiter != nil {
+			out.Throttled = nd.BitswapLimiter.Throttled()
+		}
+		return cmds.EmitOnce(res, out)
 	},
 	Encoders: cmds.EncoderMap{
-		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, s *bitswap.Stat) error {
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, s *BitswapStatOutput) error {
 			enc, err := cmdenv.GetLowLevelCidEncoder(req)
 			if err != nil {
 				return err
@@ -160,6 +190,16 @@ var bitswapStatCmd = &cmds.Command{
 				for _, p := range s.Peers {
 					fmt.Fprintf(w, "\t\t%s\n", p)
 				}
+
+				fmt.Fprintf(w, "\tthrottled peers [%d]\n", len(s.Throttled))
+				for _, t := range s.Throttled {
+					delayed := fmt.Sprint(t.DelayedBytes)
+					if human {
+						delayed = humanize.Bytes(t.DelayedBytes)
+					}
+					fmt.Fprintf(w, "\t\t%s weight: %.2f, dropped wants: %d, delayed data: %s (%s)\n",
+						t.Peer, t.Weight, t.DroppedWants, delayed, t.Delay.Round(time.Millisecond))
+				}
 			}
 
 			return nil
@@ -190,7 +230,7 @@ prints the ledger associated with a given peer.
 			return ErrNotOnline
 		}
 
-		bs, ok := nd.Exchange.(*bitswap.Bitswap)
+		bs, ok := bitswapFromExchange(nd.Exchange)
 		if !ok {
 			return e.TypeErr(bs, nd.Exchange)
 		}
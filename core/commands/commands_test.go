@@ -218,12 +218,21 @@ func TestCommands(t *testing.T) {
 		"/pubsub/peers",
 		"/pubsub/pub",
 		"/pubsub/sub",
+		"/pubsub/subscribe",
+		"/pubsub/subscriptions",
+		"/pubsub/unsubscribe",
 		"/refs",
 		"/refs/local",
 		"/repo",
 		"/repo/fsck",
 		"/repo/gc",
 		"/repo/migrate",
+		"/repo/backup",
+		"/repo/convert",
+		"/repo/du",
+		"/repo/restore",
+		"/repo/scrub",
+		"/repo/scrub/status",
 		"/repo/stat",
 		"/repo/verify",
 		"/repo/version",
@@ -233,16 +242,22 @@ func TestCommands(t *testing.T) {
 		"/stats/bitswap",
 		"/stats/bw",
 		"/stats/dht",
+		"/stats/gateways",
 		"/stats/provide",
 		"/stats/repo",
 		"/swarm",
 		"/swarm/addrs",
+		"/swarm/allowlist",
+		"/swarm/allowlist/add",
+		"/swarm/allowlist/ls",
+		"/swarm/allowlist/rm",
 		"/swarm/addrs/listen",
 		"/swarm/addrs/local",
 		"/swarm/connect",
 		"/swarm/disconnect",
 		"/swarm/filters",
 		"/swarm/filters/add",
+		"/swarm/filters/log",
 		"/swarm/filters/rm",
 		"/swarm/limit",
 		"/swarm/peers",
@@ -18,6 +18,7 @@ import (
 const (
 	mountIPFSPathOptionName = "ipfs-path"
 	mountIPNSPathOptionName = "ipns-path"
+	mountMFSPathOptionName  = "mfs-path"
 )
 
 var MountCmd = &cmds.Command{
@@ -76,11 +77,23 @@ baz
 baz
 > cat /ipfs/QmWLdkp93sNxGRjnFHPaYg8tCQ35NBY3XPn6KiETd3Z4WR
 baz
+
+The MFS tree of 'ipfs files' can also be mounted read-write, at the
+Mounts.MFS path of the configuration or the path given with --mfs-path:
+
+> ipfs mount --mfs-path=/mfs
+IPFS mounted at: /ipfs
+IPNS mounted at: /ipns
+MFS mounted at: /mfs
+> echo "baz" > /mfs/bar
+> ipfs files read /bar
+baz
 `,
 	},
 	Options: []cmds.Option{
 		cmds.StringOption(mountIPFSPathOptionName, "f", "The path where IPFS should be mounted."),
 		cmds.StringOption(mountIPNSPathOptionName, "n", "The path where IPNS should be mounted."),
+		cmds.StringOption(mountMFSPathOptionName, "m", "The path where MFS should be mounted (read-write)."),
 	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		cfg, err := env.(*oldcmds.Context).GetConfig()
@@ -109,7 +122,12 @@ baz
 			nsdir = cfg.Mounts.IPNS // NB: be sure to not redeclare!
 		}
 
-		err = nodeMount.Mount(nd, fsdir, nsdir)
+		mfsdir, found := req.Options[mountMFSPathOptionName].(string)
+		if !found {
+			mfsdir = cfg.Mounts.MFS
+		}
+
+		err = nodeMount.Mount(nd, fsdir, nsdir, mfsdir)
 		if err != nil {
 			return err
 		}
@@ -117,6 +135,7 @@ baz
 		var output config.Mounts
 		output.IPFS = fsdir
 		output.IPNS = nsdir
+		output.MFS = mfsdir
 		return cmds.EmitOnce(res, &output)
 	},
 	Type: config.Mounts{},
@@ -124,6 +143,9 @@ baz
 		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, mounts *config.Mounts) error {
 			fmt.Fprintf(w, "IPFS mounted at: %s\n", cmdenv.EscNonPrint(mounts.IPFS))
 			fmt.Fprintf(w, "IPNS mounted at: %s\n", cmdenv.EscNonPrint(mounts.IPNS))
+			if mounts.MFS != "" {
+				fmt.Fprintf(w, "MFS mounted at: %s\n", cmdenv.EscNonPrint(mounts.MFS))
+			}
 
 			return nil
 		}),
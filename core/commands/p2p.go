@@ -5,13 +5,16 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"reflect"
 	"strconv"
 	"strings"
 	"text/tabwriter"
 	"time"
 
+	config "github.com/ipfs/kubo/config"
 	core "github.com/ipfs/kubo/core"
 	cmdenv "github.com/ipfs/kubo/core/commands/cmdenv"
+	node "github.com/ipfs/kubo/core/node"
 	p2p "github.com/ipfs/kubo/p2p"
 
 	cmds "github.com/ipfs/go-ipfs-cmds"
@@ -30,6 +33,7 @@ type P2PListenerInfoOutput struct {
 	Protocol      string
 	ListenAddress string
 	TargetAddress string
+	Rejected      uint64 `json:",omitempty"`
 }
 
 // P2PStreamInfoOutput is output type of streams command
@@ -53,6 +57,10 @@ type P2PStreamsOutput struct {
 const (
 	allowCustomProtocolOptionName = "allow-custom-protocol"
 	reportPeerIDOptionName        = "report-peer-id"
+	allowPeerOptionName           = "allow-peer"
+	denyPeerOptionName            = "deny-peer"
+	aclOptionName                 = "acl"
+	p2pPersistOptionName          = "persist"
 )
 
 var resolveTimeout = 10 * time.Second
@@ -92,6 +100,18 @@ Example:
   ipfs p2p forward ` + P2PProtoPrefix + `myproto /ip4/127.0.0.1/tcp/4567 /p2p/QmPeer
     - Forward connections to 127.0.0.1:4567 to '` + P2PProtoPrefix + `myproto' service on /p2p/QmPeer
 
+<listen-address> can also be a Unix socket path (/unix/path/to/socket), or a
+UDP address. UDP datagrams are forwarded one by one, using a separate libp2p
+stream for every local client, which is closed after two minutes without
+traffic. The remote service must then be listening on a UDP target too.
+
+Example:
+  ipfs p2p forward ` + P2PProtoPrefix + `dns /ip4/127.0.0.1/udp/5353 /p2p/QmPeer
+    - Forward DNS queries sent to 127.0.0.1:5353 to '` + P2PProtoPrefix + `dns' service on /p2p/QmPeer
+
+With --persist, the forward is also added to P2P.Forwards in the config, and
+registered again every time the daemon starts.
+
 `,
 	},
 	Arguments: []cmds.Argument{
@@ -101,6 +121,7 @@ Example:
 	},
 	Options: []cmds.Option{
 		cmds.BoolOption(allowCustomProtocolOptionName, "Don't require /x/ prefix"),
+		cmds.BoolOption(p2pPersistOptionName, "Save the forward to the config, restoring it when the daemon starts."),
 	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		n, err := p2pGetNode(env)
@@ -130,7 +151,34 @@ Example:
 			return errors.New("protocol name must be within '" + P2PProtoPrefix + "' namespace")
 		}
 
-		return forwardLocal(n.Context(), n.P2P, n.Peerstore, proto, listen, targets)
+		if err := forwardLocal(n.Context(), n.P2P, n.Peerstore, proto, listen, targets); err != nil {
+			return err
+		}
+
+		if persist, _ := req.Options[p2pPersistOptionName].(bool); !persist {
+			return nil
+		}
+
+		// dns addresses were resolved above, persist the peer id instead
+		persistedTarget := targetOpt
+		if _, err := peer.AddrInfoFromString(targetOpt); err != nil {
+			persistedTarget = "/p2p/" + targets.ID.Pretty()
+		}
+		entry := config.P2PForward{
+			Protocol:            string(proto),
+			ListenAddress:       listen.String(),
+			TargetAddress:       persistedTarget,
+			AllowCustomProtocol: allowCustom,
+		}
+		return updateP2PConfig(n, func(cfg *config.P2P) {
+			forwards := []config.P2PForward{}
+			for _, f := range cfg.Forwards {
+				if f.ListenAddress != entry.ListenAddress {
+					forwards = append(forwards, f)
+				}
+			}
+			cfg.Forwards = append(forwards, entry)
+		})
 	},
 }
 
@@ -193,6 +241,26 @@ Example:
   ipfs p2p listen ` + P2PProtoPrefix + `myproto /ip4/127.0.0.1/tcp/1234
     - Forward connections to 'myproto' libp2p service to 127.0.0.1:1234
 
+<target-address> can also be a Unix socket path (/unix/path/to/socket), or a
+UDP address to which the datagrams forwarded by a UDP 'ipfs p2p forward' are
+sent.
+
+Example:
+  ipfs p2p listen ` + P2PProtoPrefix + `admin /unix/run/admin.sock
+    - Forward connections to 'admin' libp2p service to the /run/admin.sock socket
+
+By default any peer may open a stream to the service. Use --allow-peer to
+only accept the given peers and --deny-peer to reject some, or --acl to use
+an access list defined in P2P.ACLs. Streams from peers that are not allowed
+are reset before <target-address> is dialed, and counted in 'ipfs p2p ls -v'.
+
+Example:
+  ipfs p2p listen --allow-peer=QmPeer1 --allow-peer=QmPeer2 ` + P2PProtoPrefix + `db /ip4/127.0.0.1/tcp/5432
+    - Only let QmPeer1 and QmPeer2 reach 127.0.0.1:5432
+
+With --persist, the service is also added to P2P.Listeners in the config, and
+registered again every time the daemon starts.
+
 `,
 	},
 	Arguments: []cmds.Argument{
@@ -202,6 +270,10 @@ Example:
 	Options: []cmds.Option{
 		cmds.BoolOption(allowCustomProtocolOptionName, "Don't require /x/ prefix"),
 		cmds.BoolOption(reportPeerIDOptionName, "r", "Send remote base58 peerid to target when a new connection is established"),
+		cmds.StringsOption(allowPeerOptionName, "Only accept streams from this peer. Can be passed multiple times."),
+		cmds.StringsOption(denyPeerOptionName, "Reject streams from this peer. Can be passed multiple times."),
+		cmds.StringOption(aclOptionName, "Name of an access list defined in P2P.ACLs."),
+		cmds.BoolOption(p2pPersistOptionName, "Save the service to the config, restoring it when the daemon starts."),
 	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		n, err := p2pGetNode(env)
@@ -231,14 +303,102 @@ Example:
 			return errors.New("protocol name must be within '" + P2PProtoPrefix + "' namespace")
 		}
 
-		_, err = n.P2P.ForwardRemote(n.Context(), proto, target, reportPeerID)
-		return err
+		entry, err := p2pListenerConfig(req)
+		if err != nil {
+			return err
+		}
+		entry.Protocol = string(proto)
+		entry.TargetAddress = target.String()
+		entry.AllowCustomProtocol = allowCustom
+		entry.ReportPeerID = reportPeerID
+
+		cfg, err := n.Repo.Config()
+		if err != nil {
+			return err
+		}
+		acl, err := node.P2PListenerACL(cfg.P2P, entry)
+		if err != nil {
+			return err
+		}
+
+		if _, err := n.P2P.ForwardRemote(n.Context(), proto, target, reportPeerID, acl); err != nil {
+			return err
+		}
+
+		if persist, _ := req.Options[p2pPersistOptionName].(bool); !persist {
+			return nil
+		}
+		return updateP2PConfig(n, func(cfg *config.P2P) {
+			listeners := []config.P2PListener{}
+			for _, l := range cfg.Listeners {
+				if l.Protocol != entry.Protocol {
+					listeners = append(listeners, l)
+				}
+			}
+			cfg.Listeners = append(listeners, entry)
+		})
 	},
 }
 
+// p2pListenerConfig returns the access restrictions passed to 'ipfs p2p
+// listen' as a persistable listener.
+func p2pListenerConfig(req *cmds.Request) (config.P2PListener, error) {
+	var entry config.P2PListener
+	entry.ACL, _ = req.Options[aclOptionName].(string)
+
+	allowOpt, _ := req.Options[allowPeerOptionName].([]string)
+	for _, s := range allowOpt {
+		id, err := peer.Decode(s)
+		if err != nil {
+			return entry, fmt.Errorf("invalid --%s peer %q: %w", allowPeerOptionName, s, err)
+		}
+		entry.Allow = append(entry.Allow, id)
+	}
+
+	denyOpt, _ := req.Options[denyPeerOptionName].([]string)
+	for _, s := range denyOpt {
+		id, err := peer.Decode(s)
+		if err != nil {
+			return entry, fmt.Errorf("invalid --%s peer %q: %w", denyPeerOptionName, s, err)
+		}
+		entry.Deny = append(entry.Deny, id)
+	}
+
+	return entry, nil
+}
+
+// updateP2PConfig applies update to the P2P section of the config and saves
+// the lists it changed. They are set on their own, as SetConfig merges the
+// config with the file and would keep the removed entries once a list is
+// empty.
+func updateP2PConfig(n *core.IpfsNode, update func(*config.P2P)) error {
+	cfg, err := n.Repo.Config()
+	if err != nil {
+		return err
+	}
+	updated := cfg.P2P
+	update(&updated)
+
+	if len(cfg.P2P.Listeners)+len(updated.Listeners) != 0 && !reflect.DeepEqual(cfg.P2P.Listeners, updated.Listeners) {
+		if err := n.Repo.SetConfigKey("P2P.Listeners", updated.Listeners); err != nil {
+			return err
+		}
+	}
+	if len(cfg.P2P.Forwards)+len(updated.Forwards) != 0 && !reflect.DeepEqual(cfg.P2P.Forwards, updated.Forwards) {
+		if err := n.Repo.SetConfigKey("P2P.Forwards", updated.Forwards); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // checkPort checks whether target multiaddr contains tcp or udp protocol
-// and whether the port is equal to 0
+// and whether the port is equal to 0. Unix socket targets have no port.
 func checkPort(target ma.Multiaddr) error {
+	if _, err := target.ValueForProtocol(ma.P_UNIX); err == nil {
+		return nil
+	}
+
 	// get tcp or udp port from multiaddr
 	getPort := func() (string, error) {
 		sport, _ := target.ValueForProtocol(ma.P_TCP)
@@ -279,16 +439,23 @@ func forwardLocal(ctx context.Context, p *p2p.P2P, ps pstore.Peerstore, proto pr
 }
 
 const (
-	p2pHeadersOptionName = "headers"
+	p2pHeadersOptionName   = "headers"
+	p2pPersistedOptionName = "persisted"
 )
 
 var p2pLsCmd = &cmds.Command{
 	Status: cmds.Experimental,
 	Helptext: cmds.HelpText{
 		Tagline: "List active p2p listeners.",
+		ShortDescription: `
+Lists the active p2p listeners. With --persisted, lists the listeners and
+forwards saved in the config instead, which the daemon registers when it
+starts.
+`,
 	},
 	Options: []cmds.Option{
-		cmds.BoolOption(p2pHeadersOptionName, "v", "Print table headers (Protocol, Listen, Target)."),
+		cmds.BoolOption(p2pHeadersOptionName, "v", "Print table headers (Protocol, Listen, Target, Rejected)."),
+		cmds.BoolOption(p2pPersistedOptionName, "List the listeners and forwards saved in the config."),
 	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		n, err := p2pGetNode(env)
@@ -298,6 +465,28 @@ var p2pLsCmd = &cmds.Command{
 
 		output := &P2PLsOutput{}
 
+		if persisted, _ := req.Options[p2pPersistedOptionName].(bool); persisted {
+			cfg, err := n.Repo.Config()
+			if err != nil {
+				return err
+			}
+			for _, f := range cfg.P2P.Forwards {
+				output.Listeners = append(output.Listeners, P2PListenerInfoOutput{
+					Protocol:      f.Protocol,
+					ListenAddress: f.ListenAddress,
+					TargetAddress: f.TargetAddress,
+				})
+			}
+			for _, l := range cfg.P2P.Listeners {
+				output.Listeners = append(output.Listeners, P2PListenerInfoOutput{
+					Protocol:      l.Protocol,
+					ListenAddress: "/p2p/" + n.Identity.Pretty(),
+					TargetAddress: l.TargetAddress,
+				})
+			}
+			return cmds.EmitOnce(res, output)
+		}
+
 		n.P2P.ListenersLocal.Lock()
 		for _, listener := range n.P2P.ListenersLocal.Listeners {
 			output.Listeners = append(output.Listeners, P2PListenerInfoOutput{
@@ -310,11 +499,15 @@ var p2pLsCmd = &cmds.Command{
 
 		n.P2P.ListenersP2P.Lock()
 		for _, listener := range n.P2P.ListenersP2P.Listeners {
-			output.Listeners = append(output.Listeners, P2PListenerInfoOutput{
+			info := P2PListenerInfoOutput{
 				Protocol:      string(listener.Protocol()),
 				ListenAddress: listener.ListenAddress().String(),
 				TargetAddress: listener.TargetAddress().String(),
-			})
+			}
+			if rl, ok := listener.(interface{ Rejected() uint64 }); ok {
+				info.Rejected = rl.Rejected()
+			}
+			output.Listeners = append(output.Listeners, info)
 		}
 		n.P2P.ListenersP2P.Unlock()
 
@@ -327,7 +520,9 @@ var p2pLsCmd = &cmds.Command{
 			tw := tabwriter.NewWriter(w, 1, 2, 1, ' ', 0)
 			for _, listener := range out.Listeners {
 				if headers {
-					fmt.Fprintln(tw, "Protocol\tListen Address\tTarget Address")
+					fmt.Fprintln(tw, "Protocol\tListen Address\tTarget Address\tRejected")
+					fmt.Fprintf(tw, "%s\t%s\t%s\t%d\n", listener.Protocol, listener.ListenAddress, listener.TargetAddress, listener.Rejected)
+					continue
 				}
 
 				fmt.Fprintf(tw, "%s\t%s\t%s\n", listener.Protocol, listener.ListenAddress, listener.TargetAddress)
@@ -356,6 +551,7 @@ var p2pCloseCmd = &cmds.Command{
 		cmds.StringOption(p2pProtocolOptionName, "p", "Match protocol name"),
 		cmds.StringOption(p2pListenAddressOptionName, "l", "Match listen address"),
 		cmds.StringOption(p2pTargetAddressOptionName, "t", "Match target address"),
+		cmds.BoolOption(p2pPersistOptionName, "Also remove matching listeners from the config."),
 	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		n, err := p2pGetNode(env)
@@ -396,25 +592,71 @@ var p2pCloseCmd = &cmds.Command{
 			return errors.New("can't combine --all with other matching options")
 		}
 
-		match := func(listener p2p.Listener) bool {
+		matchAddrs := func(lproto protocol.ID, llisten, ltarget ma.Multiaddr) bool {
 			if closeAll {
 				return true
 			}
-			if p && proto != listener.Protocol() {
+			if p && proto != lproto {
 				return false
 			}
-			if l && !listen.Equal(listener.ListenAddress()) {
+			if l && !listen.Equal(llisten) {
 				return false
 			}
-			if t && !target.Equal(listener.TargetAddress()) {
+			if t && !target.Equal(ltarget) {
 				return false
 			}
 			return true
 		}
+		match := func(listener p2p.Listener) bool {
+			return matchAddrs(listener.Protocol(), listener.ListenAddress(), listener.TargetAddress())
+		}
 
 		done := n.P2P.ListenersLocal.Close(match)
 		done += n.P2P.ListenersP2P.Close(match)
 
+		if persist, _ := req.Options[p2pPersistOptionName].(bool); persist {
+			self, err := ma.NewMultiaddr("/p2p/" + n.Identity.Pretty())
+			if err != nil {
+				return err
+			}
+			matchPersisted := func(lproto, llisten, ltarget string) bool {
+				// invalid entries can't be matched by address, and are
+				// only removed with --all
+				lmaddr, err := ma.NewMultiaddr(llisten)
+				if err != nil {
+					return closeAll
+				}
+				tmaddr, err := ma.NewMultiaddr(ltarget)
+				if err != nil {
+					return closeAll
+				}
+				return matchAddrs(protocol.ID(lproto), lmaddr, tmaddr)
+			}
+			err = updateP2PConfig(n, func(cfg *config.P2P) {
+				listeners := []config.P2PListener{}
+				for _, pl := range cfg.Listeners {
+					if !matchPersisted(pl.Protocol, self.String(), pl.TargetAddress) {
+						listeners = append(listeners, pl)
+					}
+				}
+				forwards := []config.P2PForward{}
+				for _, pf := range cfg.Forwards {
+					// live forwards only report the peer they target
+					ptarget := pf.TargetAddress
+					if info, err := peer.AddrInfoFromString(ptarget); err == nil {
+						ptarget = "/p2p/" + info.ID.Pretty()
+					}
+					if !matchPersisted(pf.Protocol, pf.ListenAddress, ptarget) {
+						forwards = append(forwards, pf)
+					}
+				}
+				cfg.Listeners, cfg.Forwards = listeners, forwards
+			})
+			if err != nil {
+				return err
+			}
+		}
+
 		return cmds.EmitOnce(res, done)
 	},
 	Type: int(0),
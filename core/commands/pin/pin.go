@@ -22,6 +22,7 @@ import (
 	core "github.com/ipfs/kubo/core"
 	cmdenv "github.com/ipfs/kubo/core/commands/cmdenv"
 	e "github.com/ipfs/kubo/core/commands/e"
+	"github.com/ipfs/kubo/core/coreapi"
 )
 
 var PinCmd = &cmds.Command{
@@ -51,12 +52,22 @@ type AddPinOutput struct {
 const (
 	pinRecursiveOptionName = "recursive"
 	pinProgressOptionName  = "progress"
+	pinNoProvideOptionName = "no-provide"
 )
 
 var addPinCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline:          "Pin objects to local storage.",
 		ShortDescription: "Stores an IPFS object(s) from a given path locally to disk.",
+		LongDescription: `
+Stores an IPFS object(s) from a given path locally to disk.
+
+With --no-provide, the pinned objects are left out of the reprovider
+strategies that walk the pins ("pinned", "roots" and "pinned+mfs"). It has no
+effect with the default "all" strategy, which announces every block of the
+blockstore, and the blocks fetched from the network while pinning are still
+announced by bitswap. See Reprovider.Strategy.
+`,
 	},
 
 	Arguments: []cmds.Argument{
@@ -65,6 +76,7 @@ var addPinCmd = &cmds.Command{
 	Options: []cmds.Option{
 		cmds.BoolOption(pinRecursiveOptionName, "r", "Recursively pin the object linked to by the specified object(s).").WithDefault(true),
 		cmds.BoolOption(pinProgressOptionName, "Show progress"),
+		cmds.BoolOption(pinNoProvideOptionName, "Do not announce the pinned content to the routing system, see Reprovider.Strategy."),
 	},
 	Type: AddPinOutput{},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
@@ -76,6 +88,7 @@ var addPinCmd = &cmds.Command{
 		// set recursive flag
 		recursive, _ := req.Options[pinRecursiveOptionName].(bool)
 		showProgress, _ := req.Options[pinProgressOptionName].(bool)
+		noProvide, _ := req.Options[pinNoProvideOptionName].(bool)
 
 		if err := req.ParseBodyArgs(); err != nil {
 			return err
@@ -87,7 +100,7 @@ var addPinCmd = &cmds.Command{
 		}
 
 		if !showProgress {
-			added, err := pinAddMany(req.Context, api, enc, req.Arguments, recursive)
+			added, err := pinAddMany(req.Context, api, enc, req.Arguments, recursive, noProvide)
 			if err != nil {
 				return err
 			}
@@ -105,7 +118,7 @@ var addPinCmd = &cmds.Command{
 
 		ch := make(chan pinResult, 1)
 		go func() {
-			added, err := pinAddMany(ctx, api, enc, req.Arguments, recursive)
+			added, err := pinAddMany(ctx, api, enc, req.Arguments, recursive, noProvide)
 			ch <- pinResult{pins: added, err: err}
 		}()
 
@@ -181,7 +194,7 @@ var addPinCmd = &cmds.Command{
 	},
 }
 
-func pinAddMany(ctx context.Context, api coreiface.CoreAPI, enc cidenc.Encoder, paths []string, recursive bool) ([]string, error) {
+func pinAddMany(ctx context.Context, api coreiface.CoreAPI, enc cidenc.Encoder, paths []string, recursive, noProvide bool) ([]string, error) {
 	added := make([]string, len(paths))
 	for i, b := range paths {
 		rp, err := api.ResolvePath(ctx, path.New(b))
@@ -189,7 +202,14 @@ func pinAddMany(ctx context.Context, api coreiface.CoreAPI, enc cidenc.Encoder,
 			return nil, err
 		}
 
-		if err := api.Pin().Add(ctx, rp, options.Pin.Recursive(recursive)); err != nil {
+		if pinAPI, ok := api.Pin().(*coreapi.PinAPI); ok {
+			err = pinAPI.AddWithSettings(ctx, rp, coreapi.PinAddSettings{NoProvide: noProvide}, options.Pin.Recursive(recursive))
+		} else if noProvide {
+			err = fmt.Errorf("--%s is not supported by this API", pinNoProvideOptionName)
+		} else {
+			err = api.Pin().Add(ctx, rp, options.Pin.Recursive(recursive))
+		}
+		if err != nil {
 			return nil, err
 		}
 		added[i] = enc.Encode(rp.Cid())
@@ -7,7 +7,10 @@ import (
 	"net/http"
 	"sort"
 
+	oldcmds "github.com/ipfs/kubo/commands"
 	cmdenv "github.com/ipfs/kubo/core/commands/cmdenv"
+	"github.com/ipfs/kubo/pubsub/history"
+	"github.com/ipfs/kubo/repo/fsrepo"
 	mbase "github.com/multiformats/go-multibase"
 	"github.com/pkg/errors"
 
@@ -31,18 +34,28 @@ EXPERIMENTAL FEATURE
 `,
 	},
 	Subcommands: map[string]*cmds.Command{
-		"pub":   PubsubPubCmd,
-		"sub":   PubsubSubCmd,
-		"ls":    PubsubLsCmd,
-		"peers": PubsubPeersCmd,
+		"pub":           PubsubPubCmd,
+		"sub":           PubsubSubCmd,
+		"ls":            PubsubLsCmd,
+		"peers":         PubsubPeersCmd,
+		"subscribe":     PubsubSubscribeCmd,
+		"unsubscribe":   PubsubUnsubscribeCmd,
+		"subscriptions": PubsubSubscriptionsCmd,
 	},
 }
 
+const (
+	pubsubSinceOptionName      = "since"
+	pubsubPersistentOptionName = "persistent"
+	pubsubPurgeOptionName      = "purge"
+)
+
 type pubsubMessage struct {
 	From     string   `json:"from,omitempty"`
 	Data     string   `json:"data,omitempty"`
 	Seqno    string   `json:"seqno,omitempty"`
 	TopicIDs []string `json:"topicIDs,omitempty"`
+	Index    uint64   `json:"index,omitempty"`
 }
 
 var PubsubSubCmd = &cmds.Command{
@@ -71,11 +84,27 @@ TOPIC AND DATA ENCODING
 
   You can inspect the format by passing --enc=json. The ipfs multibase commands
   can be used for encoding/decoding multibase strings in the userland.
+
+REPLAYING HISTORY
+
+  Messages of topics with a managed subscription (see 'ipfs pubsub subscribe'
+  and Pubsub.Subscriptions) are recorded by the daemon. Passing --since
+  replays the recorded messages before following new ones, so a client can
+  reconnect without missing anything that arrived in between.
+
+  --since accepts a history index, an RFC3339 timestamp or a duration
+  relative to now (e.g. '10m'). Replayed messages carry their history index
+  in the 'index' field: a client reconnecting with --since=<last index seen>
+  receives exactly the messages recorded after it. Use --since=0 to replay
+  the whole retained history.
 `,
 	},
 	Arguments: []cmds.Argument{
 		cmds.StringArg("topic", true, false, "Name of topic to subscribe to (multibase encoded when sent over HTTP RPC)."),
 	},
+	Options: []cmds.Option{
+		cmds.StringOption(pubsubSinceOptionName, "Replay recorded messages since the given history index, RFC3339 time or duration."),
+	},
 	PreRun: urlArgsEncoder,
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		api, err := cmdenv.GetApi(env, req)
@@ -88,6 +117,10 @@ TOPIC AND DATA ENCODING
 
 		topic := req.Arguments[0]
 
+		if since, ok := req.Options[pubsubSinceOptionName].(string); ok {
+			return pubsubReplay(req, res, env, topic, since)
+		}
+
 		sub, err := api.PubSub().Subscribe(req.Context, topic)
 		if err != nil {
 			return err
@@ -144,6 +177,270 @@ TOPIC AND DATA ENCODING
 	Type: pubsubMessage{},
 }
 
+// pubsubReplay streams the recorded history of a managed topic followed by
+// newly recorded messages.
+func pubsubReplay(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment, topic, since string) error {
+	n, err := cmdenv.GetNode(env)
+	if err != nil {
+		return err
+	}
+	if n.PubsubHistory == nil {
+		return errors.New("pubsub history is not available, the daemon must be running with pubsub enabled")
+	}
+
+	pos, err := history.ParsePosition(since)
+	if err != nil {
+		return err
+	}
+
+	msgs, err := n.PubsubHistory.Stream(req.Context, topic, pos)
+	if err == history.ErrNotSubscribed {
+		return fmt.Errorf("topic %q has no managed subscription, use 'ipfs pubsub subscribe' first", topic)
+	} else if err != nil {
+		return err
+	}
+
+	if f, ok := res.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	encoder, _ := mbase.EncoderByName("base64url")
+	for msg := range msgs {
+		psm := pubsubMessage{
+			Data:  encoder.Encode(msg.Data),
+			Seqno: encoder.Encode(msg.Seqno),
+			Index: msg.Index,
+		}
+		if msg.From != "" {
+			psm.From = msg.From.Pretty()
+		}
+		for _, topic := range msg.Topics {
+			psm.TopicIDs = append(psm.TopicIDs, encoder.Encode([]byte(topic)))
+		}
+		if err := res.Emit(&psm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var PubsubSubscribeCmd = &cmds.Command{
+	Status: cmds.Experimental,
+	Helptext: cmds.HelpText{
+		Tagline: "Start a daemon-managed subscription to a topic.",
+		ShortDescription: `
+ipfs pubsub subscribe makes the daemon subscribe to the given topics and
+record the messages received on them, independently of any open
+'ipfs pubsub sub' request. Recorded messages can be replayed with
+'ipfs pubsub sub --since'. The number of messages kept per topic is set by
+Pubsub.HistorySize.
+
+With --persistent, the topics are also added to Pubsub.Subscriptions so the
+subscriptions are restored when the daemon restarts.
+
+EXPERIMENTAL FEATURE
+
+  It is not intended in its current state to be used in a production
+  environment.  To use, the daemon must be run with
+  '--enable-pubsub-experiment'.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("topic", true, true, "Topic to subscribe to (multibase encoded when sent over HTTP RPC)."),
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption(pubsubPersistentOptionName, "Save the subscription to Pubsub.Subscriptions.").WithDefault(false),
+	},
+	PreRun: urlArgsEncoder,
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		if !n.IsOnline {
+			return ErrNotOnline
+		}
+		if n.PubsubHistory == nil {
+			return errors.New("pubsub history is not available, the daemon must be running with pubsub enabled")
+		}
+		if err := urlArgsDecoder(req, env); err != nil {
+			return err
+		}
+
+		for _, topic := range req.Arguments {
+			if err := n.PubsubHistory.Subscribe(topic); err != nil {
+				return err
+			}
+		}
+
+		if persistent, _ := req.Options[pubsubPersistentOptionName].(bool); persistent {
+			err := updatePubsubSubscriptions(env, func(topics []string) []string {
+				for _, topic := range req.Arguments {
+					if !containsString(topics, topic) {
+						topics = append(topics, topic)
+					}
+				}
+				return topics
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return cmds.EmitOnce(res, &stringList{encodeTopics(req.Arguments)})
+	},
+	Type: stringList{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(multibaseDecodedStringListEncoder),
+	},
+}
+
+var PubsubUnsubscribeCmd = &cmds.Command{
+	Status: cmds.Experimental,
+	Helptext: cmds.HelpText{
+		Tagline: "Stop a daemon-managed subscription to a topic.",
+		ShortDescription: `
+ipfs pubsub unsubscribe stops the managed subscriptions to the given topics
+and removes them from Pubsub.Subscriptions. The recorded history is kept
+unless --purge is passed.
+
+EXPERIMENTAL FEATURE
+
+  It is not intended in its current state to be used in a production
+  environment.  To use, the daemon must be run with
+  '--enable-pubsub-experiment'.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("topic", true, true, "Topic to unsubscribe from (multibase encoded when sent over HTTP RPC)."),
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption(pubsubPurgeOptionName, "Also delete the recorded history of the topic.").WithDefault(false),
+	},
+	PreRun: urlArgsEncoder,
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		if !n.IsOnline {
+			return ErrNotOnline
+		}
+		if n.PubsubHistory == nil {
+			return errors.New("pubsub history is not available, the daemon must be running with pubsub enabled")
+		}
+		if err := urlArgsDecoder(req, env); err != nil {
+			return err
+		}
+
+		purge, _ := req.Options[pubsubPurgeOptionName].(bool)
+		for _, topic := range req.Arguments {
+			err := n.PubsubHistory.Unsubscribe(topic, purge)
+			if err == history.ErrNotSubscribed {
+				return fmt.Errorf("topic %q has no managed subscription", topic)
+			} else if err != nil {
+				return err
+			}
+		}
+
+		err = updatePubsubSubscriptions(env, func(topics []string) []string {
+			keep := topics[:0]
+			for _, topic := range topics {
+				if !containsString(req.Arguments, topic) {
+					keep = append(keep, topic)
+				}
+			}
+			return keep
+		})
+		if err != nil {
+			return err
+		}
+
+		return cmds.EmitOnce(res, &stringList{encodeTopics(req.Arguments)})
+	},
+	Type: stringList{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(multibaseDecodedStringListEncoder),
+	},
+}
+
+var PubsubSubscriptionsCmd = &cmds.Command{
+	Status: cmds.Experimental,
+	Helptext: cmds.HelpText{
+		Tagline: "List daemon-managed subscriptions.",
+		ShortDescription: `
+ipfs pubsub subscriptions lists the topics the daemon records messages for,
+as started by 'ipfs pubsub subscribe' or listed in Pubsub.Subscriptions.
+
+EXPERIMENTAL FEATURE
+
+  It is not intended in its current state to be used in a production
+  environment.  To use, the daemon must be run with
+  '--enable-pubsub-experiment'.
+
+TOPIC ENCODING
+
+  Topic names are a binary data. To ensure all bytes are transferred
+  correctly RPC client and server will use multibase encoding behind
+  the scenes.
+`,
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		if n.PubsubHistory == nil {
+			return errors.New("pubsub history is not available, the daemon must be running with pubsub enabled")
+		}
+
+		return cmds.EmitOnce(res, &stringList{encodeTopics(n.PubsubHistory.Topics())})
+	},
+	Type: stringList{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(multibaseDecodedStringListEncoder),
+	},
+}
+
+// updatePubsubSubscriptions applies update to Pubsub.Subscriptions and saves
+// the config. The key is set on its own, as SetConfig merges the config with
+// the file and would keep the removed topics once the list is empty.
+func updatePubsubSubscriptions(env cmds.Environment, update func([]string) []string) error {
+	r, err := fsrepo.Open(env.(*oldcmds.Context).ConfigRoot)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	cfg, err := r.Config()
+	if err != nil {
+		return err
+	}
+
+	topics := update(cfg.Pubsub.Subscriptions)
+	if topics == nil {
+		topics = []string{}
+	}
+	return r.SetConfigKey("Pubsub.Subscriptions", topics)
+}
+
+func encodeTopics(topics []string) []string {
+	encoder, _ := mbase.EncoderByName("base64url")
+	encoded := make([]string, len(topics))
+	for i, topic := range topics {
+		encoded[i] = encoder.Encode([]byte(topic))
+	}
+	return encoded
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
 var PubsubPubCmd = &cmds.Command{
 	Status: cmds.Experimental,
 	Helptext: cmds.HelpText{
@@ -16,6 +16,8 @@ import (
 	corerepo "github.com/ipfs/kubo/core/corerepo"
 	fsrepo "github.com/ipfs/kubo/repo/fsrepo"
 	"github.com/ipfs/kubo/repo/fsrepo/migrations"
+	// the migrations compiled into ipfs
+	_ "github.com/ipfs/kubo/repo/fsrepo/migrations/builtin"
 	"github.com/ipfs/kubo/repo/fsrepo/migrations/ipfsfetcher"
 
 	humanize "github.com/dustin/go-humanize"
@@ -43,6 +45,11 @@ var RepoCmd = &cmds.Command{
 		"version": repoVersionCmd,
 		"verify":  repoVerifyCmd,
 		"migrate": repoMigrateCmd,
+		"backup":  repoBackupCmd,
+		"restore": repoRestoreCmd,
+		"convert": repoConvertCmd,
+		"scrub":   repoScrubCmd,
+		"du":      repoDuCmd,
 	},
 }
 
@@ -57,6 +64,8 @@ const (
 	repoQuietOptionName          = "quiet"
 	repoSilentOptionName         = "silent"
 	repoAllowDowngradeOptionName = "allow-downgrade"
+	repoDryRunOptionName         = "dry-run"
+	repoBackupOptionName         = "backup"
 )
 
 var repoGcCmd = &cmds.Command{
@@ -161,6 +170,14 @@ StorageMax      string Maximum datastore size (from configuration)
 NumObjects      int Number of objects in the local repo.
 RepoPath        string The path to the repo being currently used.
 Version         string The repo version.
+
+With a tiered datastore, the sizes of its hot and cold tiers and the number of
+keys promoted to the hot tier and demoted to the cold tier are added.
+
+With a compress datastore, the sizes of its values before compression
+(LogicalSize) and once written (StoredSize) are added, the sizes of deleted
+values being estimated. RepoSize is the space used on disk. The sizes are being counted again when marked as such, after the
+repo was not closed properly.
 `,
 	},
 	Options: []cmds.Option{
@@ -219,6 +236,28 @@ Version         string The repo version.
 			if !sizeOnly {
 				fmt.Fprintf(wtr, "RepoPath:\t%s\n", stat.RepoPath)
 				fmt.Fprintf(wtr, "Version:\t%s\n", stat.Version)
+
+				for _, t := range stat.Tiers {
+					fmt.Fprintf(wtr, "Tiered %s:\n", t.Name)
+					fmt.Fprintf(wtr, "  HotObjects:\t%d\n", t.HotObjects)
+					printSize("  HotSize", t.HotSize)
+					printSize("  HotMaxSize", t.HotMaxSize)
+					printSize("  ColdSize", t.ColdSize)
+					printSize("  ColdMaxSize", t.ColdMaxSize)
+					fmt.Fprintf(wtr, "  Promotions:\t%d\n", t.Promotions)
+					fmt.Fprintf(wtr, "  Demotions:\t%d\n", t.Demotions)
+				}
+				for _, c := range stat.Compression {
+					if c.Dirty {
+						fmt.Fprintf(wtr, "Compress %s (being counted):\n", c.Name)
+					} else {
+						fmt.Fprintf(wtr, "Compress %s:\n", c.Name)
+					}
+					fmt.Fprintf(wtr, "  Objects:\t%d\n", c.Objects)
+					fmt.Fprintf(wtr, "  CompressedObjects:\t%d\n", c.Compressed)
+					printSize("  LogicalSize", c.LogicalSize)
+					printSize("  StoredSize", c.StoredSize)
+				}
 			}
 
 			return nil
@@ -396,14 +435,38 @@ var repoVersionCmd = &cmds.Command{
 var repoMigrateCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline: "Apply any outstanding migrations to the repo.",
+		ShortDescription: `
+'ipfs repo migrate' migrates the repo to the version this ipfs uses. The
+migrations compiled into ipfs run in process, the others are run from their
+fs-repo-N-to-M binaries, found in the PATH or downloaded as set by the
+Migration section of the config.
+`,
+		LongDescription: `
+'ipfs repo migrate' migrates the repo to the version this ipfs uses. The
+migrations compiled into ipfs run in process, the others are run from their
+fs-repo-N-to-M binaries, found in the PATH or downloaded as set by the
+Migration section of the config.
+
+With --dry-run, the migrations that would run are listed, and the ones compiled
+into ipfs tell what they would change in the repo as it is now.
+
+With --backup, the repo directory is copied next to it, to <repo>.backup-v<N>,
+before migrating. Datastores outside of the repo directory are not copied. If a
+migration fails, the repo is restored from the copy. Without a backup, the
+migrations that already ran are reverted.
+`,
 	},
 	Options: []cmds.Option{
 		cmds.BoolOption(repoAllowDowngradeOptionName, "Allow downgrading to a lower repo version"),
+		cmds.BoolOption(repoDryRunOptionName, "Only list the migrations that would run."),
+		cmds.BoolOption(repoBackupOptionName, "Copy the repo directory before migrating, and restore it if a migration fails."),
 	},
 	NoRemote: true,
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		cctx := env.(*oldcmds.Context)
 		allowDowngrade, _ := req.Options[repoAllowDowngradeOptionName].(bool)
+		dryRun, _ := req.Options[repoDryRunOptionName].(bool)
+		backup, _ := req.Options[repoBackupOptionName].(bool)
 
 		_, err := fsrepo.Open(cctx.ConfigRoot)
 
@@ -442,7 +505,11 @@ var repoMigrateCmd = &cmds.Command{
 		}
 		defer fetcher.Close()
 
-		err = migrations.RunMigration(cctx.Context(), fetcher, fsrepo.RepoVersion, "", allowDowngrade)
+		err = migrations.RunMigrationWithOptions(cctx.Context(), fetcher, fsrepo.RepoVersion, "", migrations.MigrationOptions{
+			AllowDowngrade: allowDowngrade,
+			DryRun:         dryRun,
+			Backup:         backup,
+		})
 		if err != nil {
 			fmt.Println("The migrations of fs-repo failed:")
 			fmt.Printf("  %s\n", err)
@@ -450,6 +517,9 @@ var repoMigrateCmd = &cmds.Command{
 			fmt.Println("  https://github.com/ipfs/fs-repo-migrations")
 			return err
 		}
+		if dryRun {
+			return nil
+		}
 
 		fmt.Printf("Success: fs-repo has been migrated to version %d.\n", fsrepo.RepoVersion)
 		return nil
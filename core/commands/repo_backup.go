@@ -0,0 +1,124 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+
+	oldcmds "github.com/ipfs/kubo/commands"
+	cmdenv "github.com/ipfs/kubo/core/commands/cmdenv"
+	corerepo "github.com/ipfs/kubo/core/corerepo"
+
+	cmds "github.com/ipfs/go-ipfs-cmds"
+)
+
+const repoPinnedOnlyOptionName = "pinned-only"
+
+// RepoBackupOutput is the output of "repo backup" and "repo restore".
+type RepoBackupOutput struct {
+	Path     string
+	Manifest *corerepo.BackupManifest
+}
+
+var repoBackupCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Save a snapshot of the repo.",
+		ShortDescription: `
+'ipfs repo backup' saves a consistent snapshot of the repo to a directory, or
+to a tar archive when the path ends with '.tar': the config, the keystore, the
+MFS root, the pins, the IPNS records and the blocks, in a CAR file. It can run
+while the daemon is running.
+`,
+		LongDescription: `
+'ipfs repo backup' saves a consistent snapshot of the repo to a directory, or
+to a tar archive when the path ends with '.tar': the config, the keystore, the
+MFS root, the pins, the IPNS records and the blocks, in a CAR file. It can run
+while the daemon is running: garbage collection waits until the backup is done.
+
+With --pinned-only, only the pinned blocks and the blocks reachable from MFS
+are saved.
+
+The path is on the machine the daemon runs on. The directory must not exist or
+be empty. A manifest.json with the checksums of the files of the backup is
+written last, and 'ipfs repo restore' verifies them.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("path", true, false, "Directory or tar archive to save the backup to."),
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption(repoPinnedOnlyOptionName, "Only save the pinned blocks and the blocks reachable from MFS."),
+	},
+	PreRun: func(req *cmds.Request, env cmds.Environment) error {
+		// the daemon does not run in the working directory of the client
+		p, err := filepath.Abs(req.Arguments[0])
+		if err != nil {
+			return err
+		}
+		req.Arguments[0] = p
+		return nil
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		p := req.Arguments[0]
+		if !filepath.IsAbs(p) {
+			return fmt.Errorf("backup path %q is not absolute", p)
+		}
+		pinnedOnly, _ := req.Options[repoPinnedOnlyOptionName].(bool)
+
+		m, err := corerepo.Backup(req.Context, n, p, corerepo.BackupOptions{PinnedOnly: pinnedOnly})
+		if err != nil {
+			return err
+		}
+		return cmds.EmitOnce(res, &RepoBackupOutput{Path: p, Manifest: m})
+	},
+	Type: RepoBackupOutput{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *RepoBackupOutput) error {
+			m := out.Manifest
+			_, err := fmt.Fprintf(w, "saved %d blocks, %d keys and %d datastore entries to %s\n", m.Blocks, m.Keys, m.Entries, out.Path)
+			return err
+		}),
+	},
+}
+
+var repoRestoreCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Restore a repo from a backup.",
+		ShortDescription: `
+'ipfs repo restore' initializes the repo from a backup written by 'ipfs repo
+backup', a directory or a tar archive. The checksums of the backup are verified
+before the repo is written, and the restored repo is checked to hold all the
+pinned blocks.
+
+The repo must not be initialized, and the daemon must not be running.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("path", true, false, "Directory or tar archive of the backup."),
+	},
+	NoRemote: true,
+	Extra:    CreateCmdExtras(SetDoesNotUseRepo(true)),
+	PreRun:   DaemonNotRunning,
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		cctx := env.(*oldcmds.Context)
+
+		m, err := corerepo.Restore(req.Context, cctx.ConfigRoot, req.Arguments[0])
+		if err != nil {
+			return err
+		}
+		return cmds.EmitOnce(res, &RepoBackupOutput{Path: cctx.ConfigRoot, Manifest: m})
+	},
+	Type: RepoBackupOutput{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *RepoBackupOutput) error {
+			m := out.Manifest
+			_, err := fmt.Fprintf(w, "restored %d blocks, %d keys and %d datastore entries of peer %s to %s\n", m.Blocks, m.Keys, m.Entries, m.PeerID, out.Path)
+			return err
+		}),
+	},
+}
@@ -0,0 +1,168 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	oldcmds "github.com/ipfs/kubo/commands"
+	config "github.com/ipfs/kubo/config"
+	cmdenv "github.com/ipfs/kubo/core/commands/cmdenv"
+	fsrepo "github.com/ipfs/kubo/repo/fsrepo"
+
+	cmds "github.com/ipfs/go-ipfs-cmds"
+)
+
+const (
+	repoToProfileOptionName = "to-profile"
+	repoToSpecOptionName    = "to-spec"
+	repoAbortOptionName     = "abort"
+)
+
+// RepoConvertOutput is the output of "repo convert": progress updates, then
+// a final message.
+type RepoConvertOutput struct {
+	fsrepo.ConvertProgress
+	Message string `json:",omitempty"`
+}
+
+var repoConvertCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Convert the repo to another datastore.",
+		ShortDescription: `
+'ipfs repo convert' copies the datastore of the repo to a new datastore, given
+by a profile (badgerds or flatfs) or by an explicit spec, then switches the
+repo to it.
+`,
+		LongDescription: `
+'ipfs repo convert' copies the datastore of the repo to a new datastore, given
+by a profile (badgerds or flatfs) or by an explicit Datastore.Spec in JSON,
+then switches the repo to it. The new datastore must not use any of the
+directories of the current one.
+
+The copy can run while the daemon is running, which takes most of the time.
+The daemon keeps using the current datastore: once the copy is done, stop the
+daemon and run 'ipfs repo convert' again. It copies what changed since, checks
+that both datastores have the same number of keys, and switches the
+'datastore_spec' and the config to the new datastore. The former datastore is
+left on disk, and can be removed once the node works with the new one.
+
+An interrupted conversion resumes where it stopped when 'ipfs repo convert' is
+run again, without options or with the same target. Blocks already copied are
+not copied again. Use --abort to drop the conversion and the new datastore.
+
+  # while the daemon runs
+  ipfs repo convert --to-profile=badgerds
+  # after stopping it
+  ipfs repo convert
+`,
+	},
+	Options: []cmds.Option{
+		cmds.StringOption(repoToProfileOptionName, "Profile setting the new datastore: badgerds or flatfs."),
+		cmds.StringOption(repoToSpecOptionName, "Datastore.Spec of the new datastore, in JSON."),
+		cmds.BoolOption(repoAbortOptionName, "Drop the conversion in progress and remove the new datastore."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		cctx := env.(*oldcmds.Context)
+
+		if abort, _ := req.Options[repoAbortOptionName].(bool); abort {
+			if err := fsrepo.AbortConvert(cctx.ConfigRoot); err != nil {
+				return err
+			}
+			return res.Emit(&RepoConvertOutput{Message: "datastore conversion aborted"})
+		}
+
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		cfg, err := n.Repo.Config()
+		if err != nil {
+			return err
+		}
+		spec, err := convertTarget(req, cfg)
+		if err != nil {
+			return err
+		}
+
+		conv, err := fsrepo.NewDatastoreConverter(cctx.ConfigRoot, n.Repo.Datastore(), spec)
+		if err != nil {
+			return err
+		}
+		defer conv.Close()
+
+		err = conv.Copy(req.Context, func(p fsrepo.ConvertProgress) {
+			// nothing to do if the client is gone, the copy can resume
+			_ = res.Emit(&RepoConvertOutput{ConvertProgress: p})
+		})
+		if err != nil {
+			return err
+		}
+
+		if n.IsDaemon {
+			return res.Emit(&RepoConvertOutput{Message: "datastore copied, stop the daemon and run 'ipfs repo convert' to finish"})
+		}
+
+		keys, err := conv.Verify(req.Context)
+		if err != nil {
+			return err
+		}
+		oldPaths, err := conv.Swap(n.Repo)
+		if err != nil {
+			return err
+		}
+		msg := fmt.Sprintf("datastore converted, %d keys", keys)
+		if len(oldPaths) > 0 {
+			msg += fmt.Sprintf("\nthe former datastore can be removed: %s", strings.Join(oldPaths, " "))
+		}
+		return res.Emit(&RepoConvertOutput{Message: msg})
+	},
+	Type: RepoConvertOutput{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *RepoConvertOutput) error {
+			if out.Message != "" {
+				_, err := fmt.Fprintf(w, "\n%s\n", out.Message)
+				return err
+			}
+			_, err := fmt.Fprintf(w, "%d keys copied, %d already there, %d removed\r", out.Copied, out.Skipped, out.Removed)
+			return err
+		}),
+	},
+}
+
+// convertTarget returns the spec of the datastore to convert to, or nil to
+// resume the conversion in progress.
+func convertTarget(req *cmds.Request, cfg *config.Config) (map[string]interface{}, error) {
+	profileName, hasProfile := req.Options[repoToProfileOptionName].(string)
+	specJSON, hasSpec := req.Options[repoToSpecOptionName].(string)
+	switch {
+	case hasProfile && hasSpec:
+		return nil, fmt.Errorf("--%s and --%s cannot be used together", repoToProfileOptionName, repoToSpecOptionName)
+	case hasSpec:
+		var spec map[string]interface{}
+		if err := json.Unmarshal([]byte(specJSON), &spec); err != nil {
+			return nil, fmt.Errorf("invalid datastore spec: %w", err)
+		}
+		return spec, nil
+	case hasProfile:
+		profile, ok := config.Profiles[profileName]
+		if !ok {
+			return nil, fmt.Errorf("%s is not a profile", profileName)
+		}
+		// only the datastore of the profile is applied
+		c, err := cfg.Clone()
+		if err != nil {
+			return nil, err
+		}
+		c.Datastore.Spec = nil
+		if err := profile.Transform(c); err != nil {
+			return nil, err
+		}
+		if c.Datastore.Spec == nil {
+			return nil, fmt.Errorf("profile %s does not set a datastore", profileName)
+		}
+		return c.Datastore.Spec, nil
+	}
+	return nil, nil
+}
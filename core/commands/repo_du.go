@@ -0,0 +1,79 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	cmdenv "github.com/ipfs/kubo/core/commands/cmdenv"
+	corerepo "github.com/ipfs/kubo/core/corerepo"
+
+	humanize "github.com/dustin/go-humanize"
+	cmds "github.com/ipfs/go-ipfs-cmds"
+)
+
+const repoRefreshOptionName = "refresh"
+
+var repoDuCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Show the space used by each pin and by MFS.",
+		ShortDescription: `
+'ipfs repo du' lists the pins and the entries at the root of MFS, with the
+space used by their blocks, the largest first:
+
+Exclusive  Size of the blocks only reachable from this root, freed when it is
+           unpinned or removed from MFS and the repo is garbage collected.
+Blocks     Number of blocks only reachable from this root.
+
+The total shows the size of the blocks reachable from several roots. Blocks
+that are not stored locally are not counted. The DAGs are walked once for all
+the roots, and the result is saved in the repo: it is shown again until the
+pins or MFS change. Use --refresh to walk the DAGs again.
+`,
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption(repoRefreshOptionName, "Walk the DAGs again, even if the pins and MFS did not change."),
+		cmds.BoolOption(repoHumanOptionName, "H", "Print sizes in human readable format (e.g., 1K 234M 2G)"),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		refresh, _ := req.Options[repoRefreshOptionName].(bool)
+		usage, err := corerepo.RepoUsage(req.Context, n, refresh)
+		if err != nil {
+			return err
+		}
+		return cmds.EmitOnce(res, usage)
+	},
+	Type: corerepo.Usage{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, u *corerepo.Usage) error {
+			wtr := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+
+			human, _ := req.Options[repoHumanOptionName].(bool)
+			size := func(s uint64) string {
+				if human {
+					return humanize.Bytes(s)
+				}
+				return fmt.Sprint(s)
+			}
+
+			fmt.Fprintln(wtr, "Exclusive\tBlocks\t\tRoot")
+			for _, r := range u.Roots {
+				name := fmt.Sprintf("%s (%s)", r.Cid, r.Pin)
+				if r.Path != "" {
+					name = fmt.Sprintf("%s (%s)", r.Path, r.Cid)
+				}
+				fmt.Fprintf(wtr, "%s\t%d\t\t%s\n", size(r.Exclusive), r.Blocks, name)
+			}
+			if err := wtr.Flush(); err != nil {
+				return err
+			}
+			_, err := fmt.Fprintf(w, "Total: %s in %d blocks, %s shared, computed at %s\n", size(u.Size), u.Blocks, size(u.Shared), u.Computed.Format(time.RFC3339))
+			return err
+		}),
+	},
+}
@@ -0,0 +1,92 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	cmdenv "github.com/ipfs/kubo/core/commands/cmdenv"
+	"github.com/ipfs/kubo/scrub"
+
+	humanize "github.com/dustin/go-humanize"
+	cmds "github.com/ipfs/go-ipfs-cmds"
+)
+
+var repoScrubCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Check the blocks of the repo in the background.",
+		ShortDescription: `
+When Datastore.ScrubInterval is set, the daemon checks the hashes of all the
+blocks of the repo every interval, reading Datastore.ScrubRate bytes per
+second. Corrupted blocks are moved to a quarantine in the datastore. The ones
+that are pinned or in MFS are fetched again from the network, and leave the
+quarantine once repaired.
+
+'ipfs repo verify' checks all the blocks at once, and only reports the
+corrupted ones.
+`,
+	},
+	Subcommands: map[string]*cmds.Command{
+		"status": repoScrubStatusCmd,
+	},
+}
+
+var repoScrubStatusCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Show the progress and the results of the scrubber.",
+		ShortDescription: `
+'ipfs repo scrub status' shows the progress of the current or of the last pass
+of the scrubber over the blocks of the repo, and the last corrupted blocks it
+found.
+`,
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		if n.Scrubber != nil {
+			status := n.Scrubber.Status()
+			return cmds.EmitOnce(res, &status)
+		}
+
+		// the status saved by the last scrubber of the repo
+		status, err := scrub.LoadStatus(req.Context, n.Repo.Datastore())
+		if err != nil {
+			return err
+		}
+		status.Running = false
+		return cmds.EmitOnce(res, &status)
+	},
+	Type: scrub.Status{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, s *scrub.Status) error {
+			switch {
+			case s.Running:
+				fmt.Fprintf(w, "Running since %s\n", s.Started.Format(time.RFC3339))
+			case s.Started.IsZero():
+				fmt.Fprintln(w, "Never run")
+			default:
+				fmt.Fprintln(w, "Not running")
+			}
+			if !s.Finished.IsZero() {
+				fmt.Fprintf(w, "Last pass finished at %s\n", s.Finished.Format(time.RFC3339))
+			}
+			if !s.Started.IsZero() {
+				fmt.Fprintf(w, "Blocks checked: %d (%s)\n", s.Checked, humanize.IBytes(s.CheckedSize))
+			}
+			fmt.Fprintf(w, "Corrupted blocks: %d, repaired: %d\n", s.Corrupted, s.Repaired)
+			for _, b := range s.Blocks {
+				state := "repaired"
+				if !b.Repaired {
+					state = "quarantined"
+					if b.Error != "" {
+						state += ": " + b.Error
+					}
+				}
+				fmt.Fprintf(w, "%s %s %s\n", b.Found.Format(time.RFC3339), b.Cid, state)
+			}
+			return nil
+		}),
+	},
+}
@@ -0,0 +1,76 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	humanize "github.com/dustin/go-humanize"
+	cmds "github.com/ipfs/go-ipfs-cmds"
+	exchange "github.com/ipfs/go-ipfs-exchange-interface"
+	"github.com/ipfs/kubo/core/commands/cmdenv"
+	"github.com/ipfs/kubo/exchange/trustless"
+)
+
+type GatewaysStatOutput struct {
+	Gateways []trustless.EndpointStat
+}
+
+var statGatewaysCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Returns statistics about the trustless gateways blocks are fetched from.",
+		ShortDescription: `
+Returns, for every gateway in HTTPRetrieval.Gateways, the number of requests
+made to it, how many failed, the bytes received and the average latency of
+successful requests.
+
+This interface is not stable and may change from release to release.
+`,
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		nd, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		if !nd.IsOnline {
+			return ErrNotOnline
+		}
+
+		ex, ok := trustlessFromExchange(nd.Exchange)
+		if !ok {
+			return fmt.Errorf("fetching blocks from gateways is disabled, see HTTPRetrieval.Gateways")
+		}
+
+		return cmds.EmitOnce(res, &GatewaysStatOutput{Gateways: ex.Client().Stats()})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *GatewaysStatOutput) error {
+			wtr := tabwriter.NewWriter(w, 1, 2, 1, ' ', 0)
+			defer wtr.Flush()
+
+			fmt.Fprintf(wtr, "URL\tRequests\tErrors\tReceived\tAvgLatency\tLastError\n")
+			for _, s := range out.Gateways {
+				fmt.Fprintf(wtr, "%s\t%d\t%d\t%s\t%s\t%s\n",
+					s.URL, s.Requests, s.Errors, humanize.Bytes(s.BytesReceived), humanDuration(s.AverageLatency), s.LastError)
+			}
+			return nil
+		}),
+	},
+	Type: GatewaysStatOutput{},
+}
+
+// trustlessFromExchange returns the exchange fetching from trustless gateways,
+// if any, looking through the exchanges wrapping it.
+func trustlessFromExchange(ex exchange.Interface) (*trustless.Exchange, bool) {
+	for {
+		if t, ok := ex.(*trustless.Exchange); ok {
+			return t, true
+		}
+		w, ok := ex.(interface{ Unwrap() exchange.Interface })
+		if !ok {
+			return nil, false
+		}
+		ex = w.Unwrap()
+	}
+}
@@ -10,14 +10,27 @@ import (
 	cmds "github.com/ipfs/go-ipfs-cmds"
 	"github.com/ipfs/kubo/core/commands/cmdenv"
 
+	provider "github.com/ipfs/go-ipfs-provider"
 	"github.com/ipfs/go-ipfs-provider/batched"
+	"github.com/ipfs/kubo/reprovide"
 )
 
+type ProvideStatOutput struct {
+	*batched.BatchedProviderStats `json:",omitempty"`
+	Reprovide                     *reprovide.Progress `json:",omitempty"`
+}
+
 var statProvideCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline: "Returns statistics about the node's (re)provider system.",
 		ShortDescription: `
-Returns statistics about the content the node is advertising.
+Returns statistics about the content the node is advertising, including the
+progress of the running reprovide cycle: keys provided so far, the estimated
+total from the last cycle, the rate and the estimated time left. Cycles
+interrupted by a restart resume after the keys they already provided.
+
+Provide batch statistics are only available when
+Experimental.AcceleratedDHTClient is enabled.
 
 This interface is not stable and may change from release to release.
 `,
@@ -34,35 +47,87 @@ This interface is not stable and may change from release to release.
 			return ErrNotOnline
 		}
 
-		sys, ok := nd.Provider.(*batched.BatchProvidingSystem)
-		if !ok {
-			return fmt.Errorf("can only return stats if Experimental.AcceleratedDHTClient is enabled")
+		var out ProvideStatOutput
+		if sys, ok := batchedProviderSystem(nd.Provider); ok {
+			stats, err := sys.Stat(req.Context)
+			if err != nil {
+				return err
+			}
+			out.BatchedProviderStats = &stats
 		}
-
-		stats, err := sys.Stat(req.Context)
-		if err != nil {
-			return err
+		if nd.Reprovide != nil {
+			p := nd.Reprovide.Progress()
+			out.Reprovide = &p
 		}
-
-		if err := res.Emit(stats); err != nil {
-			return err
+		if out.BatchedProviderStats == nil && out.Reprovide == nil {
+			return fmt.Errorf("no provider statistics available, content is not reprovided")
 		}
 
-		return nil
+		return cmds.EmitOnce(res, &out)
 	},
 	Encoders: cmds.EncoderMap{
-		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, s *batched.BatchedProviderStats) error {
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *ProvideStatOutput) error {
 			wtr := tabwriter.NewWriter(w, 1, 2, 1, ' ', 0)
 			defer wtr.Flush()
 
-			fmt.Fprintf(wtr, "TotalProvides:\t%s\n", humanNumber(s.TotalProvides))
-			fmt.Fprintf(wtr, "AvgProvideDuration:\t%s\n", humanDuration(s.AvgProvideDuration))
-			fmt.Fprintf(wtr, "LastReprovideDuration:\t%s\n", humanDuration(s.LastReprovideDuration))
-			fmt.Fprintf(wtr, "LastReprovideBatchSize:\t%s\n", humanNumber(s.LastReprovideBatchSize))
+			if s := out.BatchedProviderStats; s != nil {
+				fmt.Fprintf(wtr, "TotalProvides:\t%s\n", humanNumber(s.TotalProvides))
+				fmt.Fprintf(wtr, "AvgProvideDuration:\t%s\n", humanDuration(s.AvgProvideDuration))
+				fmt.Fprintf(wtr, "LastReprovideDuration:\t%s\n", humanDuration(s.LastReprovideDuration))
+				fmt.Fprintf(wtr, "LastReprovideBatchSize:\t%s\n", humanNumber(s.LastReprovideBatchSize))
+			}
+			if p := out.Reprovide; p != nil {
+				if p.Running {
+					total := "unknown"
+					if p.Total > 0 {
+						total = humanNumber(int(p.Total))
+					}
+					fmt.Fprintf(wtr, "CycleStarted:\t%s\n", p.CycleStarted.Format(time.RFC3339))
+					fmt.Fprintf(wtr, "CycleProvided:\t%s\n", humanNumber(int(p.Provided)))
+					fmt.Fprintf(wtr, "CycleTotal:\t%s\n", total)
+					if p.Resumed > 0 {
+						fmt.Fprintf(wtr, "CycleResumedAfter:\t%s\n", humanNumber(int(p.Resumed)))
+					}
+					fmt.Fprintf(wtr, "KeysPerSecond:\t%.1f\n", p.KeysPerSecond)
+					if p.ETA > 0 {
+						fmt.Fprintf(wtr, "ETA:\t%s\n", p.ETA.Truncate(time.Second))
+					}
+				} else {
+					fmt.Fprintf(wtr, "CycleRunning:\tfalse\n")
+				}
+				if !p.LastCycleEnd.IsZero() {
+					fmt.Fprintf(wtr, "LastCycleEnd:\t%s\n", p.LastCycleEnd.Format(time.RFC3339))
+					fmt.Fprintf(wtr, "LastCycleDuration:\t%s\n", humanDuration(p.LastCycleDuration))
+					fmt.Fprintf(wtr, "LastCycleKeys:\t%s\n", humanNumber(int(p.LastCycleKeys)))
+				}
+				fmt.Fprintf(wtr, "Failures:\t%s\n", humanNumber(int(p.Failures)))
+				if p.LastFailure != "" {
+					fmt.Fprintf(wtr, "LastFailure:\t%s\n", p.LastFailure)
+				}
+				fmt.Fprintf(wtr, "PrioritizedProvides:\t%s\n", humanNumber(int(p.Prioritized)))
+				if p.Interval > 0 && (p.LastCycleDuration > p.Interval || (p.Running && time.Since(p.CycleStarted)+p.ETA > p.Interval)) {
+					fmt.Fprintf(wtr, "Warning:\treprovide cycles take longer than Reprovider.Interval (%s)\n", p.Interval)
+				}
+			}
 			return nil
 		}),
 	},
-	Type: batched.BatchedProviderStats{},
+	Type: ProvideStatOutput{},
+}
+
+// batchedProviderSystem returns the batched provider system behind sys, if
+// any, looking through the systems wrapping it.
+func batchedProviderSystem(sys provider.System) (*batched.BatchProvidingSystem, bool) {
+	for {
+		if b, ok := sys.(*batched.BatchProvidingSystem); ok {
+			return b, true
+		}
+		w, ok := sys.(interface{ Unwrap() provider.System })
+		if !ok {
+			return nil, false
+		}
+		sys = w.Unwrap()
+	}
 }
 
 func humanDuration(val time.Duration) string {
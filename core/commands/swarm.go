@@ -9,6 +9,7 @@ import (
 	"io"
 	"path"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -17,6 +18,7 @@ import (
 	"github.com/ipfs/kubo/config"
 	"github.com/ipfs/kubo/core/commands/cmdenv"
 	"github.com/ipfs/kubo/core/node/libp2p"
+	"github.com/ipfs/kubo/peering"
 	"github.com/ipfs/kubo/repo"
 	"github.com/ipfs/kubo/repo/fsrepo"
 
@@ -57,8 +59,9 @@ ipfs peers in the internet.
 		"filters":    swarmFiltersCmd,
 		"peers":      swarmPeersCmd,
 		"peering":    swarmPeeringCmd,
-		"stats":      swarmStatsCmd, // libp2p Network Resource Manager
-		"limit":      swarmLimitCmd, // libp2p Network Resource Manager
+		"stats":      swarmStatsCmd,     // libp2p Network Resource Manager
+		"limit":      swarmLimitCmd,     // libp2p Network Resource Manager
+		"allowlist":  swarmAllowlistCmd, // libp2p Network Resource Manager
 	},
 }
 
@@ -81,7 +84,8 @@ var swarmPeeringCmd = &cmds.Command{
 'ipfs swarm peering' manages the peering subsystem. 
 Peers in the peering subsystem are maintained to be connected, reconnected 
 on disconnect with a back-off.
-The changes are not saved to the config.
+The changes are not saved to the config. Peers of Peering.Groups removed with
+'ipfs swarm peering rm' are added back when their group is resolved again.
 `,
 	},
 	Subcommands: map[string]*cmds.Command{
@@ -146,32 +150,128 @@ var swarmPeeringLsCmd = &cmds.Command{
 		Tagline: "List peers registered in the peering subsystem.",
 		ShortDescription: `
 'ipfs swarm peering ls' lists the peers that are registered in the peering subsystem and to which the daemon is always connected.
+Each peer is listed with its sources: "config" for Peering.Peers, "manual" for 'ipfs swarm peering add', or the Peering.Groups it was resolved from.
+With --verbose, the connection health of each peer is reported too: its uptime, how many times it disconnected, the reconnect attempts and their last error, its latency, the reconnect backoff and the last connection state changes.
 `,
 	},
+	Options: []cmds.Option{
+		cmds.BoolOption(swarmVerboseOptionName, "v", "Report the connection health of the peers."),
+	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		node, err := cmdenv.GetNode(env)
 		if err != nil {
 			return err
 		}
+		verbose, _ := req.Options[swarmVerboseOptionName].(bool)
+
+		stats := make(map[peer.ID]peering.PeerStat)
+		if verbose {
+			for _, stat := range node.Peering.ListPeerStats() {
+				stats[stat.ID] = stat
+			}
+		}
+
 		peers := node.Peering.ListPeers()
-		return cmds.EmitOnce(res, addrInfos{Peers: peers})
+		out := peeringPeers{Peers: make([]peeringPeer, 0, len(peers))}
+		for _, info := range peers {
+			pp := peeringPeer{
+				ID:      info.ID.String(),
+				Addrs:   make([]string, 0, len(info.Addrs)),
+				Sources: node.Peering.Sources(info.ID),
+			}
+			for _, addr := range info.Addrs {
+				pp.Addrs = append(pp.Addrs, addr.String())
+			}
+			if stat, ok := stats[info.ID]; ok {
+				pp.Health = newPeeringHealth(stat)
+			}
+			out.Peers = append(out.Peers, pp)
+		}
+		return cmds.EmitOnce(res, &out)
 	},
-	Type: addrInfos{},
+	Type: peeringPeers{},
 	Encoders: cmds.EncoderMap{
-		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, ai *addrInfos) error {
-			for _, info := range ai.Peers {
-				fmt.Fprintf(w, "%s\n", info.ID)
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, pp *peeringPeers) error {
+			for _, info := range pp.Peers {
+				fmt.Fprintf(w, "%s (%s)\n", info.ID, strings.Join(info.Sources, ", "))
 				for _, addr := range info.Addrs {
 					fmt.Fprintf(w, "\t%s\n", addr)
 				}
+				if h := info.Health; h != nil {
+					if h.Connected {
+						fmt.Fprintf(w, "\tconnected since %s, uptime %s\n", h.ConnectedSince.Format(time.RFC3339), h.Uptime)
+					} else {
+						fmt.Fprintf(w, "\tdisconnected, uptime %s\n", h.Uptime)
+					}
+					fmt.Fprintf(w, "\tdisconnects: %d, reconnect attempts: %d\n", h.Disconnects, h.ReconnectAttempts)
+					if h.Latency != "" {
+						fmt.Fprintf(w, "\tlatency: %s\n", h.Latency)
+					}
+					if h.LastError != "" {
+						fmt.Fprintf(w, "\tlast error at %s: %s\n", h.LastErrorTime.Format(time.RFC3339), strings.Join(strings.Fields(h.LastError), " "))
+					}
+					if h.Backoff != "" {
+						fmt.Fprintf(w, "\tbackoff: %s, next attempt at %s\n", h.Backoff, h.NextAttempt.Format(time.RFC3339))
+					}
+					for _, e := range h.History {
+						fmt.Fprintf(w, "\t%s %s\n", e.Time.Format(time.RFC3339), e.Event)
+					}
+				}
 			}
 			return nil
 		}),
 	},
 }
 
-type addrInfos struct {
-	Peers []peer.AddrInfo
+type peeringPeer struct {
+	ID      string
+	Addrs   []string
+	Sources []string
+	Health  *peeringHealth `json:",omitempty"`
+}
+
+// peeringHealth is the connection health of a peer of the peering subsystem.
+type peeringHealth struct {
+	Connected         bool
+	ConnectedSince    *time.Time `json:",omitempty"`
+	Uptime            string
+	Disconnects       uint64
+	ReconnectAttempts uint64
+	LastError         string     `json:",omitempty"`
+	LastErrorTime     *time.Time `json:",omitempty"`
+	Latency           string     `json:",omitempty"`
+	Backoff           string     `json:",omitempty"`
+	NextAttempt       *time.Time `json:",omitempty"`
+	History           []peering.PeerEvent
+}
+
+func newPeeringHealth(stat peering.PeerStat) *peeringHealth {
+	h := &peeringHealth{
+		Connected:         stat.Connected,
+		Uptime:            stat.Uptime.Round(time.Second).String(),
+		Disconnects:       stat.Disconnects,
+		ReconnectAttempts: stat.ReconnectAttempts,
+		LastError:         stat.LastError,
+		History:           stat.History,
+	}
+	if stat.Connected {
+		h.ConnectedSince = &stat.ConnectedSince
+	}
+	if stat.LastError != "" {
+		h.LastErrorTime = &stat.LastErrorTime
+	}
+	if stat.Latency > 0 {
+		h.Latency = stat.Latency.String()
+	}
+	if stat.Backoff > 0 {
+		h.Backoff = stat.Backoff.Round(time.Second).String()
+		h.NextAttempt = &stat.NextAttempt
+	}
+	return h
+}
+
+type peeringPeers struct {
+	Peers []peeringPeer
 }
 
 var swarmPeeringRmCmd = &cmds.Command{
@@ -322,6 +422,7 @@ The scope can be one of the following:
 - svc:<service> -- reports the resource usage of a specific service.
 - proto:<proto> -- reports the resource usage of a specific protocol.
 - peer:<peer>   -- reports the resource usage of a specific peer.
+- allowlist     -- reports the resource usage of the allowlisted peers.
 - all           -- reports the resource usage for all currently active scopes.
 
 The output of this command is JSON.
@@ -372,6 +473,8 @@ The scope can be one of the following:
 - svc:<service> -- limits for the resource usage of a specific service.
 - proto:<proto> -- limits for the resource usage of a specific protocol.
 - peer:<peer>   -- limits for the resource usage of a specific peer.
+- allowlist     -- limits for the resource usage of the allowlisted peers
+                   (read-only, they follow the system limits).
 
 The output of this command is JSON.
 
@@ -381,7 +484,9 @@ It is possible to use this command to inspect and tweak limits at runtime:
 	$ vi limit.json
 	$ ipfs swarm limit system limit.json
 
-Changes made via command line are persisted in the Swarm.ResourceMgr.Limits field of the $IPFS_PATH/config file.
+Changes made via command line are persisted in the Swarm.ResourceMgr.Limits field of the $IPFS_PATH/config file,
+before being applied. Changes of Swarm.ResourceMgr made to the config file are applied to the running daemon within
+a few seconds, without restarting it.
 `},
 	Arguments: []cmds.Argument{
 		cmds.StringArg("scope", true, false, "scope of the limit"),
@@ -437,6 +542,124 @@ Changes made via command line are persisted in the Swarm.ResourceMgr.Limits fiel
 	},
 }
 
+var swarmAllowlistCmd = &cmds.Command{
+	Status: cmds.Experimental,
+	Helptext: cmds.HelpText{
+		Tagline: "Manage the resource manager allowlist.",
+		ShortDescription: `
+'ipfs swarm allowlist' lists the peers and address ranges whose connections
+are accounted in a separate resource manager scope, so they are not refused
+when the regular limits are exhausted. Entries are multiaddrs made of an IP
+address or range, a peer ID, or both:
+
+    /ip4/192.168.0.0/ipcidr/16
+    /p2p/QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN
+    /ip4/1.2.3.4/p2p/QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN
+
+The allowlist defaults to the "Swarm.ResourceMgr.Allowlist" config key.
+`,
+	},
+	Subcommands: map[string]*cmds.Command{
+		"add": swarmAllowlistAddCmd,
+		"ls":  swarmAllowlistLsCmd,
+		"rm":  swarmAllowlistRmCmd,
+	},
+}
+
+var swarmAllowlistLsCmd = &cmds.Command{
+	Status: cmds.Experimental,
+	Helptext: cmds.HelpText{
+		Tagline: "List the resource manager allowlist.",
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		node, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		if node.ResourceManager == nil {
+			return libp2p.NoResourceMgrError
+		}
+
+		allowlist, err := libp2p.NetAllowlist(node.ResourceManager)
+		if err != nil {
+			return err
+		}
+		return cmds.EmitOnce(res, &stringList{allowlist})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(safeTextListEncoder),
+	},
+	Type: stringList{},
+}
+
+var swarmAllowlistAddCmd = &cmds.Command{
+	Status: cmds.Experimental,
+	Helptext: cmds.HelpText{
+		Tagline: "Add entries to the resource manager allowlist.",
+		ShortDescription: `
+'ipfs swarm allowlist add' persists the entries to the
+Swarm.ResourceMgr.Allowlist config key, then applies them to new connections.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("address", true, true, "Multiaddr to allowlist.").EnableStdin(),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		node, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		if node.ResourceManager == nil {
+			return libp2p.NoResourceMgrError
+		}
+
+		if err := libp2p.NetAllowlistAdd(node.ResourceManager, node.Repo, req.Arguments); err != nil {
+			return err
+		}
+		return cmds.EmitOnce(res, &stringList{req.Arguments})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(safeTextListEncoder),
+	},
+	Type: stringList{},
+}
+
+var swarmAllowlistRmCmd = &cmds.Command{
+	Status: cmds.Experimental,
+	Helptext: cmds.HelpText{
+		Tagline: "Remove entries from the resource manager allowlist.",
+		ShortDescription: `
+'ipfs swarm allowlist rm' removes the entries from the
+Swarm.ResourceMgr.Allowlist config key. Connections that were already
+allowlisted keep their scope until they are closed.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("address", true, true, "Multiaddr to remove from the allowlist.").EnableStdin(),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		node, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		if node.ResourceManager == nil {
+			return libp2p.NoResourceMgrError
+		}
+
+		if err := libp2p.NetAllowlistRemove(node.ResourceManager, node.Repo, req.Arguments); err != nil {
+			return err
+		}
+		return cmds.EmitOnce(res, &stringList{req.Arguments})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(safeTextListEncoder),
+	},
+	Type: stringList{},
+}
+
 type streamInfo struct {
 	Protocol string
 }
@@ -828,11 +1051,14 @@ Where the above is equivalent to the standard CIDR:
     192.168.0.0/16
 
 Filters default to those specified under the "Swarm.AddrFilters" config key.
+Inbound connections can also be filtered by autonomous system, agent version
+or supported protocols with the rules of the "Swarm.ConnFilters" config key.
 `,
 	},
 	Subcommands: map[string]*cmds.Command{
 		"add": swarmFiltersAddCmd,
 		"rm":  swarmFiltersRmCmd,
+		"log": swarmFiltersLogCmd,
 	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		n, err := cmdenv.GetNode(env)
@@ -982,6 +1208,50 @@ var swarmFiltersRmCmd = &cmds.Command{
 	Type: stringList{},
 }
 
+var swarmFiltersLogCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "List the inbound connections blocked by the filters.",
+		ShortDescription: `
+'ipfs swarm filters log' lists the last inbound connections blocked by the
+"Swarm.AddrFilters" or the "Swarm.ConnFilters" rules, oldest first, with the
+filter that blocked them and why.
+`,
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		if n.PeerHost == nil || n.ConnFilter == nil {
+			return ErrNotOnline
+		}
+
+		return cmds.EmitOnce(res, &filtersLog{Entries: n.ConnFilter.Log()})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, l *filtersLog) error {
+			for _, e := range l.Entries {
+				p := e.Peer
+				if p == "" {
+					p = "-"
+				}
+				fmt.Fprintf(w, "%s %s %s %s", e.Time.Format(time.RFC3339), e.Addr, p, e.Filter)
+				if e.Reason != "" {
+					fmt.Fprintf(w, " (%s)", e.Reason)
+				}
+				fmt.Fprintln(w)
+			}
+			return nil
+		}),
+	},
+	Type: filtersLog{},
+}
+
+type filtersLog struct {
+	Entries []libp2p.FilterLogEntry
+}
+
 func filtersAdd(r repo.Repo, cfg *config.Config, filters []string) ([]string, error) {
 	addedMap := map[string]struct{}{}
 	addedList := make([]string, 0, len(filters))
@@ -45,14 +45,19 @@ import (
 
 	"github.com/ipfs/go-namesys"
 	ipnsrp "github.com/ipfs/go-namesys/republisher"
+	"github.com/ipfs/kubo/blocks/blockcache"
 	"github.com/ipfs/kubo/core/bootstrap"
 	"github.com/ipfs/kubo/core/node"
 	"github.com/ipfs/kubo/core/node/libp2p"
+	"github.com/ipfs/kubo/exchange/policy"
 	"github.com/ipfs/kubo/fuse/mount"
 	"github.com/ipfs/kubo/p2p"
 	"github.com/ipfs/kubo/peering"
+	"github.com/ipfs/kubo/pubsub/history"
 	"github.com/ipfs/kubo/repo"
+	"github.com/ipfs/kubo/reprovide"
 	irouting "github.com/ipfs/kubo/routing"
+	"github.com/ipfs/kubo/scrub"
 )
 
 var log = logging.Logger("core")
@@ -67,6 +72,7 @@ type IpfsNode struct {
 
 	// Local node
 	Pinning         pin.Pinner             // the pinning manager
+	NoProvide       *reprovide.Exclusions  // pins that must not be provided
 	Mounts          Mounts                 `optional:"true"` // current mount state, if any.
 	PrivateKey      ic.PrivKey             `optional:"true"` // the local node's private Key
 	PNetFingerprint libp2p.PNetFingerprint `optional:"true"` // fingerprint of private network
@@ -76,6 +82,9 @@ type IpfsNode struct {
 	Blockstore           bstore.GCBlockstore       // the block store (lower level)
 	Filestore            *filestore.Filestore      `optional:"true"` // the filestore blockstore
 	BaseBlocks           node.BaseBlocks           // the raw blockstore, no filestore wrapping
+	BlockBloom           *blockcache.Bloom         `optional:"true"` // the bloom filter sized from the number of blocks, if any
+	BlockCache           *blockcache.Cache         `optional:"true"` // the cache of block data, if any
+	Scrubber             *scrub.Scrubber           `optional:"true"` // the scrubber of the blocks, if running
 	GCLocker             bstore.GCLocker           // the locker used to protect the blockstore during gc
 	Blocks               bserv.BlockService        // the block service, get/add blocks.
 	DAG                  ipld.DAGService           // the merkle dag service, get/add objects.
@@ -90,18 +99,22 @@ type IpfsNode struct {
 	PeerHost        p2phost.Host            `optional:"true"` // the network host (server+client)
 	Peering         *peering.PeeringService `optional:"true"`
 	Filters         *ma.Filters             `optional:"true"`
+	ConnFilter      *libp2p.ConnFilter      `optional:"true"`
 	Bootstrapper    io.Closer               `optional:"true"` // the periodic bootstrapper
 	Routing         irouting.TieredRouter   `optional:"true"` // the routing system. recommend ipfs-dht
 	DNSResolver     *madns.Resolver         // the DNS resolver
 	Exchange        exchange.Interface      // the block exchange + strategy (bitswap)
+	BitswapLimiter  *policy.Limiter         `optional:"true"` // per-peer bitswap rate limits, if any
 	Namesys         namesys.NameSystem      // the name system, resolves paths to hashes
 	Provider        provider.System         // the value provider system
+	Reprovide       *reprovide.Tracker      `optional:"true"` // progress of the reprovide cycles
 	IpnsRepub       *ipnsrp.Republisher     `optional:"true"`
 	GraphExchange   graphsync.GraphExchange `optional:"true"`
 	ResourceManager network.ResourceManager `optional:"true"`
 
-	PubSub   *pubsub.PubSub             `optional:"true"`
-	PSRouter *psrouter.PubsubValueStore `optional:"true"`
+	PubSub        *pubsub.PubSub             `optional:"true"`
+	PubsubHistory *history.Service           `optional:"true"`
+	PSRouter      *psrouter.PubsubValueStore `optional:"true"`
 
 	DHT       *ddht.DHT       `optional:"true"`
 	DHTClient routing.Routing `name:"dhtc" optional:"true"`
@@ -124,6 +137,7 @@ type IpfsNode struct {
 type Mounts struct {
 	Ipfs mount.Mount
 	Ipns mount.Mount
+	Mfs  mount.Mount
 }
 
 // Close calls Close() on the App object
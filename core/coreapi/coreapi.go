@@ -43,6 +43,7 @@ import (
 	"github.com/ipfs/kubo/core"
 	"github.com/ipfs/kubo/core/node"
 	"github.com/ipfs/kubo/repo"
+	"github.com/ipfs/kubo/reprovide"
 )
 
 type CoreAPI struct {
@@ -69,7 +70,8 @@ type CoreAPI struct {
 	routing     routing.Routing
 	dnsResolver *madns.Resolver
 
-	provider provider.System
+	provider          provider.System
+	provideExclusions *reprovide.Exclusions
 
 	pubSub *pubsub.PubSub
 
@@ -182,7 +184,8 @@ func (api *CoreAPI) WithOptions(opts ...options.ApiOption) (coreiface.CoreAPI, e
 		routing:         n.Routing,
 		dnsResolver:     n.DNSResolver,
 
-		provider: n.Provider,
+		provider:          n.Provider,
+		provideExclusions: n.NoProvide,
 
 		pubSub: n.PubSub,
 
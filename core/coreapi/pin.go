@@ -19,7 +19,21 @@ import (
 
 type PinAPI CoreAPI
 
+// PinAddSettings are the settings of PinAPI.AddWithSettings that
+// caopts.PinAddSettings has no field for.
+type PinAddSettings struct {
+	// NoProvide keeps the pinned content from being announced to the
+	// routing system by the pinned reprovider strategies, see
+	// Reprovider.Strategy.
+	NoProvide bool
+}
+
 func (api *PinAPI) Add(ctx context.Context, p path.Path, opts ...caopts.PinAddOption) error {
+	return api.AddWithSettings(ctx, p, PinAddSettings{}, opts...)
+}
+
+// AddWithSettings is Add, with the settings specific to this PinAPI.
+func (api *PinAPI) AddWithSettings(ctx context.Context, p path.Path, local PinAddSettings, opts ...caopts.PinAddOption) error {
 	ctx, span := tracing.Span(ctx, "CoreAPI.PinAPI", "Add", trace.WithAttributes(attribute.String("path", p.String())))
 	defer span.End()
 
@@ -33,7 +47,7 @@ func (api *PinAPI) Add(ctx context.Context, p path.Path, opts ...caopts.PinAddOp
 		return err
 	}
 
-	span.SetAttributes(attribute.Bool("recursive", settings.Recursive))
+	span.SetAttributes(attribute.Bool("recursive", settings.Recursive), attribute.Bool("noprovide", local.NoProvide))
 
 	defer api.blockstore.PinLock(ctx).Unlock(ctx)
 
@@ -42,8 +56,21 @@ func (api *PinAPI) Add(ctx context.Context, p path.Path, opts ...caopts.PinAddOp
 		return fmt.Errorf("pin: %s", err)
 	}
 
-	if err := api.provider.Provide(dagNode.Cid()); err != nil {
-		return err
+	// pinning again without NoProvide announces the pin again
+	if api.provideExclusions != nil {
+		if local.NoProvide {
+			err = api.provideExclusions.Add(ctx, dagNode.Cid())
+		} else {
+			err = api.provideExclusions.Remove(ctx, dagNode.Cid())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if !local.NoProvide {
+		if err := api.provider.Provide(dagNode.Cid()); err != nil {
+			return err
+		}
 	}
 
 	return api.pinning.Flush(ctx)
@@ -118,7 +145,10 @@ func (api *PinAPI) Rm(ctx context.Context, p path.Path, opts ...caopts.PinRmOpti
 		return err
 	}
 
-	return api.pinning.Flush(ctx)
+	if err := api.pinning.Flush(ctx); err != nil {
+		return err
+	}
+	return api.removeProvideExclusion(ctx, rp.Cid())
 }
 
 func (api *PinAPI) Update(ctx context.Context, from path.Path, to path.Path, opts ...caopts.PinUpdateOption) error {
@@ -152,7 +182,37 @@ func (api *PinAPI) Update(ctx context.Context, from path.Path, to path.Path, opt
 		return err
 	}
 
-	return api.pinning.Flush(ctx)
+	if err := api.pinning.Flush(ctx); err != nil {
+		return err
+	}
+
+	// the updated pin keeps being excluded from providing
+	excluded, err := api.provideExcluded(ctx, fp.Cid())
+	if err != nil || !excluded {
+		return err
+	}
+	if err := api.provideExclusions.Add(ctx, tp.Cid()); err != nil {
+		return err
+	}
+	if settings.Unpin {
+		return api.removeProvideExclusion(ctx, fp.Cid())
+	}
+	return nil
+}
+
+// provideExcluded returns whether the pin c must not be provided.
+func (api *PinAPI) provideExcluded(ctx context.Context, c cid.Cid) (bool, error) {
+	if api.provideExclusions == nil {
+		return false, nil
+	}
+	return api.provideExclusions.Has(ctx, c)
+}
+
+func (api *PinAPI) removeProvideExclusion(ctx context.Context, c cid.Cid) error {
+	if api.provideExclusions == nil {
+		return nil
+	}
+	return api.provideExclusions.Remove(ctx, c)
 }
 
 type pinStatus struct {
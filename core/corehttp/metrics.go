@@ -134,6 +134,98 @@ var (
 		[]string{"transport"},
 		nil,
 	)
+
+	peeringConnectedMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("ipfs", "peering", "connected"),
+		"Whether the peering peer is connected",
+		[]string{"peer_id"},
+		nil,
+	)
+	peeringUptimeMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("ipfs", "peering", "uptime_seconds_total"),
+		"Time connected to the peering peer since it was added",
+		[]string{"peer_id"},
+		nil,
+	)
+	peeringDisconnectsMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("ipfs", "peering", "disconnects_total"),
+		"Number of connections to the peering peer that were lost",
+		[]string{"peer_id"},
+		nil,
+	)
+	peeringReconnectAttemptsMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("ipfs", "peering", "reconnect_attempts_total"),
+		"Number of attempts to reconnect to the peering peer",
+		[]string{"peer_id"},
+		nil,
+	)
+	peeringLatencyMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("ipfs", "peering", "latency_seconds"),
+		"Moving average of the latency to the peering peer",
+		[]string{"peer_id"},
+		nil,
+	)
+	peeringBackoffMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("ipfs", "peering", "backoff_seconds"),
+		"Delay between the attempts to reconnect to the peering peer, 0 when connected",
+		[]string{"peer_id"},
+		nil,
+	)
+
+	blockCacheHitsMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("ipfs", "blockstore", "cache_hits_total"),
+		"Number of blocks read from the cache of block data",
+		nil,
+		nil,
+	)
+	blockCacheMissesMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("ipfs", "blockstore", "cache_misses_total"),
+		"Number of blocks read from the datastore, missing from the cache of block data",
+		nil,
+		nil,
+	)
+	blockCacheSizeMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("ipfs", "blockstore", "cache_size_bytes"),
+		"Size of the blocks in the cache of block data",
+		nil,
+		nil,
+	)
+	blockCacheBlocksMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("ipfs", "blockstore", "cache_blocks"),
+		"Number of blocks in the cache of block data",
+		nil,
+		nil,
+	)
+	bloomRequestsMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("ipfs", "blockstore", "bloom_requests_total"),
+		"Number of blocks looked up in the bloom filter",
+		nil,
+		nil,
+	)
+	bloomNegativesMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("ipfs", "blockstore", "bloom_negatives_total"),
+		"Number of blocks found missing by the bloom filter, without reading the datastore",
+		nil,
+		nil,
+	)
+	bloomSizeMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("ipfs", "blockstore", "bloom_size_bytes"),
+		"Size of the bloom filter",
+		nil,
+		nil,
+	)
+	bloomKeysMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("ipfs", "blockstore", "bloom_keys"),
+		"Number of blocks when the bloom filter was last built",
+		nil,
+		nil,
+	)
+	bloomBuildsMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("ipfs", "blockstore", "bloom_builds_total"),
+		"Number of times the bloom filter was built",
+		nil,
+		nil,
+	)
 )
 
 type IpfsNodeCollector struct {
@@ -142,6 +234,21 @@ type IpfsNodeCollector struct {
 
 func (_ IpfsNodeCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- peersTotalMetric
+	ch <- peeringConnectedMetric
+	ch <- peeringUptimeMetric
+	ch <- peeringDisconnectsMetric
+	ch <- peeringReconnectAttemptsMetric
+	ch <- peeringLatencyMetric
+	ch <- peeringBackoffMetric
+	ch <- blockCacheHitsMetric
+	ch <- blockCacheMissesMetric
+	ch <- blockCacheSizeMetric
+	ch <- blockCacheBlocksMetric
+	ch <- bloomRequestsMetric
+	ch <- bloomNegativesMetric
+	ch <- bloomSizeMetric
+	ch <- bloomKeysMetric
+	ch <- bloomBuildsMetric
 }
 
 func (c IpfsNodeCollector) Collect(ch chan<- prometheus.Metric) {
@@ -153,6 +260,49 @@ func (c IpfsNodeCollector) Collect(ch chan<- prometheus.Metric) {
 			tr,
 		)
 	}
+	c.collectPeering(ch)
+	c.collectBlockCaches(ch)
+}
+
+// collectPeering reports the connection health of the peers of the peering
+// subsystem.
+func (c IpfsNodeCollector) collectPeering(ch chan<- prometheus.Metric) {
+	if c.Node.Peering == nil {
+		return
+	}
+	for _, stat := range c.Node.Peering.ListPeerStats() {
+		id := stat.ID.String()
+		connected := 0.0
+		if stat.Connected {
+			connected = 1
+		}
+		ch <- prometheus.MustNewConstMetric(peeringConnectedMetric, prometheus.GaugeValue, connected, id)
+		ch <- prometheus.MustNewConstMetric(peeringUptimeMetric, prometheus.CounterValue, stat.Uptime.Seconds(), id)
+		ch <- prometheus.MustNewConstMetric(peeringDisconnectsMetric, prometheus.CounterValue, float64(stat.Disconnects), id)
+		ch <- prometheus.MustNewConstMetric(peeringReconnectAttemptsMetric, prometheus.CounterValue, float64(stat.ReconnectAttempts), id)
+		ch <- prometheus.MustNewConstMetric(peeringLatencyMetric, prometheus.GaugeValue, stat.Latency.Seconds(), id)
+		ch <- prometheus.MustNewConstMetric(peeringBackoffMetric, prometheus.GaugeValue, stat.Backoff.Seconds(), id)
+	}
+}
+
+// collectBlockCaches reports the hits and misses of the caches of the
+// blockstore.
+func (c IpfsNodeCollector) collectBlockCaches(ch chan<- prometheus.Metric) {
+	if c.Node.BlockCache != nil {
+		stat := c.Node.BlockCache.Stat()
+		ch <- prometheus.MustNewConstMetric(blockCacheHitsMetric, prometheus.CounterValue, float64(stat.Hits))
+		ch <- prometheus.MustNewConstMetric(blockCacheMissesMetric, prometheus.CounterValue, float64(stat.Misses))
+		ch <- prometheus.MustNewConstMetric(blockCacheSizeMetric, prometheus.GaugeValue, float64(stat.Size))
+		ch <- prometheus.MustNewConstMetric(blockCacheBlocksMetric, prometheus.GaugeValue, float64(stat.Blocks))
+	}
+	if c.Node.BlockBloom != nil {
+		stat := c.Node.BlockBloom.Stat()
+		ch <- prometheus.MustNewConstMetric(bloomRequestsMetric, prometheus.CounterValue, float64(stat.Requests))
+		ch <- prometheus.MustNewConstMetric(bloomNegativesMetric, prometheus.CounterValue, float64(stat.Negatives))
+		ch <- prometheus.MustNewConstMetric(bloomSizeMetric, prometheus.GaugeValue, float64(stat.Size))
+		ch <- prometheus.MustNewConstMetric(bloomKeysMetric, prometheus.GaugeValue, float64(stat.Keys))
+		ch <- prometheus.MustNewConstMetric(bloomBuildsMetric, prometheus.CounterValue, float64(stat.Builds))
+	}
 }
 
 func (c IpfsNodeCollector) PeersTotalValues() map[string]float64 {
@@ -0,0 +1,711 @@
+package corerepo
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ipfs/kubo/config"
+	serialize "github.com/ipfs/kubo/config/serialize"
+	"github.com/ipfs/kubo/core"
+	"github.com/ipfs/kubo/gc"
+	"github.com/ipfs/kubo/repo"
+	fsrepo "github.com/ipfs/kubo/repo/fsrepo"
+
+	blocks "github.com/ipfs/go-block-format"
+	bserv "github.com/ipfs/go-blockservice"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	dssync "github.com/ipfs/go-datastore/sync"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	"github.com/ipfs/go-ipfs-pinner/dspinner"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-mfs"
+	gocar "github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+	gocarv2 "github.com/ipld/go-car/v2"
+	crypto "github.com/libp2p/go-libp2p-core/crypto"
+)
+
+// BackupFormat is the version of the layout of backups.
+const BackupFormat = 1
+
+// Files of a backup.
+const (
+	backupManifestFile  = "manifest.json"
+	backupConfigFile    = "config"
+	backupKeystoreDir   = "keystore"
+	backupDatastoreFile = "datastore"
+	backupBlocksFile    = "blocks.car"
+)
+
+// backupPrefixes are the datastore prefixes saved in backups, besides the
+// blocks: the MFS root, the pins and the IPNS records.
+var backupPrefixes = []string{"/local", "/pins", "/ipns"}
+
+var filesRootKey = ds.NewKey("/local/filesroot")
+
+// BackupFile is a file of a backup, with its checksum.
+type BackupFile struct {
+	Name   string
+	Size   int64
+	Sha256 string
+}
+
+// BackupManifest describes a backup. It is the manifest.json of the backup.
+type BackupManifest struct {
+	Format      int
+	RepoVersion int
+	PeerID      string
+	Created     time.Time
+	PinnedOnly  bool
+	Keys        int
+	Entries     int
+	Blocks      int
+	Files       []BackupFile
+}
+
+// BackupOptions configures a backup.
+type BackupOptions struct {
+	// PinnedOnly saves only the pinned blocks and the blocks reachable from
+	// MFS, instead of all the blocks of the repo.
+	PinnedOnly bool
+}
+
+// Backup saves a snapshot of the repo of a running node to target: its
+// config, keystore, MFS root, pins, IPNS records and blocks. The target is a
+// directory, or a tar archive when it ends with ".tar". The snapshot is taken
+// under the pin lock, so the garbage collector cannot remove the blocks it
+// refers to while they are saved.
+func Backup(ctx context.Context, n *core.IpfsNode, target string, opts BackupOptions) (*BackupManifest, error) {
+	if !strings.HasSuffix(target, ".tar") {
+		created, err := createBackupDir(target)
+		if err != nil {
+			return nil, err
+		}
+		m, err := backup(ctx, n, target, opts)
+		if err != nil && created {
+			os.RemoveAll(target)
+		}
+		return m, err
+	}
+
+	if _, err := os.Lstat(target); err == nil {
+		return nil, fmt.Errorf("%s already exists", target)
+	}
+	dir, err := os.MkdirTemp(filepath.Dir(target), ".ipfs-backup-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	m, err := backup(ctx, n, dir, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeBackupTar(target, dir, m); err != nil {
+		os.Remove(target)
+		return nil, err
+	}
+	return m, nil
+}
+
+// createBackupDir creates the directory of a backup, unless it exists and is
+// empty.
+func createBackupDir(dir string) (bool, error) {
+	entries, err := os.ReadDir(dir)
+	switch {
+	case os.IsNotExist(err):
+		return true, os.MkdirAll(dir, 0700)
+	case err != nil:
+		return false, err
+	case len(entries) > 0:
+		return false, fmt.Errorf("%s is not empty", dir)
+	}
+	return false, nil
+}
+
+func backup(ctx context.Context, n *core.IpfsNode, dir string, opts BackupOptions) (*BackupManifest, error) {
+	if n.FilesRoot != nil {
+		if _, err := mfs.FlushPath(ctx, n.FilesRoot, "/"); err != nil {
+			return nil, err
+		}
+	}
+
+	unlocker := n.Blockstore.PinLock(ctx)
+	defer unlocker.Unlock(ctx)
+
+	if err := n.Pinning.Flush(ctx); err != nil {
+		return nil, err
+	}
+
+	m := &BackupManifest{
+		Format:      BackupFormat,
+		RepoVersion: fsrepo.RepoVersion,
+		PeerID:      n.Identity.String(),
+		Created:     time.Now().UTC(),
+		PinnedOnly:  opts.PinnedOnly,
+	}
+	w := &backupWriter{dir: dir, m: m}
+
+	cfg, err := n.Repo.Config()
+	if err != nil {
+		return nil, err
+	}
+	err = w.write(backupConfigFile, func(f io.Writer) error {
+		out, err := config.HumanOutput(cfg)
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(out)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := backupKeystore(w, n.Repo); err != nil {
+		return nil, err
+	}
+
+	// The pins and the MFS root are read back from the snapshot of the
+	// datastore, so the saved blocks match them even if they change while
+	// the backup runs.
+	snapshot := dssync.MutexWrap(ds.NewMapDatastore())
+	err = w.write(backupDatastoreFile, func(f io.Writer) error {
+		for _, prefix := range backupPrefixes {
+			res, err := n.Repo.Datastore().Query(ctx, dsq.Query{Prefix: prefix})
+			if err != nil {
+				return err
+			}
+			for r := range res.Next() {
+				if r.Error != nil {
+					res.Close()
+					return r.Error
+				}
+				// the key and the value are written as separate sections
+				if err := carutil.LdWrite(f, []byte(r.Key)); err != nil {
+					res.Close()
+					return err
+				}
+				if err := carutil.LdWrite(f, r.Value); err != nil {
+					res.Close()
+					return err
+				}
+				if err := snapshot.Put(ctx, ds.NewKey(r.Key), r.Value); err != nil {
+					res.Close()
+					return err
+				}
+				m.Entries++
+			}
+			res.Close()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	bs := n.Blockstore
+	dserv := dag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
+	pinner, err := dspinner.New(ctx, snapshot, dserv)
+	if err != nil {
+		return nil, err
+	}
+	mfsRoots, err := filesRoots(ctx, snapshot, n.FilesRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	err = w.write(backupBlocksFile, func(f io.Writer) error {
+		roots, err := pinner.RecursiveKeys(ctx)
+		if err != nil {
+			return err
+		}
+		roots = append(mfsRoots, roots...)
+		if err := gocar.WriteHeader(&gocar.CarHeader{Roots: roots, Version: 1}, f); err != nil {
+			return err
+		}
+
+		writeBlock := func(c cid.Cid) error {
+			blk, err := bs.Get(ctx, c)
+			if err != nil {
+				return err
+			}
+			m.Blocks++
+			return carutil.LdWrite(f, c.Bytes(), blk.RawData())
+		}
+
+		if !opts.PinnedOnly {
+			keys, err := bs.AllKeysChan(ctx)
+			if err != nil {
+				return err
+			}
+			for c := range keys {
+				if err := writeBlock(c); err != nil {
+					return err
+				}
+			}
+			return ctx.Err()
+		}
+
+		set, err := reachableSet(ctx, pinner, dserv, mfsRoots)
+		if err != nil {
+			return err
+		}
+		return set.ForEach(writeBlock)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return m, w.writeManifest()
+}
+
+func backupKeystore(w *backupWriter, r repo.Repo) error {
+	ks := r.Keystore()
+	names, err := ks.List()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		sk, err := ks.Get(name)
+		if err != nil {
+			return err
+		}
+		data, err := crypto.MarshalPrivateKey(sk)
+		if err != nil {
+			return err
+		}
+		err = w.write(path.Join(backupKeystoreDir, name), func(f io.Writer) error {
+			_, err := f.Write(data)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		w.m.Keys++
+	}
+	return nil
+}
+
+// filesRoots returns the MFS root saved in the datastore d, and the current
+// one if it differs.
+func filesRoots(ctx context.Context, d ds.Datastore, filesRoot *mfs.Root) ([]cid.Cid, error) {
+	var roots []cid.Cid
+	val, err := d.Get(ctx, filesRootKey)
+	switch err {
+	case nil:
+		c, err := cid.Cast(val)
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, c)
+	case ds.ErrNotFound:
+	default:
+		return nil, err
+	}
+
+	if filesRoot != nil {
+		current, err := BestEffortRoots(filesRoot)
+		if err != nil {
+			return nil, err
+		}
+		if len(roots) == 0 || !roots[0].Equals(current[0]) {
+			roots = append(roots, current...)
+		}
+	}
+	return roots, nil
+}
+
+// reachableSet returns the pinned blocks and the blocks reachable from the
+// MFS roots, failing if some are missing.
+func reachableSet(ctx context.Context, pinner pin.Pinner, ng ipld.NodeGetter, mfsRoots []cid.Cid) (*cid.Set, error) {
+	output := make(chan gc.Result)
+	var errs []error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for res := range output {
+			errs = append(errs, res.Error)
+		}
+	}()
+
+	set, err := gc.ColoredSet(ctx, pinner, ng, mfsRoots, output)
+	close(output)
+	<-done
+	if err != nil {
+		if len(errs) > 0 {
+			return nil, fmt.Errorf("%s: %s", err, errs[0])
+		}
+		return nil, err
+	}
+	return set, nil
+}
+
+// backupWriter writes the files of a backup and records their checksums.
+type backupWriter struct {
+	dir string
+	m   *BackupManifest
+}
+
+func (w *backupWriter) write(name string, fn func(io.Writer) error) error {
+	p := filepath.Join(w.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	buf := bufio.NewWriter(io.MultiWriter(f, h))
+	if err := fn(buf); err != nil {
+		return fmt.Errorf("writing %s: %w", name, err)
+	}
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	w.m.Files = append(w.m.Files, BackupFile{
+		Name:   name,
+		Size:   fi.Size(),
+		Sha256: hex.EncodeToString(h.Sum(nil)),
+	})
+	return f.Close()
+}
+
+func (w *backupWriter) writeManifest() error {
+	data, err := json.MarshalIndent(w.m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(w.dir, backupManifestFile), data, 0600)
+}
+
+// writeBackupTar archives the backup in dir to target, manifest first.
+func writeBackupTar(target, dir string, m *BackupManifest) error {
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tw := tar.NewWriter(f)
+	names := []string{backupManifestFile}
+	for _, file := range m.Files {
+		names = append(names, file.Name)
+	}
+	for _, name := range names {
+		if err := addTarFile(tw, dir, name); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func addTarFile(tw *tar.Writer, dir, name string) error {
+	f, err := os.Open(filepath.Join(dir, filepath.FromSlash(name)))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	err = tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     fi.Size(),
+		Mode:     0600,
+		ModTime:  fi.ModTime(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// extractBackupTar extracts the backup archived in src to dir.
+func extractBackupTar(src, dir string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return fmt.Errorf("unexpected entry %s in backup archive", hdr.Name)
+		}
+		name := path.Clean(hdr.Name)
+		if path.IsAbs(name) || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("invalid entry %s in backup archive", hdr.Name)
+		}
+
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+			return err
+		}
+		out, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, tr)
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// VerifyBackup checks the files of the backup in dir against the checksums
+// of its manifest, and returns the manifest.
+func VerifyBackup(dir string) (*BackupManifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, backupManifestFile))
+	if err != nil {
+		return nil, err
+	}
+	var m BackupManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid backup manifest: %w", err)
+	}
+	if m.Format != BackupFormat {
+		return nil, fmt.Errorf("unsupported backup format %d", m.Format)
+	}
+
+	seen := make(map[string]bool)
+	for _, file := range m.Files {
+		if err := verifyBackupFile(dir, file); err != nil {
+			return nil, err
+		}
+		seen[file.Name] = true
+	}
+	for _, name := range []string{backupConfigFile, backupDatastoreFile, backupBlocksFile} {
+		if !seen[name] {
+			return nil, fmt.Errorf("backup is missing %s", name)
+		}
+	}
+	return &m, nil
+}
+
+func verifyBackupFile(dir string, file BackupFile) error {
+	f, err := os.Open(filepath.Join(dir, filepath.FromSlash(file.Name)))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+	if size != file.Size || hex.EncodeToString(h.Sum(nil)) != file.Sha256 {
+		return fmt.Errorf("backup file %s is corrupt: checksum mismatch", file.Name)
+	}
+	return nil
+}
+
+// Restore initializes the repo at repoPath from the backup at src, a
+// directory or a tar archive written by Backup. The backup is verified before
+// anything is written, and the restored repo is checked to hold all the
+// pinned blocks.
+func Restore(ctx context.Context, repoPath, src string) (*BackupManifest, error) {
+	if fsrepo.IsInitialized(repoPath) {
+		return nil, fmt.Errorf("a repo already exists at %s", repoPath)
+	}
+
+	fi, err := os.Stat(src)
+	if err != nil {
+		return nil, err
+	}
+	dir := src
+	if !fi.IsDir() {
+		dir, err = os.MkdirTemp("", "ipfs-restore-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(dir)
+		if err := extractBackupTar(src, dir); err != nil {
+			return nil, err
+		}
+	}
+
+	m, err := VerifyBackup(dir)
+	if err != nil {
+		return nil, err
+	}
+	if m.RepoVersion != fsrepo.RepoVersion {
+		return nil, fmt.Errorf("backup of repo version %d cannot be restored to repo version %d", m.RepoVersion, fsrepo.RepoVersion)
+	}
+
+	cfg, err := serialize.Load(filepath.Join(dir, backupConfigFile))
+	if err != nil {
+		return nil, err
+	}
+	if err := fsrepo.Init(repoPath, cfg); err != nil {
+		return nil, err
+	}
+	if err := restore(ctx, repoPath, dir, m); err != nil {
+		return nil, fmt.Errorf("%w (remove the partially restored repo at %s before trying again)", err, repoPath)
+	}
+	return m, nil
+}
+
+func restore(ctx context.Context, repoPath, dir string, m *BackupManifest) error {
+	r, err := fsrepo.Open(repoPath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	for _, file := range m.Files {
+		if !strings.HasPrefix(file.Name, backupKeystoreDir+"/") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(file.Name)))
+		if err != nil {
+			return err
+		}
+		sk, err := crypto.UnmarshalPrivateKey(data)
+		if err != nil {
+			return err
+		}
+		if err := r.Keystore().Put(path.Base(file.Name), sk); err != nil {
+			return err
+		}
+	}
+
+	d := r.Datastore()
+	if err := restoreDatastore(ctx, d, filepath.Join(dir, backupDatastoreFile)); err != nil {
+		return err
+	}
+	bs := bstore.NewBlockstore(d)
+	if err := restoreBlocks(ctx, bs, filepath.Join(dir, backupBlocksFile)); err != nil {
+		return err
+	}
+
+	dserv := dag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
+	pinner, err := dspinner.New(ctx, d, dserv)
+	if err != nil {
+		return err
+	}
+	mfsRoots, err := filesRoots(ctx, d, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := reachableSet(ctx, pinner, dserv, mfsRoots); err != nil {
+		return fmt.Errorf("restored repo is incomplete: %w", err)
+	}
+	return nil
+}
+
+func restoreDatastore(ctx context.Context, d repo.Datastore, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	batch, err := d.Batch(ctx)
+	if err != nil {
+		return err
+	}
+	br := bufio.NewReader(f)
+	for {
+		key, err := carutil.LdRead(br)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		value, err := carutil.LdRead(br)
+		if err != nil {
+			return errors.New("backup datastore is truncated")
+		}
+		if err := batch.Put(ctx, ds.RawKey(string(key)), value); err != nil {
+			return err
+		}
+	}
+	if err := batch.Commit(ctx); err != nil {
+		return err
+	}
+	return d.Sync(ctx, ds.NewKey("/"))
+}
+
+func restoreBlocks(ctx context.Context, bs bstore.Blockstore, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// the reader checks the blocks against their CIDs
+	br, err := gocarv2.NewBlockReader(bufio.NewReader(f))
+	if err != nil {
+		return err
+	}
+	const batchSize = 256
+	batch := make([]blocks.Block, 0, batchSize)
+	for {
+		blk, err := br.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		batch = append(batch, blk)
+		if len(batch) == batchSize {
+			if err := bs.PutMany(ctx, batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	return bs.PutMany(ctx, batch)
+}
@@ -0,0 +1,143 @@
+package corerepo
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/core"
+	"github.com/ipfs/kubo/plugin/loader"
+	"github.com/ipfs/kubo/repo/fsrepo"
+
+	bserv "github.com/ipfs/go-blockservice"
+	cid "github.com/ipfs/go-cid"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	"github.com/ipfs/go-ipfs-pinner/dspinner"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+	ft "github.com/ipfs/go-unixfs"
+	"github.com/ipfs/interface-go-ipfs-core/options"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	// the datastores of fsrepo are plugins
+	l, err := loader.NewPluginLoader("")
+	if err != nil {
+		panic(err)
+	}
+	if err := l.Initialize(); err != nil {
+		panic(err)
+	}
+	if err := l.Inject(); err != nil {
+		panic(err)
+	}
+}
+
+func newBackupTestNode(t *testing.T, ctx context.Context) *core.IpfsNode {
+	t.Helper()
+	ident, err := config.CreateIdentity(io.Discard, []options.KeyGenerateOption{options.Key.Type(options.Ed25519Key)})
+	require.NoError(t, err)
+	cfg, err := config.InitWithIdentity(ident)
+	require.NoError(t, err)
+
+	repoPath := t.TempDir()
+	require.NoError(t, fsrepo.Init(repoPath, cfg))
+	r, err := fsrepo.Open(repoPath)
+	require.NoError(t, err)
+
+	n, err := core.NewNode(ctx, &core.BuildCfg{Repo: r})
+	require.NoError(t, err)
+	t.Cleanup(func() { n.Close() })
+	return n
+}
+
+func TestBackupRestore(t *testing.T) {
+	ctx := context.Background()
+	n := newBackupTestNode(t, ctx)
+
+	pinned := dag.NodeWithData(ft.FilePBData([]byte("pinned"), 6))
+	unpinned := dag.NodeWithData(ft.FilePBData([]byte("unpinned"), 8))
+	require.NoError(t, n.DAG.AddMany(ctx, []ipld.Node{pinned, unpinned}))
+	require.NoError(t, n.Pinning.Pin(ctx, pinned, true))
+	require.NoError(t, n.Pinning.Flush(ctx))
+
+	require.NoError(t, n.Repo.Keystore().Put("other", n.PrivateKey))
+
+	for _, c := range []struct {
+		name       string
+		pinnedOnly bool
+	}{
+		{"backup", false},
+		{"backup.tar", true},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			target := filepath.Join(t.TempDir(), c.name)
+			m, err := Backup(ctx, n, target, BackupOptions{PinnedOnly: c.pinnedOnly})
+			require.NoError(t, err)
+			require.Equal(t, 1, m.Keys)
+
+			repoPath := t.TempDir()
+			restored, err := Restore(ctx, repoPath, target)
+			require.NoError(t, err)
+			require.Equal(t, n.Identity.String(), restored.PeerID)
+			require.Equal(t, m.Blocks, restored.Blocks)
+
+			_, err = Restore(ctx, repoPath, target)
+			require.Error(t, err, "restoring over a repo")
+
+			r, err := fsrepo.Open(repoPath)
+			require.NoError(t, err)
+			defer r.Close()
+
+			names, err := r.Keystore().List()
+			require.NoError(t, err)
+			require.Equal(t, []string{"other"}, names)
+
+			rbs := bstore.NewBlockstore(r.Datastore())
+			has, err := rbs.Has(ctx, pinned.Cid())
+			require.NoError(t, err)
+			require.True(t, has)
+			has, err = rbs.Has(ctx, unpinned.Cid())
+			require.NoError(t, err)
+			require.Equal(t, !c.pinnedOnly, has)
+
+			pinner, err := dspinner.New(ctx, r.Datastore(), dag.NewDAGService(bserv.New(rbs, offline.Exchange(rbs))))
+			require.NoError(t, err)
+			keys, err := pinner.RecursiveKeys(ctx)
+			require.NoError(t, err)
+			require.Equal(t, []cid.Cid{pinned.Cid()}, keys)
+		})
+	}
+}
+
+func TestVerifyBackup(t *testing.T) {
+	ctx := context.Background()
+	n := newBackupTestNode(t, ctx)
+
+	dir := filepath.Join(t.TempDir(), "backup")
+	_, err := Backup(ctx, n, dir, BackupOptions{})
+	require.NoError(t, err)
+	_, err = VerifyBackup(dir)
+	require.NoError(t, err)
+
+	// the directory of a backup must be empty
+	_, err = Backup(ctx, n, dir, BackupOptions{})
+	require.Error(t, err)
+
+	p := filepath.Join(dir, backupBlocksFile)
+	data, err := os.ReadFile(p)
+	require.NoError(t, err)
+	data = bytes.Replace(data, data[len(data)-4:], []byte("nope"), 1)
+	require.NoError(t, os.WriteFile(p, data, 0600))
+	_, err = VerifyBackup(dir)
+	require.Error(t, err)
+
+	_, err = Restore(ctx, t.TempDir(), dir)
+	require.Error(t, err)
+}
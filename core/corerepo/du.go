@@ -0,0 +1,284 @@
+package corerepo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/ipfs/kubo/core"
+
+	bserv "github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+)
+
+var usageKey = datastore.NewKey("/local/du")
+
+// RootUsage is the space used by the blocks of a pin or of an MFS entry.
+type RootUsage struct {
+	Cid cid.Cid
+	// Pin is the type of the pin, recursive or direct, empty for MFS.
+	Pin string `json:",omitempty"`
+	// Path is the MFS path, empty for a pin.
+	Path string `json:",omitempty"`
+	// Blocks is the number of blocks only reachable from this root.
+	Blocks uint64
+	// Exclusive is the size of the blocks only reachable from this root,
+	// freed when it is unpinned or removed from MFS.
+	Exclusive uint64
+}
+
+// Usage is the space used by the pins and MFS.
+type Usage struct {
+	// Roots are sorted by exclusive size, the largest first.
+	Roots []RootUsage
+	// Blocks and Size count the blocks reachable from any root.
+	Blocks uint64
+	Size   uint64
+	// Shared is the size of the blocks reachable from several roots.
+	Shared uint64
+	// Computed is when the DAGs were walked.
+	Computed time.Time
+}
+
+// usageRoot identifies a root. The usage is only computed again when the
+// roots change, the DAGs below them do not.
+type usageRoot struct {
+	Cid  cid.Cid
+	Pin  string `json:",omitempty"`
+	Path string `json:",omitempty"`
+}
+
+// cachedUsage is the usage saved in the datastore with its roots.
+type cachedUsage struct {
+	Roots []usageRoot
+	Usage Usage
+}
+
+// RepoUsage returns the space used by each pin and by each entry at the root
+// of MFS. Blocks reachable from a single root are exclusive to it, the others
+// are shared. The result is saved in the datastore, and computed again once
+// the pins or MFS change, or when refresh is true.
+func RepoUsage(ctx context.Context, n *core.IpfsNode, refresh bool) (*Usage, error) {
+	roots, err := usageRoots(ctx, n)
+	if err != nil {
+		return nil, err
+	}
+
+	d := n.Repo.Datastore()
+	if !refresh {
+		var cached cachedUsage
+		data, err := d.Get(ctx, usageKey)
+		switch {
+		case err == datastore.ErrNotFound:
+		case err != nil:
+			return nil, err
+		case json.Unmarshal(data, &cached) == nil && sameUsageRoots(cached.Roots, roots):
+			return &cached.Usage, nil
+		}
+	}
+
+	u, err := computeUsage(ctx, n, roots)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(&cachedUsage{Roots: roots, Usage: *u})
+	if err != nil {
+		return nil, err
+	}
+	if err := d.Put(ctx, usageKey, data); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// usageRoots returns the pins, then the entries at the root of MFS, in a
+// stable order.
+func usageRoots(ctx context.Context, n *core.IpfsNode) ([]usageRoot, error) {
+	var roots []usageRoot
+	for _, p := range []struct {
+		typ  string
+		keys func(context.Context) ([]cid.Cid, error)
+	}{
+		{"recursive", n.Pinning.RecursiveKeys},
+		{"direct", n.Pinning.DirectKeys},
+	} {
+		keys, err := p.keys(ctx)
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i].KeyString() < keys[j].KeyString() })
+		for _, c := range keys {
+			roots = append(roots, usageRoot{Cid: c, Pin: p.typ})
+		}
+	}
+
+	entries, err := n.FilesRoot.GetDirectory().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	for _, e := range entries {
+		c, err := cid.Decode(e.Hash)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CID of MFS entry %s: %w", e.Name, err)
+		}
+		roots = append(roots, usageRoot{Cid: c, Path: path.Join("/", e.Name)})
+	}
+	return roots, nil
+}
+
+func sameUsageRoots(a, b []usageRoot) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Cid.Equals(b[i].Cid) || a[i].Pin != b[i].Pin || a[i].Path != b[i].Path {
+			return false
+		}
+	}
+	return true
+}
+
+// sharedOwner is the owner of the blocks reachable from several roots
+const sharedOwner = -1
+
+// usageBlock is a block reached while walking the roots.
+type usageBlock struct {
+	// index of the only root the block is reachable from, or sharedOwner
+	owner int
+	size  uint64
+	// the blocks it links to that are stored locally
+	links []*usageBlock
+}
+
+// share marks b and the blocks below it as reachable from several roots. The
+// blocks below a shared block are already shared.
+func (b *usageBlock) share() {
+	stack := []*usageBlock{b}
+	for len(stack) != 0 {
+		b := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if b.owner == sharedOwner {
+			continue
+		}
+		b.owner = sharedOwner
+		stack = append(stack, b.links...)
+	}
+}
+
+// computeUsage walks the DAGs of all the roots once, each block being read the
+// first time it is reached. A block reached again from another root is shared
+// with the blocks below it, through the links recorded on the first visit.
+// The blocks are keyed by multihash, as in the blockstore. Blocks that are
+// not stored locally are skipped.
+func computeUsage(ctx context.Context, n *core.IpfsNode, roots []usageRoot) (*Usage, error) {
+	bs := n.Blockstore
+	ng := dag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
+	blocks := make(map[string]*usageBlock)
+	u := &Usage{
+		Roots:    make([]RootUsage, len(roots)),
+		Computed: time.Now(),
+	}
+
+	// reach returns the block c reached from the root i, and whether it is
+	// reached for the first time.
+	reach := func(i int, c cid.Cid) (*usageBlock, bool, error) {
+		h := string(c.Hash())
+		if b, ok := blocks[h]; ok {
+			if b.owner != i {
+				b.share()
+			}
+			return b, false, nil
+		}
+		size, err := bs.GetSize(ctx, c)
+		if ipld.IsNotFound(err) {
+			return nil, false, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		b := &usageBlock{owner: i, size: uint64(size)}
+		blocks[h] = b
+		return b, true, nil
+	}
+
+	// the direct pins are reached last: the blocks below them are not
+	// reachable from them, and are not shared when they are
+	var direct []int
+	for i, r := range roots {
+		u.Roots[i] = RootUsage{Cid: r.Cid, Pin: r.Pin, Path: r.Path}
+		if r.Pin == "direct" {
+			direct = append(direct, i)
+			continue
+		}
+
+		type link struct {
+			parent *usageBlock
+			cid    cid.Cid
+		}
+		stack := []link{{cid: r.Cid}}
+		for len(stack) != 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			l := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			b, first, err := reach(i, l.cid)
+			if err != nil {
+				return nil, err
+			}
+			if b == nil {
+				continue
+			}
+			if l.parent != nil {
+				l.parent.links = append(l.parent.links, b)
+			}
+			if !first || l.cid.Type() == cid.Raw {
+				continue
+			}
+			links, err := ipld.GetLinks(ctx, ng, l.cid)
+			if err != nil {
+				// not a DAG the node can decode, or gone since
+				continue
+			}
+			for _, ln := range links {
+				stack = append(stack, link{parent: b, cid: ln.Cid})
+			}
+		}
+	}
+	for _, i := range direct {
+		c := roots[i].Cid
+		if b, ok := blocks[string(c.Hash())]; ok {
+			b.owner = sharedOwner
+			continue
+		}
+		if _, _, err := reach(i, c); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, b := range blocks {
+		u.Blocks++
+		u.Size += b.size
+		if b.owner == sharedOwner {
+			u.Shared += b.size
+		} else {
+			u.Roots[b.owner].Blocks++
+			u.Roots[b.owner].Exclusive += b.size
+		}
+	}
+	sort.SliceStable(u.Roots, func(i, j int) bool {
+		if u.Roots[i].Exclusive != u.Roots[j].Exclusive {
+			return u.Roots[i].Exclusive > u.Roots[j].Exclusive
+		}
+		return u.Roots[i].Blocks > u.Roots[j].Blocks
+	})
+	return u, nil
+}
@@ -0,0 +1,83 @@
+package corerepo
+
+import (
+	"context"
+	"testing"
+
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-mfs"
+	ft "github.com/ipfs/go-unixfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepoUsage(t *testing.T) {
+	ctx := context.Background()
+	n := newBackupTestNode(t, ctx)
+
+	leaf := func(data string) *dag.ProtoNode {
+		return dag.NodeWithData(ft.FilePBData([]byte(data), uint64(len(data))))
+	}
+	deep, onlyA, onlyB, inMFS := leaf("deep"), leaf("only a"), leaf("only b"), leaf("in mfs")
+	// the blocks below a shared block are shared
+	common := dag.NodeWithData(ft.FolderPBData())
+	require.NoError(t, common.AddNodeLink("deep", deep))
+	a := dag.NodeWithData(ft.FolderPBData())
+	require.NoError(t, a.AddNodeLink("common", common))
+	require.NoError(t, a.AddNodeLink("a", onlyA))
+	b := dag.NodeWithData(ft.FolderPBData())
+	require.NoError(t, b.AddNodeLink("common", common))
+	require.NoError(t, b.AddNodeLink("b", onlyB))
+	// common is linked twice, and counted once
+	require.NoError(t, b.AddNodeLink("again", common))
+	require.NoError(t, n.DAG.AddMany(ctx, []ipld.Node{deep, common, onlyA, onlyB, inMFS, a, b}))
+	require.NoError(t, n.Pinning.Pin(ctx, a, true))
+	require.NoError(t, n.Pinning.Pin(ctx, b, true))
+	require.NoError(t, n.Pinning.Flush(ctx))
+	require.NoError(t, mfs.PutNode(n.FilesRoot, "/file", inMFS))
+
+	size := func(nd ipld.Node) uint64 {
+		return uint64(len(nd.RawData()))
+	}
+
+	u, err := RepoUsage(ctx, n, false)
+	require.NoError(t, err)
+	require.Len(t, u.Roots, 3)
+	byCid := make(map[string]RootUsage)
+	for _, r := range u.Roots {
+		byCid[r.Cid.String()] = r
+	}
+	require.Equal(t, RootUsage{Cid: a.Cid(), Pin: "recursive", Blocks: 2, Exclusive: size(a) + size(onlyA)}, byCid[a.Cid().String()])
+	require.Equal(t, RootUsage{Cid: b.Cid(), Pin: "recursive", Blocks: 2, Exclusive: size(b) + size(onlyB)}, byCid[b.Cid().String()])
+	require.Equal(t, RootUsage{Cid: inMFS.Cid(), Path: "/file", Blocks: 1, Exclusive: size(inMFS)}, byCid[inMFS.Cid().String()])
+	require.Equal(t, uint64(7), u.Blocks)
+	require.Equal(t, size(deep)+size(common)+size(onlyA)+size(onlyB)+size(inMFS)+size(a)+size(b), u.Size)
+	require.Equal(t, size(deep)+size(common), u.Shared)
+	require.GreaterOrEqual(t, u.Roots[0].Exclusive, u.Roots[1].Exclusive)
+
+	// cached while the roots do not change
+	cached, err := RepoUsage(ctx, n, false)
+	require.NoError(t, err)
+	require.True(t, u.Computed.Equal(cached.Computed))
+	refreshed, err := RepoUsage(ctx, n, true)
+	require.NoError(t, err)
+	require.False(t, u.Computed.Equal(refreshed.Computed))
+
+	require.NoError(t, n.Pinning.Unpin(ctx, b.Cid(), true))
+	require.NoError(t, n.Pinning.Flush(ctx))
+	u, err = RepoUsage(ctx, n, false)
+	require.NoError(t, err)
+	require.Len(t, u.Roots, 2)
+	require.Equal(t, a.Cid(), u.Roots[0].Cid)
+	require.Equal(t, size(a)+size(onlyA)+size(common)+size(deep), u.Roots[0].Exclusive)
+	require.Zero(t, u.Shared)
+
+	// a direct pin only shares its own block
+	require.NoError(t, n.Pinning.Pin(ctx, common, false))
+	require.NoError(t, n.Pinning.Flush(ctx))
+	u, err = RepoUsage(ctx, n, false)
+	require.NoError(t, err)
+	require.Len(t, u.Roots, 3)
+	require.Equal(t, RootUsage{Cid: a.Cid(), Pin: "recursive", Blocks: 3, Exclusive: size(a) + size(onlyA) + size(deep)}, u.Roots[0])
+	require.Equal(t, size(common), u.Shared)
+}
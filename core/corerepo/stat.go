@@ -3,13 +3,17 @@ package corerepo
 import (
 	"fmt"
 	"math"
+	"sort"
 
 	context "context"
 
 	"github.com/ipfs/kubo/core"
 	fsrepo "github.com/ipfs/kubo/repo/fsrepo"
+	"github.com/ipfs/kubo/repo/fsrepo/compression"
+	"github.com/ipfs/kubo/repo/fsrepo/tiered"
 
 	humanize "github.com/dustin/go-humanize"
+	"github.com/ipfs/go-datastore"
 )
 
 // SizeStat wraps information about the repository size and its limit.
@@ -21,9 +25,11 @@ type SizeStat struct {
 // Stat wraps information about the objects stored on disk.
 type Stat struct {
 	SizeStat
-	NumObjects uint64
-	RepoPath   string
-	Version    string
+	NumObjects  uint64
+	RepoPath    string
+	Version     string
+	Tiers       []tiered.Stat      `json:",omitempty"`
+	Compression []compression.Stat `json:",omitempty"`
 }
 
 // NoLimit represents the value for unlimited storage
@@ -51,17 +57,50 @@ func RepoStat(ctx context.Context, n *core.IpfsNode) (Stat, error) {
 		return Stat{}, err
 	}
 
+	tiers, comp := datastoreStats(n.Repo.Datastore())
+
 	return Stat{
 		SizeStat: SizeStat{
 			RepoSize:   sizeStat.RepoSize,
 			StorageMax: sizeStat.StorageMax,
 		},
-		NumObjects: count,
-		RepoPath:   path,
-		Version:    fmt.Sprintf("fs-repo@%d", fsrepo.RepoVersion),
+		NumObjects:  count,
+		RepoPath:    path,
+		Version:     fmt.Sprintf("fs-repo@%d", fsrepo.RepoVersion),
+		Tiers:       tiers,
+		Compression: comp,
 	}, nil
 }
 
+// datastoreStats returns the stats of the tiered and the compress datastores
+// among d and the datastores it wraps, ordered by name.
+func datastoreStats(d datastore.Datastore) ([]tiered.Stat, []compression.Stat) {
+	var tiers []tiered.Stat
+	var comp []compression.Stat
+	walkDatastores(d, func(d datastore.Datastore) {
+		switch d := d.(type) {
+		case *tiered.Datastore:
+			tiers = append(tiers, d.Stat())
+		case *compression.Datastore:
+			comp = append(comp, d.Stat())
+		}
+	})
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].Name < tiers[j].Name })
+	sort.Slice(comp, func(i, j int) bool { return comp[i].Name < comp[j].Name })
+	return tiers, comp
+}
+
+// walkDatastores calls f with d and the datastores it wraps, as listed by
+// their Children method, recursively.
+func walkDatastores(d datastore.Datastore, f func(datastore.Datastore)) {
+	f(d)
+	if s, ok := d.(datastore.Shim); ok {
+		for _, c := range s.Children() {
+			walkDatastores(c, f)
+		}
+	}
+}
+
 // RepoSize returns a *Stat object with the RepoSize and StorageMax fields set.
 func RepoSize(ctx context.Context, n *core.IpfsNode) (SizeStat, error) {
 	r := n.Repo
@@ -0,0 +1,54 @@
+package corerepo
+
+import (
+	"testing"
+
+	config "github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/repo"
+	"github.com/ipfs/kubo/repo/fsrepo"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatastoreStats(t *testing.T) {
+	open := func(spec map[string]interface{}) repo.Repo {
+		t.Helper()
+		path := t.TempDir()
+		require.NoError(t, fsrepo.Init(path, &config.Config{Datastore: config.Datastore{Spec: spec}}))
+		r, err := fsrepo.Open(path)
+		require.NoError(t, err)
+		t.Cleanup(func() { r.Close() })
+		return r
+	}
+
+	r := open(map[string]interface{}{
+		"type": "mount",
+		"mounts": []interface{}{
+			map[string]interface{}{
+				"mountpoint": "/blocks",
+				"type":       "tiered",
+				"hot":        map[string]interface{}{"type": "mem"},
+				"cold":       map[string]interface{}{"type": "mem"},
+			},
+			map[string]interface{}{
+				"mountpoint": "/",
+				"type":       "measure",
+				"prefix":     "test",
+				"child": map[string]interface{}{
+					"type":  "compress",
+					"child": map[string]interface{}{"type": "mem"},
+				},
+			},
+		},
+	})
+	tiers, comp := datastoreStats(r.Datastore())
+	require.Len(t, tiers, 1)
+	require.Equal(t, "/blocks", tiers[0].Name)
+	require.Len(t, comp, 1)
+	require.Equal(t, "compress", comp[0].Name)
+
+	// the stats only cover the datastores of the repo
+	r = open(map[string]interface{}{"type": "mem"})
+	tiers, comp = datastoreStats(r.Datastore())
+	require.Empty(t, tiers)
+	require.Empty(t, comp)
+}
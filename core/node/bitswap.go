@@ -2,12 +2,18 @@ package node
 
 import (
 	"context"
+	"fmt"
+	"net/url"
+	"time"
 
+	"github.com/dustin/go-humanize"
 	"github.com/ipfs/go-bitswap"
 	"github.com/ipfs/go-bitswap/network"
 	blockstore "github.com/ipfs/go-ipfs-blockstore"
 	exchange "github.com/ipfs/go-ipfs-exchange-interface"
 	config "github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/exchange/policy"
+	"github.com/ipfs/kubo/exchange/trustless"
 	irouting "github.com/ipfs/kubo/routing"
 	"github.com/libp2p/go-libp2p-core/host"
 	"go.uber.org/fx"
@@ -21,32 +27,124 @@ const (
 	DefaultTaskWorkerCount             = 8
 	DefaultEngineTaskWorkerCount       = 8
 	DefaultMaxOutstandingBytesPerPeer  = 1 << 20
+
+	// Docs: https://github.com/ipfs/kubo/blob/master/docs/config.md#bitswap
+	DefaultBitswapServerEnabled   = true
+	DefaultBitswapClientEnabled   = true
+	DefaultBitswapMaxWantlistSize = 0
+
+	// Docs: https://github.com/ipfs/kubo/blob/master/docs/config.md#httpretrieval
+	DefaultHTTPRetrievalFallbackDelay         = 0
+	DefaultHTTPRetrievalTimeout               = 30 * time.Second
+	DefaultHTTPRetrievalMaxConcurrentRequests = 16
 )
 
+// BitswapLimiter creates the per-peer bitswap rate limiter configured in
+// Bitswap.PeerRateLimits, if any.
+func BitswapLimiter(cfg config.BitswapPeerRateLimits) interface{} {
+	return func() (*policy.Limiter, error) {
+		var limits policy.RateLimits
+		if s := cfg.BytesPerSecond.WithDefault(""); s != "" {
+			n, err := humanize.ParseBytes(s)
+			if err != nil {
+				return nil, fmt.Errorf("failure to parse config setting Bitswap.PeerRateLimits.BytesPerSecond: %w", err)
+			}
+			limits.BytesPerSecond = int(n)
+		}
+		wants := cfg.WantsPerSecond.WithDefault(0)
+		if wants < 0 {
+			return nil, fmt.Errorf("config setting Bitswap.PeerRateLimits.WantsPerSecond can not be negative: %d", wants)
+		}
+		limits.WantsPerSecond = int(wants)
+		limits.Reciprocity = cfg.Reciprocity.WithDefault(false)
+
+		return policy.NewLimiter(limits), nil
+	}
+}
+
 // OnlineExchange creates new LibP2P backed block exchange (BitSwap)
 func OnlineExchange(cfg *config.Config, provide bool) interface{} {
-	return func(mctx helpers.MetricsCtx, lc fx.Lifecycle, host host.Host, rt irouting.TieredRouter, bs blockstore.GCBlockstore) exchange.Interface {
-		bitswapNetwork := network.NewFromIpfsHost(host, rt)
-
+	return func(mctx helpers.MetricsCtx, lc fx.Lifecycle, host host.Host, rt irouting.TieredRouter, bs blockstore.GCBlockstore, limiter *policy.Limiter) (exchange.Interface, error) {
 		var internalBsCfg config.InternalBitswap
 		if cfg.Internal.Bitswap != nil {
 			internalBsCfg = *cfg.Internal.Bitswap
 		}
 
+		// Bitswap.MaxOutstandingBytesPerPeer supersedes the Internal.Bitswap setting
+		maxOutstandingBytes := internalBsCfg.MaxOutstandingBytesPerPeer.WithDefault(DefaultMaxOutstandingBytesPerPeer)
+		if s := cfg.Bitswap.MaxOutstandingBytesPerPeer.WithDefault(""); s != "" {
+			n, err := humanize.ParseBytes(s)
+			if err != nil {
+				return nil, fmt.Errorf("failure to parse config setting Bitswap.MaxOutstandingBytesPerPeer: %w", err)
+			}
+			maxOutstandingBytes = int64(n)
+		}
+
+		maxWantlistSize := cfg.Bitswap.MaxWantlistSize.WithDefault(DefaultBitswapMaxWantlistSize)
+		if maxWantlistSize < 0 {
+			return nil, fmt.Errorf("config setting Bitswap.MaxWantlistSize can not be negative: %d", maxWantlistSize)
+		}
+
+		serverEnabled := cfg.Bitswap.ServerEnabled.WithDefault(DefaultBitswapServerEnabled)
+		clientEnabled := cfg.Bitswap.ClientEnabled.WithDefault(DefaultBitswapClientEnabled)
+
+		pol := policy.Policy{
+			ServerEnabled:   serverEnabled,
+			Allow:           cfg.Bitswap.ServeAllow,
+			Deny:            cfg.Bitswap.ServeDeny,
+			MaxWantlistSize: int(maxWantlistSize),
+			Limiter:         limiter,
+		}
+		bitswapNetwork := pol.Network(network.NewFromIpfsHost(host, rt))
+
 		opts := []bitswap.Option{
-			bitswap.ProvideEnabled(provide),
+			// a node that doesn't serve blocks shouldn't advertise them
+			bitswap.ProvideEnabled(provide && serverEnabled),
 			bitswap.EngineBlockstoreWorkerCount(int(internalBsCfg.EngineBlockstoreWorkerCount.WithDefault(DefaultEngineBlockstoreWorkerCount))),
 			bitswap.TaskWorkerCount(int(internalBsCfg.TaskWorkerCount.WithDefault(DefaultTaskWorkerCount))),
 			bitswap.EngineTaskWorkerCount(int(internalBsCfg.EngineTaskWorkerCount.WithDefault(DefaultEngineTaskWorkerCount))),
-			bitswap.MaxOutstandingBytesPerPeer(int(internalBsCfg.MaxOutstandingBytesPerPeer.WithDefault(DefaultMaxOutstandingBytesPerPeer))),
+			bitswap.MaxOutstandingBytesPerPeer(int(maxOutstandingBytes)),
+		}
+		if filter := pol.BlockRequestFilter(); filter != nil {
+			opts = append(opts, bitswap.WithPeerBlockRequestFilter(filter))
+		}
+
+		ctx := helpers.LifecycleCtx(mctx, lc)
+		exch := bitswap.New(ctx, bitswapNetwork, bs, opts...)
+		if limiter != nil {
+			go limiter.TrackLedgers(ctx, exch.(policy.Ledgers))
 		}
-		exch := bitswap.New(helpers.LifecycleCtx(mctx, lc), bitswapNetwork, bs, opts...)
 		lc.Append(fx.Hook{
 			OnStop: func(ctx context.Context) error {
 				return exch.Close()
 			},
 		})
-		return exch
+		var ex exchange.Interface = exch
+		if !clientEnabled {
+			ex = &policy.ServeOnly{Interface: ex}
+		}
+		return withHTTPRetrieval(cfg.HTTPRetrieval, ex)
+	}
+}
 
+// withHTTPRetrieval wraps ex to also fetch blocks from the trustless gateways
+// in the HTTPRetrieval config, if any.
+func withHTTPRetrieval(cfg config.HTTPRetrieval, ex exchange.Interface) (exchange.Interface, error) {
+	if len(cfg.Gateways) == 0 {
+		return ex, nil
+	}
+	for _, u := range cfg.Gateways {
+		parsed, err := url.Parse(u)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return nil, fmt.Errorf("invalid gateway URL %q in HTTPRetrieval.Gateways", u)
+		}
+	}
+	workers := cfg.MaxConcurrentRequests.WithDefault(DefaultHTTPRetrievalMaxConcurrentRequests)
+	if workers <= 0 {
+		return nil, fmt.Errorf("config setting HTTPRetrieval.MaxConcurrentRequests must be positive: %d", workers)
 	}
+
+	client := trustless.NewClient(cfg.Gateways, cfg.Timeout.WithDefault(DefaultHTTPRetrievalTimeout))
+	delay := cfg.FallbackDelay.WithDefault(DefaultHTTPRetrievalFallbackDelay)
+	return trustless.New(ex, client, delay, int(workers)), nil
 }
@@ -76,7 +76,7 @@ func LibP2P(bcfg *BuildCfg, cfg *config.Config) fx.Option {
 		var pubsubOptions []pubsub.Option
 		pubsubOptions = append(
 			pubsubOptions,
-			pubsub.WithMessageSigning(!cfg.Pubsub.DisableSigning),
+			pubsub.WithMessageSignaturePolicy(PubsubSignaturePolicy(cfg.Pubsub)),
 		)
 
 		switch cfg.Pubsub.Router {
@@ -89,6 +89,11 @@ func LibP2P(bcfg *BuildCfg, cfg *config.Config) fx.Option {
 		default:
 			return fx.Error(fmt.Errorf("unknown pubsub router %s", cfg.Pubsub.Router))
 		}
+
+		ps = fx.Options(ps, fx.Invoke(PubsubTopicPolicies(cfg.Pubsub)))
+		if bcfg.getOpt("pubsub") {
+			ps = fx.Options(ps, fx.Provide(PubsubHistory(cfg.Pubsub)))
+		}
 	}
 
 	autonat := fx.Options()
@@ -151,7 +156,8 @@ func LibP2P(bcfg *BuildCfg, cfg *config.Config) fx.Option {
 
 		// Services (resource management)
 		fx.Provide(libp2p.ResourceManager(cfg.Swarm)),
-		fx.Provide(libp2p.AddrFilters(cfg.Swarm.AddrFilters)),
+		fx.Provide(libp2p.AddrFilters(cfg.Swarm.AddrFilters, cfg.Swarm.ConnFilters)),
+		fx.Invoke(libp2p.StartConnFilter),
 		fx.Provide(libp2p.AddrsFactory(cfg.Addresses.Announce, cfg.Addresses.AppendAnnounce, cfg.Addresses.NoAnnounce)),
 		fx.Provide(libp2p.SmuxTransport(cfg.Swarm.Transports)),
 		fx.Provide(libp2p.RelayTransport(enableRelayTransport)),
@@ -188,9 +194,20 @@ func LibP2P(bcfg *BuildCfg, cfg *config.Config) fx.Option {
 func Storage(bcfg *BuildCfg, cfg *config.Config) fx.Option {
 	cacheOpts := blockstore.DefaultCacheOpts()
 	cacheOpts.HasBloomFilterSize = cfg.Datastore.BloomFilterSize
+	var blockCacheOpts BlockCacheOpts
+	if cfg.Datastore.BloomFilterAutoSize.WithDefault(false) {
+		cacheOpts.HasBloomFilterSize = 0
+		blockCacheOpts.AutoBloom = bcfg.Permanent
+		blockCacheOpts.BloomRebuildPeriod = cfg.Datastore.BloomFilterRebuildPeriod.WithDefault(DefaultBloomFilterRebuildPeriod)
+	}
 	if !bcfg.Permanent {
 		cacheOpts.HasBloomFilterSize = 0
 	}
+	blockCacheSize, err := humanize.ParseBytes(cfg.Datastore.BlockCacheSize.WithDefault("0"))
+	if err != nil {
+		return fx.Error(fmt.Errorf("failure to parse config setting Datastore.BlockCacheSize: %w", err))
+	}
+	blockCacheOpts.BlockCacheSize = blockCacheSize
 
 	finalBstore := fx.Provide(GcBlockstoreCtor)
 	if cfg.Experimental.FilestoreEnabled || cfg.Experimental.UrlstoreEnabled {
@@ -200,7 +217,7 @@ func Storage(bcfg *BuildCfg, cfg *config.Config) fx.Option {
 	return fx.Options(
 		fx.Provide(RepoConfig),
 		fx.Provide(Datastore),
-		fx.Provide(BaseBlockstoreCtor(cacheOpts, bcfg.NilRepo, cfg.Datastore.HashOnRead)),
+		fx.Provide(BaseBlockstoreCtor(cacheOpts, blockCacheOpts, bcfg.NilRepo, cfg.Datastore.HashOnRead)),
 		finalBstore,
 	)
 }
@@ -289,20 +306,27 @@ func Online(bcfg *BuildCfg, cfg *config.Config) fx.Option {
 		recordLifetime = d
 	}
 
+	if !cfg.Experimental.Libp2pStreamMounting && (len(cfg.P2P.Listeners) > 0 || len(cfg.P2P.Forwards) > 0) {
+		return fx.Error(fmt.Errorf("config settings P2P.Listeners and P2P.Forwards require Experimental.Libp2pStreamMounting"))
+	}
+
 	/* don't provide from bitswap when the strategic provider service is active */
 	shouldBitswapProvide := !cfg.Experimental.StrategicProviding
 
 	return fx.Options(
+		fx.Provide(BitswapLimiter(cfg.Bitswap.PeerRateLimits)),
 		fx.Provide(OnlineExchange(cfg, shouldBitswapProvide)),
 		maybeProvide(Graphsync, cfg.Experimental.GraphsyncEnabled),
 		fx.Provide(DNSResolver),
 		fx.Provide(Namesys(ipnsCacheSize)),
 		fx.Provide(Peering),
 		PeerWith(cfg.Peering.Peers...),
+		PeeringGroups(cfg.Peering),
 
 		fx.Invoke(IpnsRepublisher(repubPeriod, recordLifetime)),
 
 		fx.Provide(p2p.New),
+		maybeInvoke(P2PPersisted(cfg.P2P), cfg.Experimental.Libp2pStreamMounting),
 
 		LibP2P(bcfg, cfg),
 		OnlineProviders(cfg.Experimental.StrategicProviding, cfg.Experimental.AcceleratedDHTClient, cfg.Reprovider.Strategy, cfg.Reprovider.Interval),
@@ -328,6 +352,7 @@ var Core = fx.Options(
 	fx.Provide(Dag),
 	fx.Provide(FetcherConfig),
 	fx.Provide(Pinning),
+	fx.Provide(ProvideExclusions),
 	fx.Provide(Files),
 )
 
@@ -375,5 +400,6 @@ func IPFS(ctx context.Context, bcfg *BuildCfg) fx.Option {
 		Networked(bcfg, cfg),
 
 		Core,
+		maybeProvide(Scrubber(cfg.Datastore), bcfg.Permanent),
 	)
 }
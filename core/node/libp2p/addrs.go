@@ -3,6 +3,7 @@ package libp2p
 import (
 	"fmt"
 
+	config "github.com/ipfs/kubo/config"
 	"github.com/libp2p/go-libp2p"
 	"github.com/libp2p/go-libp2p-core/host"
 	p2pbhost "github.com/libp2p/go-libp2p/p2p/host/basic"
@@ -10,18 +11,22 @@ import (
 	mamask "github.com/whyrusleeping/multiaddr-filter"
 )
 
-func AddrFilters(filters []string) func() (*ma.Filters, Libp2pOpts, error) {
-	return func() (filter *ma.Filters, opts Libp2pOpts, err error) {
+func AddrFilters(filters []string, connFilters config.ConnFilters) func(*resourceAllowlist) (*ma.Filters, *ConnFilter, Libp2pOpts, error) {
+	return func(allowlist *resourceAllowlist) (filter *ma.Filters, conns *ConnFilter, opts Libp2pOpts, err error) {
 		filter = ma.NewFilters()
-		opts.Opts = append(opts.Opts, libp2p.ConnectionGater((*filtersConnectionGater)(filter)))
+		conns, err = newConnFilter(connFilters)
+		if err != nil {
+			return filter, conns, opts, err
+		}
+		opts.Opts = append(opts.Opts, libp2p.ConnectionGater(&filtersConnectionGater{filters: filter, conns: conns, allowlist: allowlist}))
 		for _, s := range filters {
 			f, err := mamask.NewMask(s)
 			if err != nil {
-				return filter, opts, fmt.Errorf("incorrectly formatted address filter in config: %s", s)
+				return filter, conns, opts, fmt.Errorf("incorrectly formatted address filter in config: %s", s)
 			}
 			filter.AddFilter(*f, ma.ActionDeny)
 		}
-		return filter, opts, nil
+		return filter, conns, opts, nil
 	}
 }
 
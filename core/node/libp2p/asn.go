@@ -0,0 +1,90 @@
+package libp2p
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// asnRange maps an IP range to its autonomous system.
+type asnRange struct {
+	start, end net.IP // 16 bytes
+	asn        uint32
+}
+
+// asnDatabase is an offline IP to ASN database.
+type asnDatabase struct {
+	ranges []asnRange // sorted by start
+}
+
+// loadASNDatabase reads an IP to ASN database in the tab-separated format of
+// iptoasn.com: range start, range end, ASN, then optional fields.
+func loadASNDatabase(path string) (*asnDatabase, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	db, err := parseASNDatabase(f)
+	if err != nil {
+		return nil, fmt.Errorf("reading ASN database %s: %w", path, err)
+	}
+	return db, nil
+}
+
+func parseASNDatabase(r io.Reader) (*asnDatabase, error) {
+	db := &asnDatabase{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Split(text, "\t")
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("line %d: expected a range start, a range end and an ASN", line)
+		}
+		start, end := net.ParseIP(fields[0]), net.ParseIP(fields[1])
+		if start == nil || end == nil || bytes.Compare(start.To16(), end.To16()) > 0 {
+			return nil, fmt.Errorf("line %d: invalid range %s-%s", line, fields[0], fields[1])
+		}
+		asn, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid ASN: %w", line, err)
+		}
+		if asn == 0 { // not routed
+			continue
+		}
+		db.ranges = append(db.ranges, asnRange{start: start.To16(), end: end.To16(), asn: uint32(asn)})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(db.ranges, func(i, j int) bool {
+		return bytes.Compare(db.ranges[i].start, db.ranges[j].start) < 0
+	})
+	return db, nil
+}
+
+// lookup returns the autonomous system of ip.
+func (db *asnDatabase) lookup(ip net.IP) (uint32, bool) {
+	ip = ip.To16()
+	if ip == nil {
+		return 0, false
+	}
+	i := sort.Search(len(db.ranges), func(i int) bool {
+		return bytes.Compare(db.ranges[i].start, ip) > 0
+	}) - 1
+	if i < 0 || bytes.Compare(ip, db.ranges[i].end) > 0 {
+		return 0, false
+	}
+	return db.ranges[i].asn, true
+}
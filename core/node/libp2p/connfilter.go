@@ -0,0 +1,365 @@
+package libp2p
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	config "github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/core/node/helpers"
+
+	"github.com/libp2p/go-libp2p-core/event"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"go.uber.org/fx"
+)
+
+// filterLogSize is the number of blocked connections kept in the audit log.
+const filterLogSize = 1024
+
+// addrFiltersName names Swarm.AddrFilters in the audit log.
+const addrFiltersName = "Swarm.AddrFilters"
+
+// FilterLogEntry is an inbound connection blocked by the swarm filters.
+type FilterLogEntry struct {
+	Time   time.Time
+	Peer   string `json:",omitempty"`
+	Addr   string
+	Filter string
+	Reason string `json:",omitempty"`
+}
+
+// connFilterRule is a parsed Swarm.ConnFilters rule.
+type connFilterRule struct {
+	name      string
+	action    string
+	limit     int
+	asns      map[uint32]struct{}
+	agent     *regexp.Regexp
+	protocols []string
+
+	// count is the number of connections kept by a limit rule, guarded by
+	// the ConnFilter lock
+	count int
+}
+
+func parseConnFilterRule(i int, cfg config.ConnFilterRule) (*connFilterRule, error) {
+	r := &connFilterRule{
+		name:      fmt.Sprintf("Swarm.ConnFilters.Rules[%d]", i),
+		action:    cfg.Action,
+		limit:     cfg.Limit,
+		protocols: cfg.MissingProtocols,
+	}
+	switch cfg.Action {
+	case config.ConnFilterDeny:
+	case config.ConnFilterLimit:
+		if cfg.Limit <= 0 {
+			return nil, fmt.Errorf("%s: the limit must be positive", r.name)
+		}
+	default:
+		return nil, fmt.Errorf("%s: invalid action %q, expected %q or %q", r.name, cfg.Action, config.ConnFilterDeny, config.ConnFilterLimit)
+	}
+	if len(cfg.ASNs) > 0 {
+		r.asns = make(map[uint32]struct{}, len(cfg.ASNs))
+		for _, asn := range cfg.ASNs {
+			r.asns[asn] = struct{}{}
+		}
+	}
+	if cfg.AgentVersion != "" {
+		agent, err := regexp.Compile(cfg.AgentVersion)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid agent version pattern: %w", r.name, err)
+		}
+		r.agent = agent
+	}
+	if r.asns == nil && r.agent == nil && len(r.protocols) == 0 {
+		return nil, fmt.Errorf("%s: expected ASNs, an agent version or missing protocols to match", r.name)
+	}
+	return r, nil
+}
+
+// identify returns whether the rule matches on the identify results.
+func (r *connFilterRule) identify() bool {
+	return r.agent != nil || len(r.protocols) > 0
+}
+
+// filteredConn is an inbound connection the rules were applied to.
+type filteredConn struct {
+	conn       network.Conn
+	counted    []*connFilterRule
+	identified bool
+}
+
+// ConnFilter applies the Swarm.ConnFilters rules to the inbound connections,
+// and keeps the audit log of the connections blocked by the swarm filters.
+type ConnFilter struct {
+	asns  *asnDatabase // nil without Swarm.ConnFilters.ASNDatabase
+	rules []*connFilterRule
+	host  host.Host
+
+	mu    sync.Mutex
+	conns map[string]*filteredConn
+	log   []FilterLogEntry // ring buffer
+	next  int
+}
+
+func newConnFilter(cfg config.ConnFilters) (*ConnFilter, error) {
+	f := &ConnFilter{conns: make(map[string]*filteredConn)}
+	for i, c := range cfg.Rules {
+		r, err := parseConnFilterRule(i, c)
+		if err != nil {
+			return nil, err
+		}
+		if r.asns != nil && cfg.ASNDatabase == "" {
+			return nil, fmt.Errorf("%s: matching ASNs requires Swarm.ConnFilters.ASNDatabase", r.name)
+		}
+		f.rules = append(f.rules, r)
+	}
+	if cfg.ASNDatabase != "" {
+		asns, err := loadASNDatabase(cfg.ASNDatabase)
+		if err != nil {
+			return nil, err
+		}
+		f.asns = asns
+	}
+	return f, nil
+}
+
+// Log returns the connections blocked by the swarm filters, oldest first.
+func (f *ConnFilter) Log() []FilterLogEntry {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make([]FilterLogEntry, 0, len(f.log))
+	if len(f.log) == filterLogSize {
+		out = append(out, f.log[f.next:]...)
+	}
+	return append(out, f.log[:f.next]...)
+}
+
+// audit records a blocked connection. The filter must be locked.
+func (f *ConnFilter) audit(p peer.ID, addr ma.Multiaddr, filter, reason string) {
+	e := FilterLogEntry{Time: time.Now(), Addr: addr.String(), Filter: filter, Reason: reason}
+	if p != "" {
+		e.Peer = p.String()
+	}
+	log.Debugw("blocked inbound connection", "peer", e.Peer, "addr", e.Addr, "filter", filter, "reason", reason)
+	if len(f.log) < filterLogSize {
+		f.log = append(f.log, e)
+	} else {
+		f.log[f.next] = e
+	}
+	f.next = (f.next + 1) % filterLogSize
+}
+
+// blockedByAddrFilters records an inbound connection blocked by
+// Swarm.AddrFilters.
+func (f *ConnFilter) blockedByAddrFilters(p peer.ID, addr ma.Multiaddr) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.audit(p, addr, addrFiltersName, "")
+}
+
+// accept returns whether an inbound connection from addr is allowed by the
+// rules denying autonomous systems, before the connection is even secured.
+func (f *ConnFilter) accept(addr ma.Multiaddr) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, r := range f.rules {
+		if r.action != config.ConnFilterDeny || r.identify() {
+			continue
+		}
+		if match, reason := f.matchASN(r, addr); match {
+			f.audit("", addr, r.name, reason)
+			return false
+		}
+	}
+	return true
+}
+
+func (f *ConnFilter) matchASN(r *connFilterRule, addr ma.Multiaddr) (bool, string) {
+	if r.asns == nil {
+		return true, ""
+	}
+	ip, err := manet.ToIP(addr)
+	if err != nil {
+		return false, ""
+	}
+	asn, ok := f.asns.lookup(ip)
+	if !ok {
+		return false, ""
+	}
+	_, match := r.asns[asn]
+	return match, fmt.Sprintf("AS%d", asn)
+}
+
+func (f *ConnFilter) matchPeer(r *connFilterRule, p peer.ID) (bool, string) {
+	var reasons []string
+	if r.agent != nil {
+		agent, _ := f.host.Peerstore().Get(p, "AgentVersion")
+		s, _ := agent.(string)
+		if !r.agent.MatchString(s) {
+			return false, ""
+		}
+		reasons = append(reasons, fmt.Sprintf("agent version %q", s))
+	}
+	if len(r.protocols) > 0 {
+		supported, err := f.host.Peerstore().SupportsProtocols(p, r.protocols...)
+		if err != nil {
+			return false, ""
+		}
+		missing := make([]string, 0, len(r.protocols))
+	protocols:
+		for _, proto := range r.protocols {
+			for _, s := range supported {
+				if s == proto {
+					continue protocols
+				}
+			}
+			missing = append(missing, proto)
+		}
+		if len(missing) == 0 {
+			return false, ""
+		}
+		reasons = append(reasons, "missing "+strings.Join(missing, ", "))
+	}
+	return true, strings.Join(reasons, ", ")
+}
+
+// apply applies the rules matching on the identify results, or the others,
+// to the connection. The filter must be locked.
+func (f *ConnFilter) apply(fc *filteredConn, identify bool) {
+	c := fc.conn
+	for _, r := range f.rules {
+		if r.identify() != identify {
+			continue
+		}
+		match, reason := f.matchASN(r, c.RemoteMultiaddr())
+		if !match {
+			continue
+		}
+		if identify {
+			var peerReason string
+			if match, peerReason = f.matchPeer(r, c.RemotePeer()); !match {
+				continue
+			}
+			if reason != "" {
+				reason += ", "
+			}
+			reason += peerReason
+		}
+
+		if r.action == config.ConnFilterLimit {
+			if r.count < r.limit {
+				r.count++
+				fc.counted = append(fc.counted, r)
+				continue
+			}
+			reason = fmt.Sprintf("limit of %d connections reached: %s", r.limit, reason)
+		}
+		f.reject(fc, r.name, reason)
+		return
+	}
+}
+
+// reject closes a connection blocked by a rule. The filter must be locked.
+func (f *ConnFilter) reject(fc *filteredConn, filter, reason string) {
+	f.release(fc)
+	f.audit(fc.conn.RemotePeer(), fc.conn.RemoteMultiaddr(), filter, reason)
+	go fc.conn.Close()
+}
+
+// release stops counting the connection. The filter must be locked.
+func (f *ConnFilter) release(fc *filteredConn) {
+	for _, r := range fc.counted {
+		r.count--
+	}
+	fc.counted = nil
+	delete(f.conns, fc.conn.ID())
+}
+
+func (f *ConnFilter) connected(c network.Conn) {
+	if c.Stat().Direction != network.DirInbound {
+		return
+	}
+	// the peer may already be identified by another connection
+	_, err := f.host.Peerstore().Get(c.RemotePeer(), "AgentVersion")
+	identified := err == nil
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fc := &filteredConn{conn: c}
+	f.conns[c.ID()] = fc
+	f.apply(fc, false)
+	if _, ok := f.conns[c.ID()]; ok && identified {
+		fc.identified = true
+		f.apply(fc, true)
+	}
+}
+
+func (f *ConnFilter) disconnected(c network.Conn) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if fc, ok := f.conns[c.ID()]; ok {
+		f.release(fc)
+	}
+}
+
+func (f *ConnFilter) identified(p peer.ID) {
+	conns := f.host.Network().ConnsToPeer(p)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, c := range conns {
+		if fc, ok := f.conns[c.ID()]; ok && !fc.identified {
+			fc.identified = true
+			f.apply(fc, true)
+		}
+	}
+}
+
+// StartConnFilter applies the Swarm.ConnFilters rules to the inbound
+// connections of the host.
+func StartConnFilter(mctx helpers.MetricsCtx, lc fx.Lifecycle, host host.Host, f *ConnFilter) error {
+	if len(f.rules) == 0 {
+		return nil
+	}
+	f.host = host
+
+	sub, err := host.EventBus().Subscribe(new(event.EvtPeerIdentificationCompleted))
+	if err != nil {
+		return err
+	}
+	notifee := &network.NotifyBundle{
+		ConnectedF:    func(_ network.Network, c network.Conn) { f.connected(c) },
+		DisconnectedF: func(_ network.Network, c network.Conn) { f.disconnected(c) },
+	}
+	host.Network().Notify(notifee)
+
+	ctx := helpers.LifecycleCtx(mctx, lc)
+	go func() {
+		for {
+			select {
+			case e, ok := <-sub.Out():
+				if !ok {
+					return
+				}
+				f.identified(e.(event.EvtPeerIdentificationCompleted).Peer)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	lc.Append(fx.Hook{
+		OnStop: func(context.Context) error {
+			host.Network().StopNotify(notifee)
+			return sub.Close()
+		},
+	})
+	return nil
+}
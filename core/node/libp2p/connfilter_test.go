@@ -0,0 +1,168 @@
+package libp2p
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	config "github.com/ipfs/kubo/config"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/fx/fxtest"
+)
+
+const testASNDatabase = `1.0.0.0	1.0.0.255	13335	US	CLOUDFLARENET
+1.0.1.0	1.0.3.255	0	None	Not routed
+127.0.0.0	127.255.255.255	64512	ZZ	LOOPBACK
+2001:db8::	2001:db8::ffff	64496	ZZ	DOCUMENTATION
+`
+
+func TestASNDatabase(t *testing.T) {
+	db, err := parseASNDatabase(strings.NewReader(testASNDatabase))
+	require.NoError(t, err)
+
+	for ip, expected := range map[string]uint32{
+		"1.0.0.1":      13335,
+		"1.0.0.255":    13335,
+		"127.0.0.1":    64512,
+		"2001:db8::10": 64496,
+	} {
+		asn, ok := db.lookup(net.ParseIP(ip))
+		require.True(t, ok, ip)
+		require.Equal(t, expected, asn, ip)
+	}
+	for _, ip := range []string{"1.0.1.1", "2.0.0.0", "2001:db9::"} {
+		_, ok := db.lookup(net.ParseIP(ip))
+		require.False(t, ok, ip)
+	}
+
+	_, err = parseASNDatabase(strings.NewReader("1.0.0.0\t1.0.0.255\n"))
+	require.Error(t, err)
+	_, err = parseASNDatabase(strings.NewReader("1.0.0.255\t1.0.0.0\t1\n"))
+	require.Error(t, err)
+}
+
+func TestConnFilterConfig(t *testing.T) {
+	for _, rule := range []config.ConnFilterRule{
+		{Action: "drop", AgentVersion: "x"},
+		{Action: config.ConnFilterLimit, AgentVersion: "x"},
+		{Action: config.ConnFilterDeny},
+		{Action: config.ConnFilterDeny, AgentVersion: "("},
+		{Action: config.ConnFilterDeny, ASNs: []uint32{1}}, // without database
+	} {
+		_, err := newConnFilter(config.ConnFilters{Rules: []config.ConnFilterRule{rule}})
+		require.Error(t, err, rule)
+	}
+}
+
+func TestConnFilterLog(t *testing.T) {
+	f, err := newConnFilter(config.ConnFilters{})
+	require.NoError(t, err)
+	addr := ma.StringCast("/ip4/1.2.3.4/tcp/4001")
+	for i := 0; i < filterLogSize+10; i++ {
+		f.blockedByAddrFilters("", addr)
+	}
+	log := f.Log()
+	require.Len(t, log, filterLogSize)
+	require.Equal(t, addrFiltersName, log[0].Filter)
+	require.False(t, log[len(log)-1].Time.Before(log[0].Time))
+}
+
+func TestConnFilter(t *testing.T) {
+	db := filepath.Join(t.TempDir(), "ip2asn.tsv")
+	require.NoError(t, os.WriteFile(db, []byte(testASNDatabase), 0o600))
+
+	newFilteredHost := func(t *testing.T, cfg config.ConnFilters) (host.Host, *ConnFilter) {
+		f, err := newConnFilter(cfg)
+		require.NoError(t, err)
+		h, err := libp2p.New(
+			libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"),
+			libp2p.ConnectionGater(&filtersConnectionGater{filters: ma.NewFilters(), conns: f}),
+		)
+		require.NoError(t, err)
+		t.Cleanup(func() { h.Close() })
+
+		lc := fxtest.NewLifecycle(t)
+		require.NoError(t, StartConnFilter(context.Background(), lc, h, f))
+		lc.RequireStart()
+		t.Cleanup(lc.RequireStop)
+		return h, f
+	}
+	newHost := func(t *testing.T, agent string) host.Host {
+		h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"), libp2p.UserAgent(agent))
+		require.NoError(t, err)
+		t.Cleanup(func() { h.Close() })
+		return h
+	}
+	connect := func(h, to host.Host) error {
+		return h.Connect(context.Background(), peer.AddrInfo{ID: to.ID(), Addrs: to.Addrs()})
+	}
+
+	t.Run("asn", func(t *testing.T) {
+		h1, f := newFilteredHost(t, config.ConnFilters{
+			ASNDatabase: db,
+			Rules:       []config.ConnFilterRule{{Action: config.ConnFilterDeny, ASNs: []uint32{64512}}},
+		})
+		require.Error(t, connect(newHost(t, "any"), h1))
+		log := f.Log()
+		require.Len(t, log, 1)
+		require.Equal(t, "Swarm.ConnFilters.Rules[0]", log[0].Filter)
+		require.Equal(t, "AS64512", log[0].Reason)
+	})
+
+	t.Run("agent version limit", func(t *testing.T) {
+		h1, f := newFilteredHost(t, config.ConnFilters{
+			Rules: []config.ConnFilterRule{{Action: config.ConnFilterLimit, Limit: 1, AgentVersion: "^crawler/"}},
+		})
+		h2, h3, h4 := newHost(t, "crawler/1.0"), newHost(t, "crawler/2.0"), newHost(t, "kubo/0.15")
+		require.NoError(t, connect(h2, h1))
+		require.NoError(t, connect(h4, h1))
+		require.NoError(t, connect(h3, h1))
+
+		// the second crawler is disconnected once identified
+		require.Eventually(t, func() bool {
+			return h1.Network().Connectedness(h3.ID()) != network.Connected
+		}, 5*time.Second, 10*time.Millisecond)
+		require.Equal(t, network.Connected, h1.Network().Connectedness(h2.ID()))
+		require.Equal(t, network.Connected, h1.Network().Connectedness(h4.ID()))
+		log := f.Log()
+		require.Len(t, log, 1)
+		require.Equal(t, h3.ID().String(), log[0].Peer)
+		require.Contains(t, log[0].Reason, `limit of 1 connections reached: agent version "crawler/2.0"`)
+
+		// until the first one leaves
+		require.NoError(t, h1.Network().ClosePeer(h2.ID()))
+		require.Eventually(t, func() bool {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			return f.rules[0].count == 0
+		}, 5*time.Second, 10*time.Millisecond)
+		require.NoError(t, connect(h3, h1))
+		require.Never(t, func() bool {
+			return h1.Network().Connectedness(h3.ID()) != network.Connected
+		}, time.Second, 50*time.Millisecond)
+	})
+
+	t.Run("missing protocols", func(t *testing.T) {
+		h1, f := newFilteredHost(t, config.ConnFilters{
+			Rules: []config.ConnFilterRule{{Action: config.ConnFilterDeny, MissingProtocols: []string{"/ipfs/bitswap/1.2.0"}}},
+		})
+		h2 := newHost(t, "kubo/0.15")
+		require.NoError(t, connect(h2, h1))
+		require.Eventually(t, func() bool {
+			return h1.Network().Connectedness(h2.ID()) != network.Connected
+		}, 5*time.Second, 10*time.Millisecond)
+		require.Eventually(t, func() bool {
+			log := f.Log()
+			return len(log) == 1 && log[0].Reason == "missing /ipfs/bitswap/1.2.0"
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+}
@@ -10,13 +10,23 @@ import (
 )
 
 // filtersConnectionGater is an adapter that turns multiaddr.Filter into a
-// connmgr.ConnectionGater.
-type filtersConnectionGater ma.Filters
+// connmgr.ConnectionGater. It also denies the inbound connections blocked by
+// the Swarm.ConnFilters rules, and reports the addresses of the peers to the
+// resource manager allowlist.
+type filtersConnectionGater struct {
+	filters   *ma.Filters
+	conns     *ConnFilter
+	allowlist *resourceAllowlist // nil without the resource manager
+}
 
 var _ connmgr.ConnectionGater = (*filtersConnectionGater)(nil)
 
-func (f *filtersConnectionGater) InterceptAddrDial(_ peer.ID, addr ma.Multiaddr) (allow bool) {
-	return !(*ma.Filters)(f).AddrBlocked(addr)
+func (f *filtersConnectionGater) InterceptAddrDial(p peer.ID, addr ma.Multiaddr) (allow bool) {
+	if f.filters.AddrBlocked(addr) {
+		return false
+	}
+	f.allowlist.observe(p, addr)
+	return true
 }
 
 func (f *filtersConnectionGater) InterceptPeerDial(p peer.ID) (allow bool) {
@@ -24,11 +34,22 @@ func (f *filtersConnectionGater) InterceptPeerDial(p peer.ID) (allow bool) {
 }
 
 func (f *filtersConnectionGater) InterceptAccept(connAddr network.ConnMultiaddrs) (allow bool) {
-	return !(*ma.Filters)(f).AddrBlocked(connAddr.RemoteMultiaddr())
+	if f.filters.AddrBlocked(connAddr.RemoteMultiaddr()) {
+		f.conns.blockedByAddrFilters("", connAddr.RemoteMultiaddr())
+		return false
+	}
+	return f.conns.accept(connAddr.RemoteMultiaddr())
 }
 
-func (f *filtersConnectionGater) InterceptSecured(_ network.Direction, _ peer.ID, connAddr network.ConnMultiaddrs) (allow bool) {
-	return !(*ma.Filters)(f).AddrBlocked(connAddr.RemoteMultiaddr())
+func (f *filtersConnectionGater) InterceptSecured(dir network.Direction, p peer.ID, connAddr network.ConnMultiaddrs) (allow bool) {
+	if f.filters.AddrBlocked(connAddr.RemoteMultiaddr()) {
+		if dir == network.DirInbound {
+			f.conns.blockedByAddrFilters(p, connAddr.RemoteMultiaddr())
+		}
+		return false
+	}
+	f.allowlist.observe(p, connAddr.RemoteMultiaddr())
+	return true
 }
 
 func (f *filtersConnectionGater) InterceptUpgraded(_ network.Conn) (allow bool, reason control.DisconnectReason) {
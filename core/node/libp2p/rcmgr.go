@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/benbjohnson/clock"
 	logging "github.com/ipfs/go-log/v2"
@@ -28,8 +29,9 @@ const NetLimitTraceFilename = "rcmgr.json.gz"
 var NoResourceMgrError = fmt.Errorf("missing ResourceMgr: make sure the daemon is running with Swarm.ResourceMgr.Enabled")
 
 func ResourceManager(cfg config.SwarmConfig) interface{} {
-	return func(mctx helpers.MetricsCtx, lc fx.Lifecycle, repo repo.Repo) (network.ResourceManager, Libp2pOpts, error) {
+	return func(mctx helpers.MetricsCtx, lc fx.Lifecycle, repo repo.Repo) (network.ResourceManager, *resourceAllowlist, Libp2pOpts, error) {
 		var manager network.ResourceManager
+		var allowlist *resourceAllowlist
 		var opts Libp2pOpts
 
 		enabled := cfg.ResourceMgr.Enabled.WithDefault(false)
@@ -47,22 +49,25 @@ func ResourceManager(cfg config.SwarmConfig) interface{} {
 
 			repoPath, err := config.PathRoot()
 			if err != nil {
-				return nil, opts, fmt.Errorf("opening IPFS_PATH: %w", err)
+				return nil, nil, opts, fmt.Errorf("opening IPFS_PATH: %w", err)
 			}
 
-			defaultLimits := adjustedDefaultLimits(cfg)
-
-			var limits rcmgr.BasicLimiterConfig
-			if cfg.ResourceMgr.Limits != nil {
-				limits = *cfg.ResourceMgr.Limits
+			basicLimiter, err := newLimiter(cfg)
+			if err != nil {
+				return nil, nil, opts, err
 			}
+			limiter := &reloadableLimiter{limiter: basicLimiter}
 
-			limiter, err := rcmgr.NewLimiter(limits, defaultLimits)
+			allowlistLimiter, err := newAllowlistLimiter(cfg)
 			if err != nil {
-				return nil, opts, err
+				return nil, nil, opts, err
 			}
+			allowedLimiter := &reloadableLimiter{limiter: allowlistLimiter}
 
-			libp2p.SetDefaultServiceLimits(limiter)
+			allowlist, err = newResourceAllowlist(cfg.ResourceMgr.Allowlist)
+			if err != nil {
+				return nil, nil, opts, err
+			}
 
 			ropts := []rcmgr.Option{rcmgr.WithMetrics(createRcmgrMetrics())}
 
@@ -71,10 +76,22 @@ func ResourceManager(cfg config.SwarmConfig) interface{} {
 				ropts = append(ropts, rcmgr.WithTrace(traceFilePath))
 			}
 
-			manager, err = rcmgr.NewResourceManager(limiter, ropts...)
+			regular, err := rcmgr.NewResourceManager(limiter, ropts...)
 			if err != nil {
-				return nil, opts, fmt.Errorf("creating libp2p resource manager: %w", err)
+				return nil, nil, opts, fmt.Errorf("creating libp2p resource manager: %w", err)
 			}
+			allowed, err := rcmgr.NewResourceManager(allowedLimiter)
+			if err != nil {
+				return nil, nil, opts, fmt.Errorf("creating libp2p resource manager: %w", err)
+			}
+			manager = &allowlistResourceManager{regular: regular, allowed: allowed, allowlist: allowlist}
+
+			newLimitReloader(repo,
+				reloadLimits(regular, limiter, newLimiter),
+				reloadLimits(allowed, allowedLimiter, newAllowlistLimiter),
+				func(cfg config.SwarmConfig) error { return allowlist.set(cfg.ResourceMgr.Allowlist) },
+			).start(helpers.LifecycleCtx(mctx, lc))
+
 			lrm := &loggingResourceManager{
 				clock:    clock.New(),
 				logger:   &logging.Logger("resourcemanager").SugaredLogger,
@@ -94,7 +111,7 @@ func ResourceManager(cfg config.SwarmConfig) interface{} {
 				return manager.Close()
 			}})
 
-		return manager, opts, nil
+		return manager, allowlist, opts, nil
 	}
 }
 
@@ -104,6 +121,7 @@ type NetStatOut struct {
 	Services  map[string]network.ScopeStat `json:",omitempty"`
 	Protocols map[string]network.ScopeStat `json:",omitempty"`
 	Peers     map[string]network.ScopeStat `json:",omitempty"`
+	Allowlist *network.ScopeStat           `json:",omitempty"`
 }
 
 func NetStat(mgr network.ResourceManager, scope string) (NetStatOut, error) {
@@ -134,6 +152,16 @@ func NetStat(mgr network.ResourceManager, scope string) (NetStatOut, error) {
 				result.Peers[p.Pretty()] = stat
 			}
 		}
+		if m, err := allowlistManager(mgr); err == nil {
+			err = m.allowed.ViewSystem(func(s network.ResourceScope) error {
+				stat := s.Stat()
+				result.Allowlist = &stat
+				return nil
+			})
+			if err != nil {
+				return result, err
+			}
+		}
 
 		return result, nil
 
@@ -153,6 +181,18 @@ func NetStat(mgr network.ResourceManager, scope string) (NetStatOut, error) {
 		})
 		return result, err
 
+	case scope == config.ResourceMgrAllowlistScope:
+		m, err := allowlistManager(mgr)
+		if err != nil {
+			return result, err
+		}
+		err = m.allowed.ViewSystem(func(s network.ResourceScope) error {
+			stat := s.Stat()
+			result.Allowlist = &stat
+			return nil
+		})
+		return result, err
+
 	case strings.HasPrefix(scope, config.ResourceMgrServiceScopePrefix):
 		svc := strings.TrimPrefix(scope, config.ResourceMgrServiceScopePrefix)
 		err = mgr.ViewService(svc, func(s network.ServiceScope) error {
@@ -248,6 +288,16 @@ func NetLimit(mgr network.ResourceManager, scope string) (rcmgr.BasicLimitConfig
 		})
 		return result, err
 
+	case scope == config.ResourceMgrAllowlistScope:
+		m, err := allowlistManager(mgr)
+		if err != nil {
+			return result, err
+		}
+		err = m.allowed.ViewSystem(func(s network.ResourceScope) error {
+			return getLimit(s)
+		})
+		return result, err
+
 	case strings.HasPrefix(scope, config.ResourceMgrServiceScopePrefix):
 		svc := strings.TrimPrefix(scope, config.ResourceMgrServiceScopePrefix)
 		err := mgr.ViewService(svc, func(s network.ServiceScope) error {
@@ -278,7 +328,10 @@ func NetLimit(mgr network.ResourceManager, scope string) (rcmgr.BasicLimitConfig
 	}
 }
 
-// NetSetLimit sets new ResourceManager limits for the given scope. The limits take effect immediately, and are also persisted to the repo config.
+// netSetLimitMu serializes the changes of limits made with NetSetLimit.
+var netSetLimitMu sync.Mutex
+
+// NetSetLimit sets new ResourceManager limits for the given scope. The limits are persisted to the repo config, then take effect immediately.
 func NetSetLimit(mgr network.ResourceManager, repo repo.Repo, scope string, limit rcmgr.BasicLimitConfig) error {
 	setLimit := func(s network.ResourceScope) error {
 		limiter, ok := s.(rcmgr.ResourceScopeLimiter)
@@ -323,87 +376,106 @@ func NetSetLimit(mgr network.ResourceManager, repo repo.Repo, scope string, limi
 		return nil
 	}
 
+	// serialize the read-modify-write of the config
+	netSetLimitMu.Lock()
+	defer netSetLimitMu.Unlock()
+
 	cfg, err := repo.Config()
 	if err != nil {
 		return fmt.Errorf("reading config to set limit: %w", err)
 	}
+	// the config returned by the repo is shared, work on a copy
+	cfg, err = cfg.Clone()
+	if err != nil {
+		return fmt.Errorf("reading config to set limit: %w", err)
+	}
 
 	if cfg.Swarm.ResourceMgr.Limits == nil {
 		cfg.Swarm.ResourceMgr.Limits = &rcmgr.BasicLimiterConfig{}
 	}
 	configLimits := cfg.Swarm.ResourceMgr.Limits
 
-	var setConfigFunc func()
+	var apply func() error
 	switch {
 	case scope == config.ResourceMgrSystemScope:
-		err = mgr.ViewSystem(func(s network.ResourceScope) error {
-			return setLimit(s)
-		})
-		setConfigFunc = func() { configLimits.System = &limit }
+		configLimits.System = &limit
+		apply = func() error {
+			return mgr.ViewSystem(func(s network.ResourceScope) error {
+				return setLimit(s)
+			})
+		}
 
 	case scope == config.ResourceMgrTransientScope:
-		err = mgr.ViewTransient(func(s network.ResourceScope) error {
-			return setLimit(s)
-		})
-		setConfigFunc = func() { configLimits.Transient = &limit }
+		configLimits.Transient = &limit
+		apply = func() error {
+			return mgr.ViewTransient(func(s network.ResourceScope) error {
+				return setLimit(s)
+			})
+		}
 
 	case strings.HasPrefix(scope, config.ResourceMgrServiceScopePrefix):
 		svc := strings.TrimPrefix(scope, config.ResourceMgrServiceScopePrefix)
-		err = mgr.ViewService(svc, func(s network.ServiceScope) error {
-			return setLimit(s)
-		})
-		setConfigFunc = func() {
-			if configLimits.Service == nil {
-				configLimits.Service = map[string]rcmgr.BasicLimitConfig{}
-			}
-			configLimits.Service[svc] = limit
+		if configLimits.Service == nil {
+			configLimits.Service = map[string]rcmgr.BasicLimitConfig{}
+		}
+		configLimits.Service[svc] = limit
+		apply = func() error {
+			return mgr.ViewService(svc, func(s network.ServiceScope) error {
+				return setLimit(s)
+			})
 		}
 
 	case strings.HasPrefix(scope, config.ResourceMgrProtocolScopePrefix):
 		proto := strings.TrimPrefix(scope, config.ResourceMgrProtocolScopePrefix)
-		err = mgr.ViewProtocol(protocol.ID(proto), func(s network.ProtocolScope) error {
-			return setLimit(s)
-		})
-		setConfigFunc = func() {
-			if configLimits.Protocol == nil {
-				configLimits.Protocol = map[string]rcmgr.BasicLimitConfig{}
-			}
-			configLimits.Protocol[proto] = limit
+		if configLimits.Protocol == nil {
+			configLimits.Protocol = map[string]rcmgr.BasicLimitConfig{}
+		}
+		configLimits.Protocol[proto] = limit
+		apply = func() error {
+			return mgr.ViewProtocol(protocol.ID(proto), func(s network.ProtocolScope) error {
+				return setLimit(s)
+			})
 		}
 
 	case strings.HasPrefix(scope, config.ResourceMgrPeerScopePrefix):
 		p := strings.TrimPrefix(scope, config.ResourceMgrPeerScopePrefix)
-		var pid peer.ID
-		pid, err = peer.Decode(p)
+		pid, err := peer.Decode(p)
 		if err != nil {
 			return fmt.Errorf("invalid peer ID: %q: %w", p, err)
 		}
-		err = mgr.ViewPeer(pid, func(s network.PeerScope) error {
-			return setLimit(s)
-		})
-		setConfigFunc = func() {
-			if configLimits.Peer == nil {
-				configLimits.Peer = map[string]rcmgr.BasicLimitConfig{}
-			}
-			configLimits.Peer[p] = limit
+		if configLimits.Peer == nil {
+			configLimits.Peer = map[string]rcmgr.BasicLimitConfig{}
 		}
+		configLimits.Peer[p] = limit
+		apply = func() error {
+			return mgr.ViewPeer(pid, func(s network.PeerScope) error {
+				return setLimit(s)
+			})
+		}
+
+	case scope == config.ResourceMgrAllowlistScope:
+		return fmt.Errorf("the limits of the %s scope follow the system limits", scope)
 
 	default:
 		return fmt.Errorf("invalid scope %q", scope)
 	}
 
-	if err != nil {
-		return fmt.Errorf("setting new limits on resource manager: %w", err)
+	if _, ok := mgr.(rcmgr.ResourceManagerState); !ok { // NullResourceManager
+		return NoResourceMgrError
 	}
-
-	if cfg.Swarm.ResourceMgr.Limits == nil {
-		cfg.Swarm.ResourceMgr.Limits = &rcmgr.BasicLimiterConfig{}
+	if _, err := newLimiter(cfg.Swarm); err != nil {
+		return fmt.Errorf("invalid limits: %w", err)
 	}
-	setConfigFunc()
 
+	// persist first so the limits are never lost on restart, the config file
+	// is replaced atomically
 	if err := repo.SetConfig(cfg); err != nil {
 		return fmt.Errorf("writing new limits to repo config: %w", err)
 	}
 
+	if err := apply(); err != nil {
+		return fmt.Errorf("setting new limits on resource manager: %w", err)
+	}
+
 	return nil
 }
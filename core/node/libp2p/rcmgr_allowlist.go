@@ -0,0 +1,491 @@
+package libp2p
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	config "github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/repo"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	rcmgr "github.com/libp2p/go-libp2p-resource-manager"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// This file implements Swarm.ResourceMgr.Allowlist: connections of the peers
+// matching the allowlist are accounted in a separate resource manager, so the
+// public swarm can't exhaust their limits.
+
+// allowlistAddrTTL is how long the address a peer was seen at is kept to
+// match the allowlist once its connection gets its peer.
+const allowlistAddrTTL = time.Minute
+
+// allowlistEntry is a parsed Swarm.ResourceMgr.Allowlist pattern.
+type allowlistEntry struct {
+	pattern string
+	network *net.IPNet // nil matches any address
+	peer    peer.ID    // empty matches any peer
+}
+
+// parseAllowlistEntry parses a multiaddr pattern like /ip4/10.0.0.0/ipcidr/8,
+// /p2p/<id> or both.
+func parseAllowlistEntry(s string) (allowlistEntry, error) {
+	e := allowlistEntry{pattern: s}
+	addr, err := ma.NewMultiaddr(s)
+	if err != nil {
+		return e, fmt.Errorf("invalid allowlist pattern %q: %w", s, err)
+	}
+
+	var ip net.IP
+	var bits int
+	ma.ForEach(addr, func(c ma.Component) bool {
+		switch c.Protocol().Code {
+		case ma.P_IP4, ma.P_IP6:
+			if ip != nil {
+				err = errors.New("more than one address")
+				return false
+			}
+			ip = net.IP(c.RawValue())
+			bits = len(ip) * 8
+		case ma.P_IPCIDR:
+			if ip == nil {
+				err = errors.New("ipcidr must follow an address")
+				return false
+			}
+			bits, err = strconv.Atoi(c.Value())
+		case ma.P_P2P:
+			e.peer, err = peer.Decode(c.Value())
+		default:
+			err = fmt.Errorf("unsupported protocol %s", c.Protocol().Name)
+		}
+		return err == nil
+	})
+	if err != nil {
+		return e, fmt.Errorf("invalid allowlist pattern %q: %s", s, err)
+	}
+
+	if ip != nil {
+		if bits < 0 || bits > len(ip)*8 {
+			return e, fmt.Errorf("invalid allowlist pattern %q: invalid ipcidr", s)
+		}
+		e.network = &net.IPNet{IP: ip.Mask(net.CIDRMask(bits, len(ip)*8)), Mask: net.CIDRMask(bits, len(ip)*8)}
+	} else if e.peer == "" {
+		return e, fmt.Errorf("invalid allowlist pattern %q: expected an address or a peer", s)
+	}
+	return e, nil
+}
+
+// match returns whether peer p connected from addr, which may be nil when
+// unknown, matches the entry.
+func (e *allowlistEntry) match(p peer.ID, addr ma.Multiaddr) bool {
+	if e.peer != "" && e.peer != p {
+		return false
+	}
+	if e.network == nil {
+		return true
+	}
+	if addr == nil {
+		return false
+	}
+	ip, err := manet.ToIP(addr)
+	return err == nil && e.network.Contains(ip)
+}
+
+type seenAddr struct {
+	addr ma.Multiaddr
+	at   time.Time
+}
+
+// resourceAllowlist tracks the peers whose connections are allowlisted.
+type resourceAllowlist struct {
+	mu      sync.Mutex
+	entries []allowlistEntry
+
+	// seen is the address peers were last seen at by the connection gater,
+	// before their connection gets a peer in the resource manager.
+	seen map[peer.ID]seenAddr
+	// conns counts the allowlisted connections of each peer, their streams
+	// are allowlisted too.
+	conns map[peer.ID]int
+}
+
+func newResourceAllowlist(patterns []string) (*resourceAllowlist, error) {
+	a := &resourceAllowlist{
+		seen:  make(map[peer.ID]seenAddr),
+		conns: make(map[peer.ID]int),
+	}
+	return a, a.set(patterns)
+}
+
+// set replaces the allowlist patterns. Existing connections keep their scope.
+func (a *resourceAllowlist) set(patterns []string) error {
+	entries := make([]allowlistEntry, 0, len(patterns))
+	for _, s := range patterns {
+		e, err := parseAllowlistEntry(s)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, e)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.entries = entries
+	return nil
+}
+
+func (a *resourceAllowlist) patterns() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	patterns := make([]string, len(a.entries))
+	for i, e := range a.entries {
+		patterns[i] = e.pattern
+	}
+	return patterns
+}
+
+func (a *resourceAllowlist) empty() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.entries) == 0
+}
+
+// observe records the address peer p is being connected at, called by the
+// connection gater.
+func (a *resourceAllowlist) observe(p peer.ID, addr ma.Multiaddr) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.entries) == 0 {
+		return
+	}
+
+	now := time.Now()
+	if len(a.seen) >= 1024 {
+		for p, s := range a.seen {
+			if now.Sub(s.at) > allowlistAddrTTL {
+				delete(a.seen, p)
+			}
+		}
+	}
+	a.seen[p] = seenAddr{addr: addr, at: now}
+}
+
+// connect returns whether a connection to peer p is allowlisted, counting it
+// until disconnect is called.
+func (a *resourceAllowlist) connect(p peer.ID) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var addr ma.Multiaddr
+	if s, ok := a.seen[p]; ok {
+		delete(a.seen, p)
+		if time.Since(s.at) <= allowlistAddrTTL {
+			addr = s.addr
+		}
+	}
+	for i := range a.entries {
+		if a.entries[i].match(p, addr) {
+			a.conns[p]++
+			return true
+		}
+	}
+	return false
+}
+
+func (a *resourceAllowlist) disconnect(p peer.ID) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.conns[p]--; a.conns[p] <= 0 {
+		delete(a.conns, p)
+	}
+}
+
+// allowedPeer returns whether the streams of peer p are allowlisted.
+func (a *resourceAllowlist) allowedPeer(p peer.ID) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.conns[p] > 0 {
+		return true
+	}
+	for _, e := range a.entries {
+		if e.peer == p && e.network == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// allowlistLimits returns the limits of the allowlisted resource manager: it
+// gets a budget as large as the regular system scope, which a single peer may
+// use entirely.
+func allowlistLimits(defaults rcmgr.DefaultLimitConfig) rcmgr.DefaultLimitConfig {
+	l := defaults
+	l.TransientBaseLimit = l.SystemBaseLimit
+	l.TransientMemory = l.SystemMemory
+	l.PeerBaseLimit = l.SystemBaseLimit
+	l.PeerMemory = l.SystemMemory
+	l.ServicePeerBaseLimit = l.ServiceBaseLimit
+	l.ServicePeerMemory = l.ServiceMemory
+	l.ProtocolPeerBaseLimit = l.ProtocolBaseLimit
+	l.ProtocolPeerMemory = l.ProtocolMemory
+	return l
+}
+
+// newAllowlistLimiter returns the limiter of the allowlisted resource manager
+// for the Swarm.ResourceMgr settings of cfg.
+func newAllowlistLimiter(cfg config.SwarmConfig) (*rcmgr.BasicLimiter, error) {
+	defaultLimits, err := adjustedDefaultLimits(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return rcmgr.NewLimiter(rcmgr.BasicLimiterConfig{}, allowlistLimits(defaultLimits))
+}
+
+// allowlistResourceManager accounts the allowlisted connections and streams in
+// the allowed resource manager, and the others in the regular one.
+type allowlistResourceManager struct {
+	regular   network.ResourceManager
+	allowed   network.ResourceManager
+	allowlist *resourceAllowlist
+}
+
+var _ network.ResourceManager = (*allowlistResourceManager)(nil)
+var _ rcmgr.ResourceManagerState = (*allowlistResourceManager)(nil)
+
+func (m *allowlistResourceManager) ViewSystem(f func(network.ResourceScope) error) error {
+	return m.regular.ViewSystem(f)
+}
+func (m *allowlistResourceManager) ViewTransient(f func(network.ResourceScope) error) error {
+	return m.regular.ViewTransient(f)
+}
+func (m *allowlistResourceManager) ViewService(svc string, f func(network.ServiceScope) error) error {
+	return m.regular.ViewService(svc, f)
+}
+func (m *allowlistResourceManager) ViewProtocol(p protocol.ID, f func(network.ProtocolScope) error) error {
+	return m.regular.ViewProtocol(p, f)
+}
+func (m *allowlistResourceManager) ViewPeer(p peer.ID, f func(network.PeerScope) error) error {
+	if m.allowlist.allowedPeer(p) {
+		return m.allowed.ViewPeer(p, f)
+	}
+	return m.regular.ViewPeer(p, f)
+}
+
+func (m *allowlistResourceManager) OpenConnection(dir network.Direction, usefd bool) (network.ConnManagementScope, error) {
+	scope, err := m.regular.OpenConnection(dir, usefd)
+	if err == nil {
+		return &allowlistConnScope{ConnManagementScope: scope, m: m, dir: dir, usefd: usefd}, nil
+	}
+	if !errors.Is(err, network.ErrResourceLimitExceeded) || m.allowlist.empty() {
+		return nil, err
+	}
+
+	// the peer isn't known yet, the connection is only kept if it turns out
+	// to be allowlisted
+	scope, aerr := m.allowed.OpenConnection(dir, usefd)
+	if aerr != nil {
+		return nil, err
+	}
+	return &allowlistConnScope{ConnManagementScope: scope, m: m, dir: dir, usefd: usefd, allowed: true, rejected: err}, nil
+}
+
+func (m *allowlistResourceManager) OpenStream(p peer.ID, dir network.Direction) (network.StreamManagementScope, error) {
+	if m.allowlist.allowedPeer(p) {
+		return m.allowed.OpenStream(p, dir)
+	}
+	return m.regular.OpenStream(p, dir)
+}
+
+func (m *allowlistResourceManager) Close() error {
+	err := m.regular.Close()
+	if aerr := m.allowed.Close(); err == nil {
+		err = aerr
+	}
+	return err
+}
+
+func (m *allowlistResourceManager) ListServices() []string {
+	return m.regular.(rcmgr.ResourceManagerState).ListServices()
+}
+func (m *allowlistResourceManager) ListProtocols() []protocol.ID {
+	return m.regular.(rcmgr.ResourceManagerState).ListProtocols()
+}
+func (m *allowlistResourceManager) ListPeers() []peer.ID {
+	return m.regular.(rcmgr.ResourceManagerState).ListPeers()
+}
+func (m *allowlistResourceManager) Stat() rcmgr.ResourceManagerStat {
+	return m.regular.(rcmgr.ResourceManagerState).Stat()
+}
+
+// allowlistConnScope is a connection scope moved to the allowed resource
+// manager once its peer is known to be allowlisted.
+type allowlistConnScope struct {
+	network.ConnManagementScope
+	m     *allowlistResourceManager
+	dir   network.Direction
+	usefd bool
+
+	mu       sync.Mutex
+	allowed  bool  // the scope belongs to the allowed resource manager
+	rejected error // the regular resource manager rejected the connection
+	reserved int   // memory reserved before the peer was known
+	peer     peer.ID
+}
+
+func (s *allowlistConnScope) ReserveMemory(size int, prio uint8) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.ConnManagementScope.ReserveMemory(size, prio); err != nil {
+		return err
+	}
+	s.reserved += size
+	return nil
+}
+
+func (s *allowlistConnScope) ReleaseMemory(size int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ConnManagementScope.ReleaseMemory(size)
+	s.reserved -= size
+}
+
+func (s *allowlistConnScope) SetPeer(p peer.ID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.m.allowlist.connect(p) {
+		if s.rejected != nil {
+			return s.rejected
+		}
+		return s.ConnManagementScope.SetPeer(p)
+	}
+	s.peer = p
+
+	if !s.allowed {
+		scope, err := s.m.allowed.OpenConnection(s.dir, s.usefd)
+		if err != nil {
+			log.Debugf("keeping the connection of allowlisted peer %s in the regular scope: %s", p, err)
+			return s.ConnManagementScope.SetPeer(p)
+		}
+		if s.reserved > 0 {
+			_ = scope.ReserveMemory(s.reserved, network.ReservationPriorityAlways)
+		}
+		s.ConnManagementScope.Done()
+		s.ConnManagementScope = scope
+		s.allowed = true
+	}
+	return s.ConnManagementScope.SetPeer(p)
+}
+
+func (s *allowlistConnScope) PeerScope() network.PeerScope {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ConnManagementScope.PeerScope()
+}
+
+func (s *allowlistConnScope) Done() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ConnManagementScope.Done()
+	if s.peer != "" {
+		s.m.allowlist.disconnect(s.peer)
+		s.peer = ""
+	}
+}
+
+// allowlistManager returns the allowlist resource manager wrapped by mgr.
+func allowlistManager(mgr network.ResourceManager) (*allowlistResourceManager, error) {
+	for {
+		switch m := mgr.(type) {
+		case *allowlistResourceManager:
+			return m, nil
+		case *loggingResourceManager:
+			mgr = m.delegate
+		default: // NullResourceManager
+			return nil, NoResourceMgrError
+		}
+	}
+}
+
+// NetAllowlist returns the Swarm.ResourceMgr.Allowlist patterns in effect.
+func NetAllowlist(mgr network.ResourceManager) ([]string, error) {
+	m, err := allowlistManager(mgr)
+	if err != nil {
+		return nil, err
+	}
+	return m.allowlist.patterns(), nil
+}
+
+// NetAllowlistAdd adds patterns to Swarm.ResourceMgr.Allowlist. They are
+// persisted to the repo config, then take effect for new connections.
+func NetAllowlistAdd(mgr network.ResourceManager, repo repo.Repo, patterns []string) error {
+	for _, s := range patterns {
+		if _, err := parseAllowlistEntry(s); err != nil {
+			return err
+		}
+	}
+	return updateAllowlist(mgr, repo, func(allowlist []string) []string {
+	patterns:
+		for _, s := range patterns {
+			for _, existing := range allowlist {
+				if s == existing {
+					continue patterns
+				}
+			}
+			allowlist = append(allowlist, s)
+		}
+		return allowlist
+	})
+}
+
+// NetAllowlistRemove removes patterns from Swarm.ResourceMgr.Allowlist. The
+// connections already allowlisted keep their scope.
+func NetAllowlistRemove(mgr network.ResourceManager, repo repo.Repo, patterns []string) error {
+	return updateAllowlist(mgr, repo, func(allowlist []string) []string {
+		kept := allowlist[:0]
+		for _, existing := range allowlist {
+			removed := false
+			for _, s := range patterns {
+				removed = removed || s == existing
+			}
+			if !removed {
+				kept = append(kept, existing)
+			}
+		}
+		return kept
+	})
+}
+
+func updateAllowlist(mgr network.ResourceManager, repo repo.Repo, update func([]string) []string) error {
+	m, err := allowlistManager(mgr)
+	if err != nil {
+		return err
+	}
+
+	netSetLimitMu.Lock()
+	defer netSetLimitMu.Unlock()
+
+	cfg, err := repo.Config()
+	if err != nil {
+		return fmt.Errorf("reading config to update the allowlist: %w", err)
+	}
+	cfg, err = cfg.Clone()
+	if err != nil {
+		return fmt.Errorf("reading config to update the allowlist: %w", err)
+	}
+	cfg.Swarm.ResourceMgr.Allowlist = update(cfg.Swarm.ResourceMgr.Allowlist)
+	if err := repo.SetConfig(cfg); err != nil {
+		return fmt.Errorf("writing the allowlist to repo config: %w", err)
+	}
+	return m.allowlist.set(cfg.Swarm.ResourceMgr.Allowlist)
+}
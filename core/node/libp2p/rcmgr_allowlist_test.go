@@ -0,0 +1,142 @@
+package libp2p
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/test"
+	rcmgr "github.com/libp2p/go-libp2p-resource-manager"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAllowlistEntry(t *testing.T) {
+	p := test.RandPeerIDFatal(t)
+
+	for _, s := range []string{
+		"/ip4/10.0.0.0/ipcidr/8",
+		"/ip6/fe80::/ipcidr/10",
+		"/ip4/1.2.3.4",
+		"/p2p/" + p.Pretty(),
+		"/ip4/1.2.3.4/p2p/" + p.Pretty(),
+	} {
+		_, err := parseAllowlistEntry(s)
+		require.NoError(t, err, s)
+	}
+
+	for _, s := range []string{
+		"",
+		"10.0.0.0/8",
+		"/ip4/10.0.0.0/ipcidr/33",
+		"/ip4/1.2.3.4/tcp/4001",
+		"/dns4/example.com",
+		"/ip4/1.2.3.4/ip4/5.6.7.8",
+	} {
+		_, err := parseAllowlistEntry(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestAllowlistEntryMatch(t *testing.T) {
+	p := test.RandPeerIDFatal(t)
+	other := test.RandPeerIDFatal(t)
+	inside := ma.StringCast("/ip4/10.1.2.3/tcp/4001")
+	outside := ma.StringCast("/ip4/192.168.1.1/tcp/4001")
+
+	cidr, err := parseAllowlistEntry("/ip4/10.0.0.0/ipcidr/8")
+	require.NoError(t, err)
+	require.True(t, cidr.match(p, inside))
+	require.False(t, cidr.match(p, outside))
+	require.False(t, cidr.match(p, nil))
+
+	id, err := parseAllowlistEntry("/p2p/" + p.Pretty())
+	require.NoError(t, err)
+	require.True(t, id.match(p, nil))
+	require.True(t, id.match(p, outside))
+	require.False(t, id.match(other, inside))
+
+	both, err := parseAllowlistEntry("/ip4/10.0.0.0/ipcidr/8/p2p/" + p.Pretty())
+	require.NoError(t, err)
+	require.True(t, both.match(p, inside))
+	require.False(t, both.match(p, outside))
+	require.False(t, both.match(other, inside))
+}
+
+func TestAllowlistResourceManager(t *testing.T) {
+	allowedPeer := test.RandPeerIDFatal(t)
+	cidrPeer := test.RandPeerIDFatal(t)
+	otherPeer := test.RandPeerIDFatal(t)
+
+	allowlist, err := newResourceAllowlist([]string{"/p2p/" + allowedPeer.Pretty(), "/ip4/10.0.0.0/ipcidr/8"})
+	require.NoError(t, err)
+
+	// the regular resource manager accepts a single connection
+	defaults := rcmgr.DefaultLimits
+	defaults.SystemBaseLimit.Conns = 1
+	defaults.SystemBaseLimit.ConnsInbound = 1
+	defaults.SystemBaseLimit.ConnsOutbound = 1
+	defaults.TransientBaseLimit = defaults.SystemBaseLimit
+	limiter, err := rcmgr.NewLimiter(rcmgr.BasicLimiterConfig{}, defaults)
+	require.NoError(t, err)
+	regular, err := rcmgr.NewResourceManager(limiter)
+	require.NoError(t, err)
+	allowedLimiter, err := rcmgr.NewLimiter(rcmgr.BasicLimiterConfig{}, allowlistLimits(rcmgr.DefaultLimits))
+	require.NoError(t, err)
+	allowed, err := rcmgr.NewResourceManager(allowedLimiter)
+	require.NoError(t, err)
+	mgr := &allowlistResourceManager{regular: regular, allowed: allowed, allowlist: allowlist}
+	defer mgr.Close()
+
+	conns := func(m network.ResourceManager) int {
+		var n int
+		require.NoError(t, m.ViewSystem(func(s network.ResourceScope) error {
+			n = s.Stat().NumConnsInbound
+			return nil
+		}))
+		return n
+	}
+
+	// an allowlisted connection opened in the regular scope moves to the
+	// allowed one once its peer is known
+	c1, err := mgr.OpenConnection(network.DirInbound, true)
+	require.NoError(t, err)
+	require.Equal(t, 1, conns(regular))
+	require.NoError(t, c1.SetPeer(allowedPeer))
+	require.Equal(t, 0, conns(regular))
+	require.Equal(t, 1, conns(allowed))
+
+	// and so do its streams
+	s1, err := mgr.OpenStream(allowedPeer, network.DirInbound)
+	require.NoError(t, err)
+	require.NoError(t, mgr.ViewPeer(allowedPeer, func(s network.PeerScope) error {
+		require.Equal(t, 1, s.Stat().NumStreamsInbound)
+		return nil
+	}))
+	s1.Done()
+
+	// the regular scope is full: connections are only accepted if they turn
+	// out to be allowlisted
+	c2, err := mgr.OpenConnection(network.DirInbound, true)
+	require.NoError(t, err)
+	c3, err := mgr.OpenConnection(network.DirInbound, true)
+	require.NoError(t, err)
+	c4, err := mgr.OpenConnection(network.DirInbound, true)
+	require.NoError(t, err)
+	require.Equal(t, 1, conns(regular))
+
+	allowlist.observe(cidrPeer, ma.StringCast("/ip4/10.1.2.3/tcp/4001"))
+	require.NoError(t, c3.SetPeer(cidrPeer))
+	allowlist.observe(otherPeer, ma.StringCast("/ip4/192.168.1.1/tcp/4001"))
+	require.ErrorIs(t, c4.SetPeer(otherPeer), network.ErrResourceLimitExceeded)
+	c4.Done()
+	require.Equal(t, 2, conns(allowed))
+
+	// closed connections are released, and the peers no longer allowlisted
+	c1.Done()
+	c2.Done()
+	c3.Done()
+	require.Equal(t, 0, conns(regular))
+	require.Equal(t, 0, conns(allowed))
+	require.False(t, allowlist.allowedPeer(cidrPeer))
+	require.True(t, allowlist.allowedPeer(allowedPeer))
+}
@@ -7,40 +7,98 @@ import (
 	"os"
 	"strings"
 
+	"github.com/dustin/go-humanize"
 	config "github.com/ipfs/kubo/config"
 	"github.com/libp2p/go-libp2p"
 	rcmgr "github.com/libp2p/go-libp2p-resource-manager"
+	"github.com/pbnjay/memory"
 
 	"github.com/wI2L/jsondiff"
 )
 
 // This file defines implicit limit defaults used when Swarm.ResourceMgr.Enabled
 
+// Fallback of Swarm.ResourceMgr.MaxFileDescriptors when the system limit
+// can't be detected.
+const fallbackMaxFDs = 4096
+
+// resourceMgrMaxMemory returns Swarm.ResourceMgr.MaxMemory, and whether it is
+// set. It defaults to an eighth of the system memory, from 1GiB to 4GiB.
+func resourceMgrMaxMemory(cfg config.SwarmConfig) (int64, bool, error) {
+	if s := cfg.ResourceMgr.MaxMemory.WithDefault(""); s != "" {
+		n, err := humanize.ParseBytes(s)
+		if err != nil {
+			return 0, false, fmt.Errorf("failure to parse config setting Swarm.ResourceMgr.MaxMemory: %w", err)
+		}
+		return int64(n), true, nil
+	}
+	maxMemory := int64(memory.TotalMemory() / 8)
+	if maxMemory < 1<<30 {
+		maxMemory = 1 << 30
+	}
+	if maxMemory > 4<<30 {
+		maxMemory = 4 << 30
+	}
+	return maxMemory, false, nil
+}
+
+// resourceMgrMaxFDs returns Swarm.ResourceMgr.MaxFileDescriptors, defaulting to
+// half of the file descriptor limit of the process.
+func resourceMgrMaxFDs(cfg config.SwarmConfig) int64 {
+	if n := cfg.ResourceMgr.MaxFileDescriptors.WithDefault(0); n > 0 {
+		return n
+	}
+	if n := processMaxFDs(); n > 0 {
+		return n / 2
+	}
+	return fallbackMaxFDs
+}
+
 // adjustedDefaultLimits allows for tweaking defaults based on external factors,
-// such as values in Swarm.ConnMgr.HiWater config.
-func adjustedDefaultLimits(cfg config.SwarmConfig) rcmgr.DefaultLimitConfig {
+// such as values in Swarm.ConnMgr.HiWater config, and the memory and file
+// descriptors available to libp2p.
+func adjustedDefaultLimits(cfg config.SwarmConfig) (rcmgr.DefaultLimitConfig, error) {
 	// Run checks to avoid introducing regressions
 	if os.Getenv("IPFS_CHECK_RCMGR_DEFAULTS") != "" {
 		// FIXME: Broken. Being tracked in https://github.com/ipfs/go-ipfs/issues/8949.
 		checkImplicitDefaults()
 	}
 
+	maxMemory, explicit, err := resourceMgrMaxMemory(cfg)
+	if err != nil {
+		return rcmgr.DefaultLimitConfig{}, err
+	}
+	maxFDs := resourceMgrMaxFDs(cfg)
+
 	// Adjust limits
-	// (based on https://github.com/filecoin-project/lotus/pull/8318/files)
-	// - give it more memory, up to 4G, min of 1G
+	// - the system scope gets all of Swarm.ResourceMgr.MaxMemory, and the
+	//   system conn and stream limits scale with it: the libp2p defaults are
+	//   meant for 1G, and are only raised when MaxMemory is set, so that the
+	//   defaults of large hosts keep the same DoS protection
 	// - if Swarm.ConnMgr.HighWater is too high, adjust Conn/FD/Stream limits
-	defaultLimits := rcmgr.DefaultLimits.WithSystemMemory(.125, 1<<30, 4<<30)
+	defaultLimits := rcmgr.DefaultLimits.WithSystemMemory(.125, maxMemory, maxMemory)
 
-	// Outbound conns and FDs are set very high to allow for the accelerated DHT client to (re)load its routing table.
+	scale := float64(maxMemory) / (1 << 30)
+	if scale < .25 {
+		scale = .25
+	}
+	if !explicit && scale > 1 {
+		scale = 1
+	}
+	scaled := func(n int) int { return int(float64(n) * scale) }
+	defaultLimits.SystemBaseLimit.ConnsInbound = scaled(defaultLimits.SystemBaseLimit.ConnsInbound)
+	defaultLimits.SystemBaseLimit.StreamsInbound = scaled(defaultLimits.SystemBaseLimit.StreamsInbound)
+	defaultLimits.SystemBaseLimit.StreamsOutbound = scaled(defaultLimits.SystemBaseLimit.StreamsOutbound)
+	defaultLimits.SystemBaseLimit.Streams = scaled(defaultLimits.SystemBaseLimit.Streams)
+	defaultLimits.SystemBaseLimit.FD = int(maxFDs)
+
+	// Outbound conns are set very high to allow for the accelerated DHT client to (re)load its routing table.
 	// Currently it doesn't gracefully handle RM throttling--once it does we can lower these.
 	// High outbound conn limits are considered less of a DoS risk than high inbound conn limits.
 	// Also note that, due to the behavior of the accelerated DHT client, we don't need many streams, just conns.
 	if minOutbound := 65536; defaultLimits.SystemBaseLimit.ConnsOutbound < minOutbound {
 		defaultLimits.SystemBaseLimit.ConnsOutbound = minOutbound
 	}
-	if minFD := 4096; defaultLimits.SystemBaseLimit.FD < minFD {
-		defaultLimits.SystemBaseLimit.FD = minFD
-	}
 
 	// Do we need to adjust due to Swarm.ConnMgr.HighWater?
 	if cfg.ConnMgr.Type == "basic" {
@@ -54,8 +112,9 @@ func adjustedDefaultLimits(cfg config.SwarmConfig) rcmgr.DefaultLimitConfig {
 				defaultLimits.SystemBaseLimit.ConnsOutbound = minOutbound
 			}
 
-			if 2*maxconns > defaultLimits.SystemBaseLimit.FD {
-				defaultLimits.SystemBaseLimit.FD = logScale(2 * maxconns)
+			// but never use more file descriptors than available
+			if fd := logScale(2 * maxconns); fd > defaultLimits.SystemBaseLimit.FD && int64(fd) <= maxFDs {
+				defaultLimits.SystemBaseLimit.FD = fd
 			}
 
 			defaultLimits.SystemBaseLimit.StreamsInbound = logScale(16 * maxconns)
@@ -74,7 +133,7 @@ func adjustedDefaultLimits(cfg config.SwarmConfig) rcmgr.DefaultLimitConfig {
 
 	defaultLimits.SystemBaseLimit.Conns = defaultLimits.SystemBaseLimit.ConnsOutbound + defaultLimits.SystemBaseLimit.ConnsInbound
 
-	return defaultLimits
+	return defaultLimits, nil
 }
 
 func logScale(val int) int {
@@ -0,0 +1,9 @@
+//go:build !darwin && !linux && !netbsd && !openbsd
+// +build !darwin,!linux,!netbsd,!openbsd
+
+package libp2p
+
+// processMaxFDs returns the file descriptor limit of the process, 0 if unknown.
+func processMaxFDs() int64 {
+	return 0
+}
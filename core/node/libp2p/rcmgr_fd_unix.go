@@ -0,0 +1,21 @@
+//go:build darwin || linux || netbsd || openbsd
+// +build darwin linux netbsd openbsd
+
+package libp2p
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// processMaxFDs returns the file descriptor limit of the process, 0 if unknown.
+func processMaxFDs() int64 {
+	var rlimit unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_NOFILE, &rlimit); err != nil {
+		log.Debugf("reading the file descriptor limit: %s", err)
+		return 0
+	}
+	if rlimit.Cur > 1<<31 { // RLIM_INFINITY, or close enough
+		return 1 << 31
+	}
+	return int64(rlimit.Cur)
+}
@@ -31,6 +31,7 @@ type loggingScope struct {
 }
 
 var _ network.ResourceManager = (*loggingResourceManager)(nil)
+var _ rcmgr.ResourceManagerState = (*loggingResourceManager)(nil)
 
 func (n *loggingResourceManager) start(ctx context.Context) {
 	logInterval := n.logInterval
@@ -102,6 +103,19 @@ func (n *loggingResourceManager) Close() error {
 	return n.delegate.Close()
 }
 
+func (n *loggingResourceManager) ListServices() []string {
+	return n.delegate.(rcmgr.ResourceManagerState).ListServices()
+}
+func (n *loggingResourceManager) ListProtocols() []protocol.ID {
+	return n.delegate.(rcmgr.ResourceManagerState).ListProtocols()
+}
+func (n *loggingResourceManager) ListPeers() []peer.ID {
+	return n.delegate.(rcmgr.ResourceManagerState).ListPeers()
+}
+func (n *loggingResourceManager) Stat() rcmgr.ResourceManagerStat {
+	return n.delegate.(rcmgr.ResourceManagerState).Stat()
+}
+
 func (s *loggingScope) ReserveMemory(size int, prio uint8) error {
 	err := s.delegate.ReserveMemory(size, prio)
 	s.countErrs(err)
@@ -0,0 +1,215 @@
+package libp2p
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	config "github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/repo"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	rcmgr "github.com/libp2p/go-libp2p-resource-manager"
+)
+
+// limitReloadInterval is how often the config is checked for new limits.
+const limitReloadInterval = 10 * time.Second
+
+// newLimiter returns the limiter for the Swarm.ResourceMgr settings of cfg.
+func newLimiter(cfg config.SwarmConfig) (*rcmgr.BasicLimiter, error) {
+	defaultLimits, err := adjustedDefaultLimits(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var limits rcmgr.BasicLimiterConfig
+	if cfg.ResourceMgr.Limits != nil {
+		limits = *cfg.ResourceMgr.Limits
+	}
+
+	limiter, err := rcmgr.NewLimiter(limits, defaultLimits)
+	if err != nil {
+		return nil, err
+	}
+
+	libp2p.SetDefaultServiceLimits(limiter)
+	return limiter, nil
+}
+
+// reloadableLimiter is a limiter whose limits can be replaced while the
+// resource manager runs. The scopes created afterwards get the new limits.
+type reloadableLimiter struct {
+	mu      sync.RWMutex
+	limiter *rcmgr.BasicLimiter
+}
+
+var _ rcmgr.Limiter = (*reloadableLimiter)(nil)
+
+func (l *reloadableLimiter) get() *rcmgr.BasicLimiter {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.limiter
+}
+
+func (l *reloadableLimiter) set(limiter *rcmgr.BasicLimiter) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limiter = limiter
+}
+
+func (l *reloadableLimiter) GetSystemLimits() rcmgr.Limit {
+	return l.get().GetSystemLimits()
+}
+
+func (l *reloadableLimiter) GetTransientLimits() rcmgr.Limit {
+	return l.get().GetTransientLimits()
+}
+
+func (l *reloadableLimiter) GetServiceLimits(svc string) rcmgr.Limit {
+	return l.get().GetServiceLimits(svc)
+}
+
+func (l *reloadableLimiter) GetServicePeerLimits(svc string) rcmgr.Limit {
+	return l.get().GetServicePeerLimits(svc)
+}
+
+func (l *reloadableLimiter) GetProtocolLimits(proto protocol.ID) rcmgr.Limit {
+	return l.get().GetProtocolLimits(proto)
+}
+
+func (l *reloadableLimiter) GetProtocolPeerLimits(proto protocol.ID) rcmgr.Limit {
+	return l.get().GetProtocolPeerLimits(proto)
+}
+
+func (l *reloadableLimiter) GetPeerLimits(p peer.ID) rcmgr.Limit {
+	return l.get().GetPeerLimits(p)
+}
+
+func (l *reloadableLimiter) GetStreamLimits(p peer.ID) rcmgr.Limit {
+	return l.get().GetStreamLimits(p)
+}
+
+func (l *reloadableLimiter) GetConnLimits() rcmgr.Limit {
+	return l.get().GetConnLimits()
+}
+
+// limitReloader applies the Swarm.ResourceMgr settings to the running resource
+// manager whenever they change in the config file, including edits made
+// without going through the daemon.
+type limitReloader struct {
+	repo   repo.Repo
+	reload []func(config.SwarmConfig) error
+
+	// last is the Swarm section of the config the limits were loaded from
+	last []byte
+}
+
+func newLimitReloader(repo repo.Repo, reload ...func(config.SwarmConfig) error) *limitReloader {
+	r := &limitReloader{repo: repo, reload: reload}
+	r.last, _ = r.read()
+	return r
+}
+
+// reloadLimits returns a reload function replacing the limits of mgr with the
+// ones built by newLimiter.
+func reloadLimits(mgr network.ResourceManager, limiter *reloadableLimiter, newLimiter func(config.SwarmConfig) (*rcmgr.BasicLimiter, error)) func(config.SwarmConfig) error {
+	return func(cfg config.SwarmConfig) error {
+		l, err := newLimiter(cfg)
+		if err != nil {
+			return err
+		}
+		limiter.set(l)
+		applyLimits(mgr, l)
+		return nil
+	}
+}
+
+// read returns the Swarm section of the config file.
+func (r *limitReloader) read() ([]byte, error) {
+	v, err := r.repo.GetConfigKey("Swarm")
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+func (r *limitReloader) start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(limitReloadInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.check()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (r *limitReloader) check() {
+	raw, err := r.read()
+	if err != nil {
+		log.Debugf("reading config to reload resource manager limits: %s", err)
+		return
+	}
+	if bytes.Equal(raw, r.last) {
+		return
+	}
+	r.last = raw
+
+	var cfg config.SwarmConfig
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		log.Errorf("not reloading resource manager limits: %s", err)
+		return
+	}
+	reloaded := true
+	for _, reload := range r.reload {
+		if err := reload(cfg); err != nil {
+			log.Errorf("not reloading resource manager limits: %s", err)
+			reloaded = false
+		}
+	}
+	if reloaded {
+		log.Info("reloaded resource manager limits from the config")
+	}
+}
+
+// applyLimits sets the limits of limiter on the existing scopes of mgr.
+func applyLimits(mgr network.ResourceManager, limiter *rcmgr.BasicLimiter) {
+	set := func(limit rcmgr.Limit) func(network.ResourceScope) error {
+		return func(s network.ResourceScope) error {
+			if l, ok := s.(rcmgr.ResourceScopeLimiter); ok {
+				l.SetLimit(limit)
+			}
+			return nil
+		}
+	}
+
+	_ = mgr.ViewSystem(set(limiter.GetSystemLimits()))
+	_ = mgr.ViewTransient(set(limiter.GetTransientLimits()))
+
+	state, ok := mgr.(rcmgr.ResourceManagerState)
+	if !ok {
+		return
+	}
+	stat := state.Stat()
+	for svc := range stat.Services {
+		setSvc := set(limiter.GetServiceLimits(svc))
+		_ = mgr.ViewService(svc, func(s network.ServiceScope) error { return setSvc(s) })
+	}
+	for proto := range stat.Protocols {
+		setProto := set(limiter.GetProtocolLimits(proto))
+		_ = mgr.ViewProtocol(proto, func(s network.ProtocolScope) error { return setProto(s) })
+	}
+	for p := range stat.Peers {
+		setPeer := set(limiter.GetPeerLimits(p))
+		_ = mgr.ViewPeer(p, func(s network.PeerScope) error { return setPeer(s) })
+	}
+}
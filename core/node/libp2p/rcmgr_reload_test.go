@@ -0,0 +1,116 @@
+package libp2p
+
+import (
+	"fmt"
+	"testing"
+
+	config "github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/repo"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/test"
+	rcmgr "github.com/libp2p/go-libp2p-resource-manager"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdjustedDefaultLimits(t *testing.T) {
+	limits := func(maxMemory string, maxFDs int64) rcmgr.DefaultLimitConfig {
+		var cfg config.SwarmConfig
+		cfg.ResourceMgr.MaxMemory = config.NewOptionalString(maxMemory)
+		cfg.ResourceMgr.MaxFileDescriptors = config.NewOptionalInteger(maxFDs)
+		l, err := adjustedDefaultLimits(cfg)
+		require.NoError(t, err)
+		return l
+	}
+
+	small := limits("1GiB", 1000)
+	require.Equal(t, int64(1<<30), small.SystemMemory.MaxMemory)
+	require.Equal(t, 1000, small.SystemBaseLimit.FD)
+	require.Equal(t, rcmgr.DefaultLimits.SystemBaseLimit.ConnsInbound, small.SystemBaseLimit.ConnsInbound)
+
+	large := limits("8GiB", 100000)
+	require.Equal(t, int64(8<<30), large.SystemMemory.MaxMemory)
+	require.Equal(t, 100000, large.SystemBaseLimit.FD)
+	require.Equal(t, 8*small.SystemBaseLimit.ConnsInbound, large.SystemBaseLimit.ConnsInbound)
+	require.Equal(t, 8*small.SystemBaseLimit.StreamsInbound, large.SystemBaseLimit.StreamsInbound)
+
+	var cfg config.SwarmConfig
+	cfg.ResourceMgr.MaxMemory = config.NewOptionalString("lots")
+	_, err := adjustedDefaultLimits(cfg)
+	require.Error(t, err)
+
+	// detected from the system
+	detected, err := adjustedDefaultLimits(config.SwarmConfig{})
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, detected.SystemMemory.MaxMemory, int64(1<<30))
+	require.LessOrEqual(t, detected.SystemMemory.MaxMemory, int64(4<<30))
+	require.Greater(t, detected.SystemBaseLimit.FD, 0)
+	// only raised when MaxMemory is set
+	require.Equal(t, rcmgr.DefaultLimits.SystemBaseLimit.ConnsInbound, detected.SystemBaseLimit.ConnsInbound)
+	require.Equal(t, rcmgr.DefaultLimits.SystemBaseLimit.StreamsInbound, detected.SystemBaseLimit.StreamsInbound)
+}
+
+// swarmConfigRepo serves the Swarm section of its config like the config file.
+type swarmConfigRepo struct {
+	repo.Mock
+}
+
+func (r *swarmConfigRepo) GetConfigKey(key string) (interface{}, error) {
+	if key != "Swarm" {
+		return nil, fmt.Errorf("unexpected key %q", key)
+	}
+	return r.C.Swarm, nil
+}
+
+func TestLimitReloader(t *testing.T) {
+	r := &swarmConfigRepo{}
+	r.C.Swarm.ResourceMgr.MaxMemory = config.NewOptionalString("1GiB")
+
+	basicLimiter, err := newLimiter(r.C.Swarm)
+	require.NoError(t, err)
+	limiter := &reloadableLimiter{limiter: basicLimiter}
+	mgr, err := rcmgr.NewResourceManager(limiter)
+	require.NoError(t, err)
+	defer mgr.Close()
+	reloader := newLimitReloader(r, reloadLimits(mgr, limiter, newLimiter))
+
+	systemConns := func() int {
+		var conns int
+		require.NoError(t, mgr.ViewSystem(func(s network.ResourceScope) error {
+			conns = s.(rcmgr.ResourceScopeLimiter).Limit().GetConnTotalLimit()
+			return nil
+		}))
+		return conns
+	}
+	peerConns := func() int {
+		var conns int
+		require.NoError(t, mgr.ViewPeer(test.RandPeerIDFatal(t), func(s network.PeerScope) error {
+			conns = s.(rcmgr.ResourceScopeLimiter).Limit().GetConnTotalLimit()
+			return nil
+		}))
+		return conns
+	}
+	before := systemConns()
+
+	// nothing changed
+	reloader.check()
+	require.Equal(t, before, systemConns())
+
+	// limits set with the command line are persisted, and applied
+	limit := rcmgr.BasicLimitConfig{Conns: 10, ConnsInbound: 5, ConnsOutbound: 5, Memory: 1 << 20}
+	require.NoError(t, NetSetLimit(mgr, r, config.ResourceMgrSystemScope, limit))
+	require.Equal(t, 10, systemConns())
+	require.Equal(t, 10, r.C.Swarm.ResourceMgr.Limits.System.Conns)
+
+	// and edits of the config are reloaded, for both the existing scopes and
+	// the new ones
+	r.C.Swarm.ResourceMgr.Limits.System.Conns = 20
+	r.C.Swarm.ResourceMgr.Limits.PeerDefault = &rcmgr.BasicLimitConfig{Conns: 3, ConnsInbound: 1, ConnsOutbound: 2, Memory: 1 << 20}
+	reloader.check()
+	require.Equal(t, 20, systemConns())
+	require.Equal(t, 3, peerConns())
+
+	// invalid limits are ignored
+	r.C.Swarm.ResourceMgr.MaxMemory = config.NewOptionalString("lots")
+	reloader.check()
+	require.Equal(t, 20, systemConns())
+}
@@ -0,0 +1,123 @@
+package node
+
+import (
+	"fmt"
+	"strings"
+
+	config "github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/core/node/helpers"
+	"github.com/ipfs/kubo/p2p"
+	"github.com/libp2p/go-libp2p-core/peer"
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
+	"go.uber.org/fx"
+)
+
+// p2pProtoPrefix is the namespace p2p protocols must be in, unless they allow
+// custom protocols.
+const p2pProtoPrefix = "/x/"
+
+// P2PPersisted registers the listeners and forwards persisted in the P2P
+// config section.
+func P2PPersisted(cfg config.P2P) interface{} {
+	return func(mctx helpers.MetricsCtx, lc fx.Lifecycle, p *p2p.P2P, ps pstore.Peerstore) error {
+		ctx := helpers.LifecycleCtx(mctx, lc)
+
+		for i, l := range cfg.Listeners {
+			proto, target, acl, err := parseP2PListener(cfg, l)
+			if err != nil {
+				return fmt.Errorf("invalid P2P.Listeners entry %d: %w", i, err)
+			}
+			if _, err := p.ForwardRemote(ctx, proto, target, l.ReportPeerID, acl); err != nil {
+				return fmt.Errorf("registering P2P.Listeners entry %d (%s): %w", i, proto, err)
+			}
+		}
+
+		for i, f := range cfg.Forwards {
+			proto, listen, target, err := parseP2PForward(f)
+			if err != nil {
+				return fmt.Errorf("invalid P2P.Forwards entry %d: %w", i, err)
+			}
+			// persisted forwards are long-lived, keep the addresses they name
+			ps.AddAddrs(target.ID, target.Addrs, pstore.PermanentAddrTTL)
+			if _, err := p.ForwardLocal(ctx, target.ID, proto, listen); err != nil {
+				return fmt.Errorf("registering P2P.Forwards entry %d (%s on %s): %w", i, proto, listen, err)
+			}
+		}
+		return nil
+	}
+}
+
+// parseP2PListener validates a persisted listener, returning its protocol,
+// target address and access list.
+func parseP2PListener(cfg config.P2P, l config.P2PListener) (protocol.ID, ma.Multiaddr, *p2p.ACL, error) {
+	proto, err := parseP2PProtocol(l.Protocol, l.AllowCustomProtocol)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	target, err := ma.NewMultiaddr(l.TargetAddress)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("invalid TargetAddress %q: %w", l.TargetAddress, err)
+	}
+
+	acl, err := P2PListenerACL(cfg, l)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return proto, target, acl, nil
+}
+
+// P2PListenerACL builds the access list of a listener from the named access
+// list in P2P.ACLs and its own allowed and denied peers. It returns nil when
+// the listener isn't restricted.
+func P2PListenerACL(cfg config.P2P, l config.P2PListener) (*p2p.ACL, error) {
+	if l.ACL == "" && len(l.Allow) == 0 && len(l.Deny) == 0 {
+		return nil, nil
+	}
+
+	var allow, deny []peer.ID
+	if l.ACL != "" {
+		named, ok := cfg.ACLs[l.ACL]
+		if !ok {
+			return nil, fmt.Errorf("no access list named %q in P2P.ACLs", l.ACL)
+		}
+		allow = append(allow, named.Allow...)
+		deny = append(deny, named.Deny...)
+	}
+	allow = append(allow, l.Allow...)
+	deny = append(deny, l.Deny...)
+	return p2p.NewACL(allow, deny), nil
+}
+
+// parseP2PForward validates a persisted forward, returning its protocol,
+// listen address and the peer it forwards to.
+func parseP2PForward(f config.P2PForward) (protocol.ID, ma.Multiaddr, *peer.AddrInfo, error) {
+	proto, err := parseP2PProtocol(f.Protocol, f.AllowCustomProtocol)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	listen, err := ma.NewMultiaddr(f.ListenAddress)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("invalid ListenAddress %q: %w", f.ListenAddress, err)
+	}
+	target, err := ma.NewMultiaddr(f.TargetAddress)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("invalid TargetAddress %q: %w", f.TargetAddress, err)
+	}
+	info, err := peer.AddrInfoFromP2pAddr(target)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("TargetAddress %q must end with /p2p/<peer-id>: %w", f.TargetAddress, err)
+	}
+	return proto, listen, info, nil
+}
+
+func parseP2PProtocol(s string, allowCustom bool) (protocol.ID, error) {
+	if s == "" {
+		return "", fmt.Errorf("missing Protocol")
+	}
+	if !allowCustom && !strings.HasPrefix(s, p2pProtoPrefix) {
+		return "", fmt.Errorf("protocol name %q must be within '%s' namespace, or set AllowCustomProtocol", s, p2pProtoPrefix)
+	}
+	return protocol.ID(s), nil
+}
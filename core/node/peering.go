@@ -2,13 +2,38 @@ package node
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
 
+	files "github.com/ipfs/go-ipfs-files"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-namesys"
+	path "github.com/ipfs/go-path"
+	unixfile "github.com/ipfs/go-unixfs/file"
+	uio "github.com/ipfs/go-unixfs/io"
+	config "github.com/ipfs/kubo/config"
 	"github.com/ipfs/kubo/peering"
 	"github.com/libp2p/go-libp2p-core/host"
 	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	madns "github.com/multiformats/go-multiaddr-dns"
 	"go.uber.org/fx"
 )
 
+const (
+	// DefaultPeeringGroupsInterval is the default Peering.GroupsInterval.
+	DefaultPeeringGroupsInterval = 10 * time.Minute
+
+	// maxDnsaddrDepth bounds the nesting of the dnsaddr records of a group.
+	maxDnsaddrDepth = 4
+	// maxPeeringGroupSize bounds the size of the JSON list of an IPNS group.
+	maxPeeringGroupSize = 1 << 20
+)
+
 // Peering constructs the peering service and hooks it into fx's lifetime
 // management system.
 func Peering(lc fx.Lifecycle, host host.Host) *peering.PeeringService {
@@ -28,7 +53,154 @@ func Peering(lc fx.Lifecycle, host host.Host) *peering.PeeringService {
 func PeerWith(peers ...peer.AddrInfo) fx.Option {
 	return fx.Invoke(func(ps *peering.PeeringService) {
 		for _, ai := range peers {
-			ps.AddPeer(ai)
+			ps.AddPeerFrom(peering.SourceConfig, ai)
 		}
 	})
 }
+
+// PeeringGroups configures the peering service to peer with the members of the
+// Peering.Groups, resolved every Peering.GroupsInterval.
+func PeeringGroups(cfg config.Peering) fx.Option {
+	if len(cfg.Groups) == 0 {
+		return fx.Options()
+	}
+	for _, group := range cfg.Groups {
+		if err := checkPeeringGroup(group); err != nil {
+			return fx.Error(err)
+		}
+	}
+	interval := cfg.GroupsInterval.WithDefault(DefaultPeeringGroupsInterval)
+	if interval <= 0 {
+		return fx.Error(fmt.Errorf("config setting Peering.GroupsInterval must be positive: %s", interval))
+	}
+
+	return fx.Invoke(func(lc fx.Lifecycle, ps *peering.PeeringService, rslv *madns.Resolver, ns namesys.NameSystem, dag format.DAGService) {
+		resolver := peering.NewGroupResolver(ps, cfg.Groups, interval, func(ctx context.Context, group string) ([]peer.AddrInfo, error) {
+			if strings.HasPrefix(group, "/ipns/") {
+				return resolveIpnsPeeringGroup(ctx, ns, dag, group)
+			}
+			return resolveDNSPeeringGroup(ctx, rslv, group)
+		})
+		lc.Append(fx.Hook{
+			OnStart: func(context.Context) error {
+				resolver.Start()
+				return nil
+			},
+			OnStop: func(context.Context) error {
+				resolver.Stop()
+				return nil
+			},
+		})
+	})
+}
+
+func checkPeeringGroup(group string) error {
+	if strings.HasPrefix(group, "/ipns/") {
+		if _, err := path.ParsePath(group); err != nil {
+			return fmt.Errorf("invalid peering group %q: %w", group, err)
+		}
+		return nil
+	}
+	maddr, err := ma.NewMultiaddr(group)
+	if err != nil {
+		return fmt.Errorf("invalid peering group %q: %w", group, err)
+	}
+	if _, err := maddr.ValueForProtocol(ma.P_DNSADDR); err != nil {
+		return fmt.Errorf("invalid peering group %q: expected a /dnsaddr/ multiaddr or an /ipns/ path", group)
+	}
+	return nil
+}
+
+// resolveDNSPeeringGroup resolves the peers of a /dnsaddr/ group, following
+// nested dnsaddr records.
+func resolveDNSPeeringGroup(ctx context.Context, rslv *madns.Resolver, group string) ([]peer.AddrInfo, error) {
+	maddr, err := ma.NewMultiaddr(group)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := []ma.Multiaddr{maddr}
+	var resolved []ma.Multiaddr
+	for depth := 0; len(addrs) > 0; depth++ {
+		if depth > maxDnsaddrDepth {
+			return nil, errors.New("too many nested dnsaddr records")
+		}
+		var next []ma.Multiaddr
+		for _, addr := range addrs {
+			if _, err := addr.ValueForProtocol(ma.P_DNSADDR); err != nil {
+				resolved = append(resolved, addr)
+				continue
+			}
+			addrs, err := rslv.Resolve(ctx, addr)
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, addrs...)
+		}
+		addrs = next
+	}
+
+	infos := make(map[peer.ID]*peer.AddrInfo)
+	var peers []peer.AddrInfo
+	for _, addr := range resolved {
+		transport, id := peer.SplitAddr(addr)
+		if id == "" {
+			logger.Debugf("ignoring address %s of peering group %s without a peer ID", addr, group)
+			continue
+		}
+		info, ok := infos[id]
+		if !ok {
+			info = &peer.AddrInfo{ID: id}
+			infos[id] = info
+		}
+		if transport != nil {
+			info.Addrs = append(info.Addrs, transport)
+		}
+	}
+	for _, info := range infos {
+		peers = append(peers, *info)
+	}
+	return peers, nil
+}
+
+// resolveIpnsPeeringGroup resolves the peers of an /ipns/ group, a UnixFS file
+// holding a JSON list like Peering.Peers.
+func resolveIpnsPeeringGroup(ctx context.Context, ns namesys.NameSystem, dag format.DAGService, group string) ([]peer.AddrInfo, error) {
+	p, err := ns.Resolve(ctx, group)
+	if err != nil {
+		return nil, err
+	}
+	c, rest, err := path.SplitAbsPath(p)
+	if err != nil {
+		return nil, err
+	}
+	nd, err := dag.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range rest {
+		dir, err := uio.NewDirectoryFromNode(dag, nd)
+		if err != nil {
+			return nil, err
+		}
+		if nd, err = dir.Find(ctx, name); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := unixfile.NewUnixfsFile(ctx, dag, nd)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	file, ok := f.(files.File)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a file", p)
+	}
+
+	var peers []peer.AddrInfo
+	if err := json.NewDecoder(io.LimitReader(file, maxPeeringGroupSize)).Decode(&peers); err != nil {
+		return nil, fmt.Errorf("decoding the peers of %s: %w", p, err)
+	}
+	return peers, nil
+}
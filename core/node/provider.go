@@ -6,15 +6,18 @@ import (
 	"time"
 
 	"github.com/ipfs/go-fetcher"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
 	pin "github.com/ipfs/go-ipfs-pinner"
 	provider "github.com/ipfs/go-ipfs-provider"
 	"github.com/ipfs/go-ipfs-provider/batched"
 	q "github.com/ipfs/go-ipfs-provider/queue"
 	"github.com/ipfs/go-ipfs-provider/simple"
+	"github.com/ipfs/go-mfs"
 	"go.uber.org/fx"
 
 	"github.com/ipfs/kubo/core/node/helpers"
 	"github.com/ipfs/kubo/repo"
+	"github.com/ipfs/kubo/reprovide"
 	irouting "github.com/ipfs/kubo/routing"
 )
 
@@ -32,10 +35,17 @@ func SimpleProvider(mctx helpers.MetricsCtx, lc fx.Lifecycle, queue *q.Queue, rt
 	return simple.NewProvider(helpers.LifecycleCtx(mctx, lc), queue, rt)
 }
 
+// ReprovideTracker creates the tracker of the reprovide cycles progress
+func ReprovideTracker(reproviderInterval time.Duration) interface{} {
+	return func(mctx helpers.MetricsCtx, lc fx.Lifecycle, repo repo.Repo) (*reprovide.Tracker, error) {
+		return reprovide.NewTracker(helpers.LifecycleCtx(mctx, lc), repo.Datastore(), reproviderInterval)
+	}
+}
+
 // SimpleReprovider creates new reprovider
 func SimpleReprovider(reproviderInterval time.Duration) interface{} {
-	return func(mctx helpers.MetricsCtx, lc fx.Lifecycle, rt irouting.TieredRouter, keyProvider simple.KeyChanFunc) (provider.Reprovider, error) {
-		return simple.NewReprovider(helpers.LifecycleCtx(mctx, lc), reproviderInterval, rt, keyProvider), nil
+	return func(mctx helpers.MetricsCtx, lc fx.Lifecycle, rt irouting.TieredRouter, keyProvider simple.KeyChanFunc, tracker *reprovide.Tracker) (provider.Reprovider, error) {
+		return simple.NewReprovider(helpers.LifecycleCtx(mctx, lc), reproviderInterval, tracker.ContentRouting(rt), tracker.Keys(keyProvider)), nil
 	}
 }
 
@@ -62,7 +72,7 @@ func SimpleProviderSys(isOnline bool) interface{} {
 
 // BatchedProviderSys creates new provider system
 func BatchedProviderSys(isOnline bool, reprovideInterval string) interface{} {
-	return func(lc fx.Lifecycle, cr irouting.TieredRouter, q *q.Queue, keyProvider simple.KeyChanFunc, repo repo.Repo) (provider.System, error) {
+	return func(mctx helpers.MetricsCtx, lc fx.Lifecycle, cr irouting.TieredRouter, q *q.Queue, keyProvider simple.KeyChanFunc, repo repo.Repo, tracker *reprovide.Tracker) (provider.System, error) {
 		r := cr.ProvideMany()
 		if r == nil {
 			return nil, fmt.Errorf("BatchedProviderSys requires a content router that supports provideMany")
@@ -78,10 +88,10 @@ func BatchedProviderSys(isOnline bool, reprovideInterval string) interface{} {
 			reprovideIntervalDuration = dur
 		}
 
-		sys, err := batched.New(r, q,
+		sys, err := batched.New(tracker.ProvideMany(r), q,
 			batched.ReproviderInterval(reprovideIntervalDuration),
 			batched.Datastore(repo.Datastore()),
-			batched.KeyProvider(keyProvider))
+			batched.KeyProvider(tracker.Keys(keyProvider)))
 		if err != nil {
 			return nil, err
 		}
@@ -98,7 +108,9 @@ func BatchedProviderSys(isOnline bool, reprovideInterval string) interface{} {
 			})
 		}
 
-		return sys, nil
+		// new roots would otherwise wait for the batches of a running
+		// reprovide cycle
+		return tracker.Prioritized(helpers.LifecycleCtx(mctx, lc), sys, r), nil
 	}
 }
 
@@ -152,6 +164,10 @@ func SimpleProviders(reprovideStrategy string, reprovideInterval string) fx.Opti
 		keyProvider = fx.Provide(pinnedProviderStrategy(true))
 	case "pinned":
 		keyProvider = fx.Provide(pinnedProviderStrategy(false))
+	case "mfs":
+		keyProvider = fx.Provide(mfsProviderStrategy)
+	case "pinned+mfs":
+		keyProvider = fx.Provide(pinnedMFSProviderStrategy())
 	default:
 		return fx.Error(fmt.Errorf("unknown reprovider strategy '%s'", reprovideStrategy))
 	}
@@ -160,17 +176,42 @@ func SimpleProviders(reprovideStrategy string, reprovideInterval string) fx.Opti
 		fx.Provide(ProviderQueue),
 		fx.Provide(SimpleProvider),
 		keyProvider,
+		fx.Provide(ReprovideTracker(reproviderInterval)),
 		fx.Provide(SimpleReprovider(reproviderInterval)),
 	)
 }
 
+// ProvideExclusions returns the pins excluded from providing
+func ProvideExclusions(repo repo.Repo) *reprovide.Exclusions {
+	return reprovide.NewExclusions(repo.Datastore())
+}
+
 func pinnedProviderStrategy(onlyRoots bool) interface{} {
 	type input struct {
 		fx.In
 		Pinner      pin.Pinner
 		IPLDFetcher fetcher.Factory `name:"ipldFetcher"`
+		Exclusions  *reprovide.Exclusions
+	}
+	return func(in input) simple.KeyChanFunc {
+		return reprovide.NewPinnedProvider(onlyRoots, in.Pinner, in.IPLDFetcher, in.Exclusions)
+	}
+}
+
+func mfsProviderStrategy(root *mfs.Root, bs blockstore.Blockstore) simple.KeyChanFunc {
+	return reprovide.NewMFSProvider(root, bs)
+}
+
+func pinnedMFSProviderStrategy() interface{} {
+	type input struct {
+		fx.In
+		Pinner      pin.Pinner
+		IPLDFetcher fetcher.Factory `name:"ipldFetcher"`
+		Exclusions  *reprovide.Exclusions
+		Root        *mfs.Root
+		Blockstore  blockstore.Blockstore
 	}
 	return func(in input) simple.KeyChanFunc {
-		return simple.NewPinnedProvider(onlyRoots, in.Pinner, in.IPLDFetcher)
+		return reprovide.NewPinnedMFSProvider(in.Pinner, in.IPLDFetcher, in.Exclusions, in.Root, in.Blockstore)
 	}
 }
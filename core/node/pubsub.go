@@ -0,0 +1,118 @@
+package node
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dustin/go-humanize"
+	config "github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/pubsub/history"
+	"github.com/ipfs/kubo/pubsub/validate"
+	"github.com/ipfs/kubo/repo"
+	"github.com/libp2p/go-libp2p-core/peer"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"go.uber.org/fx"
+)
+
+const (
+	// Docs: https://github.com/ipfs/kubo/blob/master/docs/config.md#pubsubhistorysize
+	DefaultPubsubHistorySize = 1000
+)
+
+// PubsubHistory constructs the service managing the daemon's long-lived
+// pubsub subscriptions and subscribes to the topics listed in the config.
+func PubsubHistory(cfg config.PubsubConfig) interface{} {
+	return func(lc fx.Lifecycle, repo repo.Repo, ps *pubsub.PubSub) (*history.Service, error) {
+		size := cfg.HistorySize.WithDefault(DefaultPubsubHistorySize)
+		if size <= 0 {
+			return nil, fmt.Errorf("config setting Pubsub.HistorySize must be positive: %d", size)
+		}
+
+		hs := history.New(ps, repo.Datastore(), uint64(size))
+		lc.Append(fx.Hook{
+			OnStart: func(context.Context) error {
+				for _, topic := range cfg.Subscriptions {
+					if err := hs.Subscribe(topic); err != nil {
+						return fmt.Errorf("subscribing to Pubsub.Subscriptions topic %q: %w", topic, err)
+					}
+				}
+				return nil
+			},
+			OnStop: func(context.Context) error {
+				return hs.Close()
+			},
+		})
+		return hs, nil
+	}
+}
+
+// PubsubSignaturePolicy returns the message signature policy matching the
+// pubsub config. Topics requiring signatures on an otherwise unsigned network
+// need a lax policy: local messages are signed, and incoming signatures are
+// verified when present so the topic validator can require them.
+//
+// The policy applies to the whole router, so a single topic requiring
+// signatures makes the node sign its messages on every topic.
+func PubsubSignaturePolicy(cfg config.PubsubConfig) pubsub.MessageSignaturePolicy {
+	if !cfg.DisableSigning {
+		return pubsub.StrictSign
+	}
+	for _, policy := range cfg.TopicPolicies {
+		if policy.RequireSigning.WithDefault(false) {
+			return pubsub.LaxSign
+		}
+	}
+	return pubsub.StrictNoSign
+}
+
+// PubsubTopicPolicies registers the validators enforcing
+// Pubsub.TopicPolicies.
+func PubsubTopicPolicies(cfg config.PubsubConfig) interface{} {
+	return func(ps *pubsub.PubSub) error {
+		for topic, tp := range cfg.TopicPolicies {
+			p, err := parseTopicPolicy(tp)
+			if err != nil {
+				return fmt.Errorf("invalid Pubsub.TopicPolicies entry for topic %q: %w", topic, err)
+			}
+			v, err := validate.New(topic, p)
+			if err != nil {
+				return err
+			}
+			if err := ps.RegisterTopicValidator(topic, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func parseTopicPolicy(tp config.PubsubTopicPolicy) (validate.Policy, error) {
+	p := validate.Policy{
+		RequireSigning: tp.RequireSigning.WithDefault(false),
+		Validators:     tp.Validators,
+	}
+
+	for _, s := range tp.AllowedPublishers {
+		id, err := peer.Decode(s)
+		if err != nil {
+			return p, fmt.Errorf("invalid publisher %q: %w", s, err)
+		}
+		p.AllowedPublishers = append(p.AllowedPublishers, id)
+	}
+
+	if !tp.MaxMessageSize.IsDefault() {
+		size, err := humanize.ParseBytes(tp.MaxMessageSize.WithDefault(""))
+		if err != nil {
+			return p, fmt.Errorf("invalid MaxMessageSize: %w", err)
+		}
+		p.MaxMessageSize = int(size)
+	}
+
+	rate := tp.PeerRateLimit.WithDefault(0)
+	if rate < 0 {
+		return p, fmt.Errorf("PeerRateLimit cannot be negative: %d", rate)
+	}
+	p.PeerRateLimit = int(rate)
+
+	return p, nil
+}
@@ -0,0 +1,67 @@
+package node
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dustin/go-humanize"
+	bserv "github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	mfs "github.com/ipfs/go-mfs"
+	config "github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/core/node/helpers"
+	"github.com/ipfs/kubo/repo"
+	"github.com/ipfs/kubo/scrub"
+	"go.uber.org/fx"
+)
+
+// Docs: https://github.com/ipfs/kubo/blob/master/docs/config.md#datastorescrubrate
+const DefaultScrubRate = "1MiB"
+
+// Scrubber constructs the scrubber of the blocks of the repo, running every
+// Datastore.ScrubInterval while the node is up. There is no scrubber when the
+// interval is unset or zero.
+func Scrubber(cfg config.Datastore) interface{} {
+	return func(mctx helpers.MetricsCtx, lc fx.Lifecycle, repo repo.Repo, bs blockstore.GCBlockstore, pinning pin.Pinner, files *mfs.Root, bsvc bserv.BlockService) (*scrub.Scrubber, error) {
+		rate, err := humanize.ParseBytes(cfg.ScrubRate.WithDefault(DefaultScrubRate))
+		if err != nil {
+			return nil, fmt.Errorf("failure to parse config setting Datastore.ScrubRate: %w", err)
+		}
+		if rate == 0 {
+			return nil, fmt.Errorf("config setting Datastore.ScrubRate must be positive")
+		}
+		interval := cfg.ScrubInterval.WithDefault(0)
+		if interval <= 0 {
+			return nil, nil
+		}
+
+		fetch := func(ctx context.Context, c cid.Cid) error {
+			_, err := bsvc.GetBlock(ctx, c)
+			return err
+		}
+		s, err := scrub.New(mctx, repo.Datastore(), bs, pinning, files, fetch, interval, rate)
+		if err != nil {
+			return nil, err
+		}
+
+		ctx, cancel := context.WithCancel(mctx)
+		done := make(chan struct{})
+		lc.Append(fx.Hook{
+			OnStart: func(context.Context) error {
+				go func() {
+					defer close(done)
+					s.Run(ctx)
+				}()
+				return nil
+			},
+			OnStop: func(context.Context) error {
+				cancel()
+				<-done
+				return nil
+			},
+		})
+		return s, nil
+	}
+}
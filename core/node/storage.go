@@ -1,12 +1,15 @@
 package node
 
 import (
+	"time"
+
 	"github.com/ipfs/go-datastore"
 	blockstore "github.com/ipfs/go-ipfs-blockstore"
 	config "github.com/ipfs/kubo/config"
 	"go.uber.org/fx"
 
 	"github.com/ipfs/go-filestore"
+	"github.com/ipfs/kubo/blocks/blockcache"
 	"github.com/ipfs/kubo/core/node/helpers"
 	"github.com/ipfs/kubo/repo"
 	"github.com/ipfs/kubo/thirdparty/verifbs"
@@ -25,17 +28,41 @@ func Datastore(repo repo.Repo) datastore.Datastore {
 // BaseBlocks is the lower level blockstore without GC or Filestore layers
 type BaseBlocks blockstore.Blockstore
 
+// Docs: https://github.com/ipfs/kubo/blob/master/docs/config.md#datastorebloomfilterrebuildperiod
+const DefaultBloomFilterRebuildPeriod = 24 * time.Hour
+
+// BlockCacheOpts configures the caches of the blockstore in
+// blocks/blockcache.
+type BlockCacheOpts struct {
+	// AutoBloom sizes the bloom filter from the number of blocks, and
+	// rebuilds it every BloomRebuildPeriod.
+	AutoBloom          bool
+	BloomRebuildPeriod time.Duration
+	// BlockCacheSize is the size of the cache of block data, 0 disables it.
+	BlockCacheSize uint64
+}
+
 // BaseBlockstoreCtor creates cached blockstore backed by the provided datastore
-func BaseBlockstoreCtor(cacheOpts blockstore.CacheOpts, nilRepo bool, hashOnRead bool) func(mctx helpers.MetricsCtx, repo repo.Repo, lc fx.Lifecycle) (bs BaseBlocks, err error) {
-	return func(mctx helpers.MetricsCtx, repo repo.Repo, lc fx.Lifecycle) (bs BaseBlocks, err error) {
+func BaseBlockstoreCtor(cacheOpts blockstore.CacheOpts, blockCacheOpts BlockCacheOpts, nilRepo bool, hashOnRead bool) func(mctx helpers.MetricsCtx, repo repo.Repo, lc fx.Lifecycle) (bs BaseBlocks, bloom *blockcache.Bloom, cache *blockcache.Cache, err error) {
+	return func(mctx helpers.MetricsCtx, repo repo.Repo, lc fx.Lifecycle) (bs BaseBlocks, bloom *blockcache.Bloom, cache *blockcache.Cache, err error) {
 		// hash security
 		bs = blockstore.NewBlockstore(repo.Datastore())
 		bs = &verifbs.VerifBS{Blockstore: bs}
 
 		if !nilRepo {
+			if blockCacheOpts.BlockCacheSize > 0 {
+				cache = blockcache.NewCache(bs, blockCacheOpts.BlockCacheSize)
+				bs = cache
+			}
+
 			bs, err = blockstore.CachedBlockstore(helpers.LifecycleCtx(mctx, lc), bs, cacheOpts)
 			if err != nil {
-				return nil, err
+				return nil, nil, nil, err
+			}
+
+			if blockCacheOpts.AutoBloom {
+				bloom = blockcache.NewBloom(helpers.LifecycleCtx(mctx, lc), bs, blockCacheOpts.BloomRebuildPeriod)
+				bs = bloom
 			}
 		}
 
@@ -0,0 +1,40 @@
+package policy
+
+import (
+	"context"
+	"errors"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	exchange "github.com/ipfs/go-ipfs-exchange-interface"
+)
+
+// ErrClientDisabled is returned when fetching blocks through an exchange that
+// only serves them.
+var ErrClientDisabled = errors.New("fetching blocks is disabled (Bitswap.ClientEnabled is false)")
+
+// ServeOnly wraps an exchange so it keeps serving blocks and announcing new
+// ones, but never requests any from other peers.
+type ServeOnly struct {
+	exchange.Interface
+}
+
+var _ exchange.SessionExchange = (*ServeOnly)(nil)
+
+// Unwrap returns the wrapped exchange.
+func (s *ServeOnly) Unwrap() exchange.Interface {
+	return s.Interface
+}
+
+func (s *ServeOnly) GetBlock(context.Context, cid.Cid) (blocks.Block, error) {
+	return nil, ErrClientDisabled
+}
+
+func (s *ServeOnly) GetBlocks(context.Context, []cid.Cid) (<-chan blocks.Block, error) {
+	return nil, ErrClientDisabled
+}
+
+// NewSession returns the exchange itself, so sessions can't fetch either.
+func (s *ServeOnly) NewSession(context.Context) exchange.Fetcher {
+	return s
+}
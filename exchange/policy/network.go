@@ -0,0 +1,111 @@
+package policy
+
+import (
+	"context"
+	"sort"
+
+	bsmsg "github.com/ipfs/go-bitswap/message"
+	bsnet "github.com/ipfs/go-bitswap/network"
+	cid "github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// network hands bitswap a receiver enforcing the policy on incoming messages,
+// and rate limits the blocks it sends.
+type network struct {
+	bsnet.BitSwapNetwork
+	policy Policy
+}
+
+func (n *network) Start(r bsnet.Receiver) {
+	n.BitSwapNetwork.Start(&receiver{Receiver: r, policy: n.policy})
+}
+
+// SendMessage is used by the bitswap server to send blocks, wants are sent
+// through message senders and aren't limited.
+func (n *network) SendMessage(ctx context.Context, p peer.ID, msg bsmsg.BitSwapMessage) error {
+	if l := n.policy.Limiter; l != nil {
+		size := 0
+		for _, b := range msg.Blocks() {
+			size += len(b.RawData())
+		}
+		if err := l.WaitSend(ctx, p, size); err != nil {
+			return err
+		}
+	}
+	return n.BitSwapNetwork.SendMessage(ctx, p, msg)
+}
+
+// wantlister is implemented by bitswap, returning the wantlist it keeps for a
+// peer.
+type wantlister interface {
+	WantlistForPeer(peer.ID) []cid.Cid
+}
+
+type receiver struct {
+	bsnet.Receiver
+	policy Policy
+}
+
+func (r *receiver) ReceiveMessage(ctx context.Context, p peer.ID, msg bsmsg.BitSwapMessage) {
+	r.Receiver.ReceiveMessage(ctx, p, r.limitWants(p, msg))
+}
+
+// limitWants drops the wants of msg that would grow the wantlist of p beyond
+// the maximum size, or exceed its rate limit. The wants with the lowest
+// priority are dropped first.
+func (r *receiver) limitWants(p peer.ID, msg bsmsg.BitSwapMessage) bsmsg.BitSwapMessage {
+	entries := msg.Wantlist()
+	if len(entries) == 0 {
+		return msg
+	}
+
+	wanted := cid.NewSet()
+	if wl, ok := r.Receiver.(wantlister); ok && !msg.Full() {
+		for _, c := range wl.WantlistForPeer(p) {
+			wanted.Add(c)
+		}
+	}
+	for _, e := range entries {
+		if e.Cancel {
+			wanted.Remove(e.Cid)
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Priority > entries[j].Priority
+	})
+
+	var added, dropped []cid.Cid
+	for _, e := range entries {
+		if e.Cancel || wanted.Has(e.Cid) {
+			continue
+		}
+		if r.policy.MaxWantlistSize > 0 && wanted.Len() >= r.policy.MaxWantlistSize {
+			dropped = append(dropped, e.Cid)
+			continue
+		}
+		wanted.Add(e.Cid)
+		added = append(added, e.Cid)
+	}
+	if len(dropped) > 0 {
+		log.Debugf("dropping %d wants from %s: wantlist is full", len(dropped), p)
+	}
+
+	if l := r.policy.Limiter; l != nil {
+		allowed := l.AllowWants(p, len(added))
+		if allowed < len(added) {
+			log.Debugf("dropping %d wants from %s: rate limited", len(added)-allowed, p)
+			dropped = append(dropped, added[allowed:]...)
+		}
+	}
+
+	if len(dropped) == 0 {
+		return msg
+	}
+	limited := msg.Clone()
+	for _, c := range dropped {
+		limited.Remove(c)
+	}
+	return limited
+}
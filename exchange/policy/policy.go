@@ -0,0 +1,73 @@
+// Package policy restricts what a bitswap node serves to, and accepts from,
+// other peers.
+package policy
+
+import (
+	bitswap "github.com/ipfs/go-bitswap"
+	bsnet "github.com/ipfs/go-bitswap/network"
+	cid "github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+var log = logging.Logger("exchange/policy")
+
+// Policy describes the requests a bitswap server accepts.
+type Policy struct {
+	// ServerEnabled, when false, makes every block request fail as if the
+	// block wasn't available.
+	ServerEnabled bool
+
+	// Allow, when not empty, only serves the listed peers. Deny never serves
+	// the listed peers, even if also allowed.
+	Allow []peer.ID
+	Deny  []peer.ID
+
+	// MaxWantlistSize is the maximum number of entries kept in the wantlist
+	// of a peer, further wants are dropped. Zero means no limit.
+	MaxWantlistSize int
+
+	// Limiter, when not nil, rate limits the wants accepted from and the
+	// blocks sent to each peer.
+	Limiter *Limiter
+}
+
+// BlockRequestFilter returns the filter deciding which peers get served, or
+// nil when all of them do.
+func (p Policy) BlockRequestFilter() bitswap.PeerBlockRequestFilter {
+	if !p.ServerEnabled {
+		return func(peer.ID, cid.Cid) bool { return false }
+	}
+	if len(p.Allow) == 0 && len(p.Deny) == 0 {
+		return nil
+	}
+
+	allow := make(map[peer.ID]struct{}, len(p.Allow))
+	for _, id := range p.Allow {
+		allow[id] = struct{}{}
+	}
+	deny := make(map[peer.ID]struct{}, len(p.Deny))
+	for _, id := range p.Deny {
+		deny[id] = struct{}{}
+	}
+
+	return func(id peer.ID, _ cid.Cid) bool {
+		if _, ok := deny[id]; ok {
+			return false
+		}
+		if len(allow) == 0 {
+			return true
+		}
+		_, ok := allow[id]
+		return ok
+	}
+}
+
+// Network wraps n so incoming messages are checked against the policy before
+// bitswap handles them.
+func (p Policy) Network(n bsnet.BitSwapNetwork) bsnet.BitSwapNetwork {
+	if p.MaxWantlistSize <= 0 && p.Limiter == nil {
+		return n
+	}
+	return &network{BitSwapNetwork: n, policy: p}
+}
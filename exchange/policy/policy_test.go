@@ -0,0 +1,97 @@
+package policy
+
+import (
+	"context"
+	"testing"
+
+	bsmsg "github.com/ipfs/go-bitswap/message"
+	pb "github.com/ipfs/go-bitswap/message/pb"
+	bsnet "github.com/ipfs/go-bitswap/network"
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestBlockRequestFilter(t *testing.T) {
+	alice, bob, carol := peer.ID("alice"), peer.ID("bob"), peer.ID("carol")
+	c := blocks.NewBlock([]byte("block")).Cid()
+
+	if f := (Policy{ServerEnabled: true}).BlockRequestFilter(); f != nil {
+		t.Fatal("expected no filter without restrictions")
+	}
+
+	f := Policy{ServerEnabled: false}.BlockRequestFilter()
+	if f(alice, c) {
+		t.Fatal("expected a disabled server to serve nobody")
+	}
+
+	f = Policy{ServerEnabled: true, Allow: []peer.ID{alice, bob}, Deny: []peer.ID{bob}}.BlockRequestFilter()
+	for id, want := range map[peer.ID]bool{alice: true, bob: false, carol: false} {
+		if got := f(id, c); got != want {
+			t.Errorf("%s: expected %t, got %t", id, want, got)
+		}
+	}
+
+	f = Policy{ServerEnabled: true, Deny: []peer.ID{bob}}.BlockRequestFilter()
+	if !f(alice, c) || f(bob, c) {
+		t.Fatal("expected only denied peers to be refused")
+	}
+}
+
+type fakeReceiver struct {
+	bsnet.Receiver
+	wantlist []cid.Cid
+	received bsmsg.BitSwapMessage
+}
+
+func (r *fakeReceiver) ReceiveMessage(_ context.Context, _ peer.ID, msg bsmsg.BitSwapMessage) {
+	r.received = msg
+}
+
+func (r *fakeReceiver) WantlistForPeer(peer.ID) []cid.Cid {
+	return r.wantlist
+}
+
+func TestWantlistLimit(t *testing.T) {
+	var cids []cid.Cid
+	for _, s := range []string{"a", "b", "c", "d", "e"} {
+		cids = append(cids, blocks.NewBlock([]byte(s)).Cid())
+	}
+
+	fake := &fakeReceiver{wantlist: cids[:2]}
+	r := &receiver{Receiver: fake, policy: Policy{ServerEnabled: true, MaxWantlistSize: 3}}
+
+	// a, b are already wanted: re-wanting b and cancelling a leaves room for
+	// c and d, but not for e which has the lowest priority
+	msg := bsmsg.New(false)
+	msg.AddEntry(cids[1], 1, pb.Message_Wantlist_Block, false)
+	msg.Cancel(cids[0])
+	for i, c := range cids[2:] {
+		msg.AddEntry(c, int32(10-i), pb.Message_Wantlist_Block, false)
+	}
+	r.ReceiveMessage(context.Background(), "p", msg)
+
+	got := cid.NewSet()
+	for _, e := range fake.received.Wantlist() {
+		got.Add(e.Cid)
+	}
+	for i, want := range []bool{true, true, true, true, false} {
+		if got.Has(cids[i]) != want {
+			t.Errorf("entry %d: expected kept=%t", i, want)
+		}
+	}
+	if len(msg.Wantlist()) != 5 {
+		t.Fatal("expected the original message to be left untouched")
+	}
+
+	// full wantlists replace the existing one
+	fake.received = nil
+	full := bsmsg.New(true)
+	for _, c := range cids[:3] {
+		full.AddEntry(c, 1, pb.Message_Wantlist_Block, false)
+	}
+	r.ReceiveMessage(context.Background(), "p", full)
+	if fake.received != full {
+		t.Fatal("expected a full wantlist within the limit to be passed as is")
+	}
+}
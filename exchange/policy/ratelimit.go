@@ -0,0 +1,273 @@
+package policy
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-bitswap/decision"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+const (
+	// maxWeight is the largest multiplier of the rate limits a peer can earn
+	// by sending us data.
+	maxWeight = 4
+
+	// ledgerRefreshInterval is how often peer weights are recomputed from
+	// the bitswap ledgers.
+	ledgerRefreshInterval = 10 * time.Second
+
+	// idlePeerTTL is how long the limits of a peer are kept after it was
+	// last seen.
+	idlePeerTTL = 10 * time.Minute
+)
+
+// RateLimits are the per-peer rates a bitswap server is allowed to spend on
+// a single peer.
+type RateLimits struct {
+	// BytesPerSecond is the bandwidth blocks are sent to a peer with. Zero
+	// means no limit.
+	BytesPerSecond int
+
+	// WantsPerSecond is the number of new wants accepted from a peer every
+	// second, further wants are dropped. Zero means no limit.
+	WantsPerSecond int
+
+	// Reciprocity weights the limits of a peer by how much data it sent us
+	// according to the bitswap ledger, so peers that exchange data fairly
+	// get more of the bandwidth than peers that only download.
+	Reciprocity bool
+}
+
+// ThrottledPeer reports how much a peer was throttled.
+type ThrottledPeer struct {
+	Peer         peer.ID
+	Weight       float64
+	DroppedWants uint64
+	DelayedBytes uint64
+	Delay        time.Duration
+}
+
+// Limiter enforces RateLimits with a token bucket per peer and direction.
+type Limiter struct {
+	limits RateLimits
+	now    func() time.Time
+
+	mu        sync.Mutex
+	peers     map[peer.ID]*peerLimits
+	lastSweep time.Time
+}
+
+type peerLimits struct {
+	weight float64
+	bytes  bucket
+	wants  bucket
+	seen   time.Time
+
+	droppedWants uint64
+	delayedBytes uint64
+	delay        time.Duration
+}
+
+// bucket is a token bucket holding up to a second worth of tokens. Tokens
+// may be borrowed, the bucket then stays negative until refilled.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *bucket) refill(now time.Time, rate float64) {
+	if b.last.IsZero() {
+		b.tokens = rate
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * rate
+		if b.tokens > rate {
+			b.tokens = rate
+		}
+	}
+	b.last = now
+}
+
+// NewLimiter returns a limiter enforcing limits, or nil when there are none.
+func NewLimiter(limits RateLimits) *Limiter {
+	if limits.BytesPerSecond <= 0 && limits.WantsPerSecond <= 0 && !limits.Reciprocity {
+		return nil
+	}
+	return &Limiter{
+		limits: limits,
+		now:    time.Now,
+		peers:  map[peer.ID]*peerLimits{},
+	}
+}
+
+// peer returns the limits of p, l.mu must be held.
+func (l *Limiter) peer(p peer.ID, now time.Time) *peerLimits {
+	if now.Sub(l.lastSweep) > idlePeerTTL {
+		for id, pl := range l.peers {
+			if now.Sub(pl.seen) > idlePeerTTL {
+				delete(l.peers, id)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	pl, ok := l.peers[p]
+	if !ok {
+		pl = &peerLimits{weight: 1}
+		l.peers[p] = pl
+	}
+	pl.seen = now
+	return pl
+}
+
+// AllowWants returns how many of n new wants from p are accepted.
+func (l *Limiter) AllowWants(p peer.ID, n int) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// track the peer even when wants aren't limited, to weight it
+	now := l.now()
+	pl := l.peer(p, now)
+	if l.limits.WantsPerSecond <= 0 || n == 0 {
+		return n
+	}
+
+	pl.wants.refill(now, float64(l.limits.WantsPerSecond)*pl.weight)
+
+	allowed := n
+	if float64(allowed) > pl.wants.tokens {
+		allowed = int(pl.wants.tokens)
+		if allowed < 0 {
+			allowed = 0
+		}
+		pl.droppedWants += uint64(n - allowed)
+	}
+	pl.wants.tokens -= float64(allowed)
+	return allowed
+}
+
+// WaitSend blocks until size bytes may be sent to p.
+func (l *Limiter) WaitSend(ctx context.Context, p peer.ID, size int) error {
+	if l.limits.BytesPerSecond <= 0 || size == 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := l.now()
+	pl := l.peer(p, now)
+	rate := float64(l.limits.BytesPerSecond) * pl.weight
+	pl.bytes.refill(now, rate)
+	pl.bytes.tokens -= float64(size)
+
+	var wait time.Duration
+	if pl.bytes.tokens < 0 {
+		wait = time.Duration(-pl.bytes.tokens / rate * float64(time.Second))
+		pl.delayedBytes += uint64(size)
+		pl.delay += wait
+	}
+	l.mu.Unlock()
+
+	if wait == 0 {
+		return nil
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Throttled returns the peers that had wants dropped or sends delayed,
+// most throttled first.
+func (l *Limiter) Throttled() []ThrottledPeer {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var out []ThrottledPeer
+	for p, pl := range l.peers {
+		if pl.droppedWants == 0 && pl.delayedBytes == 0 {
+			continue
+		}
+		out = append(out, ThrottledPeer{
+			Peer:         p,
+			Weight:       pl.weight,
+			DroppedWants: pl.droppedWants,
+			DelayedBytes: pl.delayedBytes,
+			Delay:        pl.delay,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Delay != out[j].Delay {
+			return out[i].Delay > out[j].Delay
+		}
+		return out[i].DroppedWants > out[j].DroppedWants
+	})
+	return out
+}
+
+// Ledgers gives access to the bitswap ledgers.
+type Ledgers interface {
+	LedgerForPeer(peer.ID) *decision.Receipt
+}
+
+// ledgerWeight is the weight of a peer that received sent bytes from us,
+// and sent us recv bytes: 1 for peers sending us nothing, 2 for an even
+// exchange, up to maxWeight.
+func ledgerWeight(sent, recv uint64) float64 {
+	w := 1 + float64(recv)/float64(sent+1)
+	if w > maxWeight {
+		w = maxWeight
+	}
+	return w
+}
+
+// TrackLedgers periodically updates the peer weights from the bitswap
+// ledgers until ctx is canceled. It does nothing unless the limits use
+// reciprocity.
+func (l *Limiter) TrackLedgers(ctx context.Context, ledgers Ledgers) {
+	if !l.limits.Reciprocity {
+		return
+	}
+
+	ticker := time.NewTicker(ledgerRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		l.refreshWeights(ledgers)
+	}
+}
+
+func (l *Limiter) refreshWeights(ledgers Ledgers) {
+	l.mu.Lock()
+	peers := make([]peer.ID, 0, len(l.peers))
+	for p := range l.peers {
+		peers = append(peers, p)
+	}
+	l.mu.Unlock()
+
+	// read the ledgers without holding the lock, bitswap may be waiting on it
+	weights := make(map[peer.ID]float64, len(peers))
+	for _, p := range peers {
+		if r := ledgers.LedgerForPeer(p); r != nil {
+			weights[p] = ledgerWeight(r.Sent, r.Recv)
+		}
+	}
+
+	l.mu.Lock()
+	for p, w := range weights {
+		if pl, ok := l.peers[p]; ok {
+			pl.weight = w
+		}
+	}
+	l.mu.Unlock()
+}
@@ -0,0 +1,97 @@
+package policy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-bitswap/decision"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestLimiterWants(t *testing.T) {
+	now := time.Now()
+	l := NewLimiter(RateLimits{WantsPerSecond: 10})
+	l.now = func() time.Time { return now }
+
+	if n := l.AllowWants("a", 8); n != 8 {
+		t.Fatalf("expected 8 wants allowed, got %d", n)
+	}
+	if n := l.AllowWants("a", 5); n != 2 {
+		t.Fatalf("expected 2 wants allowed, got %d", n)
+	}
+	if n := l.AllowWants("b", 5); n != 5 {
+		t.Fatalf("expected other peers not to be limited, got %d", n)
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if n := l.AllowWants("a", 10); n != 5 {
+		t.Fatalf("expected 5 refilled wants, got %d", n)
+	}
+
+	throttled := l.Throttled()
+	if len(throttled) != 1 || throttled[0].Peer != "a" || throttled[0].DroppedWants != 8 {
+		t.Fatalf("unexpected throttled peers: %+v", throttled)
+	}
+}
+
+func TestLimiterSend(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	l := NewLimiter(RateLimits{BytesPerSecond: 1 << 20})
+	l.now = func() time.Time { return now }
+
+	if err := l.WaitSend(ctx, "a", 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	if len(l.Throttled()) != 0 {
+		t.Fatal("expected the first second of data not to be delayed")
+	}
+
+	// the next send has to wait for half a second worth of tokens
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := l.WaitSend(cctx, "a", 1<<19); err != context.Canceled {
+		t.Fatalf("expected the send to wait, got %v", err)
+	}
+	throttled := l.Throttled()
+	if len(throttled) != 1 || throttled[0].DelayedBytes != 1<<19 || throttled[0].Delay != 500*time.Millisecond {
+		t.Fatalf("unexpected throttled peers: %+v", throttled)
+	}
+}
+
+type fakeLedgers map[peer.ID]*decision.Receipt
+
+func (f fakeLedgers) LedgerForPeer(p peer.ID) *decision.Receipt {
+	return f[p]
+}
+
+func TestLimiterReciprocity(t *testing.T) {
+	if NewLimiter(RateLimits{}) != nil {
+		t.Fatal("expected no limiter without limits")
+	}
+
+	for _, tc := range []struct {
+		sent, recv uint64
+		want       float64
+	}{
+		{0, 0, 1},
+		{100, 0, 1},
+		{99, 100, 2},
+		{0, 1 << 20, maxWeight},
+	} {
+		if w := ledgerWeight(tc.sent, tc.recv); w != tc.want {
+			t.Errorf("sent %d, recv %d: expected weight %v, got %v", tc.sent, tc.recv, tc.want, w)
+		}
+	}
+
+	now := time.Now()
+	l := NewLimiter(RateLimits{WantsPerSecond: 10, Reciprocity: true})
+	l.now = func() time.Time { return now }
+	l.AllowWants("a", 0)
+	l.refreshWeights(fakeLedgers{"a": {Sent: 99, Recv: 100}})
+
+	if n := l.AllowWants("a", 30); n != 20 {
+		t.Fatalf("expected weighted limit of 20 wants, got %d", n)
+	}
+}
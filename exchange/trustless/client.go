@@ -0,0 +1,204 @@
+// Package trustless fetches raw blocks from trustless HTTP gateways, verifying
+// them against their CID.
+package trustless
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log"
+)
+
+var log = logging.Logger("exchange/trustless")
+
+const (
+	// maxBlockSize is the largest block accepted from a gateway.
+	maxBlockSize = 4 << 20
+
+	// latencyDecay is the weight of a new sample in the latency average.
+	latencyDecay = 0.2
+)
+
+// ErrVerification is returned when a gateway returns data not matching the
+// requested CID.
+var ErrVerification = errors.New("block returned by gateway does not match its cid")
+
+// EndpointStat reports how a gateway performed.
+type EndpointStat struct {
+	URL            string
+	Requests       uint64
+	Errors         uint64
+	BytesReceived  uint64
+	AverageLatency time.Duration
+	LastError      string `json:",omitempty"`
+}
+
+type endpoint struct {
+	url string
+
+	requests      uint64
+	errors        uint64
+	failures      int // consecutive
+	bytesReceived uint64
+	latency       time.Duration
+	lastError     string
+}
+
+// Client fetches blocks from a list of gateways, trying the best performing
+// ones first.
+type Client struct {
+	http *http.Client
+
+	mu        sync.Mutex
+	endpoints []*endpoint
+}
+
+// NewClient returns a client fetching from the gateways at the given base
+// URLs, each request timing out after timeout.
+func NewClient(urls []string, timeout time.Duration) *Client {
+	c := &Client{
+		http: &http.Client{Timeout: timeout},
+	}
+	for _, u := range urls {
+		c.endpoints = append(c.endpoints, &endpoint{url: strings.TrimSuffix(u, "/")})
+	}
+	return c
+}
+
+// ranked returns the endpoints ordered by consecutive failures, then latency.
+func (c *Client) ranked() []*endpoint {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	eps := append([]*endpoint(nil), c.endpoints...)
+	sort.SliceStable(eps, func(i, j int) bool {
+		if eps[i].failures != eps[j].failures {
+			return eps[i].failures < eps[j].failures
+		}
+		return eps[i].latency < eps[j].latency
+	})
+	return eps
+}
+
+// GetBlock fetches the block k, trying every gateway until one returns it.
+func (c *Client) GetBlock(ctx context.Context, k cid.Cid) (blocks.Block, error) {
+	var (
+		errs    []string
+		lastErr error
+	)
+	for _, ep := range c.ranked() {
+		blk, err := c.fetch(ctx, ep, k)
+		if err == nil {
+			return blk, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if lastErr != nil {
+			errs = append(errs, lastErr.Error()+"; ")
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		return nil, fmt.Errorf("fetching %s: no gateways configured", k)
+	}
+	// keep the last error wrapped, so callers can tell verification failures
+	return nil, fmt.Errorf("fetching %s from gateways: %s%w", k, strings.Join(errs, ""), lastErr)
+}
+
+func (c *Client) fetch(ctx context.Context, ep *endpoint, k cid.Cid) (blocks.Block, error) {
+	start := time.Now()
+	data, err := c.request(ctx, ep.url, k)
+	if err == nil {
+		err = verify(k, data)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ep.requests++
+	if err != nil {
+		// a canceled request says nothing about the gateway
+		if ctx.Err() == nil {
+			ep.errors++
+			ep.failures++
+			ep.lastError = err.Error()
+		}
+		return nil, fmt.Errorf("%s: %w", ep.url, err)
+	}
+	ep.failures = 0
+	ep.bytesReceived += uint64(len(data))
+	latency := time.Since(start)
+	if ep.latency == 0 {
+		ep.latency = latency
+	} else {
+		ep.latency = time.Duration(float64(ep.latency)*(1-latencyDecay) + float64(latency)*latencyDecay)
+	}
+
+	return blocks.NewBlockWithCid(data, k)
+}
+
+func (c *Client) request(ctx context.Context, base string, k cid.Cid) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/ipfs/"+k.String()+"?format=raw", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.ipld.raw")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBlockSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxBlockSize {
+		return nil, fmt.Errorf("block is larger than %d bytes", maxBlockSize)
+	}
+	return data, nil
+}
+
+func verify(k cid.Cid, data []byte) error {
+	sum, err := k.Prefix().Sum(data)
+	if err != nil {
+		return err
+	}
+	if !sum.Equals(k) {
+		return ErrVerification
+	}
+	return nil
+}
+
+// Stats returns the statistics of every gateway.
+func (c *Client) Stats() []EndpointStat {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	out := make([]EndpointStat, 0, len(c.endpoints))
+	for _, ep := range c.endpoints {
+		out = append(out, EndpointStat{
+			URL:            ep.url,
+			Requests:       ep.requests,
+			Errors:         ep.errors,
+			BytesReceived:  ep.bytesReceived,
+			AverageLatency: ep.latency,
+			LastError:      ep.lastError,
+		})
+	}
+	return out
+}
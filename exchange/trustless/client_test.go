@@ -0,0 +1,86 @@
+package trustless
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+)
+
+// gateway serves the given blocks like a trustless gateway.
+func gateway(t *testing.T, blks ...blocks.Block) *httptest.Server {
+	data := map[string][]byte{}
+	for _, b := range blks {
+		data[b.Cid().String()] = b.RawData()
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("format") != "raw" {
+			http.Error(w, "expected format=raw", http.StatusBadRequest)
+			return
+		}
+		d, ok := data[strings.TrimPrefix(r.URL.Path, "/ipfs/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.ipld.raw")
+		_, _ = w.Write(d)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestClientGetBlock(t *testing.T) {
+	blk := blocks.NewBlock([]byte("hello"))
+	srv := gateway(t, blk)
+
+	c := NewClient([]string{srv.URL + "/"}, time.Second)
+	got, err := c.GetBlock(context.Background(), blk.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got.RawData()) != "hello" {
+		t.Fatalf("unexpected block data %q", got.RawData())
+	}
+
+	stats := c.Stats()
+	if len(stats) != 1 || stats[0].URL != srv.URL || stats[0].Requests != 1 || stats[0].Errors != 0 || stats[0].BytesReceived != 5 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	if _, err := c.GetBlock(context.Background(), blocks.NewBlock([]byte("missing")).Cid()); err == nil {
+		t.Fatal("expected missing block to fail")
+	}
+	if s := c.Stats()[0]; s.Errors != 1 || s.LastError == "" {
+		t.Fatalf("expected failure to be recorded, got %+v", s)
+	}
+}
+
+func TestClientVerifies(t *testing.T) {
+	blk := blocks.NewBlock([]byte("hello"))
+	evil := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("not hello"))
+	}))
+	defer evil.Close()
+
+	c := NewClient([]string{evil.URL}, time.Second)
+	_, err := c.GetBlock(context.Background(), blk.Cid())
+	if !errors.Is(err, ErrVerification) {
+		t.Fatalf("expected verification error, got %v", err)
+	}
+
+	// the honest gateway is tried next, and preferred afterwards
+	honest := gateway(t, blk)
+	c = NewClient([]string{evil.URL, honest.URL}, time.Second)
+	if _, err := c.GetBlock(context.Background(), blk.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	if ranked := c.ranked(); ranked[0].url != honest.URL {
+		t.Fatalf("expected failing gateway to be ranked last, got %s first", ranked[0].url)
+	}
+}
@@ -0,0 +1,261 @@
+package trustless
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	exchange "github.com/ipfs/go-ipfs-exchange-interface"
+)
+
+// Exchange fetches blocks from both a wrapped exchange, usually bitswap, and
+// trustless gateways. The gateways are queried once the wrapped exchange
+// didn't find a block within the fallback delay, or right away when the
+// delay is zero, racing it.
+type Exchange struct {
+	exchange.Interface
+
+	client  *Client
+	delay   time.Duration
+	workers int
+}
+
+var _ exchange.SessionExchange = (*Exchange)(nil)
+
+// New returns an exchange fetching blocks from both inner and the gateways
+// of client, with at most workers concurrent gateway requests per call.
+func New(inner exchange.Interface, client *Client, delay time.Duration, workers int) *Exchange {
+	if workers <= 0 {
+		workers = 1
+	}
+	return &Exchange{
+		Interface: inner,
+		client:    client,
+		delay:     delay,
+		workers:   workers,
+	}
+}
+
+// Unwrap returns the wrapped exchange.
+func (e *Exchange) Unwrap() exchange.Interface {
+	return e.Interface
+}
+
+// Client returns the client used to query the gateways.
+func (e *Exchange) Client() *Client {
+	return e.client
+}
+
+func (e *Exchange) GetBlock(ctx context.Context, k cid.Cid) (blocks.Block, error) {
+	return e.getBlock(ctx, e.Interface, k)
+}
+
+func (e *Exchange) GetBlocks(ctx context.Context, keys []cid.Cid) (<-chan blocks.Block, error) {
+	return e.getBlocks(ctx, e.Interface, keys), nil
+}
+
+// NewSession returns a fetcher using a session of the wrapped exchange, when
+// it supports them.
+func (e *Exchange) NewSession(ctx context.Context) exchange.Fetcher {
+	var inner exchange.Fetcher = e.Interface
+	if se, ok := e.Interface.(exchange.SessionExchange); ok {
+		inner = se.NewSession(ctx)
+	}
+	return &session{exchange: e, inner: inner}
+}
+
+type session struct {
+	exchange *Exchange
+	inner    exchange.Fetcher
+}
+
+func (s *session) GetBlock(ctx context.Context, k cid.Cid) (blocks.Block, error) {
+	return s.exchange.getBlock(ctx, s.inner, k)
+}
+
+func (s *session) GetBlocks(ctx context.Context, keys []cid.Cid) (<-chan blocks.Block, error) {
+	return s.exchange.getBlocks(ctx, s.inner, keys), nil
+}
+
+// wait waits for the fallback delay, or until skip is closed. It returns false
+// if ctx is canceled first.
+func (e *Exchange) wait(ctx context.Context, skip <-chan struct{}) bool {
+	if e.delay <= 0 {
+		return true
+	}
+	t := time.NewTimer(e.delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-skip:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (e *Exchange) getBlock(ctx context.Context, inner exchange.Fetcher, k cid.Cid) (blocks.Block, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		blk blocks.Block
+		err error
+	}
+	results := make(chan result, 2)
+	innerFailed := make(chan struct{})
+
+	go func() {
+		blk, err := inner.GetBlock(ctx, k)
+		if err != nil {
+			close(innerFailed)
+		}
+		results <- result{blk, err}
+	}()
+	go func() {
+		if !e.wait(ctx, innerFailed) {
+			results <- result{err: ctx.Err()}
+			return
+		}
+		blk, err := e.client.GetBlock(ctx, k)
+		results <- result{blk, err}
+	}()
+
+	var errs []error
+	for i := 0; i < 2; i++ {
+		r := <-results
+		if r.err == nil {
+			return r.blk, nil
+		}
+		errs = append(errs, r.err)
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return nil, fmt.Errorf("%s; %s", errs[0], errs[1])
+}
+
+// cidSet is a set of cids safe for concurrent use.
+type cidSet struct {
+	mu  sync.Mutex
+	set *cid.Set
+}
+
+func (s *cidSet) add(c cid.Cid) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.set.Add(c)
+}
+
+func (s *cidSet) has(c cid.Cid) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.set.Has(c)
+}
+
+func (e *Exchange) getBlocks(ctx context.Context, inner exchange.Fetcher, keys []cid.Cid) <-chan blocks.Block {
+	out := make(chan blocks.Block)
+
+	go func() {
+		defer close(out)
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		results := make(chan blocks.Block)
+		received := &cidSet{set: cid.NewSet()}
+		var wg sync.WaitGroup
+
+		innerFailed := make(chan struct{})
+		if ch, err := inner.GetBlocks(ctx, keys); err != nil {
+			log.Debugf("fetching only from gateways: %s", err)
+			close(innerFailed)
+		} else {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for blk := range ch {
+					select {
+					case results <- blk:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}()
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if e.wait(ctx, innerFailed) {
+				e.fetchAll(ctx, keys, received, results)
+			}
+		}()
+
+		go func() {
+			wg.Wait()
+			close(results)
+		}()
+
+		pending := cid.NewSet()
+		for _, k := range keys {
+			pending.Add(k)
+		}
+		for blk := range results {
+			if !pending.Has(blk.Cid()) {
+				continue // received from both
+			}
+			pending.Remove(blk.Cid())
+			received.add(blk.Cid())
+
+			select {
+			case out <- blk:
+			case <-ctx.Done():
+				return
+			}
+			if pending.Len() == 0 {
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+// fetchAll fetches the keys not received yet from the gateways.
+func (e *Exchange) fetchAll(ctx context.Context, keys []cid.Cid, received *cidSet, results chan<- blocks.Block) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	sem := make(chan struct{}, e.workers)
+	for _, k := range keys {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		if received.has(k) {
+			<-sem
+			continue
+		}
+
+		wg.Add(1)
+		go func(k cid.Cid) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			blk, err := e.client.GetBlock(ctx, k)
+			if err != nil {
+				log.Debug(err)
+				return
+			}
+			select {
+			case results <- blk:
+			case <-ctx.Done():
+			}
+		}(k)
+	}
+}
@@ -0,0 +1,120 @@
+package trustless
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	exchange "github.com/ipfs/go-ipfs-exchange-interface"
+)
+
+// fakeExchange returns the blocks it holds, and never finds the others.
+type fakeExchange struct {
+	exchange.Interface
+	blocks map[cid.Cid]blocks.Block
+	err    error
+}
+
+func (f *fakeExchange) GetBlock(ctx context.Context, k cid.Cid) (blocks.Block, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	if b, ok := f.blocks[k]; ok {
+		return b, nil
+	}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (f *fakeExchange) GetBlocks(ctx context.Context, keys []cid.Cid) (<-chan blocks.Block, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	out := make(chan blocks.Block)
+	go func() {
+		defer close(out)
+		for _, k := range keys {
+			if b, ok := f.blocks[k]; ok {
+				select {
+				case out <- b:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+		<-ctx.Done()
+	}()
+	return out, nil
+}
+
+func TestExchangeGetBlocks(t *testing.T) {
+	a, b, c := blocks.NewBlock([]byte("a")), blocks.NewBlock([]byte("b")), blocks.NewBlock([]byte("c"))
+	inner := &fakeExchange{blocks: map[cid.Cid]blocks.Block{a.Cid(): a, b.Cid(): b}}
+	srv := gateway(t, b, c)
+
+	ex := New(inner, NewClient([]string{srv.URL}, time.Second), 0, 2)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ch, err := ex.GetBlocks(ctx, []cid.Cid{a.Cid(), b.Cid(), c.Cid()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[cid.Cid]int{}
+	for blk := range ch {
+		got[blk.Cid()]++
+	}
+	if ctx.Err() != nil {
+		t.Fatal("expected the channel to close once every block was received")
+	}
+	for _, blk := range []blocks.Block{a, b, c} {
+		if got[blk.Cid()] != 1 {
+			t.Fatalf("expected %s once, got it %d times", blk.Cid(), got[blk.Cid()])
+		}
+	}
+}
+
+func TestExchangeFallbackDelay(t *testing.T) {
+	a := blocks.NewBlock([]byte("a"))
+	inner := &fakeExchange{blocks: map[cid.Cid]blocks.Block{a.Cid(): a}}
+	srv := gateway(t, a)
+
+	client := NewClient([]string{srv.URL}, time.Second)
+	ex := New(inner, client, time.Minute, 1)
+	if _, err := ex.GetBlock(context.Background(), a.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	if s := client.Stats()[0]; s.Requests != 0 {
+		t.Fatalf("expected the gateway not to be asked before the delay, got %d requests", s.Requests)
+	}
+}
+
+func TestExchangeInnerFailure(t *testing.T) {
+	a := blocks.NewBlock([]byte("a"))
+	inner := &fakeExchange{err: errors.New("client disabled")}
+	srv := gateway(t, a)
+
+	// the delay is skipped when the inner exchange can't fetch at all
+	ex := New(inner, NewClient([]string{srv.URL}, time.Second), time.Minute, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := ex.GetBlock(ctx, a.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	ch, err := ex.NewSession(ctx).GetBlocks(ctx, []cid.Cid{a.Cid()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blk, ok := <-ch; !ok || !blk.Cid().Equals(a.Cid()) {
+		t.Fatal("expected block from the gateway")
+	}
+
+	_, err = ex.GetBlock(ctx, blocks.NewBlock([]byte("missing")).Cid())
+	if err == nil || ctx.Err() != nil {
+		t.Fatalf("expected both failures to be reported, got %v", err)
+	}
+}
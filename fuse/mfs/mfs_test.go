@@ -0,0 +1,273 @@
+//go:build !nofuse && !openbsd && !netbsd && !plan9
+// +build !nofuse,!openbsd,!netbsd,!plan9
+
+package mfs
+
+import (
+	"context"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	fuse "bazil.org/fuse"
+	fs "bazil.org/fuse/fs"
+	cid "github.com/ipfs/go-cid"
+	mdtest "github.com/ipfs/go-merkledag/test"
+	mfs "github.com/ipfs/go-mfs"
+	ft "github.com/ipfs/go-unixfs"
+	"github.com/stretchr/testify/require"
+)
+
+func setupMfsTest(t *testing.T) (*FileSystem, *mfs.Root, *Directory) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	pf := func(context.Context, cid.Cid) error { return nil }
+	root, err := mfs.NewRoot(ctx, mdtest.Mock(), ft.EmptyDirNode(), pf)
+	require.NoError(t, err)
+
+	fsys := NewFileSystem(root)
+	rootDir, err := fsys.Root()
+	require.NoError(t, err)
+	return fsys, root, rootDir.(*Directory)
+}
+
+func create(t *testing.T, dir *Directory, name string) (*FileNode, *File) {
+	t.Helper()
+	n, h, err := dir.Create(context.Background(), &fuse.CreateRequest{
+		Name:  name,
+		Flags: fuse.OpenReadWrite,
+		Mode:  0666,
+		Umask: 0022,
+	}, &fuse.CreateResponse{})
+	require.NoError(t, err)
+	return n.(*FileNode), h.(*File)
+}
+
+func write(t *testing.T, h *File, off int64, data string) {
+	t.Helper()
+	var resp fuse.WriteResponse
+	require.NoError(t, h.Write(context.Background(), &fuse.WriteRequest{Offset: off, Data: []byte(data)}, &resp))
+	require.Equal(t, len(data), resp.Size)
+}
+
+func read(t *testing.T, h *File, off int64, size int) string {
+	t.Helper()
+	resp := fuse.ReadResponse{Data: make([]byte, size)}
+	require.NoError(t, h.Read(context.Background(), &fuse.ReadRequest{Offset: off, Size: size}, &resp))
+	return string(resp.Data)
+}
+
+func closeHandle(t *testing.T, h *File) {
+	t.Helper()
+	require.NoError(t, h.Flush(context.Background(), &fuse.FlushRequest{}))
+	require.NoError(t, h.Release(context.Background(), &fuse.ReleaseRequest{}))
+}
+
+func readMfs(t *testing.T, root *mfs.Root, p string) string {
+	t.Helper()
+	fsn, err := mfs.Lookup(root, p)
+	require.NoError(t, err)
+	fd, err := fsn.(*mfs.File).Open(mfs.Flags{Read: true})
+	require.NoError(t, err)
+	defer fd.Close()
+	data, err := io.ReadAll(fd)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func lookup(t *testing.T, dir *Directory, name string) fs.Node {
+	t.Helper()
+	n, err := dir.Lookup(context.Background(), name)
+	require.NoError(t, err)
+	return n
+}
+
+func TestMfsReadWrite(t *testing.T) {
+	_, root, dir := setupMfsTest(t)
+	ctx := context.Background()
+
+	fi, h := create(t, dir, "a")
+	write(t, h, 0, "hello world")
+	require.Equal(t, "world", read(t, h, 6, 100))
+
+	// a second handle shares the pending writes
+	h2, err := fi.Open(ctx, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+	require.NoError(t, err)
+	require.Equal(t, "hello", read(t, h2.(*File), 0, 5))
+	closeHandle(t, h2.(*File))
+
+	write(t, h, 11, "!")
+	closeHandle(t, h)
+	require.Equal(t, "hello world!", readMfs(t, root, "/a"))
+
+	// truncate on open
+	h2, err = fi.Open(ctx, &fuse.OpenRequest{Flags: fuse.OpenWriteOnly | fuse.OpenTruncate}, &fuse.OpenResponse{})
+	require.NoError(t, err)
+	write(t, h2.(*File), 0, "bye")
+	closeHandle(t, h2.(*File))
+	require.Equal(t, "bye", readMfs(t, root, "/a"))
+
+	var attr fuse.Attr
+	require.NoError(t, lookup(t, dir, "a").Attr(ctx, &attr))
+	require.Equal(t, uint64(3), attr.Size)
+	require.Equal(t, os.FileMode(0644), attr.Mode)
+}
+
+func TestMfsSetattr(t *testing.T) {
+	_, root, dir := setupMfsTest(t)
+	ctx := context.Background()
+
+	fi, h := create(t, dir, "a")
+	write(t, h, 0, "0123456789")
+	closeHandle(t, h)
+
+	var resp fuse.SetattrResponse
+	require.NoError(t, fi.Setattr(ctx, &fuse.SetattrRequest{
+		Valid: fuse.SetattrSize,
+		Size:  4,
+	}, &resp))
+	require.Equal(t, uint64(4), resp.Attr.Size)
+	require.Equal(t, os.FileMode(0644), resp.Attr.Mode)
+	require.Equal(t, "0123", readMfs(t, root, "/a"))
+
+	// UnixFS does not record modes and modification times
+	err := fi.Setattr(ctx, &fuse.SetattrRequest{
+		Valid: fuse.SetattrSize | fuse.SetattrMode,
+		Size:  2,
+		Mode:  0600,
+	}, &resp)
+	require.Equal(t, fuse.ENOTSUP, err)
+	require.Equal(t, "0123", readMfs(t, root, "/a"))
+	err = fi.Setattr(ctx, &fuse.SetattrRequest{
+		Valid: fuse.SetattrMtime,
+		Mtime: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+	}, &resp)
+	require.Equal(t, fuse.ENOTSUP, err)
+
+	d, err := dir.Mkdir(ctx, &fuse.MkdirRequest{Name: "d", Mode: os.ModeDir | 0777, Umask: 0027})
+	require.NoError(t, err)
+	var attr fuse.Attr
+	require.NoError(t, d.Attr(ctx, &attr))
+	require.Equal(t, os.ModeDir|0755, attr.Mode)
+	err = d.(*Directory).Setattr(ctx, &fuse.SetattrRequest{Valid: fuse.SetattrMode, Mode: os.ModeDir | 0700}, &resp)
+	require.Equal(t, fuse.ENOTSUP, err)
+}
+
+func TestMfsRename(t *testing.T) {
+	_, root, dir := setupMfsTest(t)
+	ctx := context.Background()
+
+	d, err := dir.Mkdir(ctx, &fuse.MkdirRequest{Name: "d", Mode: os.ModeDir | 0755})
+	require.NoError(t, err)
+	sub := d.(*Directory)
+
+	// an open file keeps working after a move of its parent
+	fi, h := create(t, sub, "a")
+	write(t, h, 0, "one")
+	require.NoError(t, dir.Rename(ctx, &fuse.RenameRequest{OldName: "d", NewName: "e"}, dir))
+	write(t, h, 3, "two")
+	closeHandle(t, h)
+	require.Equal(t, "onetwo", readMfs(t, root, "/e/a"))
+	require.Same(t, fi, lookup(t, sub, "a"))
+	_, err = mfs.Lookup(root, "/d")
+	require.Error(t, err)
+
+	// moving a file replaces the target
+	_, h = create(t, dir, "b")
+	write(t, h, 0, "other")
+	closeHandle(t, h)
+	require.NoError(t, dir.Rename(ctx, &fuse.RenameRequest{OldName: "b", NewName: "a"}, sub))
+	require.Equal(t, "other", readMfs(t, root, "/e/a"))
+
+	// but not a directory with a file, or a directory into itself
+	_, h = create(t, dir, "c")
+	closeHandle(t, h)
+	err = dir.Rename(ctx, &fuse.RenameRequest{OldName: "e", NewName: "c"}, dir)
+	require.Equal(t, fuse.Errno(syscall.ENOTDIR), err)
+	err = dir.Rename(ctx, &fuse.RenameRequest{OldName: "e", NewName: "f"}, sub)
+	require.Equal(t, fuse.Errno(syscall.EINVAL), err)
+}
+
+func TestMfsRemove(t *testing.T) {
+	_, root, dir := setupMfsTest(t)
+	ctx := context.Background()
+
+	d, err := dir.Mkdir(ctx, &fuse.MkdirRequest{Name: "d", Mode: os.ModeDir | 0755})
+	require.NoError(t, err)
+	_, h := create(t, d.(*Directory), "a")
+	closeHandle(t, h)
+
+	require.Equal(t, fuse.Errno(syscall.EISDIR), dir.Remove(ctx, &fuse.RemoveRequest{Name: "d"}))
+	require.Equal(t, fuse.Errno(syscall.ENOTEMPTY), dir.Remove(ctx, &fuse.RemoveRequest{Name: "d", Dir: true}))
+	require.Equal(t, fuse.Errno(syscall.ENOTDIR), d.(*Directory).Remove(ctx, &fuse.RemoveRequest{Name: "a", Dir: true}))
+	require.NoError(t, d.(*Directory).Remove(ctx, &fuse.RemoveRequest{Name: "a"}))
+	require.NoError(t, dir.Remove(ctx, &fuse.RemoveRequest{Name: "d", Dir: true}))
+
+	// writes to an unlinked open file do not bring it back
+	_, h = create(t, dir, "b")
+	require.NoError(t, dir.Remove(ctx, &fuse.RemoveRequest{Name: "b"}))
+	write(t, h, 0, "gone")
+	closeHandle(t, h)
+	names, err := root.GetDirectory().ListNames(ctx)
+	require.NoError(t, err)
+	require.Empty(t, names)
+}
+
+func TestMfsFsync(t *testing.T) {
+	_, root, dir := setupMfsTest(t)
+	ctx := context.Background()
+
+	before, err := root.GetDirectory().GetNode()
+	require.NoError(t, err)
+
+	fi, h := create(t, dir, "a")
+	write(t, h, 0, "data")
+	require.NoError(t, fi.Fsync(ctx, &fuse.FsyncRequest{}))
+
+	// the file is still open, and cannot be read through MFS
+	fsn, err := mfs.Lookup(root, "/a")
+	require.NoError(t, err)
+	size, err := fsn.(*mfs.File).Size()
+	require.NoError(t, err)
+	require.Equal(t, int64(4), size)
+
+	after, err := root.GetDirectory().GetNode()
+	require.NoError(t, err)
+	require.NotEqual(t, before.Cid(), after.Cid())
+	closeHandle(t, h)
+}
+
+func TestMfsReadOnlyOpen(t *testing.T) {
+	_, root, dir := setupMfsTest(t)
+	ctx := context.Background()
+
+	fi, h := create(t, dir, "a")
+	write(t, h, 0, "hello")
+	closeHandle(t, h)
+
+	// read-only handles don't lock other readers out of the file
+	h2, err := fi.Open(ctx, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+	require.NoError(t, err)
+	done := make(chan string)
+	go func() { done <- readMfs(t, root, "/a") }()
+	select {
+	case data := <-done:
+		require.Equal(t, "hello", data)
+	case <-time.After(5 * time.Second):
+		t.Fatal("reading the file blocked on a read-only handle")
+	}
+
+	// handles opened for writing only take the write descriptor on write
+	h3, err := fi.Open(ctx, &fuse.OpenRequest{Flags: fuse.OpenReadWrite}, &fuse.OpenResponse{})
+	require.NoError(t, err)
+	require.Equal(t, "hello", readMfs(t, root, "/a"))
+	write(t, h3.(*File), 5, " world")
+	require.Equal(t, "hello world", read(t, h2.(*File), 0, 100))
+	closeHandle(t, h3.(*File))
+	closeHandle(t, h2.(*File))
+	require.Equal(t, "hello world", readMfs(t, root, "/a"))
+}
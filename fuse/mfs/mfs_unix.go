@@ -0,0 +1,827 @@
+//go:build !nofuse && !openbsd && !netbsd && !plan9
+// +build !nofuse,!openbsd,!netbsd,!plan9
+
+// package fuse/mfs implements a read-write fuse filesystem of the node's
+// mutable file system, the tree 'ipfs files' works on.
+package mfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	fuse "bazil.org/fuse"
+	fs "bazil.org/fuse/fs"
+	logging "github.com/ipfs/go-log"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+	mfs "github.com/ipfs/go-mfs"
+	ft "github.com/ipfs/go-unixfs"
+)
+
+var log = logging.Logger("fuse/mfs")
+
+// Permissions of the entries. UnixFS does not record modes and modification
+// times, changing them fails with ENOTSUP.
+const (
+	defaultDirMode  os.FileMode = 0755
+	defaultFileMode os.FileMode = 0644
+)
+
+// node is a directory or a file handed to the kernel.
+type node interface {
+	fs.Node
+
+	// entry returns the MFS entry of the node. The filesystem lock must be
+	// held.
+	entry() mfs.FSNode
+	// detach stops using the MFS entry of the node before it moves, flushing
+	// the pending writes. The filesystem lock must be held.
+	detach() error
+	// rebind points the node to the entry at p after a move. The filesystem
+	// lock must be held.
+	rebind(p string, fsn mfs.FSNode) error
+	// unlink marks the node as removed from the tree. The filesystem lock
+	// must be held.
+	unlink()
+}
+
+// FileSystem is the read-write MFS fuse filesystem.
+type FileSystem struct {
+	root     *mfs.Root
+	uid, gid uint32
+	start    time.Time
+
+	// mu serializes the changes to the tree, and guards nodes and the
+	// directories
+	mu    sync.Mutex
+	nodes map[string]node // by path
+}
+
+// NewFileSystem constructs a filesystem of the given MFS root.
+func NewFileSystem(root *mfs.Root) *FileSystem {
+	return &FileSystem{
+		root:  root,
+		uid:   uint32(os.Getuid()),
+		gid:   uint32(os.Getgid()),
+		start: time.Now(),
+		nodes: make(map[string]node),
+	}
+}
+
+// Root returns the root directory of the filesystem.
+func (f *FileSystem) Root() (fs.Node, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.node("/", f.root.GetDirectory())
+}
+
+// Destroy flushes the tree when the filesystem is unmounted.
+func (f *FileSystem) Destroy() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.root.GetDirectory().Flush(); err != nil {
+		log.Errorf("flushing MFS on unmount: %s", err)
+	}
+}
+
+// node returns the node of the entry fsn at p. The filesystem lock must be
+// held.
+func (f *FileSystem) node(p string, fsn mfs.FSNode) (node, error) {
+	if n, ok := f.nodes[p]; ok && n.entry() == fsn {
+		return n, nil
+	}
+
+	var n node
+	switch fsn := fsn.(type) {
+	case *mfs.Directory:
+		n = &Directory{fs: f, path: p, dir: fsn}
+	case *mfs.File:
+		n = &FileNode{fs: f, path: p, fi: fsn}
+	default:
+		return nil, fuse.EIO
+	}
+	f.nodes[p] = n
+	return n, nil
+}
+
+// forget drops n from the known nodes. The filesystem lock must be held.
+func (f *FileSystem) forget(p string, n node) {
+	if f.nodes[p] == n {
+		delete(f.nodes, p)
+	}
+}
+
+// subtree returns the known nodes at p and below. The filesystem lock must be
+// held.
+func (f *FileSystem) subtree(p string) map[string]node {
+	out := make(map[string]node)
+	for np, n := range f.nodes {
+		if np == p || strings.HasPrefix(np, p+"/") {
+			out[np] = n
+		}
+	}
+	return out
+}
+
+// unlinkPath forgets the nodes at p and below, after their removal from the
+// tree. The filesystem lock must be held.
+func (f *FileSystem) unlinkPath(p string) {
+	for np, n := range f.subtree(p) {
+		n.unlink()
+		delete(f.nodes, np)
+	}
+}
+
+// move moves the nodes at from and below to to, after the move of the entry
+// in the tree. The filesystem lock must be held.
+func (f *FileSystem) move(moved map[string]node, from, to string) {
+	for np, n := range moved {
+		f.forget(np, n)
+	}
+	for np, n := range moved {
+		p := to + strings.TrimPrefix(np, from)
+		fsn, err := mfs.Lookup(f.root, p)
+		if err == nil {
+			err = n.rebind(p, fsn)
+		}
+		if err != nil {
+			log.Errorf("rebinding %s after a move: %s", p, err)
+			n.unlink()
+			continue
+		}
+		f.nodes[p] = n
+	}
+}
+
+// fillAttr sets the attributes of an entry with the given mode to a.
+func (f *FileSystem) fillAttr(mode os.FileMode, a *fuse.Attr) {
+	a.Mode |= mode
+	a.Mtime = f.start
+	a.Ctime = a.Mtime
+	a.Atime = a.Mtime
+	a.Uid = f.uid
+	a.Gid = f.gid
+}
+
+// checkSetattr rejects the changes of req to the attributes UnixFS does not
+// record.
+func checkSetattr(req *fuse.SetattrRequest) error {
+	if req.Valid.Mode() || req.Valid.Mtime() || req.Valid.MtimeNow() {
+		return fuse.ENOTSUP
+	}
+	return nil
+}
+
+// Directory is a wrapper over an mfs directory to satisfy the fuse fs
+// interface.
+type Directory struct {
+	fs *FileSystem
+
+	// guarded by the filesystem lock
+	path string
+	dir  *mfs.Directory
+}
+
+func (d *Directory) entry() mfs.FSNode { return d.dir }
+
+func (d *Directory) detach() error { return nil }
+
+func (d *Directory) rebind(p string, fsn mfs.FSNode) error {
+	dir, ok := fsn.(*mfs.Directory)
+	if !ok {
+		return errors.New("not a directory")
+	}
+	d.path, d.dir = p, dir
+	return nil
+}
+
+func (d *Directory) unlink() {}
+
+// Attr returns the attributes of the directory.
+func (d *Directory) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Mode = os.ModeDir
+	d.fs.fillAttr(defaultDirMode, a)
+	return nil
+}
+
+// Setattr fails to change the mode or the modification time of the
+// directory, which UnixFS does not record.
+func (d *Directory) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
+	if err := checkSetattr(req); err != nil {
+		return err
+	}
+	return d.Attr(ctx, &resp.Attr)
+}
+
+// Lookup performs a lookup under this directory.
+func (d *Directory) Lookup(ctx context.Context, name string) (fs.Node, error) {
+	d.fs.mu.Lock()
+	defer d.fs.mu.Unlock()
+
+	child, err := d.dir.Child(name)
+	if err != nil {
+		return nil, fuse.ENOENT
+	}
+	return d.fs.node(path.Join(d.path, name), child)
+}
+
+// ReadDirAll lists the entries of the directory.
+func (d *Directory) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	d.fs.mu.Lock()
+	defer d.fs.mu.Unlock()
+
+	listing, err := d.dir.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]fuse.Dirent, len(listing))
+	for i, entry := range listing {
+		entries[i].Name = entry.Name
+		switch mfs.NodeType(entry.Type) {
+		case mfs.TDir:
+			entries[i].Type = fuse.DT_Dir
+		case mfs.TFile:
+			entries[i].Type = fuse.DT_File
+		}
+	}
+	return entries, nil
+}
+
+// Mkdir creates a directory under this directory.
+func (d *Directory) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (fs.Node, error) {
+	d.fs.mu.Lock()
+	defer d.fs.mu.Unlock()
+
+	child, err := d.dir.Mkdir(req.Name)
+	if err != nil {
+		if os.IsExist(err) {
+			return nil, fuse.EEXIST
+		}
+		return nil, err
+	}
+
+	if err := d.dir.Flush(); err != nil {
+		return nil, err
+	}
+	return d.fs.node(path.Join(d.path, req.Name), child)
+}
+
+// Create creates and opens a file under this directory.
+func (d *Directory) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.CreateResponse) (fs.Node, fs.Handle, error) {
+	d.fs.mu.Lock()
+	defer d.fs.mu.Unlock()
+
+	nd := dag.NodeWithData(ft.FilePBData(nil, 0))
+	nd.SetCidBuilder(d.dir.GetCidBuilder())
+	if err := d.dir.AddChild(req.Name, nd); err != nil {
+		if err == mfs.ErrDirExists {
+			return nil, nil, fuse.EEXIST
+		}
+		return nil, nil, err
+	}
+	child, err := d.dir.Child(req.Name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := d.dir.Flush(); err != nil {
+		return nil, nil, err
+	}
+
+	n, err := d.fs.node(path.Join(d.path, req.Name), child)
+	if err != nil {
+		return nil, nil, err
+	}
+	fi := n.(*FileNode)
+	h, err := fi.open(req.Flags)
+	if err != nil {
+		return nil, nil, err
+	}
+	return fi, h, nil
+}
+
+// Remove removes a file or an empty directory from this directory.
+func (d *Directory) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
+	d.fs.mu.Lock()
+	defer d.fs.mu.Unlock()
+
+	child, err := d.dir.Child(req.Name)
+	if err != nil {
+		return fuse.ENOENT
+	}
+	switch child := child.(type) {
+	case *mfs.Directory:
+		if !req.Dir {
+			return fuse.Errno(syscall.EISDIR)
+		}
+		names, err := child.ListNames(ctx)
+		if err != nil {
+			return err
+		}
+		if len(names) > 0 {
+			return fuse.Errno(syscall.ENOTEMPTY)
+		}
+	case *mfs.File:
+		if req.Dir {
+			return fuse.Errno(syscall.ENOTDIR)
+		}
+	}
+
+	if err := d.dir.Unlink(req.Name); err != nil {
+		return err
+	}
+	d.fs.unlinkPath(path.Join(d.path, req.Name))
+	return d.dir.Flush()
+}
+
+// Rename moves an entry of this directory to newDir, replacing the entry
+// there if any.
+func (d *Directory) Rename(ctx context.Context, req *fuse.RenameRequest, newDir fs.Node) error {
+	d.fs.mu.Lock()
+	defer d.fs.mu.Unlock()
+
+	target, ok := newDir.(*Directory)
+	if !ok {
+		return fuse.EIO
+	}
+	from := path.Join(d.path, req.OldName)
+	to := path.Join(target.path, req.NewName)
+	if from == to {
+		return nil
+	}
+
+	src, err := d.dir.Child(req.OldName)
+	if err != nil {
+		return fuse.ENOENT
+	}
+	_, srcIsDir := src.(*mfs.Directory)
+	if srcIsDir && strings.HasPrefix(to, from+"/") {
+		return fuse.Errno(syscall.EINVAL)
+	}
+
+	dst, err := target.dir.Child(req.NewName)
+	exists := err == nil
+	if exists {
+		switch dst := dst.(type) {
+		case *mfs.Directory:
+			if !srcIsDir {
+				return fuse.Errno(syscall.EISDIR)
+			}
+			names, err := dst.ListNames(ctx)
+			if err != nil {
+				return err
+			}
+			if len(names) > 0 {
+				return fuse.Errno(syscall.ENOTEMPTY)
+			}
+		case *mfs.File:
+			if srcIsDir {
+				return fuse.Errno(syscall.ENOTDIR)
+			}
+		}
+	}
+
+	// flush the open files being moved, so that the node of the entry holds
+	// all their writes
+	moved := d.fs.subtree(from)
+	for _, n := range moved {
+		if err := n.detach(); err != nil {
+			d.fs.move(moved, from, from)
+			return err
+		}
+	}
+	err = d.rename(src, target, req.OldName, req.NewName, exists)
+	if err != nil {
+		d.fs.move(moved, from, from)
+		return err
+	}
+	if exists {
+		d.fs.unlinkPath(to)
+	}
+	d.fs.move(moved, from, to)
+
+	if err := d.dir.Flush(); err != nil {
+		return err
+	}
+	if target.dir != d.dir {
+		return target.dir.Flush()
+	}
+	return nil
+}
+
+// rename links src as newName in target and unlinks oldName. The changes are
+// rolled back on failure, so that the entry is never listed twice.
+func (d *Directory) rename(src mfs.FSNode, target *Directory, oldName, newName string, replace bool) error {
+	nd, err := src.GetNode()
+	if err != nil {
+		return err
+	}
+	var replaced ipld.Node
+	if replace {
+		dst, err := target.dir.Child(newName)
+		if err != nil {
+			return err
+		}
+		if replaced, err = dst.GetNode(); err != nil {
+			return err
+		}
+		if err := target.dir.Unlink(newName); err != nil {
+			return err
+		}
+	}
+	restore := func() {
+		if replaced == nil {
+			return
+		}
+		if err := target.dir.AddChild(newName, replaced); err != nil {
+			log.Errorf("restoring %s after a failed rename: %s", newName, err)
+		}
+	}
+
+	if err := target.dir.AddChild(newName, nd); err != nil {
+		restore()
+		return err
+	}
+	if err := d.dir.Unlink(oldName); err != nil {
+		if uerr := target.dir.Unlink(newName); uerr != nil {
+			log.Errorf("removing %s after a failed rename: %s", newName, uerr)
+		} else {
+			restore()
+		}
+		return err
+	}
+	return nil
+}
+
+// Fsync flushes the directory to the root of the tree.
+func (d *Directory) Fsync(ctx context.Context, req *fuse.FsyncRequest) error {
+	d.fs.mu.Lock()
+	p := d.path
+	d.fs.mu.Unlock()
+
+	_, err := mfs.FlushPath(ctx, d.fs.root, p)
+	return err
+}
+
+// Forget drops the directory when the kernel no longer references it.
+func (d *Directory) Forget() {
+	d.fs.mu.Lock()
+	defer d.fs.mu.Unlock()
+	d.fs.forget(d.path, d)
+}
+
+// FileNode is a wrapper over an mfs file to satisfy the fuse fs interface.
+// The open handles of the file share a single descriptor, as an mfs file
+// cannot be opened twice for writing.
+type FileNode struct {
+	fs *FileSystem
+
+	// guarded by both the filesystem lock and mu
+	path string
+	fi   *mfs.File
+
+	mu       sync.Mutex
+	fd       mfs.FileDescriptor // nil without open handles
+	writable bool               // fd was opened for writing
+	handles  int
+	dirty    bool // written since the last flush
+	unlinked bool
+}
+
+func (fi *FileNode) entry() mfs.FSNode { return fi.fi }
+
+func (fi *FileNode) detach() error {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	if fi.fd == nil {
+		return nil
+	}
+	if err := fi.flush(); err != nil {
+		return err
+	}
+	err := fi.fd.Close()
+	fi.fd, fi.writable = nil, false
+	return err
+}
+
+func (fi *FileNode) rebind(p string, fsn mfs.FSNode) error {
+	file, ok := fsn.(*mfs.File)
+	if !ok {
+		return errors.New("not a file")
+	}
+
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	fi.path, fi.fi = p, file
+	if fi.handles == 0 || fi.fd != nil {
+		return nil
+	}
+	fd, err := file.Open(mfs.Flags{Read: true})
+	if err != nil {
+		return err
+	}
+	fi.fd = fd
+	return nil
+}
+
+// writeFD returns the shared descriptor, reopening it for writing if it was
+// only opened for reading. A writable descriptor excludes any other access to
+// the file, e.g. with 'ipfs files read', so it is only taken on the first
+// write. mu must be held.
+func (fi *FileNode) writeFD() (mfs.FileDescriptor, error) {
+	if fi.writable {
+		return fi.fd, nil
+	}
+	if fi.fd != nil {
+		if err := fi.fd.Close(); err != nil {
+			return nil, err
+		}
+		fi.fd = nil
+	}
+	fd, err := fi.fi.Open(mfs.Flags{Read: true, Write: true})
+	if err != nil {
+		// keep a descriptor for the remaining handles to read from
+		fi.fd, _ = fi.fi.Open(mfs.Flags{Read: true})
+		return nil, err
+	}
+	fi.fd, fi.writable = fd, true
+	return fd, nil
+}
+
+func (fi *FileNode) unlink() {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	fi.unlinked = true
+}
+
+// flush writes the pending changes of the file to the tree. mu must be held.
+func (fi *FileNode) flush() error {
+	if !fi.dirty || fi.unlinked {
+		return nil
+	}
+	if err := fi.fd.Flush(); err != nil {
+		return err
+	}
+	fi.dirty = false
+	return nil
+}
+
+// Attr returns the attributes of the file.
+func (fi *FileNode) Attr(ctx context.Context, a *fuse.Attr) error {
+	fi.mu.Lock()
+	var size int64
+	var err error
+	if fi.fd != nil {
+		size, err = fi.fd.Size()
+	} else {
+		size, err = fi.fi.Size()
+	}
+	fi.mu.Unlock()
+	if err != nil {
+		// In this case, the dag node in question may not be unixfs
+		return err
+	}
+
+	a.Size = uint64(size)
+	a.Blocks = (a.Size + 511) / 512
+	fi.fs.fillAttr(defaultFileMode, a)
+	return nil
+}
+
+// Setattr truncates the file. Its mode and modification time cannot be
+// changed, UnixFS does not record them.
+func (fi *FileNode) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
+	if err := checkSetattr(req); err != nil {
+		return err
+	}
+	if req.Valid.Size() {
+		if err := fi.truncate(int64(req.Size)); err != nil {
+			return err
+		}
+	}
+	return fi.Attr(ctx, &resp.Attr)
+}
+
+func (fi *FileNode) truncate(size int64) error {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+
+	var fd mfs.FileDescriptor
+	var err error
+	if fi.fd != nil {
+		fd, err = fi.writeFD()
+	} else {
+		fd, err = fi.fi.Open(mfs.Flags{Write: true, Sync: !fi.unlinked})
+		if err == nil {
+			defer fd.Close()
+		}
+	}
+	if err != nil {
+		return err
+	}
+	cur, err := fd.Size()
+	if err != nil {
+		return err
+	}
+	if cur != size {
+		if err := fd.Truncate(size); err != nil {
+			return err
+		}
+		fi.dirty = fi.fd != nil
+	}
+	return nil
+}
+
+// Open opens the file, truncating it with O_TRUNC.
+func (fi *FileNode) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	return fi.open(req.Flags)
+}
+
+func (fi *FileNode) open(flags fuse.OpenFlags) (*File, error) {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+
+	if fi.fd == nil {
+		fd, err := fi.fi.Open(mfs.Flags{Read: true})
+		if err != nil {
+			return nil, err
+		}
+		fi.fd = fd
+	}
+	fi.handles++
+	h := &File{node: fi}
+
+	if flags&fuse.OpenTruncate != 0 && !flags.IsReadOnly() {
+		size, err := fi.fd.Size()
+		if err == nil && size > 0 {
+			var fd mfs.FileDescriptor
+			if fd, err = fi.writeFD(); err == nil {
+				err = fd.Truncate(0)
+				fi.dirty = true
+			}
+		}
+		if err != nil {
+			_ = fi.release()
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// release closes the shared descriptor after the last handle. mu must be
+// held.
+func (fi *FileNode) release() error {
+	fi.handles--
+	if fi.handles > 0 || fi.fd == nil {
+		return nil
+	}
+	err := fi.flush()
+	if cerr := fi.fd.Close(); err == nil {
+		err = cerr
+	}
+	fi.fd, fi.writable = nil, false
+	return err
+}
+
+// Fsync flushes the file and waits for the root of the tree to be updated.
+func (fi *FileNode) Fsync(ctx context.Context, req *fuse.FsyncRequest) error {
+	fi.mu.Lock()
+	var err error
+	if fi.fd != nil {
+		err = fi.flush()
+	}
+	p, unlinked := fi.path, fi.unlinked
+	fi.mu.Unlock()
+	if err != nil || unlinked {
+		return err
+	}
+
+	_, err = mfs.FlushPath(ctx, fi.fs.root, path.Dir(p))
+	return err
+}
+
+// Forget drops the file when the kernel no longer references it.
+func (fi *FileNode) Forget() {
+	fi.fs.mu.Lock()
+	defer fi.fs.mu.Unlock()
+
+	fi.mu.Lock()
+	open := fi.handles > 0
+	fi.mu.Unlock()
+	if !open {
+		fi.fs.forget(fi.path, fi)
+	}
+}
+
+// File is an open handle of a FileNode.
+type File struct {
+	node *FileNode
+}
+
+// Read reads from the file at the requested offset.
+func (h *File) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	fi := h.node
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+
+	if fi.fd == nil {
+		return fuse.EIO
+	}
+	size, err := fi.fd.Size()
+	if err != nil {
+		return err
+	}
+	if req.Offset >= size {
+		resp.Data = resp.Data[:0]
+		return nil
+	}
+	if _, err := fi.fd.Seek(req.Offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	readsize := req.Size
+	if int64(readsize) > size-req.Offset {
+		readsize = int(size - req.Offset)
+	}
+	n, err := fi.fd.CtxReadFull(ctx, resp.Data[:readsize])
+	resp.Data = resp.Data[:n]
+	return err
+}
+
+// Write writes to the file at the requested offset.
+func (h *File) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
+	fi := h.node
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+
+	fd, err := fi.writeFD()
+	if err != nil {
+		return err
+	}
+	n, err := fd.WriteAt(req.Data, req.Offset)
+	if err != nil {
+		return err
+	}
+	fi.dirty = true
+	resp.Size = n
+	return nil
+}
+
+// Flush writes the pending changes of the file to the tree, on each close
+// of a file descriptor.
+func (h *File) Flush(ctx context.Context, req *fuse.FlushRequest) error {
+	fi := h.node
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	return fi.flush()
+}
+
+// Release closes the handle.
+func (h *File) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	fi := h.node
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	return fi.release()
+}
+
+// to check that our Node implements all the interfaces we want
+type mfsDirectory interface {
+	fs.HandleReadDirAller
+	fs.Node
+	fs.NodeCreater
+	fs.NodeForgetter
+	fs.NodeFsyncer
+	fs.NodeMkdirer
+	fs.NodeRemover
+	fs.NodeRenamer
+	fs.NodeSetattrer
+	fs.NodeStringLookuper
+}
+
+var _ mfsDirectory = (*Directory)(nil)
+
+type mfsFileNode interface {
+	fs.Node
+	fs.NodeForgetter
+	fs.NodeFsyncer
+	fs.NodeOpener
+	fs.NodeSetattrer
+}
+
+type mfsFile interface {
+	fs.HandleFlusher
+	fs.HandleReader
+	fs.HandleReleaser
+	fs.HandleWriter
+}
+
+var _ mfsFileNode = (*FileNode)(nil)
+var _ mfsFile = (*File)(nil)
+var _ fs.FSDestroyer = (*FileSystem)(nil)
@@ -0,0 +1,28 @@
+//go:build (linux || darwin || freebsd || netbsd || openbsd) && !nofuse
+// +build linux darwin freebsd netbsd openbsd
+// +build !nofuse
+
+package mfs
+
+import (
+	"errors"
+
+	core "github.com/ipfs/kubo/core"
+	mount "github.com/ipfs/kubo/fuse/mount"
+)
+
+// Mount mounts the MFS root of the node at a given location, and returns a
+// mount.Mount instance.
+func Mount(ipfs *core.IpfsNode, mountpoint string) (mount.Mount, error) {
+	if ipfs.FilesRoot == nil {
+		return nil, errors.New("the node has no MFS root")
+	}
+
+	cfg, err := ipfs.Repo.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	fsys := NewFileSystem(ipfs.FilesRoot)
+	return mount.NewMount(ipfs.Process, fsys, mountpoint, cfg.Mounts.FuseAllowOther)
+}
@@ -63,7 +63,7 @@ func TestExternalUnmount(t *testing.T) {
 	mkdir(t, ipfsDir)
 	mkdir(t, ipnsDir)
 
-	err = Mount(node, ipfsDir, ipnsDir)
+	err = Mount(node, ipfsDir, ipnsDir, "")
 	if err != nil {
 		if strings.Contains(err.Error(), "unable to check fuse version") || err == fuse.ErrOSXFUSENotFound {
 			t.Skip(err)
@@ -0,0 +1,159 @@
+//go:build !nofuse && !openbsd && !netbsd && !plan9
+// +build !nofuse,!openbsd,!netbsd,!plan9
+
+package readonly
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"bazil.org/fuse"
+
+	coremock "github.com/ipfs/kubo/core/mock"
+
+	chunker "github.com/ipfs/go-ipfs-chunker"
+	dag "github.com/ipfs/go-merkledag"
+	mdtest "github.com/ipfs/go-merkledag/test"
+	ft "github.com/ipfs/go-unixfs"
+	"github.com/ipfs/go-unixfs/importer/balanced"
+	h "github.com/ipfs/go-unixfs/importer/helpers"
+	"github.com/ipfs/go-unixfs/importer/trickle"
+)
+
+func TestNodeCache(t *testing.T) {
+	a := dag.NodeWithData(ft.FolderPBData())
+	b := dag.NodeWithData(ft.FilePBData([]byte("b"), 1))
+	c := dag.NodeWithData(ft.FilePBData([]byte("c"), 1))
+
+	cache := newNodeCache(2)
+	ea, err := cache.add(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ea.direntType() != fuse.DT_Dir {
+		t.Fatal("expected a directory")
+	}
+	if _, err := cache.add(b); err != nil {
+		t.Fatal(err)
+	}
+	// a is now the most recently used, c evicts b
+	if e, ok := cache.get(a.Cid()); !ok || e != ea {
+		t.Fatal("expected a to be cached")
+	}
+	if _, err := cache.add(c); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.get(b.Cid()); ok {
+		t.Fatal("expected b to be evicted")
+	}
+	if _, ok := cache.get(c.Cid()); !ok {
+		t.Fatal("expected c to be cached")
+	}
+
+	disabled := newNodeCache(0)
+	if _, err := disabled.add(a); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := disabled.get(a.Cid()); ok {
+		t.Fatal("expected nothing to be cached")
+	}
+}
+
+func TestPrefetch(t *testing.T) {
+	const blockSize = 100
+	const size = 100 * blockSize
+
+	for name, layout := range map[string]func(db *h.DagBuilderHelper) (*dag.ProtoNode, error){
+		"balanced": func(db *h.DagBuilderHelper) (*dag.ProtoNode, error) {
+			nd, err := balanced.Layout(db)
+			if err != nil {
+				return nil, err
+			}
+			return nd.(*dag.ProtoNode), nil
+		},
+		"trickle": func(db *h.DagBuilderHelper) (*dag.ProtoNode, error) {
+			nd, err := trickle.Layout(db)
+			if err != nil {
+				return nil, err
+			}
+			return nd.(*dag.ProtoNode), nil
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ds := mdtest.Mock()
+			params := h.DagBuilderParams{Dagserv: ds, Maxlinks: 4}
+			db, err := params.New(chunker.NewSizeSplitter(bytes.NewReader(make([]byte, size)), blockSize))
+			if err != nil {
+				t.Fatal(err)
+			}
+			nd, err := layout(db)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx := context.Background()
+			for _, c := range []struct {
+				offset uint64
+				n      int
+				end    uint64
+			}{
+				{0, 8, 8 * blockSize},
+				{blockSize + 1, 3, 4 * blockSize},
+				{42 * blockSize, 20, 62 * blockSize},
+				{95 * blockSize, 20, size},
+				{size, 1, size},
+			} {
+				end, err := prefetch(ctx, ds, nd, c.offset, c.n)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if end != c.end {
+					t.Fatalf("prefetch of %d blocks at %d: expected end %d, got %d", c.n, c.offset, c.end, end)
+				}
+			}
+		})
+	}
+}
+
+func TestFileHandleRead(t *testing.T) {
+	nd, err := coremock.NewMockNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := NewFileSystemWithOptions(nd, Options{ReadAhead: 4, CacheSize: DefaultCacheSize})
+	obj, data := randObj(t, nd, 2000000)
+
+	n, err := fsys.node(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fh, err := newFileHandle(context.Background(), n, fsys.opts.ReadAhead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Release(context.Background(), &fuse.ReleaseRequest{})
+
+	read := func(offset int64, size int) {
+		t.Helper()
+		resp := fuse.ReadResponse{Data: make([]byte, 0, size)}
+		if err := fh.Read(context.Background(), &fuse.ReadRequest{Offset: offset, Size: size}, &resp); err != nil {
+			t.Fatal(err)
+		}
+		end := offset + int64(size)
+		if end > int64(len(data)) {
+			end = int64(len(data))
+		}
+		if !bytes.Equal(resp.Data, data[offset:end]) {
+			t.Fatalf("read of %d bytes at %d: wrong data", size, offset)
+		}
+	}
+
+	// sequential reads, then seeks back and forth
+	for off := int64(0); off < int64(len(data)); off += 128 * 1024 {
+		read(off, 128*1024)
+	}
+	read(1234567, 4096)
+	read(10, 100)
+	read(int64(len(data))-50, 4096)
+}
@@ -0,0 +1,143 @@
+//go:build (linux || darwin || freebsd) && !nofuse
+// +build linux darwin freebsd
+// +build !nofuse
+
+package readonly
+
+import (
+	"container/list"
+	"sync"
+
+	fuse "bazil.org/fuse"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	mdag "github.com/ipfs/go-merkledag"
+	ft "github.com/ipfs/go-unixfs"
+)
+
+// cacheEntry is the cached state of a node. Like the node, the attributes
+// and the directory entries never change.
+type cacheEntry struct {
+	nd  ipld.Node
+	fsn *ft.FSNode // nil for raw nodes
+
+	mu       sync.Mutex
+	dirents  []fuse.Dirent // nil until listed
+	children map[string]cid.Cid
+}
+
+func newCacheEntry(nd ipld.Node) (*cacheEntry, error) {
+	e := &cacheEntry{nd: nd}
+	if pbnd, ok := nd.(*mdag.ProtoNode); ok {
+		fsn, err := ft.FSNodeFromBytes(pbnd.Data())
+		if err != nil {
+			return nil, err
+		}
+		e.fsn = fsn
+	}
+	return e, nil
+}
+
+// direntType returns the type of the node in directory entries.
+func (e *cacheEntry) direntType() fuse.DirentType {
+	if e.fsn == nil {
+		return fuse.DT_File
+	}
+	switch e.fsn.Type() {
+	case ft.TDirectory, ft.THAMTShard:
+		return fuse.DT_Dir
+	case ft.TFile, ft.TRaw:
+		return fuse.DT_File
+	case ft.TSymlink:
+		return fuse.DT_Link
+	case ft.TMetadata:
+		log.Error("metadata object in fuse should contain its wrapped type")
+	default:
+		log.Error("unrecognized protonode data type: ", e.fsn.Type())
+	}
+	return fuse.DT_Unknown
+}
+
+// child returns the CID of the child name of a directory, if looked up
+// before.
+func (e *cacheEntry) child(name string) (cid.Cid, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	c, ok := e.children[name]
+	return c, ok
+}
+
+func (e *cacheEntry) setChild(name string, c cid.Cid) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.children == nil {
+		e.children = make(map[string]cid.Cid)
+	}
+	e.children[name] = c
+}
+
+func (e *cacheEntry) listing() ([]fuse.Dirent, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.dirents, e.dirents != nil
+}
+
+func (e *cacheEntry) setListing(dirents []fuse.Dirent, children map[string]cid.Cid) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.dirents = dirents
+	e.children = children
+}
+
+// nodeCache is an LRU cache of the nodes of the filesystem, by CID.
+type nodeCache struct {
+	size int // caching is disabled when not positive
+
+	mu      sync.Mutex
+	lru     *list.List // of *cacheEntry, most recently used first
+	entries map[cid.Cid]*list.Element
+}
+
+func newNodeCache(size int) *nodeCache {
+	return &nodeCache{
+		size:    size,
+		lru:     list.New(),
+		entries: make(map[cid.Cid]*list.Element),
+	}
+}
+
+// get returns the entry of the node c, if cached.
+func (c *nodeCache) get(k cid.Cid) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[k]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return el.Value.(*cacheEntry), true
+}
+
+// add returns the entry of nd, adding it to the cache.
+func (c *nodeCache) add(nd ipld.Node) (*cacheEntry, error) {
+	if e, ok := c.get(nd.Cid()); ok {
+		return e, nil
+	}
+	e, err := newCacheEntry(nd)
+	if err != nil || c.size <= 0 {
+		return e, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[nd.Cid()]; ok {
+		return el.Value.(*cacheEntry), nil
+	}
+	c.entries[nd.Cid()] = c.lru.PushFront(e)
+	for c.lru.Len() > c.size {
+		el := c.lru.Back()
+		c.lru.Remove(el)
+		delete(c.entries, el.Value.(*cacheEntry).nd.Cid())
+	}
+	return e, nil
+}
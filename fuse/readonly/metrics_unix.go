@@ -0,0 +1,41 @@
+//go:build (linux || darwin || freebsd) && !nofuse
+// +build linux darwin freebsd
+// +build !nofuse
+
+package readonly
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Caches of the filesystem, as labels of the cache metrics.
+const (
+	cacheAttr      = "attr"
+	cacheLookup    = "lookup"
+	cacheDirent    = "dirent"
+	cacheReadAhead = "readahead"
+)
+
+var (
+	cacheHits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ipfs_fuse_readonly_cache_hits_total",
+		Help: "Requests of the read-only FUSE mount served from its caches, or reads served from prefetched blocks",
+	}, []string{"cache"})
+	cacheMisses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ipfs_fuse_readonly_cache_misses_total",
+		Help: "Requests of the read-only FUSE mount not served from its caches, or reads not served from prefetched blocks",
+	}, []string{"cache"})
+	prefetchedBlocks = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ipfs_fuse_readonly_prefetched_blocks_total",
+		Help: "Blocks prefetched by the read-ahead of the read-only FUSE mount",
+	})
+)
+
+func cacheHit(cache string, hit bool) {
+	if hit {
+		cacheHits.WithLabelValues(cache).Inc()
+	} else {
+		cacheMisses.WithLabelValues(cache).Inc()
+	}
+}
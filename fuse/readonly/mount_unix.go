@@ -16,6 +16,10 @@ func Mount(ipfs *core.IpfsNode, mountpoint string) (mount.Mount, error) {
 		return nil, err
 	}
 	allow_other := cfg.Mounts.FuseAllowOther
-	fsys := NewFileSystem(ipfs)
+	fsys := NewFileSystemWithOptions(ipfs, Options{
+		ReadAhead: int(cfg.Mounts.ReadAhead.WithDefault(DefaultReadAhead)),
+		CacheTTL:  cfg.Mounts.CacheTTL.WithDefault(DefaultCacheTTL),
+		CacheSize: int(cfg.Mounts.CacheSize.WithDefault(DefaultCacheSize)),
+	})
 	return mount.NewMount(ipfs.Process, fsys, mountpoint, allow_other)
 }
@@ -0,0 +1,216 @@
+//go:build (linux || darwin || freebsd) && !nofuse
+// +build linux darwin freebsd
+// +build !nofuse
+
+package readonly
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	fuse "bazil.org/fuse"
+	fs "bazil.org/fuse/fs"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	mdag "github.com/ipfs/go-merkledag"
+	ft "github.com/ipfs/go-unixfs"
+	uio "github.com/ipfs/go-unixfs/io"
+)
+
+// prefetcher fetches the leaves of a file in parallel, level by level.
+type prefetcher struct {
+	ng     ipld.NodeGetter
+	leaves int // left to fetch
+}
+
+// prefetch fetches up to n leaves of the file nd, starting with the one
+// holding offset. It returns the offset the fetched leaves end at.
+func prefetch(ctx context.Context, ng ipld.NodeGetter, nd ipld.Node, offset uint64, n int) (uint64, error) {
+	pbnd, ok := nd.(*mdag.ProtoNode)
+	if !ok || len(pbnd.Links()) == 0 {
+		// a single block, already there
+		return offset, nil
+	}
+	p := &prefetcher{ng: ng, leaves: n}
+	end, err := p.walk(ctx, pbnd, 0, offset)
+	prefetchedBlocks.Add(float64(n - p.leaves))
+	return end, err
+}
+
+// walk fetches the leaves under the file node nd, starting at start in the
+// file, from offset on. It returns the offset the fetched leaves end at.
+func (p *prefetcher) walk(ctx context.Context, nd *mdag.ProtoNode, start, offset uint64) (uint64, error) {
+	fsn, err := ft.FSNodeFromBytes(nd.Data())
+	if err != nil {
+		return start, err
+	}
+	links := nd.Links()
+	if fsn.NumChildren() != len(links) {
+		return start, errors.New("the block sizes do not match the links of the file node")
+	}
+
+	start += uint64(len(fsn.Data()))
+	i := 0
+	for ; i < len(links) && start+fsn.BlockSize(i) <= offset; i++ {
+		start += fsn.BlockSize(i)
+	}
+	for i < len(links) && p.leaves > 0 {
+		// the children are fetched in parallel, a batch the size of the
+		// leaves left at a time
+		batch := len(links) - i
+		if batch > p.leaves {
+			batch = p.leaves
+		}
+		cids := make([]cid.Cid, batch)
+		for j := range cids {
+			cids[j] = links[i+j].Cid
+		}
+		promises := ipld.GetNodes(ctx, p.ng, cids)
+
+		for _, promise := range promises {
+			child, err := promise.Get(ctx)
+			if err != nil {
+				return start, err
+			}
+			if pbchild, ok := child.(*mdag.ProtoNode); ok && len(pbchild.Links()) > 0 {
+				end, err := p.walk(ctx, pbchild, start, offset)
+				if err != nil || end < start+fsn.BlockSize(i) {
+					return end, err
+				}
+			} else {
+				p.leaves--
+			}
+			start += fsn.BlockSize(i)
+			i++
+			if p.leaves == 0 {
+				break
+			}
+		}
+	}
+	return start, nil
+}
+
+// fileHandle is an open file of the filesystem. It keeps its DAG reader
+// between reads, and prefetches the blocks ahead of sequential reads.
+type fileHandle struct {
+	node      *Node
+	readAhead int
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu  sync.Mutex
+	r   uio.DagReader
+	pos int64 // of the reader
+
+	// the blocks of the window are prefetched, the next ones once the reads
+	// pass trigger
+	windowStart, windowEnd, trigger uint64
+	prefetching                     bool
+	generation                      int // of the window
+}
+
+func newFileHandle(ctx context.Context, s *Node, readAhead int) (*fileHandle, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	r, err := uio.NewDagReader(ctx, s.Nd, s.Ipfs.DAG)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &fileHandle{
+		node:      s,
+		readAhead: readAhead,
+		ctx:       ctx,
+		cancel:    cancel,
+		r:         r,
+	}, nil
+}
+
+// Read reads the file at the requested offset.
+func (h *fileHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.readAhead > 0 {
+		h.readAheadOf(uint64(req.Offset), uint64(req.Size))
+	}
+	if req.Offset != h.pos {
+		if _, err := h.r.Seek(req.Offset, io.SeekStart); err != nil {
+			return err
+		}
+		h.pos = req.Offset
+	}
+
+	// Data has a capacity of Size
+	buf := resp.Data[:req.Size]
+	n, err := h.r.CtxReadFull(ctx, buf)
+	h.pos += int64(n)
+	resp.Data = buf[:n]
+	switch err {
+	case nil, io.EOF, io.ErrUnexpectedEOF:
+		return nil
+	default:
+		// the position of the reader is unknown
+		h.pos = -1
+		return err
+	}
+}
+
+// readAheadOf moves the read-ahead window along a read. h.mu must be held.
+func (h *fileHandle) readAheadOf(offset, size uint64) {
+	cacheHit(cacheReadAhead, offset >= h.windowStart && offset+size <= h.windowEnd)
+
+	if offset < h.windowStart || offset > h.windowEnd && offset != uint64(h.pos) {
+		// not sequential, start over
+		h.windowStart, h.windowEnd, h.trigger = offset, offset, offset
+		h.generation++
+		h.prefetching = false
+	}
+	if h.prefetching || offset < h.trigger {
+		return
+	}
+
+	from := h.windowEnd
+	if from < offset {
+		from = offset
+	}
+	h.prefetching = true
+	generation := h.generation
+	go func() {
+		end, err := prefetch(h.ctx, h.node.Ipfs.DAG, h.node.Nd, from, h.readAhead)
+		if err != nil && h.ctx.Err() == nil {
+			log.Debugf("read-ahead of %s: %s", h.node.Nd.Cid(), err)
+		}
+
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if generation != h.generation {
+			return
+		}
+		h.prefetching = false
+		if end > h.windowEnd {
+			h.windowEnd = end
+		}
+		// prefetch again halfway through the fetched blocks
+		h.trigger = from + (end-from)/2
+		if end == from {
+			// nothing left, or failed: retry past the window
+			h.trigger = end + 1
+		}
+	}()
+}
+
+// Release closes the handle.
+func (h *fileHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	h.cancel()
+	return h.r.Close()
+}
+
+type roFileHandle interface {
+	fs.HandleReader
+	fs.HandleReleaser
+}
+
+var _ roFileHandle = (*fileHandle)(nil)
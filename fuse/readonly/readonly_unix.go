@@ -7,9 +7,9 @@ package readonly
 import (
 	"context"
 	"fmt"
-	"io"
 	"os"
 	"syscall"
+	"time"
 
 	fuse "bazil.org/fuse"
 	fs "bazil.org/fuse/fs"
@@ -28,24 +28,84 @@ import (
 
 var log = logging.Logger("fuse/ipfs")
 
+// Defaults of the filesystem Options.
+const (
+	DefaultReadAhead = 8
+	DefaultCacheTTL  = 10 * time.Minute
+	DefaultCacheSize = 16384
+)
+
+// Options tunes the read-ahead and the caching of the filesystem.
+type Options struct {
+	// ReadAhead is the number of blocks prefetched ahead of sequential reads
+	// of a file, zero to disable read-ahead.
+	ReadAhead int
+	// CacheTTL is how long the kernel caches the attributes and the
+	// directory entries.
+	CacheTTL time.Duration
+	// CacheSize is the number of nodes cached by the filesystem, for their
+	// attributes, lookups and directory entries.
+	CacheSize int
+}
+
+// DefaultOptions are the options of NewFileSystem.
+var DefaultOptions = Options{
+	ReadAhead: DefaultReadAhead,
+	CacheTTL:  DefaultCacheTTL,
+	CacheSize: DefaultCacheSize,
+}
+
 // FileSystem is the readonly IPFS Fuse Filesystem.
 type FileSystem struct {
 	Ipfs *core.IpfsNode
+
+	opts  Options
+	cache *nodeCache
 }
 
 // NewFileSystem constructs new fs using given core.IpfsNode instance.
 func NewFileSystem(ipfs *core.IpfsNode) *FileSystem {
-	return &FileSystem{Ipfs: ipfs}
+	return NewFileSystemWithOptions(ipfs, DefaultOptions)
+}
+
+// NewFileSystemWithOptions constructs new fs using given core.IpfsNode
+// instance and options.
+func NewFileSystemWithOptions(ipfs *core.IpfsNode, opts Options) *FileSystem {
+	return &FileSystem{Ipfs: ipfs, opts: opts, cache: newNodeCache(opts.CacheSize)}
 }
 
 // Root constructs the Root of the filesystem, a Root object.
-func (f FileSystem) Root() (fs.Node, error) {
-	return &Root{Ipfs: f.Ipfs}, nil
+func (f *FileSystem) Root() (fs.Node, error) {
+	return &Root{Ipfs: f.Ipfs, fs: f}, nil
+}
+
+// node returns the node of the filesystem for nd.
+func (f *FileSystem) node(nd ipld.Node) (*Node, error) {
+	e, err := f.cache.add(nd)
+	if err != nil {
+		return nil, err
+	}
+	return f.nodeOf(e), nil
+}
+
+func (f *FileSystem) nodeOf(e *cacheEntry) *Node {
+	return &Node{Ipfs: f.Ipfs, Nd: e.nd, cached: e.fsn, fs: f, entry: e}
+}
+
+// cached returns the node of the filesystem for the CID c, if cached.
+func (f *FileSystem) cached(c cid.Cid) (*Node, bool) {
+	e, ok := f.cache.get(c)
+	cacheHit(cacheAttr, ok)
+	if !ok {
+		return nil, false
+	}
+	return f.nodeOf(e), true
 }
 
 // Root is the root object of the filesystem tree.
 type Root struct {
 	Ipfs *core.IpfsNode
+	fs   *FileSystem
 }
 
 // Attr returns file attributes.
@@ -55,8 +115,10 @@ func (*Root) Attr(ctx context.Context, a *fuse.Attr) error {
 }
 
 // Lookup performs a lookup under this node.
-func (s *Root) Lookup(ctx context.Context, name string) (fs.Node, error) {
+func (s *Root) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.LookupResponse) (fs.Node, error) {
+	name := req.Name
 	log.Debugf("Root Lookup: '%s'", name)
+	resp.EntryValid = s.fs.opts.CacheTTL
 	switch name {
 	case "mach_kernel", ".hidden", "._.":
 		// Just quiet some log noise on OS X.
@@ -81,6 +143,10 @@ func (s *Root) Lookup(ctx context.Context, name string) (fs.Node, error) {
 		return nil, fuse.ENOENT
 	}
 
+	if nd, ok := s.fs.cached(cidLnk.Cid); ok {
+		return nd, nil
+	}
+
 	// convert ipld-prime node to universal node
 	blk, err := s.Ipfs.Blockstore.Get(ctx, cidLnk.Cid)
 	if err != nil {
@@ -109,7 +175,7 @@ func (s *Root) Lookup(ctx context.Context, name string) (fs.Node, error) {
 		return nil, fuse.ENOENT
 	}
 
-	return &Node{Ipfs: s.Ipfs, Nd: fnd}, nil
+	return s.fs.node(fnd)
 }
 
 // ReadDirAll reads a particular directory. Disallowed for root.
@@ -123,22 +189,15 @@ type Node struct {
 	Ipfs   *core.IpfsNode
 	Nd     ipld.Node
 	cached *ft.FSNode
-}
 
-func (s *Node) loadData() error {
-	if pbnd, ok := s.Nd.(*mdag.ProtoNode); ok {
-		fsn, err := ft.FSNodeFromBytes(pbnd.Data())
-		if err != nil {
-			return err
-		}
-		s.cached = fsn
-	}
-	return nil
+	fs    *FileSystem
+	entry *cacheEntry
 }
 
 // Attr returns the attributes of a given node.
 func (s *Node) Attr(ctx context.Context, a *fuse.Attr) error {
 	log.Debug("Node attr")
+	a.Valid = s.fs.opts.CacheTTL
 	if rawnd, ok := s.Nd.(*mdag.RawNode); ok {
 		a.Mode = 0444
 		a.Size = uint64(len(rawnd.RawData()))
@@ -147,9 +206,7 @@ func (s *Node) Attr(ctx context.Context, a *fuse.Attr) error {
 	}
 
 	if s.cached == nil {
-		if err := s.loadData(); err != nil {
-			return fmt.Errorf("readonly: loadData() failed: %s", err)
-		}
+		return fmt.Errorf("readonly: not a unixfs node: %s", s.Nd.Cid())
 	}
 	switch s.cached.Type() {
 	case ft.TDirectory, ft.THAMTShard:
@@ -173,8 +230,19 @@ func (s *Node) Attr(ctx context.Context, a *fuse.Attr) error {
 }
 
 // Lookup performs a lookup under this node.
-func (s *Node) Lookup(ctx context.Context, name string) (fs.Node, error) {
+func (s *Node) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.LookupResponse) (fs.Node, error) {
+	name := req.Name
 	log.Debugf("Lookup '%s'", name)
+	resp.EntryValid = s.fs.opts.CacheTTL
+
+	if c, ok := s.entry.child(name); ok {
+		if nd, ok := s.fs.cached(c); ok {
+			cacheHit(cacheLookup, true)
+			return nd, nil
+		}
+	}
+	cacheHit(cacheLookup, false)
+
 	link, _, err := uio.ResolveUnixfsOnce(ctx, s.Ipfs.DAG, s.Nd, []string{name})
 	switch err {
 	case os.ErrNotExist, mdag.ErrLinkNotFound:
@@ -188,66 +256,100 @@ func (s *Node) Lookup(ctx context.Context, name string) (fs.Node, error) {
 	}
 
 	nd, err := s.Ipfs.DAG.Get(ctx, link.Cid)
-	if err != nil && !ipld.IsNotFound(err) {
+	if ipld.IsNotFound(err) {
+		return nil, fuse.ENOENT
+	}
+	if err != nil {
 		log.Errorf("fuse lookup %q: %s", name, err)
 		return nil, err
 	}
 
-	return &Node{Ipfs: s.Ipfs, Nd: nd}, nil
+	child, err := s.fs.node(nd)
+	if err != nil {
+		return nil, err
+	}
+	s.entry.setChild(name, link.Cid)
+	return child, nil
 }
 
 // ReadDirAll reads the link structure as directory entries
 func (s *Node) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 	log.Debug("Node ReadDir")
+	entries, ok := s.entry.listing()
+	cacheHit(cacheDirent, ok)
+	if !ok {
+		var err error
+		entries, err = s.readDirAll(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(entries) > 0 {
+		return entries, nil
+	}
+	return nil, fuse.ENOENT
+}
+
+func (s *Node) readDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 	dir, err := uio.NewDirectoryFromNode(s.Ipfs.DAG, s.Nd)
 	if err != nil {
 		return nil, err
 	}
 
-	var entries []fuse.Dirent
+	var links []*ipld.Link
 	err = dir.ForEachLink(ctx, func(lnk *ipld.Link) error {
+		links = append(links, lnk)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// fetch the children missing from the cache in parallel
+	children := make([]*cacheEntry, len(links))
+	var missing []cid.Cid
+	for i, lnk := range links {
+		if e, ok := s.fs.cache.get(lnk.Cid); ok {
+			children[i] = e
+		} else {
+			missing = append(missing, lnk.Cid)
+		}
+	}
+	promises := ipld.GetNodes(ctx, s.Ipfs.DAG, missing)
+
+	entries := make([]fuse.Dirent, 0, len(links))
+	names := make(map[string]cid.Cid, len(links))
+	for i, lnk := range links {
 		n := lnk.Name
 		if len(n) == 0 {
 			n = lnk.Cid.String()
 		}
-		nd, err := s.Ipfs.DAG.Get(ctx, lnk.Cid)
-		if err != nil {
-			log.Warn("error fetching directory child node: ", err)
-		}
+		names[n] = lnk.Cid
 
 		t := fuse.DT_Unknown
-		switch nd := nd.(type) {
-		case *mdag.RawNode:
-			t = fuse.DT_File
-		case *mdag.ProtoNode:
-			if fsn, err := ft.FSNodeFromBytes(nd.Data()); err != nil {
-				log.Warn("failed to unmarshal protonode data field:", err)
-			} else {
-				switch fsn.Type() {
-				case ft.TDirectory, ft.THAMTShard:
-					t = fuse.DT_Dir
-				case ft.TFile, ft.TRaw:
-					t = fuse.DT_File
-				case ft.TSymlink:
-					t = fuse.DT_Link
-				case ft.TMetadata:
-					log.Error("metadata object in fuse should contain its wrapped type")
-				default:
-					log.Error("unrecognized protonode data type: ", fsn.Type())
+		e := children[i]
+		if e == nil {
+			nd, err := promises[0].Get(ctx)
+			promises = promises[1:]
+			if err == nil {
+				e, err = s.fs.cache.add(nd)
+			}
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
 				}
+				log.Warn("error fetching directory child node: ", err)
 			}
 		}
+		if e != nil {
+			t = e.direntType()
+		}
 		entries = append(entries, fuse.Dirent{Name: n, Type: t})
-		return nil
-	})
-	if err != nil {
-		return nil, err
 	}
 
-	if len(entries) > 0 {
-		return entries, nil
-	}
-	return nil, fuse.ENOENT
+	s.entry.setListing(entries, names)
+	return entries, nil
 }
 
 func (s *Node) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
@@ -263,42 +365,39 @@ func (s *Node) Readlink(ctx context.Context, req *fuse.ReadlinkRequest) (string,
 	return string(s.cached.Data()), nil
 }
 
-func (s *Node) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
-	r, err := uio.NewDagReader(ctx, s.Nd, s.Ipfs.DAG)
-	if err != nil {
-		return err
-	}
-	_, err = r.Seek(req.Offset, io.SeekStart)
-	if err != nil {
-		return err
+// Open opens the node. Files are read through a handle prefetching their
+// blocks, which the kernel may cache as the content never changes.
+func (s *Node) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	if s.cached != nil {
+		switch s.cached.Type() {
+		case ft.TFile, ft.TRaw:
+		default:
+			return s, nil
+		}
 	}
-	// Data has a capacity of Size
-	buf := resp.Data[:int(req.Size)]
-	n, err := io.ReadFull(r, buf)
-	resp.Data = buf[:n]
-	switch err {
-	case nil, io.EOF, io.ErrUnexpectedEOF:
-	default:
-		return err
+	resp.Flags |= fuse.OpenKeepCache
+
+	readAhead := s.fs.opts.ReadAhead
+	if len(s.Nd.Links()) == 0 {
+		readAhead = 0
 	}
-	resp.Data = resp.Data[:n]
-	return nil // may be non-nil / not succeeded
+	return newFileHandle(s.Ipfs.Context(), s, readAhead)
 }
 
 // to check that out Node implements all the interfaces we want
 type roRoot interface {
 	fs.Node
 	fs.HandleReadDirAller
-	fs.NodeStringLookuper
+	fs.NodeRequestLookuper
 }
 
 var _ roRoot = (*Root)(nil)
 
 type roNode interface {
 	fs.HandleReadDirAller
-	fs.HandleReader
 	fs.Node
-	fs.NodeStringLookuper
+	fs.NodeOpener
+	fs.NodeRequestLookuper
 	fs.NodeReadlinker
 	fs.NodeGetxattrer
 }
@@ -0,0 +1,42 @@
+package p2p
+
+import (
+	peer "github.com/libp2p/go-libp2p-core/peer"
+)
+
+// ACL decides which peers may open streams to a remote listener.
+type ACL struct {
+	allow map[peer.ID]struct{}
+	deny  map[peer.ID]struct{}
+}
+
+// NewACL creates an ACL denying the peers in deny and, when allow is not
+// empty, any peer not listed in allow. Deny entries take precedence.
+func NewACL(allow, deny []peer.ID) *ACL {
+	acl := &ACL{deny: make(map[peer.ID]struct{}, len(deny))}
+	for _, p := range deny {
+		acl.deny[p] = struct{}{}
+	}
+	if len(allow) > 0 {
+		acl.allow = make(map[peer.ID]struct{}, len(allow))
+		for _, p := range allow {
+			acl.allow[p] = struct{}{}
+		}
+	}
+	return acl
+}
+
+// Allowed reports whether p may open streams. A nil ACL allows every peer.
+func (acl *ACL) Allowed(p peer.ID) bool {
+	if acl == nil {
+		return true
+	}
+	if _, ok := acl.deny[p]; ok {
+		return false
+	}
+	if acl.allow == nil {
+		return true
+	}
+	_, ok := acl.allow[p]
+	return ok
+}
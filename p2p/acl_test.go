@@ -0,0 +1,158 @@
+package p2p
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	host "github.com/libp2p/go-libp2p-core/host"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	protocol "github.com/libp2p/go-libp2p-core/protocol"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+const testProto = protocol.ID("/x/test")
+
+func TestACLAllowed(t *testing.T) {
+	a, b, c := peer.ID("a"), peer.ID("b"), peer.ID("c")
+
+	var nilACL *ACL
+	if !nilACL.Allowed(a) {
+		t.Fatal("nil ACL should allow every peer")
+	}
+
+	testCases := []struct {
+		name        string
+		allow, deny []peer.ID
+		allowed     map[peer.ID]bool
+	}{
+		{
+			name:    "empty",
+			allowed: map[peer.ID]bool{a: true, b: true, c: true},
+		},
+		{
+			name:    "deny",
+			deny:    []peer.ID{b},
+			allowed: map[peer.ID]bool{a: true, b: false, c: true},
+		},
+		{
+			name:    "allow",
+			allow:   []peer.ID{a, b},
+			allowed: map[peer.ID]bool{a: true, b: true, c: false},
+		},
+		{
+			name:    "deny takes precedence",
+			allow:   []peer.ID{a, b},
+			deny:    []peer.ID{b},
+			allowed: map[peer.ID]bool{a: true, b: false, c: false},
+		},
+	}
+	for _, tc := range testCases {
+		acl := NewACL(tc.allow, tc.deny)
+		for p, want := range tc.allowed {
+			if got := acl.Allowed(p); got != want {
+				t.Errorf("%s: Allowed(%s) = %t, want %t", tc.name, p, got, want)
+			}
+		}
+	}
+}
+
+// newTestP2P connects two mock hosts and returns a P2P service on the first
+// one along with the second host, used to open streams to it.
+func newTestP2P(t *testing.T) (*P2P, host.Host) {
+	t.Helper()
+
+	mn := mocknet.New()
+	t.Cleanup(func() { mn.Close() })
+
+	for i := 0; i < 2; i++ {
+		if _, err := mn.GenPeer(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mn.LinkAll(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mn.ConnectAllButSelf(); err != nil {
+		t.Fatal(err)
+	}
+
+	hosts := mn.Hosts()
+	h := hosts[0]
+	return New(h.ID(), h, h.Peerstore()), hosts[1]
+}
+
+func TestRemoteListenerACL(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	p, client := newTestP2P(t)
+	server := p.peerHost
+
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	go func() {
+		for {
+			c, err := target.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = io.Copy(c, c)
+			c.Close()
+		}
+	}()
+	taddr, err := manet.FromNetAddr(target.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dial := func() error {
+		s, err := client.NewStream(ctx, server.ID(), testProto)
+		if err != nil {
+			return err
+		}
+		defer s.Close()
+
+		if _, err := s.Write([]byte("ping")); err != nil {
+			return err
+		}
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(s, buf)
+		return err
+	}
+
+	// denied peers are reset and counted
+	l, err := p.ForwardRemote(ctx, testProto, taddr, false, NewACL(nil, []peer.ID{client.ID()}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl := l.(*remoteListener)
+	for i := 1; i <= 2; i++ {
+		if err := dial(); err == nil {
+			t.Fatal("expected the stream of a denied peer to be reset")
+		}
+		if n := rl.Rejected(); n != uint64(i) {
+			t.Fatalf("expected %d rejected streams, got %d", i, n)
+		}
+	}
+
+	// allowed peers reach the target
+	p.ListenersP2P.Close(func(Listener) bool { return true })
+	l, err = p.ForwardRemote(ctx, testProto, taddr, false, NewACL([]peer.ID{client.ID()}, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl = l.(*remoteListener)
+	if err := dial(); err != nil {
+		t.Fatalf("expected the stream of an allowed peer to be forwarded: %s", err)
+	}
+	if n := rl.Rejected(); n != 0 {
+		t.Fatalf("expected no rejected streams, got %d", n)
+	}
+}
@@ -0,0 +1,355 @@
+package p2p
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	tec "github.com/jbenet/go-temp-err-catcher"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+const (
+	// maxDatagramSize is the largest datagram that can be forwarded.
+	maxDatagramSize = 64 << 10
+
+	// datagramIdleTimeout closes datagram streams without any traffic from
+	// the local side for that long.
+	datagramIdleTimeout = 2 * time.Minute
+
+	// sessionQueueSize is the number of datagrams buffered per local
+	// session, further datagrams are dropped until the stream catches up.
+	sessionQueueSize = 64
+)
+
+var errInvalidFrame = errors.New("invalid datagram frame")
+
+// isDatagramAddr reports whether addr is a UDP address, forwarded datagram
+// by datagram instead of as a byte stream.
+func isDatagramAddr(addr ma.Multiaddr) bool {
+	_, err := addr.ValueForProtocol(ma.P_UDP)
+	return err == nil
+}
+
+// datagramConn adapts a packet oriented connection, where every Read and
+// Write is a single datagram, to the byte stream forwarded over libp2p. Each
+// datagram is framed as uvarint(length) || payload.
+type datagramConn struct {
+	manet.Conn
+
+	idle time.Duration
+
+	pkt  []byte
+	rbuf []byte
+	wbuf []byte
+}
+
+func newDatagramConn(c manet.Conn) *datagramConn {
+	return &datagramConn{
+		Conn: c,
+		idle: datagramIdleTimeout,
+		pkt:  make([]byte, maxDatagramSize),
+	}
+}
+
+// Read returns the framed datagrams received on the underlying connection. It
+// returns io.EOF once no datagram was received for the idle timeout.
+func (c *datagramConn) Read(p []byte) (int, error) {
+	if len(c.rbuf) == 0 {
+		if err := c.Conn.SetReadDeadline(time.Now().Add(c.idle)); err != nil {
+			return 0, err
+		}
+		n, err := c.Conn.Read(c.pkt)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return 0, io.EOF
+			}
+			return 0, err
+		}
+
+		var hdr [binary.MaxVarintLen64]byte
+		hn := binary.PutUvarint(hdr[:], uint64(n))
+		c.rbuf = append(append(c.rbuf[:0], hdr[:hn]...), c.pkt[:n]...)
+	}
+
+	n := copy(p, c.rbuf)
+	c.rbuf = c.rbuf[n:]
+	return n, nil
+}
+
+// Write reassembles frames from p, sending every complete datagram on the
+// underlying connection.
+func (c *datagramConn) Write(p []byte) (int, error) {
+	c.wbuf = append(c.wbuf, p...)
+
+	for {
+		size, hn := binary.Uvarint(c.wbuf)
+		if hn == 0 {
+			break // incomplete header
+		}
+		if hn < 0 || size > maxDatagramSize {
+			return 0, errInvalidFrame
+		}
+		if uint64(len(c.wbuf)-hn) < size {
+			break // incomplete payload
+		}
+
+		end := hn + int(size)
+		if _, err := c.Conn.Write(c.wbuf[hn:end]); err != nil {
+			return 0, err
+		}
+		c.wbuf = c.wbuf[end:]
+	}
+
+	// move the incomplete frame, if any, to the front of the buffer
+	c.wbuf = append(c.wbuf[:0:0], c.wbuf...)
+	return len(p), nil
+}
+
+// udpSession is the packet oriented connection between a local UDP client and
+// a forwarded stream. It receives the datagrams the listener demultiplexed
+// from the client's address, and replies through the shared socket.
+type udpSession struct {
+	listener *localPacketListener
+	raddr    net.Addr
+	rmaddr   ma.Multiaddr
+
+	in     chan []byte
+	closed chan struct{}
+	once   sync.Once
+
+	mu       sync.Mutex
+	deadline time.Time
+}
+
+var _ manet.Conn = (*udpSession)(nil)
+
+func (s *udpSession) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	deadline := s.deadline
+	s.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		t := time.NewTimer(time.Until(deadline))
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	select {
+	case pkt := <-s.in:
+		return copy(p, pkt), nil
+	case <-s.closed:
+		return 0, io.EOF
+	case <-timeout:
+		return 0, os.ErrDeadlineExceeded
+	}
+}
+
+func (s *udpSession) Write(p []byte) (int, error) {
+	return s.listener.conn.WriteTo(p, s.raddr)
+}
+
+func (s *udpSession) Close() error {
+	s.once.Do(func() {
+		close(s.closed)
+		s.listener.removeSession(s)
+	})
+	return nil
+}
+
+func (s *udpSession) LocalAddr() net.Addr           { return s.listener.conn.LocalAddr() }
+func (s *udpSession) RemoteAddr() net.Addr          { return s.raddr }
+func (s *udpSession) LocalMultiaddr() ma.Multiaddr  { return s.listener.laddr }
+func (s *udpSession) RemoteMultiaddr() ma.Multiaddr { return s.rmaddr }
+
+func (s *udpSession) SetDeadline(t time.Time) error {
+	return s.SetReadDeadline(t)
+}
+
+func (s *udpSession) SetReadDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deadline = t
+	return nil
+}
+
+func (s *udpSession) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// localPacketListener reads datagrams from a local UDP socket and forwards
+// them to a remote listener, using one libp2p stream per client address.
+type localPacketListener struct {
+	ctx context.Context
+
+	p2p *P2P
+
+	proto protocol.ID
+	laddr ma.Multiaddr
+	peer  peer.ID
+
+	conn manet.PacketConn
+
+	mu       sync.Mutex
+	sessions map[string]*udpSession
+}
+
+func (p2p *P2P) forwardLocalPacket(ctx context.Context, peer peer.ID, proto protocol.ID, bindAddr ma.Multiaddr) (Listener, error) {
+	conn, err := manet.ListenPacket(bindAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	listener := &localPacketListener{
+		ctx:      ctx,
+		p2p:      p2p,
+		proto:    proto,
+		laddr:    conn.LocalMultiaddr(),
+		peer:     peer,
+		conn:     conn,
+		sessions: map[string]*udpSession{},
+	}
+
+	if err := p2p.ListenersLocal.Register(listener); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	go listener.readPackets()
+
+	return listener, nil
+}
+
+func (l *localPacketListener) readPackets() {
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, addr, err := l.conn.ReadFrom(buf)
+		if err != nil {
+			if tec.ErrIsTemporary(err) {
+				continue
+			}
+			return
+		}
+
+		s, isNew := l.session(addr)
+		if s == nil {
+			continue
+		}
+		pkt := make([]byte, n)
+		copy(pkt, buf[:n])
+		select {
+		case s.in <- pkt:
+		default:
+			log.Debugf("dropping datagram from %s: stream is not keeping up", addr)
+		}
+
+		if isNew {
+			go l.setupStream(s)
+		}
+	}
+}
+
+// session returns the session of the client at addr, creating it if needed.
+func (l *localPacketListener) session(addr net.Addr) (*udpSession, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if s, ok := l.sessions[addr.String()]; ok {
+		return s, false
+	}
+
+	rmaddr, err := manet.FromNetAddr(addr)
+	if err != nil {
+		log.Debugf("ignoring datagram from %s: %s", addr, err)
+		return nil, false
+	}
+	s := &udpSession{
+		listener: l,
+		raddr:    addr,
+		rmaddr:   rmaddr,
+		in:       make(chan []byte, sessionQueueSize),
+		closed:   make(chan struct{}),
+	}
+	l.sessions[addr.String()] = s
+	return s, true
+}
+
+func (l *localPacketListener) removeSession(s *udpSession) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.sessions[s.raddr.String()] == s {
+		delete(l.sessions, s.raddr.String())
+	}
+}
+
+func (l *localPacketListener) setupStream(s *udpSession) {
+	cctx, cancel := context.WithTimeout(l.ctx, time.Second*30)
+	defer cancel()
+
+	remote, err := l.p2p.peerHost.NewStream(cctx, l.peer, l.proto)
+	if err != nil {
+		s.Close()
+		log.Warnf("failed to dial to remote %s/%s", l.peer.Pretty(), l.proto)
+		return
+	}
+
+	stream := &Stream{
+		Protocol: l.proto,
+
+		OriginAddr: s.rmaddr,
+		TargetAddr: l.TargetAddress(),
+		peer:       l.peer,
+
+		Local:  newDatagramConn(s),
+		Remote: remote,
+
+		Registry: l.p2p.Streams,
+	}
+
+	l.p2p.Streams.Register(stream)
+}
+
+func (l *localPacketListener) close() {
+	l.conn.Close()
+
+	l.mu.Lock()
+	sessions := make([]*udpSession, 0, len(l.sessions))
+	for _, s := range l.sessions {
+		sessions = append(sessions, s)
+	}
+	l.mu.Unlock()
+
+	for _, s := range sessions {
+		s.Close()
+	}
+}
+
+func (l *localPacketListener) Protocol() protocol.ID {
+	return l.proto
+}
+
+func (l *localPacketListener) ListenAddress() ma.Multiaddr {
+	return l.laddr
+}
+
+func (l *localPacketListener) TargetAddress() ma.Multiaddr {
+	addr, err := ma.NewMultiaddr(maPrefix + l.peer.Pretty())
+	if err != nil {
+		panic(err)
+	}
+	return addr
+}
+
+func (l *localPacketListener) key() string {
+	return l.ListenAddress().String()
+}
@@ -0,0 +1,136 @@
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+func TestDatagramConnFraming(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	saddr, err := manet.FromNetAddr(server.LocalAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := manet.Dial(saddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dc := newDatagramConn(c)
+	defer dc.Close()
+
+	// two frames, split at awkward places, must produce two datagrams
+	stream := []byte{5, 'h', 'e', 'l', 'l', 'o', 3, 'f', 'o', 'o'}
+	for _, chunk := range [][]byte{stream[:1], stream[1:7], stream[7:]} {
+		if _, err := dc.Write(chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buf := make([]byte, 64)
+	var client net.Addr
+	for _, want := range []string{"hello", "foo"} {
+		_ = server.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, addr, err := server.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != want {
+			t.Fatalf("expected datagram %q, got %q", want, buf[:n])
+		}
+		client = addr
+	}
+
+	// replies are framed, and reads may consume a frame in several calls
+	if _, err := server.WriteTo([]byte("reply"), client); err != nil {
+		t.Fatal(err)
+	}
+	var got bytes.Buffer
+	small := make([]byte, 4)
+	for got.Len() < 6 {
+		n, err := dc.Read(small)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got.Write(small[:n])
+	}
+	if !bytes.Equal(got.Bytes(), []byte{5, 'r', 'e', 'p', 'l', 'y'}) {
+		t.Fatalf("unexpected framed reply %v", got.Bytes())
+	}
+
+	// idle connections end cleanly
+	dc.idle = 10 * time.Millisecond
+	if _, err := dc.Read(small); err != io.EOF {
+		t.Fatalf("expected io.EOF after idle timeout, got %v", err)
+	}
+
+	if _, err := dc.Write([]byte{0xff, 0xff, 0xff, 0xff, 0x0f}); err != errInvalidFrame {
+		t.Fatalf("expected errInvalidFrame, got %v", err)
+	}
+}
+
+func TestRemoteListenerUDPReportPeerID(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	p, client := newTestP2P(t)
+
+	target, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	taddr, err := manet.FromNetAddr(target.LocalAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.ForwardRemote(ctx, testProto, taddr, true, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := client.NewStream(ctx, p.peerHost.ID(), testProto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, err := s.Write([]byte{5, 'h', 'e', 'l', 'l', 'o', 3, 'f', 'o', 'o'}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the peer ID comes first, as a datagram of its own
+	buf := make([]byte, 128)
+	var from net.Addr
+	for _, want := range []string{client.ID().Pretty() + "\n", "hello", "foo"} {
+		_ = target.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, addr, err := target.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != want {
+			t.Fatalf("expected datagram %q, got %q", want, buf[:n])
+		}
+		from = addr
+	}
+
+	if _, err := target.WriteTo([]byte("reply"), from); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, 6)
+	if _, err := io.ReadFull(s, reply); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reply, []byte{5, 'r', 'e', 'p', 'l', 'y'}) {
+		t.Fatalf("unexpected framed reply %v", reply)
+	}
+}
@@ -25,8 +25,14 @@ type localListener struct {
 	listener manet.Listener
 }
 
-// ForwardLocal creates new P2P stream to a remote listener
+// ForwardLocal creates new P2P stream to a remote listener. When bindAddr is
+// a UDP address, every local client gets its own stream carrying its
+// datagrams, the remote listener must then target a UDP address too.
 func (p2p *P2P) ForwardLocal(ctx context.Context, peer peer.ID, proto protocol.ID, bindAddr ma.Multiaddr) (Listener, error) {
+	if isDatagramAddr(bindAddr) {
+		return p2p.forwardLocalPacket(ctx, peer, proto, bindAddr)
+	}
+
 	listener := &localListener{
 		ctx:   ctx,
 		p2p:   p2p,
@@ -80,10 +86,16 @@ func (l *localListener) setupStream(local manet.Conn) {
 		return
 	}
 
+	origin := local.RemoteMultiaddr()
+	if origin == nil {
+		// unix socket clients have no address
+		origin = l.laddr
+	}
+
 	stream := &Stream{
 		Protocol: l.proto,
 
-		OriginAddr: local.RemoteMultiaddr(),
+		OriginAddr: origin,
 		TargetAddr: l.TargetAddress(),
 		peer:       l.peer,
 
@@ -3,6 +3,7 @@ package p2p
 import (
 	"context"
 	"fmt"
+	"sync/atomic"
 
 	net "github.com/libp2p/go-libp2p-core/network"
 	protocol "github.com/libp2p/go-libp2p-core/protocol"
@@ -14,6 +15,10 @@ var maPrefix = "/" + ma.ProtocolWithCode(ma.P_IPFS).Name + "/"
 
 // remoteListener accepts libp2p streams and proxies them to a manet host
 type remoteListener struct {
+	// rejected counts the streams reset because the peer was not allowed.
+	// Kept first for 64-bit alignment of atomic operations.
+	rejected uint64
+
 	p2p *P2P
 
 	// Application proto identifier.
@@ -25,10 +30,14 @@ type remoteListener struct {
 	// reportRemote if set to true makes the handler send '<base58 remote peerid>\n'
 	// to target before any data is forwarded
 	reportRemote bool
+
+	// acl restricts the peers allowed to open streams, nil allows everyone
+	acl *ACL
 }
 
-// ForwardRemote creates new p2p listener
-func (p2p *P2P) ForwardRemote(ctx context.Context, proto protocol.ID, addr ma.Multiaddr, reportRemote bool) (Listener, error) {
+// ForwardRemote creates new p2p listener. Streams from peers not allowed by
+// acl are reset before the target is dialed, a nil acl allows every peer.
+func (p2p *P2P) ForwardRemote(ctx context.Context, proto protocol.ID, addr ma.Multiaddr, reportRemote bool, acl *ACL) (Listener, error) {
 	listener := &remoteListener{
 		p2p: p2p,
 
@@ -36,6 +45,7 @@ func (p2p *P2P) ForwardRemote(ctx context.Context, proto protocol.ID, addr ma.Mu
 		addr:  addr,
 
 		reportRemote: reportRemote,
+		acl:          acl,
 	}
 
 	if err := p2p.ListenersP2P.Register(listener); err != nil {
@@ -46,14 +56,24 @@ func (p2p *P2P) ForwardRemote(ctx context.Context, proto protocol.ID, addr ma.Mu
 }
 
 func (l *remoteListener) handleStream(remote net.Stream) {
+	peer := remote.Conn().RemotePeer()
+
+	if !l.acl.Allowed(peer) {
+		atomic.AddUint64(&l.rejected, 1)
+		log.Debugf("rejected %s stream from %s", l.proto, peer)
+		_ = remote.Reset()
+		return
+	}
+
+	var local manet.Conn
 	local, err := manet.Dial(l.addr)
 	if err != nil {
 		_ = remote.Reset()
 		return
 	}
 
-	peer := remote.Conn().RemotePeer()
-
+	// written before any framing, so UDP targets get the peer ID as a
+	// datagram of its own
 	if l.reportRemote {
 		if _, err := fmt.Fprintf(local, "%s\n", peer.Pretty()); err != nil {
 			_ = remote.Reset()
@@ -61,6 +81,10 @@ func (l *remoteListener) handleStream(remote net.Stream) {
 		}
 	}
 
+	if isDatagramAddr(l.addr) {
+		local = newDatagramConn(local)
+	}
+
 	peerMa, err := ma.NewMultiaddr(maPrefix + peer.Pretty())
 	if err != nil {
 		_ = remote.Reset()
@@ -99,6 +123,11 @@ func (l *remoteListener) TargetAddress() ma.Multiaddr {
 	return l.addr
 }
 
+// Rejected returns the number of streams rejected by the listener's ACL.
+func (l *remoteListener) Rejected() uint64 {
+	return atomic.LoadUint64(&l.rejected)
+}
+
 func (l *remoteListener) close() {}
 
 func (l *remoteListener) key() string {
@@ -0,0 +1,91 @@
+package peering
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// groupResolveTimeout bounds the resolution of a single peering group.
+const groupResolveTimeout = time.Minute
+
+// ResolveGroupFunc resolves the members of a peering group.
+type ResolveGroupFunc func(ctx context.Context, group string) ([]peer.AddrInfo, error)
+
+// GroupResolver keeps the peers of peering groups, like a DNS record or an
+// IPNS published list, in a peering service. The groups are resolved when
+// started and then periodically; a group that fails to resolve keeps its
+// previous peers.
+type GroupResolver struct {
+	ps       *PeeringService
+	groups   []string
+	interval time.Duration
+	resolve  ResolveGroupFunc
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewGroupResolver constructs a resolver keeping the peers of groups in ps,
+// resolving them every interval.
+func NewGroupResolver(ps *PeeringService, groups []string, interval time.Duration, resolve ResolveGroupFunc) *GroupResolver {
+	g := &GroupResolver{
+		ps:       ps,
+		groups:   groups,
+		interval: interval,
+		resolve:  resolve,
+	}
+	g.ctx, g.cancel = context.WithCancel(context.Background())
+	return g
+}
+
+// Start resolves the groups in the background, then every interval.
+func (g *GroupResolver) Start() {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+
+		ticker := time.NewTicker(g.interval)
+		defer ticker.Stop()
+		for {
+			g.ResolveAll()
+			select {
+			case <-ticker.C:
+			case <-g.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops resolving the groups. Their peers stay in the peering service.
+func (g *GroupResolver) Stop() {
+	g.cancel()
+	g.wg.Wait()
+}
+
+// ResolveAll resolves all the groups once, updating their peers.
+func (g *GroupResolver) ResolveAll() {
+	for _, group := range g.groups {
+		ctx, cancel := context.WithTimeout(g.ctx, groupResolveTimeout)
+		peers, err := g.resolve(ctx, group)
+		cancel()
+		if err != nil {
+			logger.Warnw("failed to resolve peering group", "group", group, "error", err)
+			continue
+		}
+		// a node usually finds itself in the groups it is a member of
+		self := g.ps.host.ID()
+		members := peers[:0:0]
+		for _, p := range peers {
+			if p.ID != self {
+				members = append(members, p)
+			}
+		}
+		logger.Debugw("resolved peering group", "group", group, "peers", len(members))
+		g.ps.SetSourcePeers(group, members)
+	}
+}
@@ -0,0 +1,83 @@
+package peering
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/test"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetSourcePeers(t *testing.T) {
+	ps := NewPeeringService(newNode(t))
+	p1, p2, p3 := test.RandPeerIDFatal(t), test.RandPeerIDFatal(t), test.RandPeerIDFatal(t)
+
+	ps.AddPeerFrom(SourceConfig, peer.AddrInfo{ID: p1})
+	ps.SetSourcePeers("group", []peer.AddrInfo{{ID: p1}, {ID: p2}})
+	require.Equal(t, []string{SourceConfig, "group"}, ps.Sources(p1))
+	require.Equal(t, []string{"group"}, ps.Sources(p2))
+
+	// peers no longer listed by the group are removed, unless listed by
+	// another source
+	ps.SetSourcePeers("group", []peer.AddrInfo{{ID: p3}})
+	require.Equal(t, []string{SourceConfig}, ps.Sources(p1))
+	require.Nil(t, ps.Sources(p2))
+	require.Equal(t, []string{"group"}, ps.Sources(p3))
+	require.Len(t, ps.ListPeers(), 2)
+
+	ps.AddPeer(peer.AddrInfo{ID: p3})
+	require.Equal(t, []string{"group", SourceManual}, ps.Sources(p3))
+	ps.RemovePeer(p3)
+	require.Nil(t, ps.Sources(p3))
+}
+
+func TestGroupResolver(t *testing.T) {
+	h := newNode(t)
+	ps := NewPeeringService(h)
+	p1, p2 := test.RandPeerIDFatal(t), test.RandPeerIDFatal(t)
+
+	var mu sync.Mutex
+	members := map[string][]peer.AddrInfo{
+		"/dnsaddr/a.example.com": {{ID: p1}, {ID: h.ID()}},
+		"/dnsaddr/b.example.com": {{ID: p2}},
+	}
+	var failing bool
+	resolve := func(ctx context.Context, group string) ([]peer.AddrInfo, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if failing {
+			return nil, errors.New("unavailable")
+		}
+		return members[group], nil
+	}
+
+	g := NewGroupResolver(ps, []string{"/dnsaddr/a.example.com", "/dnsaddr/b.example.com"}, 10*time.Millisecond, resolve)
+	g.Start()
+	defer g.Stop()
+	require.Eventually(t, func() bool {
+		return len(ps.ListPeers()) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, []string{"/dnsaddr/a.example.com"}, ps.Sources(p1))
+	// the node doesn't peer with itself
+	require.Nil(t, ps.Sources(h.ID()))
+
+	// membership changes are picked up
+	mu.Lock()
+	members["/dnsaddr/b.example.com"] = nil
+	mu.Unlock()
+	require.Eventually(t, func() bool {
+		return ps.Sources(p2) == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// and the peers are kept while resolving fails
+	mu.Lock()
+	failing = true
+	mu.Unlock()
+	g.ResolveAll()
+	require.Equal(t, []string{"/dnsaddr/a.example.com"}, ps.Sources(p1))
+}
@@ -0,0 +1,140 @@
+package peering
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// maxPeerEvents bounds the connection history kept for each peer.
+const maxPeerEvents = 16
+
+// Connection events of the history of a peer.
+const (
+	EventConnected    = "connected"
+	EventDisconnected = "disconnected"
+)
+
+// PeerEvent is a change of the connection state of a peer.
+type PeerEvent struct {
+	Time  time.Time
+	Event string
+}
+
+// PeerStat reports the connection health of a peer of the peering service.
+type PeerStat struct {
+	ID        peer.ID
+	Connected bool
+	// ConnectedSince is when the current connection was established, zero
+	// when disconnected.
+	ConnectedSince time.Time
+	// Uptime is the total time connected since the peer was added.
+	Uptime time.Duration
+	// Disconnects counts the connections to the peer that were lost.
+	Disconnects uint64
+	// ReconnectAttempts counts the attempts to reconnect to the peer.
+	ReconnectAttempts uint64
+	// LastError is the error of the last failed reconnect attempt.
+	LastError     string
+	LastErrorTime time.Time
+	// Latency is the moving average of the latency to the peer.
+	Latency time.Duration
+	// Backoff is the current delay between reconnect attempts, and
+	// NextAttempt when the next one happens. Both are zero when no reconnect
+	// is pending.
+	Backoff     time.Duration
+	NextAttempt time.Time
+	// History lists the last connection state changes, oldest first.
+	History []PeerEvent
+}
+
+// peerHealth is the connection health of a peer, guarded by its handler lock.
+type peerHealth struct {
+	connected      bool
+	connectedSince time.Time
+	uptime         time.Duration
+	disconnects    uint64
+	attempts       uint64
+	lastError      string
+	lastErrorTime  time.Time
+	nextAttempt    time.Time
+	history        []PeerEvent
+}
+
+func (h *peerHealth) record(event string, now time.Time) {
+	if len(h.history) == maxPeerEvents {
+		copy(h.history, h.history[1:])
+		h.history = h.history[:maxPeerEvents-1]
+	}
+	h.history = append(h.history, PeerEvent{Time: now, Event: event})
+}
+
+// updateConnectedness records a change of the connection state of the peer.
+// The handler must be locked.
+func (ph *peerHandler) updateConnectedness() {
+	connected := ph.host.Network().Connectedness(ph.peer) == network.Connected
+	h := &ph.health
+	if connected == h.connected {
+		return
+	}
+
+	now := time.Now()
+	if connected {
+		h.connectedSince = now
+		h.record(EventConnected, now)
+	} else {
+		h.uptime += now.Sub(h.connectedSince)
+		h.connectedSince = time.Time{}
+		h.disconnects++
+		h.record(EventDisconnected, now)
+	}
+	h.connected = connected
+}
+
+// scheduleReconnect records when the reconnect timer fires. The handler must
+// be locked.
+func (ph *peerHandler) scheduleReconnect(delay time.Duration) {
+	ph.health.nextAttempt = time.Now().Add(delay)
+}
+
+func (ph *peerHandler) stat() PeerStat {
+	ph.mu.Lock()
+	defer ph.mu.Unlock()
+	ph.updateConnectedness()
+
+	h := &ph.health
+	s := PeerStat{
+		ID:                ph.peer,
+		Connected:         h.connected,
+		ConnectedSince:    h.connectedSince,
+		Uptime:            h.uptime,
+		Disconnects:       h.disconnects,
+		ReconnectAttempts: h.attempts,
+		LastError:         h.lastError,
+		LastErrorTime:     h.lastErrorTime,
+		Latency:           ph.host.Peerstore().LatencyEWMA(ph.peer),
+		History:           append([]PeerEvent(nil), h.history...),
+	}
+	if h.connected {
+		s.Uptime += time.Since(h.connectedSince)
+	}
+	if ph.reconnectTimer != nil {
+		s.Backoff = ph.nextDelay
+		s.NextAttempt = h.nextAttempt
+	}
+	return s
+}
+
+// ListPeerStats reports the connection health of the peers in the peering
+// service.
+func (ps *PeeringService) ListPeerStats() []PeerStat {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	out := make([]PeerStat, 0, len(ps.peers))
+	for _, handler := range ps.peers {
+		out = append(out, handler.stat())
+	}
+	return out
+}
@@ -0,0 +1,83 @@
+package peering
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/test"
+	"github.com/multiformats/go-multiaddr"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeerStats(t *testing.T) {
+	h1 := newNode(t)
+	h2 := newNode(t)
+	ps := NewPeeringService(h1)
+	require.NoError(t, ps.Start())
+	defer ps.Stop()
+
+	stat := func(id peer.ID) PeerStat {
+		for _, s := range ps.ListPeerStats() {
+			if s.ID == id {
+				return s
+			}
+		}
+		t.Fatalf("no stats for %s", id)
+		return PeerStat{}
+	}
+
+	// a connected peer
+	ps.AddPeer(peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()})
+	require.NoError(t, h1.Connect(context.Background(), peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}))
+	require.Eventually(t, func() bool {
+		return stat(h2.ID()).Connected
+	}, 5*time.Second, 10*time.Millisecond)
+	s := stat(h2.ID())
+	require.False(t, s.ConnectedSince.IsZero())
+	require.Zero(t, s.Disconnects)
+	require.Len(t, s.History, 1)
+	require.Equal(t, EventConnected, s.History[0].Event)
+
+	// losing the connection is recorded, and a reconnect scheduled
+	require.NoError(t, h1.Network().ClosePeer(h2.ID()))
+	require.Eventually(t, func() bool {
+		s := stat(h2.ID())
+		return !s.Connected && s.Backoff > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	s = stat(h2.ID())
+	require.Equal(t, uint64(1), s.Disconnects)
+	require.Greater(t, s.Uptime, time.Duration(0))
+	require.True(t, s.ConnectedSince.IsZero())
+	require.True(t, s.NextAttempt.After(time.Now()))
+	require.Equal(t, EventDisconnected, s.History[1].Event)
+
+	// failed reconnect attempts are recorded
+	unreachable := test.RandPeerIDFatal(t)
+	ps.AddPeer(peer.AddrInfo{ID: unreachable, Addrs: []multiaddr.Multiaddr{multiaddr.StringCast("/ip4/127.0.0.1/tcp/1")}})
+	require.Eventually(t, func() bool {
+		return stat(unreachable).Backoff > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	ps.mu.RLock()
+	handler := ps.peers[unreachable]
+	ps.mu.RUnlock()
+	handler.reconnect()
+	s = stat(unreachable)
+	require.Equal(t, uint64(1), s.ReconnectAttempts)
+	require.NotEmpty(t, s.LastError)
+	require.Empty(t, s.History)
+	require.Equal(t, network.NotConnected, h1.Network().Connectedness(unreachable))
+}
+
+func TestPeerEventHistory(t *testing.T) {
+	var h peerHealth
+	now := time.Now()
+	for i := 0; i < 2*maxPeerEvents; i++ {
+		h.record(EventConnected, now.Add(time.Duration(i)))
+	}
+	require.Len(t, h.history, maxPeerEvents)
+	require.Equal(t, now.Add(maxPeerEvents), h.history[0].Time)
+}
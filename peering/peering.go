@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"math/rand"
+	"sort"
 	"strconv"
 	"sync"
 	"time"
@@ -33,6 +34,15 @@ const (
 
 var logger = log.Logger("peering")
 
+// Sources of the peers of the peering service. Peering groups use their own
+// name as source.
+const (
+	// SourceConfig is the source of the peers listed in Peering.Peers.
+	SourceConfig = "config"
+	// SourceManual is the source of the peers added with AddPeer.
+	SourceManual = "manual"
+)
+
 type State uint
 
 func (s State) String() string {
@@ -65,7 +75,11 @@ type peerHandler struct {
 	addrs          []multiaddr.Multiaddr
 	reconnectTimer *time.Timer
 
+	// sources the peer was added from, guarded by the PeeringService lock
+	sources map[string]struct{}
+
 	nextDelay time.Duration
+	health    peerHealth
 }
 
 // setAddrs sets the addresses for this peer.
@@ -119,19 +133,24 @@ func (ph *peerHandler) reconnect() {
 	logger.Debugw("reconnecting", "peer", ph.peer, "addrs", addrs)
 
 	err := ph.host.Connect(ph.ctx, peer.AddrInfo{ID: ph.peer, Addrs: addrs})
+	ph.mu.Lock()
+	ph.health.attempts++
 	if err != nil {
 		logger.Debugw("failed to reconnect", "peer", ph.peer, "error", err)
+		ph.health.lastError = err.Error()
+		ph.health.lastErrorTime = time.Now()
 		// Ok, we failed. Extend the timeout.
-		ph.mu.Lock()
 		if ph.reconnectTimer != nil {
 			// Only counts if the reconnectTimer still exists. If not, a
 			// connection _was_ somehow established.
-			ph.reconnectTimer.Reset(ph.nextBackoff())
+			delay := ph.nextBackoff()
+			ph.reconnectTimer.Reset(delay)
+			ph.scheduleReconnect(delay)
 		}
 		// Otherwise, someone else has stopped us so we can assume that
 		// we're either connected or someone else will start us.
-		ph.mu.Unlock()
 	}
+	ph.mu.Unlock()
 
 	// Always call this. We could have connected since we processed the
 	// error.
@@ -141,6 +160,7 @@ func (ph *peerHandler) reconnect() {
 func (ph *peerHandler) stopIfConnected() {
 	ph.mu.Lock()
 	defer ph.mu.Unlock()
+	ph.updateConnectedness()
 
 	if ph.reconnectTimer != nil && ph.host.Network().Connectedness(ph.peer) == network.Connected {
 		logger.Debugw("successfully reconnected", "peer", ph.peer)
@@ -154,11 +174,14 @@ func (ph *peerHandler) stopIfConnected() {
 func (ph *peerHandler) startIfDisconnected() {
 	ph.mu.Lock()
 	defer ph.mu.Unlock()
+	ph.updateConnectedness()
 
 	if ph.reconnectTimer == nil && ph.host.Network().Connectedness(ph.peer) != network.Connected {
 		logger.Debugw("disconnected from peer", "peer", ph.peer)
 		// Always start with a short timeout so we can stagger things a bit.
-		ph.reconnectTimer = time.AfterFunc(ph.nextBackoff(), ph.reconnect)
+		delay := ph.nextBackoff()
+		ph.reconnectTimer = time.AfterFunc(delay, ph.reconnect)
+		ph.scheduleReconnect(delay)
 	}
 }
 
@@ -232,14 +255,25 @@ func (ps *PeeringService) Stop() error {
 // Add peer may also be called multiple times for the same peer. The new
 // addresses will replace the old.
 func (ps *PeeringService) AddPeer(info peer.AddrInfo) {
+	ps.AddPeerFrom(SourceManual, info)
+}
+
+// AddPeerFrom adds a peer to the peering service like AddPeer, recording the
+// source it was added from. A peer stays in the peering service as long as
+// one of its sources lists it.
+func (ps *PeeringService) AddPeerFrom(source string, info peer.AddrInfo) {
 	ps.mu.Lock()
 	defer ps.mu.Unlock()
+	ps.addPeer(source, info)
+}
 
+func (ps *PeeringService) addPeer(source string, info peer.AddrInfo) {
 	if handler, ok := ps.peers[info.ID]; ok {
-		logger.Infow("updating addresses", "peer", info.ID, "addrs", info.Addrs)
+		logger.Infow("updating addresses", "peer", info.ID, "addrs", info.Addrs, "source", source)
 		handler.setAddrs(info.Addrs)
+		handler.sources[source] = struct{}{}
 	} else {
-		logger.Infow("peer added", "peer", info.ID, "addrs", info.Addrs)
+		logger.Infow("peer added", "peer", info.ID, "addrs", info.Addrs, "source", source)
 		ps.host.ConnManager().Protect(info.ID, connmgrTag)
 
 		handler = &peerHandler{
@@ -247,6 +281,7 @@ func (ps *PeeringService) AddPeer(info peer.AddrInfo) {
 			peer:      info.ID,
 			addrs:     info.Addrs,
 			nextDelay: initialDelay,
+			sources:   map[string]struct{}{source: {}},
 		}
 		handler.ctx, handler.cancel = context.WithCancel(context.Background())
 		ps.peers[info.ID] = handler
@@ -262,6 +297,32 @@ func (ps *PeeringService) AddPeer(info peer.AddrInfo) {
 	}
 }
 
+// SetSourcePeers replaces the peers of source: the listed peers are added,
+// and the peers it listed before but no longer does are removed unless
+// another source lists them.
+func (ps *PeeringService) SetSourcePeers(source string, infos []peer.AddrInfo) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	listed := make(map[peer.ID]struct{}, len(infos))
+	for _, info := range infos {
+		listed[info.ID] = struct{}{}
+		ps.addPeer(source, info)
+	}
+	for id, handler := range ps.peers {
+		if _, ok := handler.sources[source]; !ok {
+			continue
+		}
+		if _, ok := listed[id]; ok {
+			continue
+		}
+		delete(handler.sources, source)
+		if len(handler.sources) == 0 {
+			ps.removePeer(id)
+		}
+	}
+}
+
 // ListPeers lists peers in the peering service.
 func (ps *PeeringService) ListPeers() []peer.AddrInfo {
 	ps.mu.RLock()
@@ -276,13 +337,34 @@ func (ps *PeeringService) ListPeers() []peer.AddrInfo {
 	return out
 }
 
-// RemovePeer removes a peer from the peering service. This function may be
-// safely called at any time: before the service is started, while running, or
-// after it stops.
+// Sources returns the sorted sources peer id was added from, or nil when it
+// isn't in the peering service.
+func (ps *PeeringService) Sources(id peer.ID) []string {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	handler, ok := ps.peers[id]
+	if !ok {
+		return nil
+	}
+	sources := make([]string, 0, len(handler.sources))
+	for source := range handler.sources {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	return sources
+}
+
+// RemovePeer removes a peer from the peering service, whatever its sources.
+// This function may be safely called at any time: before the service is
+// started, while running, or after it stops.
 func (ps *PeeringService) RemovePeer(id peer.ID) {
 	ps.mu.Lock()
 	defer ps.mu.Unlock()
+	ps.removePeer(id)
+}
 
+func (ps *PeeringService) removePeer(id peer.ID) {
 	if handler, ok := ps.peers[id]; ok {
 		logger.Infow("peer removed", "peer", id)
 		ps.host.ConnManager().Unprotect(id, connmgrTag)
@@ -15,6 +15,7 @@ import (
 	"github.com/ipfs/kubo/core"
 	"github.com/ipfs/kubo/core/coreapi"
 	plugin "github.com/ipfs/kubo/plugin"
+	"github.com/ipfs/kubo/pubsub/validate"
 	fsrepo "github.com/ipfs/kubo/repo/fsrepo"
 
 	logging "github.com/ipfs/go-log"
@@ -262,6 +263,13 @@ func (loader *PluginLoader) Inject() error {
 				return err
 			}
 		}
+		if pl, ok := pl.(plugin.PluginPubsubValidator); ok {
+			err := injectPubsubValidatorPlugin(pl)
+			if err != nil {
+				loader.state = loaderFailed
+				return err
+			}
+		}
 	}
 
 	return loader.transition(loaderInjecting, loaderInjected)
@@ -334,6 +342,10 @@ func injectDatastorePlugin(pl plugin.PluginDatastore) error {
 	return fsrepo.AddDatastoreConfigHandler(pl.DatastoreTypeName(), pl.DatastoreConfigParser())
 }
 
+func injectPubsubValidatorPlugin(pl plugin.PluginPubsubValidator) error {
+	return validate.AddValidator(pl.PubsubValidatorName(), pl.ValidatePubsubMessage)
+}
+
 func injectIPLDPlugin(pl plugin.PluginIPLD) error {
 	return pl.Register(multicodec.DefaultRegistry)
 }
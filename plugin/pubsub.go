@@ -0,0 +1,24 @@
+package plugin
+
+import (
+	"context"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+)
+
+// PluginPubsubValidator is an interface that can be implemented to validate
+// pubsub messages before they are delivered or forwarded. The validator is
+// applied to the topics listing its name in
+// Pubsub.TopicPolicies["topic"].Validators.
+type PluginPubsubValidator interface {
+	Plugin
+
+	// PubsubValidatorName returns the name topic policies refer to the
+	// validator by.
+	PubsubValidatorName() string
+
+	// ValidatePubsubMessage decides whether msg, received from peer from, is
+	// accepted, rejected or ignored.
+	ValidatePubsubMessage(ctx context.Context, from peer.ID, msg *pubsub.Message) pubsub.ValidationResult
+}
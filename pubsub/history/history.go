@@ -0,0 +1,225 @@
+// Package history implements daemon-managed pubsub subscriptions whose
+// messages are recorded in the repo, so that clients can replay what they
+// missed while disconnected.
+package history
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	logging "github.com/ipfs/go-log"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+)
+
+var log = logging.Logger("pubsub-history")
+
+// ErrNotSubscribed is returned when a topic has no managed subscription.
+var ErrNotSubscribed = errors.New("topic has no managed subscription")
+
+// Position selects the first message of a replay.
+type Position struct {
+	// After replays messages with an index strictly greater than After.
+	After uint64
+	// Since, when set, replays messages received at or after Since instead.
+	Since time.Time
+}
+
+// ParsePosition parses a replay position. It accepts a history index, an
+// RFC3339 timestamp or a duration (e.g. "15m"), the latter being relative to
+// now.
+func ParsePosition(s string) (Position, error) {
+	if idx, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return Position{After: idx}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return Position{Since: t}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return Position{Since: time.Now().Add(-d)}, nil
+	}
+	return Position{}, fmt.Errorf("invalid history position %q: expected an index, an RFC3339 time or a duration", s)
+}
+
+type managedTopic struct {
+	log    *topicLog
+	sub    *pubsub.Subscription
+	cancel context.CancelFunc
+}
+
+// Service manages long-lived pubsub subscriptions and records the messages
+// received on them in a bounded per-topic history.
+type Service struct {
+	ps     *pubsub.PubSub
+	dstore ds.Batching
+	size   uint64
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	// wg tracks the goroutines recording messages
+	wg sync.WaitGroup
+
+	mu     sync.Mutex
+	topics map[string]*managedTopic
+}
+
+// New creates a history service keeping up to size messages per topic in
+// dstore.
+func New(ps *pubsub.PubSub, dstore ds.Batching, size uint64) *Service {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Service{
+		ps:     ps,
+		dstore: dstore,
+		size:   size,
+		ctx:    ctx,
+		cancel: cancel,
+		topics: make(map[string]*managedTopic),
+	}
+}
+
+// Subscribe starts a managed subscription to topic. It is a no-op if the
+// topic is already subscribed.
+func (s *Service) Subscribe(topic string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ctx.Err() != nil {
+		return errors.New("pubsub history service is closed")
+	}
+	if _, ok := s.topics[topic]; ok {
+		return nil
+	}
+
+	l, err := openTopicLog(s.ctx, s.dstore, topic, s.size)
+	if err != nil {
+		return err
+	}
+	sub, err := s.ps.Subscribe(topic)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	mt := &managedTopic{log: l, sub: sub, cancel: cancel}
+	s.topics[topic] = mt
+	s.wg.Add(1)
+	go s.record(ctx, topic, mt)
+	return nil
+}
+
+func (s *Service) record(ctx context.Context, topic string, mt *managedTopic) {
+	defer s.wg.Done()
+	defer mt.sub.Cancel()
+	for {
+		msg, err := mt.sub.Next(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Errorw("managed subscription ended", "topic", topic, "error", err)
+			}
+			return
+		}
+
+		err = mt.log.append(ctx, &Message{
+			From:     msg.GetFrom(),
+			Data:     msg.GetData(),
+			Seqno:    msg.GetSeqno(),
+			Topics:   []string{msg.GetTopic()},
+			Received: time.Now(),
+		})
+		if err != nil && ctx.Err() == nil {
+			log.Errorw("failed to record message", "topic", topic, "error", err)
+		}
+	}
+}
+
+// Unsubscribe stops the managed subscription to topic. The recorded history
+// is removed as well when purge is true.
+func (s *Service) Unsubscribe(topic string, purge bool) error {
+	s.mu.Lock()
+	mt, ok := s.topics[topic]
+	delete(s.topics, topic)
+	s.mu.Unlock()
+
+	if !ok {
+		return ErrNotSubscribed
+	}
+	mt.cancel()
+
+	if purge {
+		return mt.log.purge(s.ctx)
+	}
+	return nil
+}
+
+// Topics lists the topics with a managed subscription.
+func (s *Service) Topics() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	topics := make([]string, 0, len(s.topics))
+	for t := range s.topics {
+		topics = append(topics, t)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// Stream sends the recorded messages of topic starting at pos, then keeps
+// sending new messages as they are recorded until ctx is canceled. The
+// returned channel is closed when streaming stops.
+func (s *Service) Stream(ctx context.Context, topic string, pos Position) (<-chan *Message, error) {
+	s.mu.Lock()
+	mt, ok := s.topics[topic]
+	s.mu.Unlock()
+	if !ok {
+		return nil, ErrNotSubscribed
+	}
+
+	out := make(chan *Message)
+	go func() {
+		defer close(out)
+
+		after := pos.After
+		for {
+			msgs, notify, err := mt.log.read(ctx, after)
+			if err != nil {
+				log.Errorw("failed to read history", "topic", topic, "error", err)
+				return
+			}
+			for _, msg := range msgs {
+				after = msg.Index
+				if !pos.Since.IsZero() && msg.Received.Before(pos.Since) {
+					continue
+				}
+				select {
+				case out <- msg:
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case <-notify:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// Close stops all managed subscriptions and waits for the messages being
+// recorded to be written. Recorded history is kept.
+func (s *Service) Close() error {
+	s.mu.Lock()
+	s.cancel()
+	s.topics = make(map[string]*managedTopic)
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return nil
+}
@@ -0,0 +1,130 @@
+package history
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+)
+
+func appendN(t *testing.T, l *topicLog, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		err := l.append(context.Background(), &Message{Data: []byte(fmt.Sprint(i)), Received: time.Now()})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTopicLogRing(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+
+	l, err := openTopicLog(ctx, dstore, "a/topic", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendN(t, l, 5)
+
+	msgs, _, err := l.read(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 3 || msgs[0].Index != 3 || msgs[2].Index != 5 {
+		t.Fatalf("unexpected messages: %v", msgs)
+	}
+
+	msgs, _, err = l.read(ctx, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || string(msgs[0].Data) != "4" {
+		t.Fatalf("unexpected messages: %v", msgs)
+	}
+
+	// reopening with a smaller size keeps numbering and trims old entries
+	l, err = openTopicLog(ctx, dstore, "a/topic", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendN(t, l, 1)
+	msgs, _, err = l.read(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 || msgs[0].Index != 5 || msgs[1].Index != 6 {
+		t.Fatalf("unexpected messages after reopen: %v", msgs)
+	}
+
+	if err := l.purge(ctx); err != nil {
+		t.Fatal(err)
+	}
+	msgs, _, err = l.read(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 0 {
+		t.Fatalf("expected no messages after purge, got %d", len(msgs))
+	}
+}
+
+func TestStreamReplaysThenFollows(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	l, err := openTopicLog(ctx, dssync.MutexWrap(ds.NewMapDatastore()), "t", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendN(t, l, 3)
+
+	s := &Service{topics: map[string]*managedTopic{"t": {log: l}}}
+	if _, err := s.Stream(ctx, "other", Position{}); err != ErrNotSubscribed {
+		t.Fatalf("expected ErrNotSubscribed, got %v", err)
+	}
+
+	out, err := s.Stream(ctx, "t", Position{After: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for want := uint64(2); want <= 3; want++ {
+		if msg := <-out; msg.Index != want {
+			t.Fatalf("expected index %d, got %d", want, msg.Index)
+		}
+	}
+
+	appendN(t, l, 1)
+	select {
+	case msg := <-out:
+		if msg.Index != 4 {
+			t.Fatalf("expected index 4, got %d", msg.Index)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for live message")
+	}
+}
+
+func TestParsePosition(t *testing.T) {
+	pos, err := ParsePosition("42")
+	if err != nil || pos.After != 42 || !pos.Since.IsZero() {
+		t.Fatalf("unexpected position %v (err %v)", pos, err)
+	}
+
+	pos, err = ParsePosition("2022-08-01T10:00:00Z")
+	if err != nil || pos.Since.IsZero() {
+		t.Fatalf("unexpected position %v (err %v)", pos, err)
+	}
+
+	pos, err = ParsePosition("1h")
+	if err != nil || time.Since(pos.Since) < time.Hour {
+		t.Fatalf("unexpected position %v (err %v)", pos, err)
+	}
+
+	if _, err := ParsePosition("yesterday"); err == nil {
+		t.Fatal("expected an error")
+	}
+}
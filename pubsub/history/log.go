@@ -0,0 +1,211 @@
+package history
+
+import (
+	"context"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// historyPrefix is the datastore namespace all topic histories live under.
+var historyPrefix = ds.NewKey("/pubsub/history")
+
+// topicEncoding encodes topic names into datastore-safe key segments.
+var topicEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Message is a pubsub message recorded in the history of a topic.
+type Message struct {
+	// Index is the position of the message in the topic history. It is
+	// assigned locally, starts at 1 and increases by one for every recorded
+	// message.
+	Index uint64
+
+	From     peer.ID `json:",omitempty"`
+	Data     []byte
+	Seqno    []byte
+	Topics   []string
+	Received time.Time
+}
+
+// topicLog is a bounded ring of messages kept in the datastore for a single
+// topic. Only the last size messages are retained.
+type topicLog struct {
+	dstore ds.Batching
+	prefix ds.Key
+	size   uint64
+
+	mu     sync.Mutex
+	next   uint64
+	notify chan struct{}
+}
+
+func openTopicLog(ctx context.Context, dstore ds.Batching, topic string, size uint64) (*topicLog, error) {
+	if size == 0 {
+		return nil, fmt.Errorf("history size must be positive")
+	}
+
+	l := &topicLog{
+		dstore: dstore,
+		prefix: historyPrefix.ChildString(topicEncoding.EncodeToString([]byte(topic))),
+		size:   size,
+		next:   1,
+		notify: make(chan struct{}),
+	}
+
+	head, err := dstore.Get(ctx, l.headKey())
+	switch err {
+	case nil:
+		if len(head) != 8 {
+			return nil, fmt.Errorf("corrupt history head for topic %q", topic)
+		}
+		l.next = binary.BigEndian.Uint64(head)
+	case ds.ErrNotFound:
+	default:
+		return nil, err
+	}
+
+	// The configured size may have shrunk since the log was written.
+	if err := l.trim(ctx, l.first()); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *topicLog) headKey() ds.Key {
+	return l.prefix.ChildString("head")
+}
+
+func (l *topicLog) msgKey(index uint64) ds.Key {
+	// zero padded so that keys sort in index order
+	return l.prefix.ChildString("msgs").ChildString(fmt.Sprintf("%020d", index))
+}
+
+// first returns the index of the oldest message that may still be retained.
+func (l *topicLog) first() uint64 {
+	if l.next <= l.size {
+		return 1
+	}
+	return l.next - l.size
+}
+
+// trim deletes all messages with an index lower than first.
+func (l *topicLog) trim(ctx context.Context, first uint64) error {
+	res, err := l.dstore.Query(ctx, dsq.Query{
+		Prefix:   l.prefix.ChildString("msgs").String(),
+		KeysOnly: true,
+	})
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	b, err := l.dstore.Batch(ctx)
+	if err != nil {
+		return err
+	}
+	for r := range res.Next() {
+		if r.Error != nil {
+			return r.Error
+		}
+		k := ds.RawKey(r.Key)
+		idx, err := strconv.ParseUint(k.BaseNamespace(), 10, 64)
+		if err != nil || idx < first {
+			if err := b.Delete(ctx, k); err != nil {
+				return err
+			}
+		}
+	}
+	return b.Commit(ctx)
+}
+
+// append records msg at the head of the log, assigning its Index and evicting
+// the oldest message if the log is full.
+func (l *topicLog) append(ctx context.Context, msg *Message) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	msg.Index = l.next
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	var head [8]byte
+	binary.BigEndian.PutUint64(head[:], l.next+1)
+
+	b, err := l.dstore.Batch(ctx)
+	if err != nil {
+		return err
+	}
+	if err := b.Put(ctx, l.msgKey(msg.Index), data); err != nil {
+		return err
+	}
+	if msg.Index > l.size {
+		if err := b.Delete(ctx, l.msgKey(msg.Index-l.size)); err != nil {
+			return err
+		}
+	}
+	if err := b.Put(ctx, l.headKey(), head[:]); err != nil {
+		return err
+	}
+	if err := b.Commit(ctx); err != nil {
+		return err
+	}
+
+	l.next++
+	close(l.notify)
+	l.notify = make(chan struct{})
+	return nil
+}
+
+// read returns the retained messages with an index greater than after, along
+// with a channel that is closed once a newer message has been appended.
+func (l *topicLog) read(ctx context.Context, after uint64) ([]*Message, <-chan struct{}, error) {
+	l.mu.Lock()
+	next, notify := l.next, l.notify
+	from := l.first()
+	l.mu.Unlock()
+
+	if after+1 > from {
+		from = after + 1
+	}
+
+	var msgs []*Message
+	for idx := from; idx < next; idx++ {
+		data, err := l.dstore.Get(ctx, l.msgKey(idx))
+		if err == ds.ErrNotFound {
+			// evicted while we were reading
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		msg := new(Message)
+		if err := json.Unmarshal(data, msg); err != nil {
+			return nil, nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, notify, nil
+}
+
+// purge removes every message of the log along with its head.
+func (l *topicLog) purge(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.trim(ctx, math.MaxUint64); err != nil {
+		return err
+	}
+	l.next = 1
+	return l.dstore.Delete(ctx, l.headKey())
+}
@@ -0,0 +1,72 @@
+package validate
+
+import (
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// idleBucketTTL is how long an untouched bucket is kept before being
+// forgotten. Any bucket idle for more than a second is full again, so
+// dropping it doesn't change the outcome.
+const idleBucketTTL = time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a token bucket per peer refilled at rate tokens per second,
+// holding at most rate tokens.
+type rateLimiter struct {
+	rate float64
+	now  func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[peer.ID]*bucket
+	lastSweep time.Time
+}
+
+func newRateLimiter(rate int) *rateLimiter {
+	return &rateLimiter{
+		rate:    float64(rate),
+		now:     time.Now,
+		buckets: make(map[peer.ID]*bucket),
+	}
+}
+
+// allow consumes a token from the bucket of p, reporting whether one was
+// available.
+func (rl *rateLimiter) allow(p peer.ID) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+	if now.Sub(rl.lastSweep) > idleBucketTTL {
+		for id, b := range rl.buckets {
+			if now.Sub(b.last) > idleBucketTTL {
+				delete(rl.buckets, id)
+			}
+		}
+		rl.lastSweep = now
+	}
+
+	b, ok := rl.buckets[p]
+	if !ok {
+		b = &bucket{tokens: rl.rate, last: now}
+		rl.buckets[p] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * rl.rate
+	if b.tokens > rl.rate {
+		b.tokens = rl.rate
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,125 @@
+// Package validate enforces per-topic policies on pubsub messages before they
+// are delivered locally or forwarded to other peers.
+package validate
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	logging "github.com/ipfs/go-log"
+	"github.com/libp2p/go-libp2p-core/peer"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+)
+
+var log = logging.Logger("pubsub-validate")
+
+// Validator decides whether a message received from a peer is accepted,
+// rejected or ignored.
+type Validator func(ctx context.Context, from peer.ID, msg *pubsub.Message) pubsub.ValidationResult
+
+var (
+	validatorsMu sync.Mutex
+	validators   = map[string]Validator{}
+)
+
+// AddValidator registers a named validator that topic policies can refer to.
+func AddValidator(name string, v Validator) error {
+	validatorsMu.Lock()
+	defer validatorsMu.Unlock()
+
+	if _, ok := validators[name]; ok {
+		return fmt.Errorf("already have a pubsub validator named %q", name)
+	}
+	validators[name] = v
+	return nil
+}
+
+func getValidator(name string) (Validator, bool) {
+	validatorsMu.Lock()
+	defer validatorsMu.Unlock()
+
+	v, ok := validators[name]
+	return v, ok
+}
+
+// Policy describes the messages accepted on a topic. The zero value accepts
+// everything.
+type Policy struct {
+	// RequireSigning rejects messages that don't carry a signature.
+	RequireSigning bool
+	// AllowedPublishers, when not empty, rejects messages authored by any
+	// other peer.
+	AllowedPublishers []peer.ID
+	// MaxMessageSize rejects messages with a larger payload. Zero means no
+	// limit.
+	MaxMessageSize int
+	// PeerRateLimit is the number of messages per second accepted from each
+	// publisher, messages above the limit are ignored. Zero means no limit.
+	PeerRateLimit int
+	// Validators are the names of registered validators run, in order, on
+	// messages passing the checks above.
+	Validators []string
+}
+
+// New builds the validator enforcing p on a topic.
+func New(topic string, p Policy) (pubsub.ValidatorEx, error) {
+	extra := make([]Validator, 0, len(p.Validators))
+	for _, name := range p.Validators {
+		v, ok := getValidator(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown pubsub validator %q for topic %q", name, topic)
+		}
+		extra = append(extra, v)
+	}
+
+	var allowed map[peer.ID]struct{}
+	if len(p.AllowedPublishers) > 0 {
+		allowed = make(map[peer.ID]struct{}, len(p.AllowedPublishers))
+		for _, id := range p.AllowedPublishers {
+			allowed[id] = struct{}{}
+		}
+	}
+
+	var limiter *rateLimiter
+	if p.PeerRateLimit > 0 {
+		limiter = newRateLimiter(p.PeerRateLimit)
+	}
+
+	return func(ctx context.Context, from peer.ID, msg *pubsub.Message) pubsub.ValidationResult {
+		if p.MaxMessageSize > 0 && len(msg.GetData()) > p.MaxMessageSize {
+			log.Debugw("rejecting oversized message", "topic", topic, "from", from, "size", len(msg.GetData()))
+			return pubsub.ValidationReject
+		}
+		if p.RequireSigning && len(msg.GetSignature()) == 0 {
+			log.Debugw("rejecting unsigned message", "topic", topic, "from", from)
+			return pubsub.ValidationReject
+		}
+
+		author := msg.GetFrom()
+		if allowed != nil {
+			if _, ok := allowed[author]; !ok {
+				log.Debugw("rejecting message from unlisted publisher", "topic", topic, "from", from, "author", author)
+				return pubsub.ValidationReject
+			}
+		}
+		if limiter != nil {
+			if author == "" {
+				// unsigned messages without an author are accounted
+				// to the peer that sent them to us
+				author = from
+			}
+			if !limiter.allow(author) {
+				log.Debugw("ignoring rate limited message", "topic", topic, "from", from, "author", author)
+				return pubsub.ValidationIgnore
+			}
+		}
+
+		for _, v := range extra {
+			if res := v(ctx, from, msg); res != pubsub.ValidationAccept {
+				return res
+			}
+		}
+		return pubsub.ValidationAccept
+	}, nil
+}
@@ -0,0 +1,110 @@
+package validate
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	pb "github.com/libp2p/go-libp2p-pubsub/pb"
+)
+
+func message(author peer.ID, data string, signed bool) *pubsub.Message {
+	m := &pb.Message{From: []byte(author), Data: []byte(data)}
+	if signed {
+		m.Signature = []byte("sig")
+	}
+	return &pubsub.Message{Message: m}
+}
+
+func TestPolicy(t *testing.T) {
+	ctx := context.Background()
+	alice, bob := peer.ID("alice"), peer.ID("bob")
+
+	v, err := New("t", Policy{
+		RequireSigning:    true,
+		AllowedPublishers: []peer.ID{alice},
+		MaxMessageSize:    4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		msg  *pubsub.Message
+		want pubsub.ValidationResult
+	}{
+		{"valid", message(alice, "ok", true), pubsub.ValidationAccept},
+		{"unsigned", message(alice, "ok", false), pubsub.ValidationReject},
+		{"oversized", message(alice, "too big", true), pubsub.ValidationReject},
+		{"unlisted", message(bob, "ok", true), pubsub.ValidationReject},
+	} {
+		if res := v(ctx, bob, tc.msg); res != tc.want {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.want, res)
+		}
+	}
+}
+
+func TestPolicyValidators(t *testing.T) {
+	ctx := context.Background()
+
+	if _, err := New("t", Policy{Validators: []string{"missing"}}); err == nil {
+		t.Fatal("expected an error for an unknown validator")
+	}
+
+	err := AddValidator("no-bob", func(_ context.Context, _ peer.ID, msg *pubsub.Message) pubsub.ValidationResult {
+		if string(msg.GetData()) == "bob" {
+			return pubsub.ValidationIgnore
+		}
+		return pubsub.ValidationAccept
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := AddValidator("no-bob", nil); err == nil {
+		t.Fatal("expected an error registering a validator twice")
+	}
+
+	v, err := New("t", Policy{Validators: []string{"no-bob"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := v(ctx, "p", message("a", "alice", false)); res != pubsub.ValidationAccept {
+		t.Errorf("expected accept, got %v", res)
+	}
+	if res := v(ctx, "p", message("a", "bob", false)); res != pubsub.ValidationIgnore {
+		t.Errorf("expected ignore, got %v", res)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Now()
+	rl := newRateLimiter(2)
+	rl.now = func() time.Time { return now }
+
+	if !rl.allow("a") || !rl.allow("a") {
+		t.Fatal("expected the initial burst to be allowed")
+	}
+	if rl.allow("a") {
+		t.Fatal("expected the third message to be limited")
+	}
+	if !rl.allow("b") {
+		t.Fatal("expected other peers not to be limited")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if !rl.allow("a") {
+		t.Fatal("expected a token to be refilled")
+	}
+	if rl.allow("a") {
+		t.Fatal("expected the bucket to be empty again")
+	}
+
+	now = now.Add(2 * idleBucketTTL)
+	rl.allow("c")
+	if _, ok := rl.buckets["a"]; ok {
+		t.Fatal("expected idle buckets to be swept")
+	}
+}
@@ -0,0 +1,646 @@
+// Package compression implements a datastore wrapper compressing the values
+// it writes to its child datastore. Values which do not compress, judging by
+// their entropy, are written as they are.
+package compression
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+
+	"github.com/golang/snappy"
+	ds "github.com/ipfs/go-datastore"
+	query "github.com/ipfs/go-datastore/query"
+	logging "github.com/ipfs/go-log"
+	kcompress "github.com/klauspost/compress"
+	"github.com/klauspost/compress/zstd"
+)
+
+var log = logging.Logger("compression")
+
+// A value written to the child datastore starts with the algorithm it is
+// compressed with. Compressed values then have their size as a uvarint.
+const (
+	algoNone byte = iota
+	algoZstd
+	algoSnappy
+)
+
+const (
+	// values smaller than minSize are not compressed
+	minSize = 64
+	// the entropy is estimated on the first entropySample bytes of a value
+	entropySample = 8 << 10
+	// values larger than maxSize are not compressed, and a larger decompressed
+	// size is a corrupted header
+	maxSize = 64 << 20
+	// maxRatio bounds the decompressed size of a value to a multiple of its
+	// compressed size. zstd writes 128KiB of a repeated byte in 4 bytes.
+	maxRatio = 1 << 15
+)
+
+// DefaultMaxEntropy is the entropy, in bits per byte, above which values are
+// written as they are. Compressed data has an entropy close to 8.
+const DefaultMaxEntropy = 7.5
+
+// statsKey holds the Stat of the datastore, in JSON. It is a valid flatfs
+// key, and is hidden from the keys of the datastore.
+var statsKey = ds.NewKey("/COMPRESSION-STATS")
+
+// Options configures a compressing datastore.
+type Options struct {
+	// Name identifies the datastore in its stats, such as its mountpoint.
+	Name string
+	// Algorithm is "zstd" or "snappy".
+	Algorithm string
+	// Level is the zstd compression level, from 1 to 22. 0 is the default
+	// level, 3.
+	Level int
+	// MaxEntropy is the entropy, in bits per byte, above which values are
+	// not compressed. 0 is DefaultMaxEntropy.
+	MaxEntropy float64
+}
+
+// Stat holds the sizes of the values of a compressing datastore. The values
+// deleted or overwritten are not read again: their sizes are estimated from
+// their stored sizes, and the stats are only exact when counted.
+type Stat struct {
+	Name string `json:",omitempty"`
+	// LogicalSize is the size of the values before compression.
+	LogicalSize uint64
+	// StoredSize is the size of the values written to the child datastore.
+	StoredSize uint64
+	Objects    uint64
+	Compressed uint64
+	// Dirty is set while the datastore is open, and after a crash: the sizes
+	// are then counted again.
+	Dirty bool `json:",omitempty"`
+}
+
+// Datastore compresses the values written to its child datastore.
+type Datastore struct {
+	child ds.Batching
+	opts  Options
+	algo  byte
+
+	enc *zstd.Encoder
+	dec *zstd.Decoder
+
+	// serialize the writes of a key, whose former value is accounted for
+	locks [64]sync.Mutex
+
+	lk       sync.Mutex
+	stat     Stat
+	counting bool
+	// the part of a compressed value removed from the stats
+	compressed float64
+
+	closing chan struct{}
+	done    chan struct{}
+}
+
+var _ ds.Batching = (*Datastore)(nil)
+var _ ds.PersistentDatastore = (*Datastore)(nil)
+var _ ds.Shim = (*Datastore)(nil)
+
+// New returns a datastore compressing the values written to child. The values
+// already in child must have been written by such a datastore.
+func New(child ds.Batching, opts Options) (*Datastore, error) {
+	d := &Datastore{
+		child:   child,
+		opts:    opts,
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if d.opts.MaxEntropy == 0 {
+		d.opts.MaxEntropy = DefaultMaxEntropy
+	}
+
+	var err error
+	switch opts.Algorithm {
+	case "", "zstd":
+		d.algo = algoZstd
+		level := zstd.SpeedDefault
+		if opts.Level != 0 {
+			level = zstd.EncoderLevelFromZstd(opts.Level)
+		}
+		d.enc, err = zstd.NewWriter(nil, zstd.WithEncoderLevel(level), zstd.WithEncoderCRC(false))
+		if err != nil {
+			return nil, err
+		}
+	case "snappy":
+		d.algo = algoSnappy
+	default:
+		return nil, fmt.Errorf("unknown compression algorithm %q", opts.Algorithm)
+	}
+	// values compressed with zstd are read whatever the algorithm
+	d.dec, err = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxSize))
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	b, err := child.Get(ctx, statsKey)
+	switch err {
+	case nil:
+		err = json.Unmarshal(b, &d.stat)
+	case ds.ErrNotFound:
+		// a new datastore, unless the stats were lost
+		d.stat.Dirty = true
+		err = nil
+	}
+	if err != nil {
+		d.close()
+		return nil, fmt.Errorf("cannot read the compression stats: %w", err)
+	}
+	d.stat.Name = opts.Name
+	recount := d.stat.Dirty
+
+	// the stats are only right once the datastore is closed
+	d.stat.Dirty = true
+	if err := d.writeStat(ctx); err != nil {
+		d.close()
+		return nil, err
+	}
+
+	if recount {
+		d.counting = true
+		go d.count()
+	} else {
+		close(d.done)
+	}
+	return d, nil
+}
+
+// Stat returns the sizes of the values of the datastore. Dirty is set while
+// they are being counted.
+func (d *Datastore) Stat() Stat {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	s := d.stat
+	s.Dirty = d.counting
+	return s
+}
+
+func (d *Datastore) writeStat(ctx context.Context) error {
+	d.lk.Lock()
+	b, err := json.Marshal(d.stat)
+	d.lk.Unlock()
+	if err != nil {
+		return err
+	}
+	return d.child.Put(ctx, statsKey, b)
+}
+
+// count counts the sizes of the values of the child datastore again. The
+// changes made meanwhile are added to the count, values written during the
+// count may be counted twice.
+func (d *Datastore) count() {
+	defer close(d.done)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-d.closing:
+			cancel()
+		case <-d.done:
+		}
+	}()
+
+	d.lk.Lock()
+	base := d.stat
+	d.lk.Unlock()
+
+	res, err := d.child.Query(ctx, query.Query{})
+	if err != nil {
+		log.Errorf("cannot count the sizes of %s: %s", d.opts.Name, err)
+		return
+	}
+	defer res.Close()
+
+	var s Stat
+	for r := range res.Next() {
+		if r.Error != nil {
+			if ctx.Err() == nil {
+				log.Errorf("cannot count the sizes of %s: %s", d.opts.Name, r.Error)
+			}
+			return
+		}
+		if r.Key == statsKey.String() {
+			continue
+		}
+		addValue(&s, r.Value)
+	}
+
+	d.lk.Lock()
+	recount := func(counted, current, base uint64) uint64 {
+		if v := int64(counted) + int64(current) - int64(base); v > 0 {
+			return uint64(v)
+		}
+		return 0
+	}
+	d.stat.LogicalSize = recount(s.LogicalSize, d.stat.LogicalSize, base.LogicalSize)
+	d.stat.StoredSize = recount(s.StoredSize, d.stat.StoredSize, base.StoredSize)
+	d.stat.Objects = recount(s.Objects, d.stat.Objects, base.Objects)
+	d.stat.Compressed = recount(s.Compressed, d.stat.Compressed, base.Compressed)
+	d.counting = false
+	d.lk.Unlock()
+}
+
+// addValue adds a stored value to the stats.
+func addValue(s *Stat, stored []byte) {
+	size, err := logicalSize(stored)
+	if err != nil {
+		return
+	}
+	s.LogicalSize += uint64(size)
+	s.StoredSize += uint64(len(stored))
+	s.Objects++
+	if stored[0] != algoNone {
+		s.Compressed++
+	}
+}
+
+// removeValue removes a value stored in size bytes from the stats. Its size
+// once decompressed, and whether it is compressed, are estimated from the
+// ratios of the stats.
+func (d *Datastore) removeValue(size int) {
+	s := &d.stat
+	if s.Objects <= 1 {
+		s.LogicalSize, s.StoredSize, s.Objects, s.Compressed = 0, 0, 0, 0
+		d.compressed = 0
+		return
+	}
+	logical := uint64(size)
+	if s.StoredSize > 0 {
+		logical = uint64(float64(size) * float64(s.LogicalSize) / float64(s.StoredSize))
+	}
+	sub := func(v *uint64, delta uint64) {
+		if *v > delta {
+			*v -= delta
+		} else {
+			*v = 0
+		}
+	}
+	sub(&s.LogicalSize, logical)
+	sub(&s.StoredSize, uint64(size))
+	d.compressed += float64(s.Compressed) / float64(s.Objects)
+	s.Objects--
+	if d.compressed >= 1 {
+		d.compressed--
+		sub(&s.Compressed, 1)
+	}
+}
+
+var errCorrupted = errors.New("corrupted compressed value")
+
+// logicalSize returns the size of a stored value once decompressed.
+func logicalSize(stored []byte) (int, error) {
+	if len(stored) == 0 {
+		return 0, errCorrupted
+	}
+	if stored[0] == algoNone {
+		return len(stored) - 1, nil
+	}
+	size, n := binary.Uvarint(stored[1:])
+	if n <= 0 || size > maxSize || size > uint64(len(stored)-1-n)*maxRatio {
+		return 0, errCorrupted
+	}
+	return int(size), nil
+}
+
+// compressible returns whether v may be worth compressing.
+func (d *Datastore) compressible(v []byte) bool {
+	if len(v) < minSize {
+		return false
+	}
+	sample := v
+	if len(sample) > entropySample {
+		sample = sample[:entropySample]
+	}
+	bits := kcompress.ShannonEntropyBits(sample)
+	return float64(bits)/float64(len(sample)) <= d.opts.MaxEntropy
+}
+
+// encode returns the value written to the child datastore for v.
+func (d *Datastore) encode(v []byte) []byte {
+	if len(v) <= maxSize && d.compressible(v) {
+		out := make([]byte, 1+binary.MaxVarintLen64, 1+binary.MaxVarintLen64+len(v))
+		out[0] = d.algo
+		out = out[:1+binary.PutUvarint(out[1:], uint64(len(v)))]
+		switch d.algo {
+		case algoZstd:
+			out = d.enc.EncodeAll(v, out)
+		case algoSnappy:
+			out = append(out, snappy.Encode(nil, v)...)
+		}
+		if len(out) < len(v)+1 {
+			return out
+		}
+	}
+	out := make([]byte, 1+len(v))
+	out[0] = algoNone
+	copy(out[1:], v)
+	return out
+}
+
+// decode returns the value stored as stored.
+func (d *Datastore) decode(stored []byte) ([]byte, error) {
+	size, err := logicalSize(stored)
+	if err != nil {
+		return nil, err
+	}
+	if stored[0] == algoNone {
+		return stored[1:], nil
+	}
+	_, n := binary.Uvarint(stored[1:])
+	data := stored[1+n:]
+	var v []byte
+	switch stored[0] {
+	case algoZstd:
+		v, err = d.dec.DecodeAll(data, make([]byte, 0, size))
+	case algoSnappy:
+		// snappy allocates the size in its own header
+		if n, err := snappy.DecodedLen(data); err != nil || n != size {
+			return nil, errCorrupted
+		}
+		v, err = snappy.Decode(make([]byte, size), data)
+	default:
+		return nil, fmt.Errorf("unknown compression algorithm %d", stored[0])
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(v) != size {
+		return nil, errCorrupted
+	}
+	return v, nil
+}
+
+func (d *Datastore) keyLock(k ds.Key) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write(k.Bytes())
+	return &d.locks[h.Sum32()%uint32(len(d.locks))]
+}
+
+// storedSize returns the size of the value of k in the child datastore, or -1
+// if there is none.
+func (d *Datastore) storedSize(ctx context.Context, k ds.Key) (int, error) {
+	size, err := d.child.GetSize(ctx, k)
+	if err == ds.ErrNotFound {
+		return -1, nil
+	}
+	return size, err
+}
+
+// account replaces the former value of k, if any, by stored in the stats.
+func (d *Datastore) account(ctx context.Context, k ds.Key, stored []byte) error {
+	old, err := d.storedSize(ctx, k)
+	if err != nil {
+		return err
+	}
+	d.lk.Lock()
+	if old >= 0 {
+		d.removeValue(old)
+	}
+	if stored != nil {
+		addValue(&d.stat, stored)
+	}
+	d.lk.Unlock()
+	return nil
+}
+
+// Put compresses value and writes it to the child datastore.
+func (d *Datastore) Put(ctx context.Context, k ds.Key, value []byte) error {
+	if k == statsKey {
+		return fmt.Errorf("%s is reserved", k)
+	}
+	stored := d.encode(value)
+	l := d.keyLock(k)
+	l.Lock()
+	defer l.Unlock()
+	if err := d.account(ctx, k, stored); err != nil {
+		return err
+	}
+	return d.child.Put(ctx, k, stored)
+}
+
+// Get reads and decompresses the value of k.
+func (d *Datastore) Get(ctx context.Context, k ds.Key) ([]byte, error) {
+	if k == statsKey {
+		return nil, ds.ErrNotFound
+	}
+	stored, err := d.child.Get(ctx, k)
+	if err != nil {
+		return nil, err
+	}
+	return d.decode(stored)
+}
+
+// Has returns whether k is in the child datastore.
+func (d *Datastore) Has(ctx context.Context, k ds.Key) (bool, error) {
+	if k == statsKey {
+		return false, nil
+	}
+	return d.child.Has(ctx, k)
+}
+
+// GetSize returns the size of the value of k once decompressed, as written
+// in the header of the stored value. The datastore interface has no partial
+// reads, so the stored value is read, but it is not decompressed.
+func (d *Datastore) GetSize(ctx context.Context, k ds.Key) (int, error) {
+	if k == statsKey {
+		return -1, ds.ErrNotFound
+	}
+	stored, err := d.child.Get(ctx, k)
+	if err != nil {
+		return -1, err
+	}
+	return logicalSize(stored)
+}
+
+// Delete removes k from the child datastore.
+func (d *Datastore) Delete(ctx context.Context, k ds.Key) error {
+	if k == statsKey {
+		return nil
+	}
+	l := d.keyLock(k)
+	l.Lock()
+	defer l.Unlock()
+	if err := d.account(ctx, k, nil); err != nil {
+		return err
+	}
+	return d.child.Delete(ctx, k)
+}
+
+// Query returns the entries of the child datastore with their values
+// decompressed. Sizes are the sizes of the decompressed values, read from the
+// headers of the stored values: they are read, but not decompressed, when
+// only keys are requested.
+func (d *Datastore) Query(ctx context.Context, q query.Query) (query.Results, error) {
+	cq := query.Query{
+		Prefix:            q.Prefix,
+		KeysOnly:          q.KeysOnly && !q.ReturnsSizes,
+		ReturnExpirations: q.ReturnExpirations,
+	}
+	res, err := d.child.Query(ctx, cq)
+	if err != nil {
+		return nil, err
+	}
+	var decoded query.Results = query.ResultsFromIterator(q, query.Iterator{
+		Next: func() (query.Result, bool) {
+			for {
+				r, ok := res.NextSync()
+				if !ok || r.Error != nil {
+					return r, ok
+				}
+				if r.Key == statsKey.String() {
+					continue
+				}
+				if cq.KeysOnly {
+					r.Size = -1
+					return r, true
+				}
+				if q.KeysOnly {
+					size, err := logicalSize(r.Value)
+					if err != nil {
+						return query.Result{Error: fmt.Errorf("cannot decode %s: %w", r.Key, err)}, true
+					}
+					r.Size, r.Value = size, nil
+					return r, true
+				}
+				v, err := d.decode(r.Value)
+				if err != nil {
+					return query.Result{Error: fmt.Errorf("cannot decode %s: %w", r.Key, err)}, true
+				}
+				r.Size, r.Value = len(v), v
+				return r, true
+			}
+		},
+		Close: res.Close,
+	})
+	decoded = query.NaiveQueryApply(query.Query{
+		Filters: q.Filters,
+		Orders:  q.Orders,
+		Limit:   q.Limit,
+		Offset:  q.Offset,
+	}, decoded)
+	return query.ResultsReplaceQuery(decoded, q), nil
+}
+
+// Children returns the child datastore.
+func (d *Datastore) Children() []ds.Datastore {
+	return []ds.Datastore{d.child}
+}
+
+// Sync syncs the child datastore.
+func (d *Datastore) Sync(ctx context.Context, prefix ds.Key) error {
+	return d.child.Sync(ctx, prefix)
+}
+
+// DiskUsage returns the disk usage of the child datastore.
+func (d *Datastore) DiskUsage(ctx context.Context) (uint64, error) {
+	return ds.DiskUsage(ctx, d.child)
+}
+
+// Close writes the stats and closes the child datastore.
+func (d *Datastore) Close() error {
+	close(d.closing)
+	<-d.done
+
+	d.lk.Lock()
+	d.stat.Dirty = d.counting
+	d.lk.Unlock()
+	err := d.writeStat(context.Background())
+	if err == nil {
+		err = d.child.Sync(context.Background(), statsKey)
+	}
+	d.close()
+	if cerr := d.child.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (d *Datastore) close() {
+	if d.enc != nil {
+		d.enc.Close()
+	}
+	if d.dec != nil {
+		d.dec.Close()
+	}
+}
+
+type batchOp struct {
+	stored []byte
+	delete bool
+}
+
+type batch struct {
+	d   *Datastore
+	ops map[ds.Key]batchOp
+}
+
+// Batch returns a batch compressing the values it writes.
+func (d *Datastore) Batch(ctx context.Context) (ds.Batch, error) {
+	return &batch{d: d, ops: make(map[ds.Key]batchOp)}, nil
+}
+
+func (b *batch) Put(ctx context.Context, k ds.Key, value []byte) error {
+	if k == statsKey {
+		return fmt.Errorf("%s is reserved", k)
+	}
+	b.ops[k] = batchOp{stored: b.d.encode(value)}
+	return nil
+}
+
+func (b *batch) Delete(ctx context.Context, k ds.Key) error {
+	if k != statsKey {
+		b.ops[k] = batchOp{delete: true}
+	}
+	return nil
+}
+
+func (b *batch) Commit(ctx context.Context) error {
+	d := b.d
+	cb, err := d.child.Batch(ctx)
+	if err != nil {
+		return err
+	}
+	olds := make(map[ds.Key]int, len(b.ops))
+	for k, op := range b.ops {
+		old, err := d.storedSize(ctx, k)
+		if err != nil {
+			return err
+		}
+		olds[k] = old
+		if op.delete {
+			err = cb.Delete(ctx, k)
+		} else {
+			err = cb.Put(ctx, k, op.stored)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := cb.Commit(ctx); err != nil {
+		return err
+	}
+
+	d.lk.Lock()
+	for k, op := range b.ops {
+		if olds[k] >= 0 {
+			d.removeValue(olds[k])
+		}
+		if op.stored != nil {
+			addValue(&d.stat, op.stored)
+		}
+	}
+	d.lk.Unlock()
+	b.ops = make(map[ds.Key]batchOp)
+	return nil
+}
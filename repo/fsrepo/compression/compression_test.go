@@ -0,0 +1,158 @@
+package compression
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"testing"
+
+	"github.com/golang/snappy"
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	dssync "github.com/ipfs/go-datastore/sync"
+	dstest "github.com/ipfs/go-datastore/test"
+	"github.com/stretchr/testify/require"
+)
+
+// waitCount waits for the sizes of d to be counted.
+func waitCount(d *Datastore) {
+	<-d.done
+}
+
+func TestSuite(t *testing.T) {
+	for _, algo := range []string{"zstd", "snappy"} {
+		t.Run(algo, func(t *testing.T) {
+			d, err := New(dssync.MutexWrap(ds.NewMapDatastore()), Options{Algorithm: algo})
+			require.NoError(t, err)
+			defer d.Close()
+			dstest.SubtestAll(t, d)
+		})
+	}
+}
+
+func TestCompression(t *testing.T) {
+	ctx := context.Background()
+	child := dssync.MutexWrap(ds.NewMapDatastore())
+	d, err := New(child, Options{Name: "/blocks", Level: 19})
+	require.NoError(t, err)
+	waitCount(d)
+
+	text := bytes.Repeat([]byte(`{"hello": "world"} `), 1000)
+	random := make([]byte, 10000)
+	_, err = rand.Read(random)
+	require.NoError(t, err)
+
+	a, b, c := ds.NewKey("/a"), ds.NewKey("/b"), ds.NewKey("/c")
+	require.NoError(t, d.Put(ctx, a, text))
+	require.NoError(t, d.Put(ctx, b, random))
+	require.NoError(t, d.Put(ctx, c, []byte("small")))
+
+	stored, err := child.Get(ctx, a)
+	require.NoError(t, err)
+	require.Less(t, len(stored), len(text)/10)
+	stored, err = child.Get(ctx, b)
+	require.NoError(t, err)
+	require.Equal(t, algoNone, stored[0], "random data is not compressed")
+
+	values := map[ds.Key][]byte{a: text, b: random, c: []byte("small")}
+	for k, v := range values {
+		got, err := d.Get(ctx, k)
+		require.NoError(t, err)
+		require.Equal(t, v, got)
+		size, err := d.GetSize(ctx, k)
+		require.NoError(t, err)
+		require.Equal(t, len(v), size)
+	}
+
+	// the stats key is hidden, and sizes are the decompressed ones
+	res, err := d.Query(ctx, dsq.Query{KeysOnly: true, ReturnsSizes: true})
+	require.NoError(t, err)
+	entries, err := res.Rest()
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	for _, e := range entries {
+		require.Equal(t, len(values[ds.RawKey(e.Key)]), e.Size)
+		require.Nil(t, e.Value)
+	}
+	has, err := d.Has(ctx, statsKey)
+	require.NoError(t, err)
+	require.False(t, has)
+
+	stat := d.Stat()
+	require.Equal(t, uint64(3), stat.Objects)
+	require.Equal(t, uint64(1), stat.Compressed)
+	require.Equal(t, uint64(len(text)+len(random)+5), stat.LogicalSize)
+	require.Less(t, stat.StoredSize, uint64(len(random)+len(text)/10))
+
+	// the sizes of deleted values are estimated
+	require.NoError(t, d.Delete(ctx, a))
+	stat = d.Stat()
+	require.Equal(t, uint64(2), stat.Objects)
+	require.Less(t, stat.LogicalSize, uint64(len(text)+len(random)+5))
+	require.NoError(t, d.Close())
+
+	// the stats are kept
+	d, err = New(child, Options{Name: "/blocks", Algorithm: "snappy"})
+	require.NoError(t, err)
+	defer d.Close()
+	require.False(t, d.Stat().Dirty)
+	require.Equal(t, stat, d.Stat())
+}
+
+func TestCorrupted(t *testing.T) {
+	ctx := context.Background()
+	child := dssync.MutexWrap(ds.NewMapDatastore())
+	d, err := New(child, Options{})
+	require.NoError(t, err)
+	defer d.Close()
+	waitCount(d)
+
+	header := func(algo byte, size uint64) []byte {
+		b := make([]byte, 1+binary.MaxVarintLen64)
+		b[0] = algo
+		return b[:1+binary.PutUvarint(b[1:], size)]
+	}
+	values := map[string][]byte{
+		"/empty":     {},
+		"/no-size":   {algoZstd},
+		"/too-large": append(header(algoZstd, 1<<40), make([]byte, 100)...),
+		"/ratio":     append(header(algoZstd, 1<<20), make([]byte, 10)...),
+		// the snappy header is larger than the one of the datastore
+		"/snappy": append(header(algoSnappy, 10), snappy.Encode(nil, make([]byte, 1000))...),
+	}
+	for k, v := range values {
+		require.NoError(t, child.Put(ctx, ds.NewKey(k), v))
+	}
+	for k := range values {
+		_, err := d.Get(ctx, ds.NewKey(k))
+		require.ErrorIs(t, err, errCorrupted, k)
+	}
+	for _, k := range []string{"/empty", "/no-size", "/too-large", "/ratio"} {
+		_, err := d.GetSize(ctx, ds.NewKey(k))
+		require.ErrorIs(t, err, errCorrupted, k)
+	}
+}
+
+func TestRecount(t *testing.T) {
+	ctx := context.Background()
+	child := dssync.MutexWrap(ds.NewMapDatastore())
+	d, err := New(child, Options{Algorithm: "snappy"})
+	require.NoError(t, err)
+	waitCount(d)
+	text := bytes.Repeat([]byte("text "), 1000)
+	b, err := d.Batch(ctx)
+	require.NoError(t, err)
+	require.NoError(t, b.Put(ctx, ds.NewKey("/a"), text))
+	require.NoError(t, b.Put(ctx, ds.NewKey("/b"), text))
+	require.NoError(t, b.Commit(ctx))
+	stat := d.Stat()
+	require.Equal(t, uint64(2*len(text)), stat.LogicalSize)
+
+	// not closed, as after a crash
+	d, err = New(child, Options{Algorithm: "snappy"})
+	require.NoError(t, err)
+	defer d.Close()
+	waitCount(d)
+	require.Equal(t, stat, d.Stat())
+}
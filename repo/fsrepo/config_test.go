@@ -73,6 +73,37 @@ var measureConfig = []byte(`{
           "type": "measure"
 }`)
 
+var tieredConfig = []byte(`{
+          "hot": {
+            "compression": "none",
+            "path": "hotblocks",
+            "type": "levelds"
+          },
+          "cold": {
+            "path": "blocks",
+            "shardFunc": "/repo/flatfs/shard/v1/next-to-last/2",
+            "sync": true,
+            "type": "flatfs"
+          },
+          "hotMaxSize": "10GB",
+          "demoteAfter": "24h",
+          "mountpoint": "/blocks",
+          "type": "tiered"
+}`)
+
+var compressConfig = []byte(`{
+          "child": {
+            "path": "blocks",
+            "shardFunc": "/repo/flatfs/shard/v1/next-to-last/2",
+            "sync": true,
+            "type": "flatfs"
+          },
+          "algorithm": "zstd",
+          "level": 9,
+          "mountpoint": "/blocks",
+          "type": "compress"
+}`)
+
 func TestDefaultDatastoreConfig(t *testing.T) {
 	loader, err := loader.NewPluginLoader("")
 	if err != nil {
@@ -115,8 +146,8 @@ func TestDefaultDatastoreConfig(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if typ := reflect.TypeOf(ds).String(); typ != "*mount.Datastore" {
-		t.Errorf("expected '*mount.Datastore' got '%s'", typ)
+	if typ := reflect.TypeOf(ds).String(); typ != "*fsrepo.mountDatastore" {
+		t.Errorf("expected '*fsrepo.mountDatastore' got '%s'", typ)
 	}
 }
 
@@ -229,7 +260,85 @@ func TestMeasureConfig(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if typ := reflect.TypeOf(ds).String(); typ != "*measure.measure" {
-		t.Errorf("expected '*measure.measure' got '%s'", typ)
+	if typ := reflect.TypeOf(ds).String(); typ != "*fsrepo.measureDatastore" {
+		t.Errorf("expected '*fsrepo.measureDatastore' got '%s'", typ)
+	}
+}
+
+func TestTieredConfig(t *testing.T) {
+	dir, err := os.MkdirTemp("", "ipfs-datastore-config-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // clean up
+
+	spec := make(map[string]interface{})
+	err = json.Unmarshal(tieredConfig, &spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dsc, err := fsrepo.AnyDatastoreConfig(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"cold":{"path":"blocks","shardFunc":"/repo/flatfs/shard/v1/next-to-last/2","type":"flatfs"},"hot":{"path":"hotblocks","type":"levelds"},"type":"tiered"}`
+	if dsc.DiskSpec().String() != expected {
+		t.Errorf("expected '%s' got '%s' as DiskId", expected, dsc.DiskSpec().String())
+	}
+
+	ds, err := dsc.Create(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Close()
+
+	if typ := reflect.TypeOf(ds).String(); typ != "*tiered.Datastore" {
+		t.Errorf("expected '*tiered.Datastore' got '%s'", typ)
+	}
+
+	spec["hotMaxSize"] = "lots"
+	if _, err := fsrepo.AnyDatastoreConfig(spec); err == nil {
+		t.Error("expected an invalid hotMaxSize to fail")
+	}
+}
+
+func TestCompressConfig(t *testing.T) {
+	dir, err := os.MkdirTemp("", "ipfs-datastore-config-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // clean up
+
+	spec := make(map[string]interface{})
+	err = json.Unmarshal(compressConfig, &spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dsc, err := fsrepo.AnyDatastoreConfig(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"child":{"path":"blocks","shardFunc":"/repo/flatfs/shard/v1/next-to-last/2","type":"flatfs"},"type":"compress"}`
+	if dsc.DiskSpec().String() != expected {
+		t.Errorf("expected '%s' got '%s' as DiskId", expected, dsc.DiskSpec().String())
+	}
+
+	ds, err := dsc.Create(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Close()
+
+	if typ := reflect.TypeOf(ds).String(); typ != "*compression.Datastore" {
+		t.Errorf("expected '*compression.Datastore' got '%s'", typ)
+	}
+
+	spec["algorithm"] = "lzma"
+	if _, err := fsrepo.AnyDatastoreConfig(spec); err == nil {
+		t.Error("expected an unknown algorithm to fail")
 	}
 }
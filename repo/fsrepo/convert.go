@@ -0,0 +1,486 @@
+package fsrepo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/facebookgo/atomicfile"
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	config "github.com/ipfs/kubo/config"
+	repo "github.com/ipfs/kubo/repo"
+)
+
+// convertFn is the file keeping the state of a datastore conversion in
+// progress, so it can be resumed.
+const convertFn = "datastore_convert"
+
+// convertBatchSize is the number of writes to the new datastore per batch.
+const convertBatchSize = 1024
+
+// ErrNoConversion is returned when resuming a datastore conversion that was
+// not started.
+var ErrNoConversion = errors.New("no datastore conversion in progress")
+
+var blocksPrefix = ds.NewKey("/blocks")
+
+// ConvertProgress is the progress of a datastore conversion.
+type ConvertProgress struct {
+	// Copied is the number of keys written to the new datastore.
+	Copied uint64
+	// Skipped is the number of keys already there, from a previous run.
+	Skipped uint64
+	// Removed is the number of keys removed from the new datastore since
+	// they are no longer in the current one.
+	Removed uint64
+}
+
+// convertState is saved in convertFn.
+type convertState struct {
+	Spec map[string]interface{}
+}
+
+// DatastoreConverter copies the keys of the datastore of a repo to a new
+// datastore with another spec, then swaps the spec of the repo.
+//
+// The copy can run while the repo is in use, and run again to catch up with
+// the writes made since: blocks already copied are skipped, other keys are
+// compared, and keys no longer in the current datastore are removed. The swap
+// must only happen when nothing writes to the current datastore anymore.
+type DatastoreConverter struct {
+	path string
+	src  repo.Datastore
+	spec map[string]interface{}
+	dst  repo.Datastore
+}
+
+// NewDatastoreConverter prepares the conversion of the datastore src of the
+// repo at repoPath to spec, or resumes the conversion in progress when spec
+// is nil. The new datastore is created next to the current one, and must not
+// share any path with it.
+func NewDatastoreConverter(repoPath string, src repo.Datastore, spec map[string]interface{}) (*DatastoreConverter, error) {
+	state, err := readConvertState(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	if spec == nil {
+		if state == nil {
+			return nil, ErrNoConversion
+		}
+		spec = state.Spec
+	}
+
+	dsc, err := AnyDatastoreConfig(spec)
+	if err != nil {
+		return nil, err
+	}
+	if state != nil {
+		cur, err := AnyDatastoreConfig(state.Spec)
+		if err != nil {
+			return nil, err
+		}
+		if cur.DiskSpec().String() != dsc.DiskSpec().String() {
+			return nil, fmt.Errorf("a conversion of the datastore to %s is in progress, abort it first", cur.DiskSpec())
+		}
+	}
+
+	oldPaths, err := datastorePaths(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	fn, err := config.Path(repoPath, specFn)
+	if err != nil {
+		return nil, err
+	}
+	oldSpec, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(string(oldSpec)) == dsc.DiskSpec().String() {
+		return nil, errors.New("the repo already uses this datastore")
+	}
+	for _, p := range diskSpecPaths(dsc.DiskSpec()) {
+		p = resolvePath(repoPath, p)
+		for _, old := range oldPaths {
+			if p == old || strings.HasPrefix(p, old+string(filepath.Separator)) || strings.HasPrefix(old, p+string(filepath.Separator)) {
+				return nil, fmt.Errorf("the new datastore cannot use %s, the current datastore uses %s", p, old)
+			}
+		}
+	}
+
+	if state == nil {
+		if err := writeConvertState(repoPath, &convertState{Spec: spec}); err != nil {
+			return nil, err
+		}
+	}
+
+	dst, err := dsc.Create(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	return &DatastoreConverter{path: repoPath, src: src, spec: spec, dst: dst}, nil
+}
+
+// Spec returns the spec of the new datastore.
+func (c *DatastoreConverter) Spec() map[string]interface{} {
+	return c.spec
+}
+
+// Copy brings the new datastore up to date with the current one. The
+// progress callback is called every convertBatchSize keys.
+func (c *DatastoreConverter) Copy(ctx context.Context, progress func(ConvertProgress)) error {
+	var p ConvertProgress
+	var seen uint64
+	batch, err := c.dst.Batch(ctx)
+	if err != nil {
+		return err
+	}
+	pending := 0
+	step := func(written bool) error {
+		if seen++; progress != nil && seen%convertBatchSize == 0 {
+			progress(p)
+		}
+		if !written {
+			return nil
+		}
+		if pending++; pending < convertBatchSize {
+			return nil
+		}
+		if err := batch.Commit(ctx); err != nil {
+			return err
+		}
+		pending = 0
+		batch, err = c.dst.Batch(ctx)
+		return err
+	}
+
+	res, err := c.src.Query(ctx, dsq.Query{KeysOnly: true})
+	if err != nil {
+		return err
+	}
+	for r := range res.Next() {
+		if r.Error != nil {
+			res.Close()
+			return r.Error
+		}
+		copied, err := c.copyKey(ctx, batch, ds.RawKey(r.Key))
+		if err != nil {
+			res.Close()
+			return err
+		}
+		if copied {
+			p.Copied++
+		} else {
+			p.Skipped++
+		}
+		if err := step(copied); err != nil {
+			res.Close()
+			return err
+		}
+	}
+	res.Close()
+
+	// remove what was deleted from the current datastore since the last run
+	res, err = c.dst.Query(ctx, dsq.Query{KeysOnly: true})
+	if err != nil {
+		return err
+	}
+	for r := range res.Next() {
+		if r.Error != nil {
+			res.Close()
+			return r.Error
+		}
+		k := ds.RawKey(r.Key)
+		has, err := c.src.Has(ctx, k)
+		if err != nil {
+			res.Close()
+			return err
+		}
+		if !has {
+			if err := batch.Delete(ctx, k); err != nil {
+				res.Close()
+				return err
+			}
+			p.Removed++
+		}
+		if err := step(!has); err != nil {
+			res.Close()
+			return err
+		}
+	}
+	res.Close()
+
+	if err := batch.Commit(ctx); err != nil {
+		return err
+	}
+	if progress != nil {
+		progress(p)
+	}
+	return c.dst.Sync(ctx, ds.NewKey("/"))
+}
+
+// copyKey adds the key k to the batch, unless the new datastore has it
+// already. Blocks are addressed by their content and are not compared.
+func (c *DatastoreConverter) copyKey(ctx context.Context, batch ds.Batch, k ds.Key) (bool, error) {
+	if blocksPrefix.IsAncestorOf(k) {
+		has, err := c.dst.Has(ctx, k)
+		if err != nil || has {
+			return false, err
+		}
+	}
+	value, err := c.src.Get(ctx, k)
+	if err == ds.ErrNotFound {
+		// deleted since the query
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !blocksPrefix.IsAncestorOf(k) {
+		old, err := c.dst.Get(ctx, k)
+		if err == nil && bytes.Equal(old, value) {
+			return false, nil
+		}
+		if err != nil && err != ds.ErrNotFound {
+			return false, err
+		}
+	}
+	return true, batch.Put(ctx, k, value)
+}
+
+// Verify counts the keys of both datastores, and returns an error if they
+// differ.
+func (c *DatastoreConverter) Verify(ctx context.Context) (uint64, error) {
+	n, err := countKeys(ctx, c.src)
+	if err != nil {
+		return 0, err
+	}
+	m, err := countKeys(ctx, c.dst)
+	if err != nil {
+		return 0, err
+	}
+	if n != m {
+		return 0, fmt.Errorf("the new datastore has %d keys, the current one %d", m, n)
+	}
+	return n, nil
+}
+
+func countKeys(ctx context.Context, d ds.Datastore) (uint64, error) {
+	res, err := d.Query(ctx, dsq.Query{KeysOnly: true})
+	if err != nil {
+		return 0, err
+	}
+	defer res.Close()
+	var n uint64
+	for r := range res.Next() {
+		if r.Error != nil {
+			return 0, r.Error
+		}
+		n++
+	}
+	return n, nil
+}
+
+// Swap makes the new datastore the datastore of the repo r, from the next
+// time it is opened. The datastore of r must not be written to anymore. The
+// paths of the former datastore are returned, they can be removed.
+func (c *DatastoreConverter) Swap(r repo.Repo) ([]string, error) {
+	oldPaths, err := datastorePaths(c.path)
+	if err != nil {
+		return nil, err
+	}
+	dsc, err := AnyDatastoreConfig(c.spec)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each file is replaced atomically. If interrupted after the config is
+	// written, the swap completes the next time the repo is opened.
+	if err := r.SetConfigKey("Datastore.Spec", c.spec); err != nil {
+		return nil, err
+	}
+	return oldPaths, finishSwap(c.path, dsc.DiskSpec())
+}
+
+// resumeSwap completes the swap of a datastore conversion interrupted after
+// the config was written, when spec is the disk spec of the config. It
+// returns whether there was such a swap.
+func resumeSwap(repoPath string, spec DiskSpec) (bool, error) {
+	state, err := readConvertState(repoPath)
+	if err != nil || state == nil {
+		return false, err
+	}
+	dsc, err := AnyDatastoreConfig(state.Spec)
+	if err != nil {
+		return false, err
+	}
+	if dsc.DiskSpec().String() != spec.String() {
+		return false, nil
+	}
+	log.Warnf("completing the conversion of the datastore to %s", spec)
+	return true, finishSwap(repoPath, spec)
+}
+
+// finishSwap writes the disk spec of the new datastore and drops the state
+// of the conversion.
+func finishSwap(repoPath string, spec DiskSpec) error {
+	fn, err := config.Path(repoPath, specFn)
+	if err != nil {
+		return err
+	}
+	f, err := atomicfile.New(fn, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(spec.Bytes()); err != nil {
+		f.Abort()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return removeConvertState(repoPath)
+}
+
+// Close closes the new datastore.
+func (c *DatastoreConverter) Close() error {
+	return c.dst.Close()
+}
+
+// AbortConvert drops the datastore conversion in progress in the repo at
+// repoPath, and removes the new datastore.
+func AbortConvert(repoPath string) error {
+	state, err := readConvertState(repoPath)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		return ErrNoConversion
+	}
+	dsc, err := AnyDatastoreConfig(state.Spec)
+	if err != nil {
+		return err
+	}
+	oldPaths, err := datastorePaths(repoPath)
+	if err != nil {
+		return err
+	}
+	for _, p := range diskSpecPaths(dsc.DiskSpec()) {
+		p = resolvePath(repoPath, p)
+		for _, old := range oldPaths {
+			if p == old {
+				return fmt.Errorf("not removing %s, the current datastore uses it", p)
+			}
+		}
+		if err := os.RemoveAll(p); err != nil {
+			return err
+		}
+	}
+	return removeConvertState(repoPath)
+}
+
+// datastorePaths returns the paths of the current datastore of the repo.
+func datastorePaths(repoPath string) ([]string, error) {
+	fn, err := config.Path(repoPath, specFn)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	var spec DiskSpec
+	if err := json.Unmarshal(b, &spec); err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, p := range diskSpecPaths(spec) {
+		paths = append(paths, resolvePath(repoPath, p))
+	}
+	return paths, nil
+}
+
+// diskSpecPaths returns the paths in a disk spec and its children.
+func diskSpecPaths(spec map[string]interface{}) []string {
+	var paths []string
+	if p, ok := spec["path"].(string); ok {
+		paths = append(paths, p)
+	}
+	for _, field := range []string{"child", "hot", "cold"} {
+		if child, ok := specMap(spec[field]); ok {
+			paths = append(paths, diskSpecPaths(child)...)
+		}
+	}
+	if mounts, ok := spec["mounts"].([]interface{}); ok {
+		for _, m := range mounts {
+			if m, ok := specMap(m); ok {
+				paths = append(paths, diskSpecPaths(m)...)
+			}
+		}
+	}
+	return paths
+}
+
+// specMap returns the children of a spec, read from JSON or built by
+// DatastoreConfig.DiskSpec.
+func specMap(v interface{}) (map[string]interface{}, bool) {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		return m, true
+	case DiskSpec:
+		return m, true
+	}
+	return nil, false
+}
+
+func resolvePath(repoPath, p string) string {
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(repoPath, p)
+	}
+	return filepath.Clean(p)
+}
+
+func readConvertState(repoPath string) (*convertState, error) {
+	fn, err := config.Path(repoPath, convertFn)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(fn)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state convertState
+	if err := json.Unmarshal(b, &state); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", fn, err)
+	}
+	return &state, nil
+}
+
+func writeConvertState(repoPath string, state *convertState) error {
+	fn, err := config.Path(repoPath, convertFn)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fn, b, 0600)
+}
+
+func removeConvertState(repoPath string) error {
+	fn, err := config.Path(repoPath, convertFn)
+	if err != nil {
+		return err
+	}
+	return os.Remove(fn)
+}
@@ -0,0 +1,127 @@
+package fsrepo_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	config "github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/plugin/loader"
+	"github.com/ipfs/kubo/repo/fsrepo"
+
+	datastore "github.com/ipfs/go-datastore"
+	"github.com/stretchr/testify/require"
+)
+
+// injectDatastores registers the datastore plugins, unless another test did.
+func injectDatastores(t *testing.T) {
+	if _, err := fsrepo.AnyDatastoreConfig(map[string]interface{}{"type": "levelds", "path": "datastore"}); err == nil {
+		return
+	}
+	l, err := loader.NewPluginLoader("")
+	require.NoError(t, err)
+	require.NoError(t, l.Initialize())
+	require.NoError(t, l.Inject())
+}
+
+func initConvertTestRepo(t *testing.T, path string) {
+	// swapping sets the config, which must have a private key
+	require.NoError(t, fsrepo.Init(path, &config.Config{
+		Identity:  config.Identity{PrivKey: "key"},
+		Datastore: config.DefaultDatastoreConfig(),
+	}))
+}
+
+func TestConvertDatastore(t *testing.T) {
+	injectDatastores(t)
+	ctx := context.Background()
+	path := t.TempDir()
+	initConvertTestRepo(t, path)
+
+	r, err := fsrepo.Open(path)
+	require.NoError(t, err)
+	d := r.Datastore()
+	block := datastore.NewKey("/blocks/CIQA")
+	require.NoError(t, d.Put(ctx, block, []byte("block")))
+	require.NoError(t, d.Put(ctx, datastore.NewKey("/local/filesroot"), []byte("a")))
+	require.NoError(t, d.Put(ctx, datastore.NewKey("/pins/pin/x"), []byte("pin")))
+
+	// the new datastore cannot reuse the directories of the current one
+	_, err = fsrepo.NewDatastoreConverter(path, d, map[string]interface{}{"type": "levelds", "path": "datastore"})
+	require.Error(t, err)
+	_, err = fsrepo.NewDatastoreConverter(path, d, map[string]interface{}{
+		"type": "mount",
+		"mounts": []interface{}{
+			map[string]interface{}{"mountpoint": "/", "type": "levelds", "path": "datastore"},
+		},
+	})
+	require.Error(t, err)
+
+	spec := map[string]interface{}{"type": "levelds", "path": "converted"}
+	conv, err := fsrepo.NewDatastoreConverter(path, d, spec)
+	require.NoError(t, err)
+	var p fsrepo.ConvertProgress
+	require.NoError(t, conv.Copy(ctx, func(cp fsrepo.ConvertProgress) { p = cp }))
+	require.Equal(t, fsrepo.ConvertProgress{Copied: 3}, p)
+	require.NoError(t, conv.Close())
+
+	// the repo keeps changing while the conversion is resumed
+	require.NoError(t, d.Put(ctx, datastore.NewKey("/local/filesroot"), []byte("b")))
+	require.NoError(t, d.Delete(ctx, datastore.NewKey("/pins/pin/x")))
+	require.NoError(t, d.Put(ctx, datastore.NewKey("/blocks/CIQB"), []byte("other")))
+
+	_, err = fsrepo.NewDatastoreConverter(path, d, map[string]interface{}{"type": "levelds", "path": "other"})
+	require.Error(t, err, "another conversion is in progress")
+	conv, err = fsrepo.NewDatastoreConverter(path, d, nil)
+	require.NoError(t, err)
+	require.NoError(t, conv.Copy(ctx, func(cp fsrepo.ConvertProgress) { p = cp }))
+	require.Equal(t, fsrepo.ConvertProgress{Copied: 2, Skipped: 1, Removed: 1}, p)
+	n, err := conv.Verify(ctx)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), n)
+
+	oldPaths, err := conv.Swap(r)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{filepath.Join(path, "blocks"), filepath.Join(path, "datastore")}, oldPaths)
+	require.NoError(t, conv.Close())
+	require.NoError(t, r.Close())
+
+	r, err = fsrepo.Open(path)
+	require.NoError(t, err)
+	v, err := r.Datastore().Get(ctx, datastore.NewKey("/local/filesroot"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("b"), v)
+	has, err := r.Datastore().Has(ctx, block)
+	require.NoError(t, err)
+	require.True(t, has)
+	require.NoError(t, r.Close())
+
+	_, err = fsrepo.NewDatastoreConverter(path, nil, nil)
+	require.Equal(t, fsrepo.ErrNoConversion, err)
+}
+
+func TestConvertDatastoreInterruptedSwap(t *testing.T) {
+	injectDatastores(t)
+	path := t.TempDir()
+	initConvertTestRepo(t, path)
+
+	r, err := fsrepo.Open(path)
+	require.NoError(t, err)
+	spec := map[string]interface{}{"type": "levelds", "path": "converted"}
+	conv, err := fsrepo.NewDatastoreConverter(path, r.Datastore(), spec)
+	require.NoError(t, err)
+	require.NoError(t, conv.Close())
+
+	// the config is written, not the disk spec
+	require.NoError(t, r.SetConfigKey("Datastore.Spec", spec))
+	require.NoError(t, r.Close())
+
+	r, err = fsrepo.Open(path)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	_, err = os.Stat(filepath.Join(path, "datastore_convert"))
+	require.True(t, os.IsNotExist(err))
+
+	require.Equal(t, fsrepo.ErrNoConversion, fsrepo.AbortConvert(path))
+}
@@ -5,9 +5,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"sort"
+	"time"
 
 	"github.com/ipfs/kubo/repo"
+	"github.com/ipfs/kubo/repo/fsrepo/compression"
+	"github.com/ipfs/kubo/repo/fsrepo/tiered"
 
+	humanize "github.com/dustin/go-humanize"
 	ds "github.com/ipfs/go-datastore"
 	"github.com/ipfs/go-datastore/mount"
 	dssync "github.com/ipfs/go-datastore/sync"
@@ -57,10 +61,12 @@ var datastores map[string]ConfigFromMap
 
 func init() {
 	datastores = map[string]ConfigFromMap{
-		"mount":   MountDatastoreConfig,
-		"mem":     MemDatastoreConfig,
-		"log":     LogDatastoreConfig,
-		"measure": MeasureDatastoreConfig,
+		"mount":    MountDatastoreConfig,
+		"mem":      MemDatastoreConfig,
+		"log":      LogDatastoreConfig,
+		"measure":  MeasureDatastoreConfig,
+		"tiered":   TieredDatastoreConfig,
+		"compress": CompressDatastoreConfig,
 	}
 }
 
@@ -158,7 +164,29 @@ func (c *mountDatastoreConfig) Create(path string) (repo.Datastore, error) {
 		mounts[i].Datastore = ds
 		mounts[i].Prefix = m.prefix
 	}
-	return mount.New(mounts), nil
+	return newMountDatastore(mounts), nil
+}
+
+// mountDatastore is a mount datastore listing the datastores it mounts as its
+// children, so that the datastores of a repo can be walked.
+type mountDatastore struct {
+	*mount.Datastore
+	children []ds.Datastore
+}
+
+var _ ds.Shim = (*mountDatastore)(nil)
+
+func newMountDatastore(mounts []mount.Mount) *mountDatastore {
+	d := &mountDatastore{Datastore: mount.New(mounts)}
+	for _, m := range mounts {
+		d.children = append(d.children, m.Datastore)
+	}
+	return d
+}
+
+// Children returns the mounted datastores.
+func (d *mountDatastore) Children() []ds.Datastore {
+	return d.children
 }
 
 type memDatastoreConfig struct {
@@ -244,5 +272,195 @@ func (c measureDatastoreConfig) Create(path string) (repo.Datastore, error) {
 	if err != nil {
 		return nil, err
 	}
-	return measure.New(c.prefix, child), nil
+	return newMeasureDatastore(c.prefix, child), nil
+}
+
+// measuredDatastore is the method set of the go-ds-measure datastores.
+type measuredDatastore interface {
+	ds.Batching
+	ds.CheckedDatastore
+	ds.ScrubbedDatastore
+	ds.GCDatastore
+	ds.PersistentDatastore
+}
+
+// measureDatastore is a measure datastore listing the datastore it measures
+// as its child, so that the datastores of a repo can be walked.
+type measureDatastore struct {
+	measuredDatastore
+	child ds.Datastore
+}
+
+var _ ds.Shim = (*measureDatastore)(nil)
+
+func newMeasureDatastore(prefix string, child repo.Datastore) *measureDatastore {
+	return &measureDatastore{measure.New(prefix, child), child}
+}
+
+// Children returns the measured datastore.
+func (d *measureDatastore) Children() []ds.Datastore {
+	return []ds.Datastore{d.child}
+}
+
+type compressDatastoreConfig struct {
+	child DatastoreConfig
+	opts  compression.Options
+}
+
+// CompressDatastoreConfig returns a compress DatastoreConfig from a spec
+func CompressDatastoreConfig(params map[string]interface{}) (DatastoreConfig, error) {
+	childField, ok := params["child"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("'child' field is missing or not a map")
+	}
+	child, err := AnyDatastoreConfig(childField)
+	if err != nil {
+		return nil, err
+	}
+	c := &compressDatastoreConfig{child: child}
+	if v, ok := params["algorithm"]; ok {
+		if c.opts.Algorithm, ok = v.(string); !ok {
+			return nil, fmt.Errorf("'algorithm' field is not a string")
+		}
+	}
+	switch c.opts.Algorithm {
+	case "", "zstd", "snappy":
+	default:
+		return nil, fmt.Errorf("unknown compression algorithm %q", c.opts.Algorithm)
+	}
+	if v, ok := params["level"]; ok {
+		level, ok := v.(float64)
+		if !ok || level < 1 || level > 22 {
+			return nil, fmt.Errorf("'level' field is not a zstd level, from 1 to 22")
+		}
+		c.opts.Level = int(level)
+	}
+	if v, ok := params["maxEntropy"]; ok {
+		if c.opts.MaxEntropy, ok = v.(float64); !ok {
+			return nil, fmt.Errorf("'maxEntropy' field is not a number")
+		}
+	}
+	c.opts.Name, _ = params["mountpoint"].(string)
+	if c.opts.Name == "" {
+		c.opts.Name = "compress"
+	}
+	return c, nil
+}
+
+func (c *compressDatastoreConfig) DiskSpec() DiskSpec {
+	return map[string]interface{}{
+		"type":  "compress",
+		"child": c.child.DiskSpec(),
+	}
+}
+
+func (c *compressDatastoreConfig) Create(path string) (repo.Datastore, error) {
+	child, err := c.child.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	d, err := compression.New(child, c.opts)
+	if err != nil {
+		child.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+type tieredDatastoreConfig struct {
+	hot, cold DatastoreConfig
+	opts      tiered.Options
+}
+
+// TieredDatastoreConfig returns a tiered DatastoreConfig from a spec
+func TieredDatastoreConfig(params map[string]interface{}) (DatastoreConfig, error) {
+	var c tieredDatastoreConfig
+	for _, tier := range []struct {
+		name string
+		dsc  *DatastoreConfig
+	}{{"hot", &c.hot}, {"cold", &c.cold}} {
+		field, ok := params[tier.name].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("'%s' field is missing or not a map", tier.name)
+		}
+		child, err := AnyDatastoreConfig(field)
+		if err != nil {
+			return nil, err
+		}
+		*tier.dsc = child
+	}
+
+	var err error
+	if c.opts.HotMaxSize, err = sizeParam(params, "hotMaxSize"); err != nil {
+		return nil, err
+	}
+	if c.opts.ColdMaxSize, err = sizeParam(params, "coldMaxSize"); err != nil {
+		return nil, err
+	}
+	if v, ok := params["demoteAfter"]; ok {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("'demoteAfter' field is not a string")
+		}
+		if c.opts.DemoteAfter, err = time.ParseDuration(s); err != nil {
+			return nil, fmt.Errorf("invalid 'demoteAfter': %w", err)
+		}
+	}
+	if v, ok := params["promote"]; ok {
+		promote, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("'promote' field is not a boolean")
+		}
+		c.opts.NoPromote = !promote
+	}
+	c.opts.Name, _ = params["mountpoint"].(string)
+	if c.opts.Name == "" {
+		c.opts.Name = "tiered"
+	}
+	return &c, nil
+}
+
+// sizeParam reads a size in bytes, given as a number or as a string such as
+// "10GB". It is 0 when missing.
+func sizeParam(params map[string]interface{}, name string) (uint64, error) {
+	switch v := params[name].(type) {
+	case nil:
+		return 0, nil
+	case float64:
+		return uint64(v), nil
+	case string:
+		size, err := humanize.ParseBytes(v)
+		if err != nil {
+			return 0, fmt.Errorf("invalid '%s': %w", name, err)
+		}
+		return size, nil
+	}
+	return 0, fmt.Errorf("'%s' field is not a size", name)
+}
+
+func (c *tieredDatastoreConfig) DiskSpec() DiskSpec {
+	return map[string]interface{}{
+		"type": "tiered",
+		"hot":  c.hot.DiskSpec(),
+		"cold": c.cold.DiskSpec(),
+	}
+}
+
+func (c *tieredDatastoreConfig) Create(path string) (repo.Datastore, error) {
+	hot, err := c.hot.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	cold, err := c.cold.Create(path)
+	if err != nil {
+		hot.Close()
+		return nil, err
+	}
+	d, err := tiered.New(hot, cold, c.opts)
+	if err != nil {
+		hot.Close()
+		cold.Close()
+		return nil, err
+	}
+	return d, nil
 }
@@ -17,7 +17,6 @@ import (
 	dir "github.com/ipfs/kubo/thirdparty/dir"
 
 	ds "github.com/ipfs/go-datastore"
-	measure "github.com/ipfs/go-ds-measure"
 	lockfile "github.com/ipfs/go-fs-lock"
 	util "github.com/ipfs/go-ipfs-util"
 	logging "github.com/ipfs/go-log"
@@ -431,8 +430,14 @@ func (r *FSRepo) openDatastore() error {
 		return err
 	}
 	if oldSpec != spec.String() {
-		return fmt.Errorf("datastore configuration of '%s' does not match what is on disk '%s'",
-			oldSpec, spec.String())
+		swapped, err := resumeSwap(r.path, spec)
+		if err != nil {
+			return err
+		}
+		if !swapped {
+			return fmt.Errorf("datastore configuration of '%s' does not match what is on disk '%s'",
+				oldSpec, spec.String())
+		}
 	}
 
 	d, err := dsc.Create(r.path)
@@ -443,7 +448,7 @@ func (r *FSRepo) openDatastore() error {
 
 	// Wrap it with metrics gathering
 	prefix := "ipfs.fsrepo.datastore"
-	r.ds = measure.New(prefix, r.ds)
+	r.ds = newMeasureDatastore(prefix, r.ds)
 
 	return nil
 }
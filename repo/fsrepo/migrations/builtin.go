@@ -0,0 +1,151 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// Migration is a repo migration compiled into ipfs. RunMigration uses it
+// instead of fetching and running the fs-repo-N-to-M binary of the same
+// versions.
+type Migration struct {
+	// From is the repo version the migration applies to. It migrates the
+	// repo to From+1.
+	From int
+
+	// Apply migrates the repo in ipfsDir to From+1. With dryRun, it only
+	// logs the changes it would make. The version file is written by
+	// RunMigration once Apply returns.
+	Apply func(ctx context.Context, ipfsDir string, dryRun bool, logger *log.Logger) error
+
+	// Revert migrates the repo in ipfsDir back to From. It must also undo a
+	// partial Apply, as it runs when Apply fails. It is nil if the migration
+	// cannot be reverted.
+	Revert func(ctx context.Context, ipfsDir string, dryRun bool, logger *log.Logger) error
+}
+
+var builtinMigrations = map[string]*Migration{}
+
+// RegisterMigration adds a migration to the ones compiled into ipfs. It is
+// meant to be called from init functions.
+func RegisterMigration(m *Migration) error {
+	name := migrationName(m.From, m.From+1)
+	if _, ok := builtinMigrations[name]; ok {
+		return fmt.Errorf("already have a built-in migration %s", name)
+	}
+	if m.Apply == nil {
+		return fmt.Errorf("built-in migration %s has no Apply function", name)
+	}
+	builtinMigrations[name] = m
+	return nil
+}
+
+// runBuiltinMigration applies or reverts a built-in migration, then writes the
+// version of the migrated repo.
+func runBuiltinMigration(ctx context.Context, m *Migration, ipfsDir string, revert, dryRun bool, logger *log.Logger) error {
+	run, ver := m.Apply, m.From+1
+	if revert {
+		run, ver = m.Revert, m.From
+		if run == nil {
+			return fmt.Errorf("%s cannot be reverted", migrationName(m.From, m.From+1))
+		}
+	}
+	if err := run(ctx, ipfsDir, dryRun, logger); err != nil {
+		return err
+	}
+	if dryRun {
+		return nil
+	}
+	return WriteRepoVersion(ipfsDir, ver)
+}
+
+// backupRepo copies the repo directory next to it, and returns the path of
+// the copy. Datastores outside of the repo directory are not copied.
+func backupRepo(ipfsDir string, ver int, logger *log.Logger) (string, error) {
+	backupDir := fmt.Sprintf("%s.backup-v%d", filepath.Clean(ipfsDir), ver)
+	if _, err := os.Lstat(backupDir); err == nil {
+		return "", fmt.Errorf("cannot back up the repo: %s already exists", backupDir)
+	}
+	logger.Println("Backing up the repo to", backupDir, "...")
+	if err := copyDir(ipfsDir, backupDir); err != nil {
+		os.RemoveAll(backupDir)
+		return "", fmt.Errorf("cannot back up the repo: %w", err)
+	}
+	return backupDir, nil
+}
+
+// restoreRepo replaces the repo with its backup.
+func restoreRepo(ipfsDir, backupDir string, logger *log.Logger) error {
+	logger.Println("Restoring the repo from", backupDir, "...")
+	failedDir := filepath.Clean(ipfsDir) + ".failed"
+	if err := os.RemoveAll(failedDir); err != nil {
+		return err
+	}
+	if err := os.Rename(ipfsDir, failedDir); err != nil {
+		return fmt.Errorf("cannot move the repo aside, restore it from %s: %w", backupDir, err)
+	}
+	if err := os.Rename(backupDir, ipfsDir); err != nil {
+		return fmt.Errorf("cannot restore the backup, the repo was moved to %s and its backup is in %s: %w", failedDir, backupDir, err)
+	}
+	return os.RemoveAll(failedDir)
+}
+
+// copyDir copies the files, directories and symlinks of the src directory to
+// dst, but the lock of the repo and the API file of a running daemon.
+func copyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		if rel == "repo.lock" || rel == "api" {
+			return nil
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return os.Mkdir(target, info.Mode().Perm())
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case d.Type().IsRegular():
+			return copyFile(p, target, info.Mode().Perm())
+		}
+		return nil
+	})
+}
+
+func copyFile(src, dst string, perm fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err = out.ReadFrom(in); err != nil {
+		out.Close()
+		return err
+	}
+	if err = out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
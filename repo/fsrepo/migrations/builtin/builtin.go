@@ -0,0 +1,44 @@
+// Package builtin registers the repo migrations compiled into ipfs. They are
+// used by migrations.RunMigration instead of the fs-repo-N-to-M binaries of
+// the same versions.
+package builtin
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	config "github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/repo/fsrepo"
+
+	datastore "github.com/ipfs/go-datastore"
+)
+
+// openDatastore opens the datastore of the repo in ipfsDir as its config
+// describes it, without checking the version of the repo. Only the datastore
+// spec of the config is read, the rest of it may be from any version.
+func openDatastore(ipfsDir string) (datastore.Batching, error) {
+	cfgPath, err := config.Filename(ipfsDir, "")
+	if err != nil {
+		return nil, err
+	}
+	cfgFile, err := os.Open(cfgPath)
+	if err != nil {
+		return nil, err
+	}
+	defer cfgFile.Close()
+
+	var cfg struct {
+		Datastore struct {
+			Spec map[string]interface{}
+		}
+	}
+	if err := json.NewDecoder(cfgFile).Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("cannot read the datastore spec: %w", err)
+	}
+	dsc, err := fsrepo.AnyDatastoreConfig(cfg.Datastore.Spec)
+	if err != nil {
+		return nil, err
+	}
+	return dsc.Create(ipfsDir)
+}
@@ -0,0 +1,258 @@
+package builtin
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/ipfs/kubo/repo/fsrepo/migrations"
+
+	cid "github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	query "github.com/ipfs/go-datastore/query"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+)
+
+// Since repo version 12, blocks are keyed by their multihash instead of their
+// CID. CIDv0 are multihashes, so only the blocks keyed by a CIDv1 move. Their
+// CIDs are saved to cidsFile, for the migration to be reverted.
+
+const (
+	cidsFile = "11-to-12-cids.txt"
+	// keys moved at a time
+	batchSize = 1024
+)
+
+var blocksPrefix = datastore.NewKey("/blocks")
+
+func init() {
+	err := migrations.RegisterMigration(&migrations.Migration{
+		From:   11,
+		Apply:  apply11to12,
+		Revert: revert11to12,
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func cidKey(c cid.Cid) datastore.Key {
+	return blocksPrefix.Child(dshelp.NewKeyFromBinary(c.Bytes()))
+}
+
+func multihashKey(c cid.Cid) datastore.Key {
+	return blocksPrefix.Child(dshelp.MultihashToDsKey(c.Hash()))
+}
+
+// cidv1Blocks returns the CIDs of the blocks keyed by a CIDv1.
+func cidv1Blocks(ctx context.Context, d datastore.Datastore) ([]cid.Cid, error) {
+	res, err := d.Query(ctx, query.Query{Prefix: blocksPrefix.String(), KeysOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var cids []cid.Cid
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		b, err := dshelp.BinaryFromDsKey(datastore.NewKey(datastore.RawKey(r.Key).BaseNamespace()))
+		if err != nil {
+			continue
+		}
+		c, err := cid.Cast(b)
+		if err != nil || c.Version() == 0 {
+			continue
+		}
+		cids = append(cids, c)
+	}
+	return cids, nil
+}
+
+func apply11to12(ctx context.Context, ipfsDir string, dryRun bool, logger *log.Logger) error {
+	d, err := openDatastore(ipfsDir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	cids, err := cidv1Blocks(ctx, d)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		logger.Printf("  %d blocks keyed by a CIDv1 would be keyed by their multihash", len(cids))
+		return nil
+	}
+
+	// CIDs are appended: the lines of an interrupted run are kept, and a
+	// block listed twice is reverted once
+	f, err := os.OpenFile(filepath.Join(ipfsDir, cidsFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for len(cids) != 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n := batchSize
+		if n > len(cids) {
+			n = len(cids)
+		}
+		if err := moveBlocks(ctx, d, f, cids[:n]); err != nil {
+			return err
+		}
+		cids = cids[n:]
+	}
+	if err := d.Sync(ctx, blocksPrefix); err != nil {
+		return err
+	}
+	logger.Printf("  blocks keyed by a CIDv1 are now keyed by their multihash, their CIDs are saved to %s", cidsFile)
+	return nil
+}
+
+// moveBlocks keys the blocks of cids by their multihash. The CIDs are on disk
+// before the blocks move, so that the blocks can be moved back whenever the
+// migration stops.
+func moveBlocks(ctx context.Context, d datastore.Batching, f *os.File, cids []cid.Cid) error {
+	w := bufio.NewWriter(f)
+	for _, c := range cids {
+		fmt.Fprintln(w, c)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+
+	b, err := d.Batch(ctx)
+	if err != nil {
+		return err
+	}
+	for _, c := range cids {
+		has, err := d.Has(ctx, multihashKey(c))
+		if err != nil {
+			return err
+		}
+		if has {
+			// the same data under another CID
+			continue
+		}
+		v, err := d.Get(ctx, cidKey(c))
+		if err != nil {
+			return fmt.Errorf("cannot read block %s: %w", c, err)
+		}
+		if err := b.Put(ctx, multihashKey(c), v); err != nil {
+			return err
+		}
+	}
+	if err := b.Commit(ctx); err != nil {
+		return err
+	}
+	if err := d.Sync(ctx, blocksPrefix); err != nil {
+		return err
+	}
+
+	b, err = d.Batch(ctx)
+	if err != nil {
+		return err
+	}
+	for _, c := range cids {
+		if err := b.Delete(ctx, cidKey(c)); err != nil {
+			return err
+		}
+	}
+	return b.Commit(ctx)
+}
+
+// revert11to12 keys the blocks listed in cidsFile by their CID again. Their
+// multihash keys are left, and are removed by the next garbage collection
+// unless the blocks are pinned as CIDv0. Blocks added by CIDv1 since the
+// migration stay under their multihash.
+func revert11to12(ctx context.Context, ipfsDir string, dryRun bool, logger *log.Logger) error {
+	p := filepath.Join(ipfsDir, cidsFile)
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		logger.Printf("  no %s, no block keys to revert", cidsFile)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	d, err := openDatastore(ipfsDir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	var reverted, missing int
+	b, err := d.Batch(ctx)
+	if err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		c, err := cid.Decode(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("invalid line in %s: %w", cidsFile, err)
+		}
+		has, err := d.Has(ctx, cidKey(c))
+		if err != nil {
+			return err
+		}
+		if has {
+			// not moved yet, or listed twice
+			continue
+		}
+		v, err := d.Get(ctx, multihashKey(c))
+		if err == datastore.ErrNotFound {
+			// removed since the migration
+			missing++
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		reverted++
+		if dryRun {
+			continue
+		}
+		if err := b.Put(ctx, cidKey(c), v); err != nil {
+			return err
+		}
+		if reverted%batchSize == 0 {
+			if err := b.Commit(ctx); err != nil {
+				return err
+			}
+			if b, err = d.Batch(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if dryRun {
+		logger.Printf("  %d blocks would be keyed by their CIDv1 again, %d were removed since the migration", reverted, missing)
+		return nil
+	}
+	if err := b.Commit(ctx); err != nil {
+		return err
+	}
+	if err := d.Sync(ctx, blocksPrefix); err != nil {
+		return err
+	}
+	logger.Printf("  %d blocks are keyed by their CIDv1 again, %d were removed since the migration", reverted, missing)
+	f.Close()
+	return os.Remove(p)
+}
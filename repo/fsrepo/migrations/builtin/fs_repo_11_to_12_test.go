@@ -0,0 +1,101 @@
+package builtin
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	config "github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/plugin/loader"
+	"github.com/ipfs/kubo/repo/fsrepo"
+	"github.com/ipfs/kubo/repo/fsrepo/migrations"
+
+	cid "github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	// the datastores of fsrepo are plugins
+	l, err := loader.NewPluginLoader("")
+	if err != nil {
+		panic(err)
+	}
+	if err := l.Initialize(); err != nil {
+		panic(err)
+	}
+	if err := l.Inject(); err != nil {
+		panic(err)
+	}
+}
+
+func testCid(t *testing.T, codec uint64, data string) cid.Cid {
+	h, err := multihash.Sum([]byte(data), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	if codec == cid.DagProtobuf {
+		return cid.NewCidV0(h)
+	}
+	return cid.NewCidV1(codec, h)
+}
+
+func TestMigration11to12(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	require.NoError(t, fsrepo.Init(dir, &config.Config{Datastore: config.DefaultDatastoreConfig()}))
+	require.NoError(t, migrations.WriteRepoVersion(dir, 11))
+
+	v0 := testCid(t, cid.DagProtobuf, "v0")
+	raw := testCid(t, cid.Raw, "raw")
+	// the same data as v0, under a CIDv1
+	dupe := cid.NewCidV1(cid.DagProtobuf, v0.Hash())
+	blocks := map[cid.Cid]string{v0: "v0", raw: "raw", dupe: "v0"}
+
+	d, err := openDatastore(dir)
+	require.NoError(t, err)
+	for c, v := range blocks {
+		require.NoError(t, d.Put(ctx, cidKey(c), []byte(v)))
+	}
+	require.NoError(t, d.Close())
+
+	checkKeys := func(keys map[datastore.Key]string) {
+		t.Helper()
+		d, err := openDatastore(dir)
+		require.NoError(t, err)
+		defer d.Close()
+		// a CIDv0 key is its multihash key
+		all := map[datastore.Key]bool{}
+		for _, c := range []cid.Cid{v0, raw, dupe} {
+			all[cidKey(c)] = true
+			all[multihashKey(c)] = true
+		}
+		for k := range all {
+			v, err := d.Get(ctx, k)
+			if want, ok := keys[k]; ok {
+				require.NoError(t, err, k)
+				require.Equal(t, want, string(v))
+			} else {
+				require.Equal(t, datastore.ErrNotFound, err, k)
+			}
+		}
+	}
+
+	require.NoError(t, migrations.RunMigrationWithOptions(ctx, nil, 12, dir, migrations.MigrationOptions{DryRun: true}))
+	checkKeys(map[datastore.Key]string{cidKey(v0): "v0", cidKey(raw): "raw", cidKey(dupe): "v0"})
+
+	require.NoError(t, migrations.RunMigration(ctx, nil, 12, dir, false))
+	ver, err := migrations.RepoVersion(dir)
+	require.NoError(t, err)
+	require.Equal(t, 12, ver)
+	checkKeys(map[datastore.Key]string{multihashKey(v0): "v0", multihashKey(raw): "raw"})
+
+	require.NoError(t, migrations.RunMigration(ctx, nil, 11, dir, true))
+	ver, err = migrations.RepoVersion(dir)
+	require.NoError(t, err)
+	require.Equal(t, 11, ver)
+	// the multihash key of raw is left for the garbage collection
+	checkKeys(map[datastore.Key]string{cidKey(v0): "v0", cidKey(raw): "raw", cidKey(dupe): "v0", multihashKey(raw): "raw"})
+	_, err = os.Stat(filepath.Join(dir, cidsFile))
+	require.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,173 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// registerTestMigrations registers built-in migrations from version 100 to
+// 103 that append to a "steps" file of the repo. The migration from failVer
+// fails after writing.
+func registerTestMigrations(t *testing.T, failVer int) {
+	step := func(s string, fail bool) func(context.Context, string, bool, *log.Logger) error {
+		return func(ctx context.Context, ipfsDir string, dryRun bool, logger *log.Logger) error {
+			if dryRun {
+				return nil
+			}
+			f, err := os.OpenFile(filepath.Join(ipfsDir, "steps"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if _, err = f.WriteString(s); err != nil {
+				return err
+			}
+			if fail {
+				return errors.New("failed")
+			}
+			return nil
+		}
+	}
+	for v := 100; v < 103; v++ {
+		s := string(rune('a' + v - 100))
+		err := RegisterMigration(&Migration{
+			From:   v,
+			Apply:  step(s, v == failVer),
+			Revert: step("-"+s, false),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		for v := 100; v < 103; v++ {
+			delete(builtinMigrations, migrationName(v, v+1))
+		}
+	})
+}
+
+func initTestRepo(t *testing.T, ver int) string {
+	dir := filepath.Join(t.TempDir(), "ipfs")
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteRepoVersion(dir, ver); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func checkRepo(t *testing.T, dir string, ver int, steps string) {
+	t.Helper()
+	v, err := RepoVersion(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != ver {
+		t.Fatalf("expected repo version %d, got %d", ver, v)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "steps"))
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	if string(b) != steps {
+		t.Fatalf("expected steps %q, got %q", steps, b)
+	}
+}
+
+func TestRegisterMigration(t *testing.T) {
+	registerTestMigrations(t, 0)
+	if err := RegisterMigration(&Migration{From: 100, Apply: builtinMigrations["fs-repo-100-to-101"].Apply}); err == nil {
+		t.Fatal("expected error registering a migration twice")
+	}
+
+	migs, bins, err := findMigrations(context.Background(), 100, 103)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migs) != 3 || len(bins) != 0 {
+		t.Fatal("built-in migrations should be found without binaries")
+	}
+}
+
+func TestRunBuiltinMigrations(t *testing.T) {
+	registerTestMigrations(t, 0)
+	ctx := context.Background()
+	dir := initTestRepo(t, 100)
+
+	// no fetcher is needed
+	err := RunMigrationWithOptions(ctx, nil, 103, dir, MigrationOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRepo(t, dir, 100, "")
+
+	if err = RunMigration(ctx, nil, 103, dir, false); err != nil {
+		t.Fatal(err)
+	}
+	checkRepo(t, dir, 103, "abc")
+
+	if err = RunMigration(ctx, nil, 101, dir, false); err == nil {
+		t.Fatal("expected downgrade to fail")
+	}
+	if err = RunMigration(ctx, nil, 101, dir, true); err != nil {
+		t.Fatal(err)
+	}
+	checkRepo(t, dir, 101, "abc-c-b")
+}
+
+func TestBuiltinMigrationRollback(t *testing.T) {
+	registerTestMigrations(t, 102)
+	ctx := context.Background()
+
+	// the migrations that ran are reverted, the failed one too
+	dir := initTestRepo(t, 100)
+	if err := RunMigration(ctx, nil, 103, dir, false); err == nil {
+		t.Fatal("expected migration to fail")
+	}
+	checkRepo(t, dir, 100, "abc-c-b-a")
+
+	// the repo is restored from its backup
+	dir = initTestRepo(t, 100)
+	if err := RunMigrationWithOptions(ctx, nil, 103, dir, MigrationOptions{Backup: true}); err == nil {
+		t.Fatal("expected migration to fail")
+	}
+	checkRepo(t, dir, 100, "")
+	if _, err := os.Stat(dir + ".backup-v100"); !os.IsNotExist(err) {
+		t.Fatal("the backup should have been moved back")
+	}
+	if _, err := os.Stat(dir + ".failed"); !os.IsNotExist(err) {
+		t.Fatal("the failed repo should have been removed")
+	}
+}
+
+func TestBuiltinMigrationBackup(t *testing.T) {
+	registerTestMigrations(t, 0)
+	dir := initTestRepo(t, 100)
+	if err := os.WriteFile(filepath.Join(dir, "repo.lock"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	err := RunMigrationWithOptions(context.Background(), nil, 103, dir, MigrationOptions{Backup: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRepo(t, dir, 103, "abc")
+	checkRepo(t, dir+".backup-v100", 100, "")
+	if _, err := os.Stat(filepath.Join(dir+".backup-v100", "repo.lock")); !os.IsNotExist(err) {
+		t.Fatal("the lock of the repo should not be copied")
+	}
+
+	// an existing backup is not overwritten
+	if err = WriteRepoVersion(dir, 100); err != nil {
+		t.Fatal(err)
+	}
+	err = RunMigrationWithOptions(context.Background(), nil, 103, dir, MigrationOptions{Backup: true})
+	if err == nil {
+		t.Fatal("expected the backup to fail")
+	}
+}
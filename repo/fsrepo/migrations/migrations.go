@@ -23,9 +23,33 @@ const (
 	distFSRM     = "fs-repo-migrations"
 )
 
+// MigrationOptions are the options of RunMigrationWithOptions.
+type MigrationOptions struct {
+	// AllowDowngrade allows migrating the repo to a lower version.
+	AllowDowngrade bool
+	// DryRun only logs the migrations that would run. Built-in migrations
+	// log the changes they would make to the repo, as it is now. Migration
+	// binaries are neither fetched nor run.
+	DryRun bool
+	// Backup copies the repo directory next to it before migrating. When a
+	// migration fails, the repo is restored from the copy.
+	Backup bool
+}
+
 // RunMigration finds, downloads, and runs the individual migrations needed to
 // migrate the repo from its current version to the target version.
 func RunMigration(ctx context.Context, fetcher Fetcher, targetVer int, ipfsDir string, allowDowngrade bool) error {
+	return RunMigrationWithOptions(ctx, fetcher, targetVer, ipfsDir, MigrationOptions{AllowDowngrade: allowDowngrade})
+}
+
+// RunMigrationWithOptions migrates the repo from its current version to the
+// target version. The migrations compiled into ipfs are used when there are,
+// and the binaries of the others are found in the PATH or downloaded.
+//
+// When a migration fails, the repo is put back to the version it had: it is
+// restored from its backup if one was made, or the migrations that already
+// ran are reverted.
+func RunMigrationWithOptions(ctx context.Context, fetcher Fetcher, targetVer int, ipfsDir string, opts MigrationOptions) error {
 	ipfsDir, err := CheckIpfsDir(ipfsDir)
 	if err != nil {
 		return err
@@ -38,28 +62,49 @@ func RunMigration(ctx context.Context, fetcher Fetcher, targetVer int, ipfsDir s
 		// repo already at target version number
 		return nil
 	}
-	if fromVer > targetVer && !allowDowngrade {
+	if fromVer > targetVer && !opts.AllowDowngrade {
 		return fmt.Errorf("downgrade not allowed from %d to %d", fromVer, targetVer)
 	}
 
 	logger := log.New(os.Stdout, "", 0)
 
-	logger.Print("Looking for suitable migration binaries.")
+	logger.Print("Looking for suitable migrations.")
 
 	migrations, binPaths, err := findMigrations(ctx, fromVer, targetVer)
 	if err != nil {
 		return err
 	}
 
-	// Download migrations that were not found
-	if len(binPaths) < len(migrations) {
-		missing := make([]string, 0, len(migrations)-len(binPaths))
-		for _, mig := range migrations {
-			if _, ok := binPaths[mig]; !ok {
-				missing = append(missing, mig)
+	var revert bool
+	if fromVer > targetVer {
+		revert = true
+	}
+
+	if opts.DryRun {
+		for _, migration := range migrations {
+			if m := builtinMigrations[migration]; m != nil {
+				logger.Println("Would run built-in migration", migration)
+				if err = runBuiltinMigration(ctx, m, ipfsDir, revert, true, logger); err != nil {
+					return fmt.Errorf("migration %s failed: %s", migration, err)
+				}
+			} else if bin, ok := binPaths[migration]; ok {
+				logger.Println("Would run", bin)
+			} else {
+				logger.Println("Would download and run", migration)
 			}
 		}
+		logger.Printf("Dry run: fs-repo would be migrated to version %d.\n", targetVer)
+		return nil
+	}
 
+	// Download migrations that were not found
+	var missing []string
+	for _, mig := range migrations {
+		if _, ok := binPaths[mig]; !ok && builtinMigrations[mig] == nil {
+			missing = append(missing, mig)
+		}
+	}
+	if len(missing) != 0 {
 		logger.Println("Need", len(missing), "migrations, downloading.")
 
 		tmpDir, err := os.MkdirTemp("", "migrations")
@@ -79,22 +124,69 @@ func RunMigration(ctx context.Context, fetcher Fetcher, targetVer int, ipfsDir s
 		}
 	}
 
-	var revert bool
-	if fromVer > targetVer {
-		revert = true
+	var backupDir string
+	if opts.Backup {
+		backupDir, err = backupRepo(ipfsDir, fromVer, logger)
+		if err != nil {
+			return err
+		}
 	}
-	for _, migration := range migrations {
+
+	for i, migration := range migrations {
 		logger.Println("Running migration", migration, "...")
-		err = runMigration(ctx, binPaths[migration], ipfsDir, revert, logger)
+		if m := builtinMigrations[migration]; m != nil {
+			err = runBuiltinMigration(ctx, m, ipfsDir, revert, false, logger)
+		} else {
+			err = runMigration(ctx, binPaths[migration], ipfsDir, revert, logger)
+		}
 		if err != nil {
-			return fmt.Errorf("migration %s failed: %s", migration, err)
+			err = fmt.Errorf("migration %s failed: %s", migration, err)
+			if rbErr := rollback(ipfsDir, backupDir, migrations[:i+1], binPaths, revert, logger); rbErr != nil {
+				return fmt.Errorf("%s, and the rollback failed: %s", err, rbErr)
+			}
+			logger.Printf("Rolled back fs-repo to version %d.\n", fromVer)
+			return err
 		}
 	}
 	logger.Printf("Success: fs-repo migrated to version %d.\n", targetVer)
+	if backupDir != "" {
+		logger.Println("The backup of the repo is in", backupDir, "and can be removed.")
+	}
 
 	return nil
 }
 
+// rollback puts the repo back to the version it had before the given
+// migrations ran, the last of which failed. The repo is restored from its
+// backup if there is one. Otherwise the migrations are run in the other
+// direction: a failed built-in migration too, while a failed migration binary
+// is expected to undo its own changes.
+func rollback(ipfsDir, backupDir string, done []string, binPaths map[string]string, revert bool, logger *log.Logger) error {
+	if backupDir != "" {
+		return restoreRepo(ipfsDir, backupDir, logger)
+	}
+	if builtinMigrations[done[len(done)-1]] == nil {
+		done = done[:len(done)-1]
+	}
+	// the migrations may have failed because ctx is done, the rollback
+	// must run anyway
+	ctx := context.Background()
+	for i := len(done) - 1; i >= 0; i-- {
+		migration := done[i]
+		logger.Println("Rolling back migration", migration, "...")
+		var err error
+		if m := builtinMigrations[migration]; m != nil {
+			err = runBuiltinMigration(ctx, m, ipfsDir, !revert, false, logger)
+		} else {
+			err = runMigration(ctx, binPaths[migration], ipfsDir, !revert, logger)
+		}
+		if err != nil {
+			return fmt.Errorf("migration %s: %s", migration, err)
+		}
+	}
+	return nil
+}
+
 func NeedMigration(target int) (bool, error) {
 	vnum, err := RepoVersion("")
 	if err != nil {
@@ -201,7 +293,7 @@ func migrationName(from, to int) string {
 
 // findMigrations returns a list of migrations, ordered from first to last
 // migration to apply, and a map of locations of migration binaries of any
-// migrations that were found.
+// migrations that were found. Built-in migrations are not looked up.
 func findMigrations(ctx context.Context, from, to int) ([]string, map[string]string, error) {
 	step := 1
 	count := to - from
@@ -224,6 +316,9 @@ func findMigrations(ctx context.Context, from, to int) ([]string, map[string]str
 			migName = migrationName(cur, cur+step)
 		}
 		migrations = append(migrations, migName)
+		if builtinMigrations[migName] != nil {
+			continue
+		}
 		bin, err := exec.LookPath(migName)
 		if err != nil {
 			continue
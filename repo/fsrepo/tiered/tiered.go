@@ -0,0 +1,648 @@
+// Package tiered implements a datastore keeping its keys in two datastores: a
+// fast hot tier, where writes land and where the keys read from the cold tier
+// are promoted to, and a cold tier of larger capacity, where the least
+// recently used keys are demoted to when the hot tier is full or when they
+// were not used for a while.
+package tiered
+
+import (
+	"container/list"
+	"context"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	multierror "github.com/hashicorp/go-multierror"
+	ds "github.com/ipfs/go-datastore"
+	query "github.com/ipfs/go-datastore/query"
+	logging "github.com/ipfs/go-log"
+)
+
+var log = logging.Logger("tiered")
+
+// demoteInterval is how often the keys not used for Options.DemoteAfter are
+// demoted.
+var demoteInterval = time.Minute
+
+// Options configures a tiered datastore.
+type Options struct {
+	// Name identifies the datastore in its stats, such as its mountpoint.
+	Name string
+
+	// HotMaxSize is the size of the hot tier above which the least recently
+	// used keys are demoted, until it is 90% full. 0 is no limit.
+	HotMaxSize uint64
+
+	// ColdMaxSize is the size of the cold tier above which keys are no longer
+	// demoted: the hot tier then grows over HotMaxSize. 0 is no limit.
+	ColdMaxSize uint64
+
+	// DemoteAfter is how long a key stays in the hot tier without being
+	// used. 0 is until the hot tier is full.
+	DemoteAfter time.Duration
+
+	// NoPromote leaves the keys read from the cold tier there.
+	NoPromote bool
+}
+
+// Stat holds the sizes and counters of a tiered datastore. Sizes are the
+// sizes of the values, not the space used on disk.
+type Stat struct {
+	Name        string
+	HotSize     uint64
+	HotMaxSize  uint64
+	HotObjects  uint64
+	ColdSize    uint64
+	ColdMaxSize uint64
+	Promotions  uint64
+	Demotions   uint64
+}
+
+// Datastore is a tiered datastore. The keys of the hot tier are indexed in
+// memory, by order of use.
+type Datastore struct {
+	hot, cold ds.Batching
+	opts      Options
+
+	// serialize the moves of keys between tiers and their writes
+	locks [256]sync.Mutex
+
+	lk         sync.Mutex
+	lru        *list.List // of *entry, least recently used first
+	index      map[ds.Key]*list.Element
+	hotSize    uint64
+	coldSize   uint64
+	promotions uint64
+	demotions  uint64
+	coldFull   bool
+
+	demote  chan struct{}
+	closing chan struct{}
+	done    chan struct{}
+}
+
+type entry struct {
+	key  ds.Key
+	size uint64
+	used time.Time
+}
+
+var _ ds.Batching = (*Datastore)(nil)
+var _ ds.PersistentDatastore = (*Datastore)(nil)
+var _ ds.Shim = (*Datastore)(nil)
+
+// New returns a tiered datastore over the hot and cold datastores. It reads
+// the keys of the hot tier, whose order of use is lost when it is closed, and
+// the sizes of the cold tier.
+func New(hot, cold ds.Batching, opts Options) (*Datastore, error) {
+	d, err := newDatastore(hot, cold, opts)
+	if err != nil {
+		return nil, err
+	}
+	go d.run()
+	d.signalDemote()
+	return d, nil
+}
+
+// newDatastore returns a tiered datastore which does not demote keys until
+// its run method is started.
+func newDatastore(hot, cold ds.Batching, opts Options) (*Datastore, error) {
+	ctx := context.Background()
+	d := &Datastore{
+		hot:     hot,
+		cold:    cold,
+		opts:    opts,
+		lru:     list.New(),
+		index:   make(map[ds.Key]*list.Element),
+		demote:  make(chan struct{}, 1),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	res, err := hot.Query(ctx, query.Query{KeysOnly: true, ReturnsSizes: true})
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for r := range res.Next() {
+		if r.Error != nil {
+			res.Close()
+			return nil, r.Error
+		}
+		k := ds.RawKey(r.Key)
+		var size uint64
+		if r.Size > 0 {
+			size = uint64(r.Size)
+		}
+		d.index[k] = d.lru.PushBack(&entry{key: k, size: size, used: now})
+		d.hotSize += size
+	}
+	res.Close()
+
+	d.coldSize, err = valuesSize(ctx, cold)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// valuesSize returns the total size of the values of d.
+func valuesSize(ctx context.Context, d ds.Datastore) (uint64, error) {
+	res, err := d.Query(ctx, query.Query{KeysOnly: true, ReturnsSizes: true})
+	if err != nil {
+		return 0, err
+	}
+	defer res.Close()
+	var total uint64
+	for r := range res.Next() {
+		if r.Error != nil {
+			return 0, r.Error
+		}
+		if r.Size > 0 {
+			total += uint64(r.Size)
+		}
+	}
+	return total, nil
+}
+
+// Stat returns the stats of the datastore.
+func (d *Datastore) Stat() Stat {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	return Stat{
+		Name:        d.opts.Name,
+		HotSize:     d.hotSize,
+		HotMaxSize:  d.opts.HotMaxSize,
+		HotObjects:  uint64(len(d.index)),
+		ColdSize:    d.coldSize,
+		ColdMaxSize: d.opts.ColdMaxSize,
+		Promotions:  d.promotions,
+		Demotions:   d.demotions,
+	}
+}
+
+func (d *Datastore) stripe(k ds.Key) int {
+	h := fnv.New32a()
+	h.Write(k.Bytes())
+	return int(h.Sum32() % uint32(len(d.locks)))
+}
+
+func (d *Datastore) keyLock(k ds.Key) *sync.Mutex {
+	return &d.locks[d.stripe(k)]
+}
+
+// lockKeys locks the key locks of keys in a fixed order, and returns a
+// function unlocking them.
+func (d *Datastore) lockKeys(keys []ds.Key) func() {
+	set := make(map[int]struct{})
+	for _, k := range keys {
+		set[d.stripe(k)] = struct{}{}
+	}
+	stripes := make([]int, 0, len(set))
+	for i := range set {
+		stripes = append(stripes, i)
+	}
+	sort.Ints(stripes)
+	for _, i := range stripes {
+		d.locks[i].Lock()
+	}
+	return func() {
+		for _, i := range stripes {
+			d.locks[i].Unlock()
+		}
+	}
+}
+
+// touch records a write of size bytes to k in the hot tier.
+func (d *Datastore) touch(k ds.Key, size uint64) {
+	d.lk.Lock()
+	if el, ok := d.index[k]; ok {
+		e := el.Value.(*entry)
+		d.hotSize -= e.size
+		e.size = size
+		e.used = time.Now()
+		d.lru.MoveToBack(el)
+	} else {
+		d.index[k] = d.lru.PushBack(&entry{key: k, size: size, used: time.Now()})
+	}
+	d.hotSize += size
+	full := d.opts.HotMaxSize != 0 && d.hotSize > d.opts.HotMaxSize
+	d.lk.Unlock()
+	if full {
+		d.signalDemote()
+	}
+}
+
+// used records a read of k.
+func (d *Datastore) used(k ds.Key) {
+	d.lk.Lock()
+	if el, ok := d.index[k]; ok {
+		el.Value.(*entry).used = time.Now()
+		d.lru.MoveToBack(el)
+	}
+	d.lk.Unlock()
+}
+
+// forget records the removal of k from the hot tier.
+func (d *Datastore) forget(k ds.Key) {
+	d.lk.Lock()
+	if el, ok := d.index[k]; ok {
+		d.hotSize -= el.Value.(*entry).size
+		d.lru.Remove(el)
+		delete(d.index, k)
+	}
+	d.lk.Unlock()
+}
+
+func (d *Datastore) addColdSize(size uint64, removed bool) {
+	d.lk.Lock()
+	if !removed {
+		d.coldSize += size
+	} else if size > d.coldSize {
+		d.coldSize = 0
+	} else {
+		d.coldSize -= size
+	}
+	d.lk.Unlock()
+}
+
+// deleteCold removes k from the cold tier, if it is there.
+func (d *Datastore) deleteCold(ctx context.Context, k ds.Key) error {
+	size, err := d.cold.GetSize(ctx, k)
+	if err == ds.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := d.cold.Delete(ctx, k); err != nil {
+		return err
+	}
+	d.addColdSize(uint64(size), true)
+	return nil
+}
+
+// Put writes k to the hot tier. A value of k in the cold tier is removed.
+func (d *Datastore) Put(ctx context.Context, k ds.Key, value []byte) error {
+	l := d.keyLock(k)
+	l.Lock()
+	defer l.Unlock()
+	if err := d.hot.Put(ctx, k, value); err != nil {
+		return err
+	}
+	d.touch(k, uint64(len(value)))
+	return d.deleteCold(ctx, k)
+}
+
+// Get reads k from the hot tier, or from the cold tier and promotes it.
+func (d *Datastore) Get(ctx context.Context, k ds.Key) ([]byte, error) {
+	v, err := d.hot.Get(ctx, k)
+	if err == nil {
+		d.used(k)
+		return v, nil
+	}
+	if err != ds.ErrNotFound {
+		return nil, err
+	}
+	v, err = d.cold.Get(ctx, k)
+	if err == ds.ErrNotFound {
+		// promoted since it was looked up in the hot tier
+		return d.hot.Get(ctx, k)
+	}
+	if err != nil || d.opts.NoPromote {
+		return v, err
+	}
+	if err := d.promote(ctx, k, v); err != nil {
+		log.Errorf("cannot promote %s: %s", k, err)
+	}
+	return v, nil
+}
+
+// promote moves k, of value v, from the cold tier to the hot tier, unless it
+// was written or removed since v was read.
+func (d *Datastore) promote(ctx context.Context, k ds.Key, v []byte) error {
+	l := d.keyLock(k)
+	l.Lock()
+	defer l.Unlock()
+	has, err := d.hot.Has(ctx, k)
+	if err != nil || has {
+		return err
+	}
+	has, err = d.cold.Has(ctx, k)
+	if err != nil || !has {
+		return err
+	}
+	if err := d.hot.Put(ctx, k, v); err != nil {
+		return err
+	}
+	if err := d.hot.Sync(ctx, k); err != nil {
+		return err
+	}
+	d.touch(k, uint64(len(v)))
+	if err := d.cold.Delete(ctx, k); err != nil {
+		return err
+	}
+	d.addColdSize(uint64(len(v)), true)
+	d.lk.Lock()
+	d.promotions++
+	d.lk.Unlock()
+	return nil
+}
+
+// Has returns whether k is in either tier.
+func (d *Datastore) Has(ctx context.Context, k ds.Key) (bool, error) {
+	has, err := d.hot.Has(ctx, k)
+	if err != nil || has {
+		return has, err
+	}
+	has, err = d.cold.Has(ctx, k)
+	if err != nil || has {
+		return has, err
+	}
+	// promoted since it was looked up in the hot tier
+	return d.hot.Has(ctx, k)
+}
+
+// GetSize returns the size of the value of k in either tier.
+func (d *Datastore) GetSize(ctx context.Context, k ds.Key) (int, error) {
+	size, err := d.hot.GetSize(ctx, k)
+	if err != ds.ErrNotFound {
+		return size, err
+	}
+	size, err = d.cold.GetSize(ctx, k)
+	if err != ds.ErrNotFound {
+		return size, err
+	}
+	return d.hot.GetSize(ctx, k)
+}
+
+// Delete removes k from both tiers.
+func (d *Datastore) Delete(ctx context.Context, k ds.Key) error {
+	l := d.keyLock(k)
+	l.Lock()
+	defer l.Unlock()
+	if err := d.hot.Delete(ctx, k); err != nil {
+		return err
+	}
+	d.forget(k)
+	return d.deleteCold(ctx, k)
+}
+
+// Query returns the keys of the hot tier, then the ones of the cold tier. A
+// key moving between tiers during the query may be missed. Orders, limits
+// and offsets are applied to the results of both tiers.
+func (d *Datastore) Query(ctx context.Context, q query.Query) (query.Results, error) {
+	cq := q
+	cq.Orders, cq.Limit, cq.Offset = nil, 0, 0
+	hot, err := d.hot.Query(ctx, cq)
+	if err != nil {
+		return nil, err
+	}
+	cold, err := d.cold.Query(ctx, cq)
+	if err != nil {
+		hot.Close()
+		return nil, err
+	}
+
+	seen := make(map[string]struct{})
+	current := hot
+	res := query.ResultsFromIterator(cq, query.Iterator{
+		Next: func() (query.Result, bool) {
+			for {
+				r, ok := current.NextSync()
+				if !ok {
+					if current == cold {
+						return r, false
+					}
+					current = cold
+					continue
+				}
+				if r.Error == nil {
+					if current == hot {
+						seen[r.Key] = struct{}{}
+					} else if _, ok := seen[r.Key]; ok {
+						// demoted since it was returned
+						continue
+					}
+				}
+				return r, true
+			}
+		},
+		Close: func() error {
+			return combine(hot.Close(), cold.Close())
+		},
+	})
+	res = query.NaiveQueryApply(query.Query{Orders: q.Orders, Limit: q.Limit, Offset: q.Offset}, res)
+	return query.ResultsReplaceQuery(res, q), nil
+}
+
+// Children returns the hot and the cold tiers.
+func (d *Datastore) Children() []ds.Datastore {
+	return []ds.Datastore{d.hot, d.cold}
+}
+
+// Sync syncs both tiers.
+func (d *Datastore) Sync(ctx context.Context, prefix ds.Key) error {
+	return combine(d.hot.Sync(ctx, prefix), d.cold.Sync(ctx, prefix))
+}
+
+// DiskUsage returns the disk usage of both tiers.
+func (d *Datastore) DiskUsage(ctx context.Context) (uint64, error) {
+	hot, err := ds.DiskUsage(ctx, d.hot)
+	if err != nil {
+		return 0, err
+	}
+	cold, err := ds.DiskUsage(ctx, d.cold)
+	if err != nil {
+		return 0, err
+	}
+	return hot + cold, nil
+}
+
+// Close stops the demotions and closes both tiers.
+func (d *Datastore) Close() error {
+	close(d.closing)
+	<-d.done
+	return combine(d.hot.Close(), d.cold.Close())
+}
+
+type batchOp struct {
+	value  []byte
+	delete bool
+}
+
+type batch struct {
+	d   *Datastore
+	ops map[ds.Key]batchOp
+}
+
+// Batch returns a batch writing to the hot tier.
+func (d *Datastore) Batch(ctx context.Context) (ds.Batch, error) {
+	return &batch{d: d, ops: make(map[ds.Key]batchOp)}, nil
+}
+
+func (b *batch) Put(ctx context.Context, k ds.Key, value []byte) error {
+	b.ops[k] = batchOp{value: value}
+	return nil
+}
+
+func (b *batch) Delete(ctx context.Context, k ds.Key) error {
+	b.ops[k] = batchOp{delete: true}
+	return nil
+}
+
+func (b *batch) Commit(ctx context.Context) error {
+	d := b.d
+	keys := make([]ds.Key, 0, len(b.ops))
+	for k := range b.ops {
+		keys = append(keys, k)
+	}
+	defer d.lockKeys(keys)()
+
+	hb, err := d.hot.Batch(ctx)
+	if err != nil {
+		return err
+	}
+	for k, op := range b.ops {
+		if op.delete {
+			err = hb.Delete(ctx, k)
+		} else {
+			err = hb.Put(ctx, k, op.value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := hb.Commit(ctx); err != nil {
+		return err
+	}
+
+	for k, op := range b.ops {
+		if op.delete {
+			d.forget(k)
+		} else {
+			d.touch(k, uint64(len(op.value)))
+		}
+		if err := d.deleteCold(ctx, k); err != nil {
+			return err
+		}
+	}
+	b.ops = make(map[ds.Key]batchOp)
+	return nil
+}
+
+func combine(errs ...error) error {
+	return multierror.Append(nil, errs...).ErrorOrNil()
+}
+
+func (d *Datastore) signalDemote() {
+	select {
+	case d.demote <- struct{}{}:
+	default:
+	}
+}
+
+func (d *Datastore) run() {
+	defer close(d.done)
+	ticker := time.NewTicker(demoteInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.closing:
+			return
+		case <-ticker.C:
+		case <-d.demote:
+		}
+		d.demoteKeys(time.Now())
+	}
+}
+
+// demoteKeys demotes the least recently used keys while the hot tier is
+// over 90% of its size, once it went over it, and the keys not used since
+// DemoteAfter.
+func (d *Datastore) demoteKeys(now time.Time) {
+	ctx := context.Background()
+	d.lk.Lock()
+	target := d.hotSize
+	if d.opts.HotMaxSize != 0 && d.hotSize > d.opts.HotMaxSize {
+		target = d.opts.HotMaxSize / 10 * 9
+	}
+	d.lk.Unlock()
+
+	for {
+		select {
+		case <-d.closing:
+			return
+		default:
+		}
+
+		d.lk.Lock()
+		el := d.lru.Front()
+		if el == nil {
+			d.lk.Unlock()
+			return
+		}
+		e := el.Value.(*entry)
+		old := d.opts.DemoteAfter != 0 && now.Sub(e.used) > d.opts.DemoteAfter
+		if d.hotSize <= target && !old {
+			d.lk.Unlock()
+			return
+		}
+		if d.opts.ColdMaxSize != 0 && d.coldSize+e.size > d.opts.ColdMaxSize {
+			if !d.coldFull {
+				log.Warnf("the cold tier of %s is full, keys are no longer demoted", d.opts.Name)
+			}
+			d.coldFull = true
+			d.lk.Unlock()
+			return
+		}
+		d.coldFull = false
+		d.lk.Unlock()
+
+		if err := d.demoteKey(ctx, el); err != nil {
+			log.Errorf("cannot demote %s: %s", e.key, err)
+			return
+		}
+	}
+}
+
+// demoteKey moves the key of el from the hot tier to the cold tier, unless it
+// was used or removed since el was the least recently used key.
+func (d *Datastore) demoteKey(ctx context.Context, el *list.Element) error {
+	k := el.Value.(*entry).key
+	l := d.keyLock(k)
+	l.Lock()
+	defer l.Unlock()
+
+	d.lk.Lock()
+	current := d.lru.Front() == el
+	d.lk.Unlock()
+	if !current {
+		return nil
+	}
+
+	v, err := d.hot.Get(ctx, k)
+	if err == ds.ErrNotFound {
+		d.forget(k)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := d.cold.Put(ctx, k, v); err != nil {
+		return err
+	}
+	if err := d.cold.Sync(ctx, k); err != nil {
+		return err
+	}
+	d.addColdSize(uint64(len(v)), false)
+	if err := d.hot.Delete(ctx, k); err != nil {
+		return err
+	}
+	d.forget(k)
+	d.lk.Lock()
+	d.demotions++
+	d.lk.Unlock()
+	return nil
+}
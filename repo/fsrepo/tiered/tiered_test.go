@@ -0,0 +1,156 @@
+package tiered
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	dssync "github.com/ipfs/go-datastore/sync"
+	dstest "github.com/ipfs/go-datastore/test"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestDatastore returns a tiered datastore whose demotions are run by the
+// test.
+func newTestDatastore(t *testing.T, opts Options) (*Datastore, ds.Batching, ds.Batching) {
+	hot := dssync.MutexWrap(ds.NewMapDatastore())
+	cold := dssync.MutexWrap(ds.NewMapDatastore())
+	d, err := newDatastore(hot, cold, opts)
+	require.NoError(t, err)
+	close(d.done)
+	t.Cleanup(func() { d.Close() })
+	return d, hot, cold
+}
+
+func TestSuite(t *testing.T) {
+	hot := dssync.MutexWrap(ds.NewMapDatastore())
+	cold := dssync.MutexWrap(ds.NewMapDatastore())
+	d, err := New(hot, cold, Options{HotMaxSize: 1 << 10})
+	require.NoError(t, err)
+	defer d.Close()
+	dstest.SubtestAll(t, d)
+}
+
+func TestDemoteAndPromote(t *testing.T) {
+	ctx := context.Background()
+	d, hot, cold := newTestDatastore(t, Options{Name: "/blocks", HotMaxSize: 100})
+
+	a, b, c := ds.NewKey("/a"), ds.NewKey("/b"), ds.NewKey("/c")
+	require.NoError(t, d.Put(ctx, a, make([]byte, 40)))
+	require.NoError(t, d.Put(ctx, b, make([]byte, 40)))
+	_, err := d.Get(ctx, a)
+	require.NoError(t, err)
+	require.NoError(t, d.Put(ctx, c, make([]byte, 40)))
+
+	// b is the least recently used key
+	d.demoteKeys(time.Now())
+	has, err := hot.Has(ctx, b)
+	require.NoError(t, err)
+	require.False(t, has)
+	has, err = cold.Has(ctx, b)
+	require.NoError(t, err)
+	require.True(t, has)
+	require.Equal(t, Stat{Name: "/blocks", HotSize: 80, HotMaxSize: 100, HotObjects: 2, ColdSize: 40, Demotions: 1}, d.Stat())
+
+	// all keys are listed once
+	res, err := d.Query(ctx, dsq.Query{KeysOnly: true, Orders: []dsq.Order{dsq.OrderByKey{}}})
+	require.NoError(t, err)
+	entries, err := res.Rest()
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	require.Equal(t, "/b", entries[1].Key)
+
+	size, err := d.GetSize(ctx, b)
+	require.NoError(t, err)
+	require.Equal(t, 40, size)
+
+	// reading b promotes it
+	_, err = d.Get(ctx, b)
+	require.NoError(t, err)
+	has, err = cold.Has(ctx, b)
+	require.NoError(t, err)
+	require.False(t, has)
+	require.Equal(t, Stat{Name: "/blocks", HotSize: 120, HotMaxSize: 100, HotObjects: 3, Promotions: 1, Demotions: 1}, d.Stat())
+
+	// keys not used for a while are demoted
+	d.opts.DemoteAfter = time.Hour
+	d.demoteKeys(time.Now().Add(2 * time.Hour))
+	require.Equal(t, Stat{Name: "/blocks", HotMaxSize: 100, ColdSize: 120, Promotions: 1, Demotions: 4}, d.Stat())
+
+	// a put replaces the value in the cold tier
+	require.NoError(t, d.Put(ctx, a, []byte("new")))
+	has, err = cold.Has(ctx, a)
+	require.NoError(t, err)
+	require.False(t, has)
+	v, err := d.Get(ctx, a)
+	require.NoError(t, err)
+	require.Equal(t, []byte("new"), v)
+
+	require.NoError(t, d.Delete(ctx, c))
+	has, err = d.Has(ctx, c)
+	require.NoError(t, err)
+	require.False(t, has)
+	require.Equal(t, Stat{Name: "/blocks", HotSize: 3, HotMaxSize: 100, HotObjects: 1, ColdSize: 40, Promotions: 1, Demotions: 4}, d.Stat())
+
+	// the sizes of both tiers are read back on open
+	d2, err := newDatastore(hot, cold, Options{Name: "/blocks"})
+	require.NoError(t, err)
+	require.Equal(t, Stat{Name: "/blocks", HotSize: 3, HotObjects: 1, ColdSize: 40}, d2.Stat())
+}
+
+func TestPromoteDeleted(t *testing.T) {
+	ctx := context.Background()
+	d, _, cold := newTestDatastore(t, Options{})
+
+	// a key deleted between its read from the cold tier and its promotion
+	// stays deleted
+	k := ds.NewKey("/a")
+	require.NoError(t, cold.Put(ctx, k, []byte("a")))
+	v, err := cold.Get(ctx, k)
+	require.NoError(t, err)
+	require.NoError(t, d.Delete(ctx, k))
+	require.NoError(t, d.promote(ctx, k, v))
+	has, err := d.Has(ctx, k)
+	require.NoError(t, err)
+	require.False(t, has)
+	require.Equal(t, uint64(0), d.Stat().Promotions)
+}
+
+func TestColdMaxSize(t *testing.T) {
+	ctx := context.Background()
+	d, _, cold := newTestDatastore(t, Options{HotMaxSize: 10, ColdMaxSize: 15})
+
+	require.NoError(t, d.Put(ctx, ds.NewKey("/a"), make([]byte, 10)))
+	require.NoError(t, d.Put(ctx, ds.NewKey("/b"), make([]byte, 10)))
+	d.demoteKeys(time.Now())
+
+	// the cold tier has room for a only
+	res, err := cold.Query(ctx, dsq.Query{KeysOnly: true})
+	require.NoError(t, err)
+	entries, err := res.Rest()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, uint64(10), d.Stat().HotSize)
+}
+
+func TestNoPromote(t *testing.T) {
+	ctx := context.Background()
+	hot := dssync.MutexWrap(ds.NewMapDatastore())
+	cold := dssync.MutexWrap(ds.NewMapDatastore())
+	require.NoError(t, cold.Put(ctx, ds.NewKey("/a"), []byte("a")))
+	require.NoError(t, hot.Put(ctx, ds.NewKey("/b"), []byte("bb")))
+
+	d, err := New(hot, cold, Options{NoPromote: true})
+	require.NoError(t, err)
+	defer d.Close()
+	require.Equal(t, uint64(2), d.Stat().HotSize)
+
+	v, err := d.Get(ctx, ds.NewKey("/a"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("a"), v)
+	has, err := hot.Has(ctx, ds.NewKey("/a"))
+	require.NoError(t, err)
+	require.False(t, has)
+}
@@ -0,0 +1,84 @@
+package reprovide
+
+import (
+	"context"
+	"errors"
+
+	cid "github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+)
+
+var exclusionsPrefix = datastore.NewKey("/provider/noprovide")
+
+// Exclusions is the persisted set of pins that must not be provided.
+type Exclusions struct {
+	ds datastore.Datastore
+}
+
+// NewExclusions returns the exclusions stored in ds.
+func NewExclusions(ds datastore.Datastore) *Exclusions {
+	return &Exclusions{ds: ds}
+}
+
+func exclusionKey(c cid.Cid) datastore.Key {
+	return exclusionsPrefix.ChildString(c.String())
+}
+
+// Add excludes the pin c from providing.
+func (e *Exclusions) Add(ctx context.Context, c cid.Cid) error {
+	k := exclusionKey(c)
+	if err := e.ds.Put(ctx, k, nil); err != nil {
+		return err
+	}
+	return e.ds.Sync(ctx, k)
+}
+
+// Remove provides the pin c again.
+func (e *Exclusions) Remove(ctx context.Context, c cid.Cid) error {
+	k := exclusionKey(c)
+	if err := e.ds.Delete(ctx, k); err != nil {
+		return err
+	}
+	return e.ds.Sync(ctx, k)
+}
+
+// Has returns whether the pin c is excluded from providing.
+func (e *Exclusions) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	return e.ds.Has(ctx, exclusionKey(c))
+}
+
+// Set returns all excluded pins.
+func (e *Exclusions) Set(ctx context.Context) (*cid.Set, error) {
+	res, err := e.ds.Query(ctx, query.Query{Prefix: exclusionsPrefix.String(), KeysOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	set := cid.NewSet()
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		c, err := cid.Decode(datastore.RawKey(r.Key).BaseNamespace())
+		if err != nil {
+			log.Errorf("invalid excluded pin %q: %s", r.Key, err)
+			continue
+		}
+		set.Add(c)
+	}
+	return set, nil
+}
+
+// excluded returns the excluded pins of e, which may be nil.
+func (e *Exclusions) excluded(ctx context.Context) (*cid.Set, error) {
+	if e == nil {
+		return cid.NewSet(), nil
+	}
+	set, err := e.Set(ctx)
+	if err != nil {
+		return nil, errors.New("listing pins excluded from providing: " + err.Error())
+	}
+	return set, nil
+}
@@ -0,0 +1,93 @@
+package reprovide
+
+import (
+	"context"
+
+	cid "github.com/ipfs/go-cid"
+	provider "github.com/ipfs/go-ipfs-provider"
+	irouting "github.com/ipfs/kubo/routing"
+	"github.com/multiformats/go-multihash"
+)
+
+// maxPending is the number of new roots waiting to be provided ahead of a
+// running cycle, further roots are queued as usual.
+const maxPending = 1024
+
+// Prioritized wraps a provider system whose reprovides hold up new provides,
+// like the batched one, to provide new roots through pm right away while a
+// cycle runs.
+func (t *Tracker) Prioritized(ctx context.Context, sys provider.System, pm irouting.ProvideMany) provider.System {
+	p := &prioritized{
+		System:  sys,
+		tracker: t,
+		pm:      pm,
+		pending: make(chan cid.Cid, maxPending),
+	}
+	go p.run(ctx)
+	return p
+}
+
+type prioritized struct {
+	provider.System
+	tracker *Tracker
+	pm      irouting.ProvideMany
+	pending chan cid.Cid
+}
+
+// Unwrap returns the wrapped provider system.
+func (p *prioritized) Unwrap() provider.System {
+	return p.System
+}
+
+func (p *prioritized) Provide(c cid.Cid) error {
+	if p.tracker.Running() {
+		select {
+		case p.pending <- c:
+			return nil
+		default:
+		}
+	}
+	return p.System.Provide(c)
+}
+
+func (p *prioritized) run(ctx context.Context) {
+	for {
+		var keys []cid.Cid
+		select {
+		case c := <-p.pending:
+			keys = append(keys, c)
+		case <-ctx.Done():
+			return
+		}
+	drain:
+		for len(keys) < maxPending {
+			select {
+			case c := <-p.pending:
+				keys = append(keys, c)
+			default:
+				break drain
+			}
+		}
+
+		mhs := make([]multihash.Multihash, len(keys))
+		for i, c := range keys {
+			mhs[i] = c.Hash()
+		}
+		if err := p.pm.ProvideMany(ctx, mhs); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Debugf("providing %d new roots failed, queuing them: %s", len(keys), err)
+			for _, c := range keys {
+				if err := p.System.Provide(c); err != nil {
+					log.Errorf("could not queue %s to be provided: %s", c, err)
+				}
+			}
+			continue
+		}
+
+		p.tracker.mu.Lock()
+		p.tracker.prioritized += uint64(len(keys))
+		p.tracker.mu.Unlock()
+	}
+}
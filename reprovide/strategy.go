@@ -0,0 +1,150 @@
+package reprovide
+
+import (
+	"context"
+
+	"github.com/ipfs/go-blockservice"
+	cid "github.com/ipfs/go-cid"
+	"github.com/ipfs/go-cidutil"
+	"github.com/ipfs/go-fetcher"
+	fetcherhelpers "github.com/ipfs/go-fetcher/helpers"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	"github.com/ipfs/go-ipfs-provider/simple"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-mfs"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// walkFunc adds the keys to provide to set, and the roots of the subtrees it
+// listed entirely to walked, so later walks can skip them.
+type walkFunc func(ctx context.Context, set *cidutil.StreamingSet, walked *cid.Set) error
+
+// newKeyProvider returns a key provider listing the keys found by the walks,
+// in order and without duplicates.
+func newKeyProvider(walks ...walkFunc) simple.KeyChanFunc {
+	return func(ctx context.Context) (<-chan cid.Cid, error) {
+		set := cidutil.NewStreamingSet()
+		walked := cid.NewSet()
+		go func() {
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			defer close(set.New)
+
+			for _, walk := range walks {
+				if err := walk(ctx, set, walked); err != nil {
+					if ctx.Err() == nil {
+						log.Errorf("listing keys to reprovide: %s", err)
+					}
+					return
+				}
+			}
+		}()
+		return set.New, nil
+	}
+}
+
+// NewPinnedProvider returns a key provider listing the pinned keys, or only
+// the pinned roots, leaving out the pins in excluded.
+func NewPinnedProvider(onlyRoots bool, pinning pin.Pinner, fetchConfig fetcher.Factory, excluded *Exclusions) simple.KeyChanFunc {
+	return newKeyProvider(walkPinned(onlyRoots, pinning, fetchConfig, excluded))
+}
+
+// NewMFSProvider returns a key provider listing the keys of the MFS tree that
+// are in bs.
+func NewMFSProvider(root *mfs.Root, bs blockstore.Blockstore) simple.KeyChanFunc {
+	return newKeyProvider(walkMFS(root, bs))
+}
+
+// NewPinnedMFSProvider returns a key provider listing the pinned keys, then
+// the keys of the MFS tree.
+func NewPinnedMFSProvider(pinning pin.Pinner, fetchConfig fetcher.Factory, excluded *Exclusions, root *mfs.Root, bs blockstore.Blockstore) simple.KeyChanFunc {
+	return newKeyProvider(walkPinned(false, pinning, fetchConfig, excluded), walkMFS(root, bs))
+}
+
+func walkPinned(onlyRoots bool, pinning pin.Pinner, fetchConfig fetcher.Factory, excluded *Exclusions) walkFunc {
+	return func(ctx context.Context, set *cidutil.StreamingSet, walked *cid.Set) error {
+		skip, err := excluded.excluded(ctx)
+		if err != nil {
+			return err
+		}
+		visit := set.Visitor(ctx)
+
+		dkeys, err := pinning.DirectKeys(ctx)
+		if err != nil {
+			return err
+		}
+		for _, k := range dkeys {
+			if !skip.Has(k) {
+				visit(k)
+			}
+		}
+
+		rkeys, err := pinning.RecursiveKeys(ctx)
+		if err != nil {
+			return err
+		}
+		session := fetchConfig.NewSession(ctx)
+		for _, k := range rkeys {
+			if skip.Has(k) {
+				continue
+			}
+			visit(k)
+			if onlyRoots {
+				continue
+			}
+			err := fetcherhelpers.BlockAll(ctx, session, cidlink.Link{Cid: k}, func(res fetcher.FetchResult) error {
+				if l, ok := res.LastBlockLink.(cidlink.Link); ok {
+					visit(l.Cid)
+					walked.Add(l.Cid)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			walked.Add(k)
+		}
+		return nil
+	}
+}
+
+func walkMFS(root *mfs.Root, bs blockstore.Blockstore) walkFunc {
+	return func(ctx context.Context, set *cidutil.StreamingSet, walked *cid.Set) error {
+		nd, err := root.GetDirectory().GetNode()
+		if err != nil {
+			return err
+		}
+
+		visit := set.Visitor(ctx)
+		dag := merkledag.NewDAGService(blockservice.New(bs, offline.Exchange(bs)))
+		// only provide the blocks that are stored locally, MFS may reference
+		// content that was never fetched
+		getLinks := func(ctx context.Context, c cid.Cid) ([]*ipld.Link, error) {
+			if c.Type() == cid.Raw {
+				has, err := bs.Has(ctx, c)
+				if err != nil {
+					return nil, err
+				}
+				if has {
+					visit(c)
+				}
+				return nil, nil
+			}
+			links, err := ipld.GetLinks(ctx, dag, c)
+			if err == nil {
+				visit(c)
+			}
+			return links, err
+		}
+		// only skip the subtrees an earlier walk listed entirely, keys merely
+		// listed, like direct pins, may have children left to provide
+		seen := cid.NewSet()
+		walkNode := func(c cid.Cid) bool {
+			return !walked.Has(c) && seen.Visit(c)
+		}
+		return merkledag.Walk(ctx, getLinks, nd.Cid(), walkNode, merkledag.IgnoreMissing())
+	}
+}
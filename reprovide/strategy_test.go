@@ -0,0 +1,146 @@
+package reprovide
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-blockservice"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	bsfetcher "github.com/ipfs/go-fetcher/impl/blockservice"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	"github.com/ipfs/go-ipfs-pinner/dspinner"
+	"github.com/ipfs/go-ipfs-provider/simple"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-mfs"
+	ft "github.com/ipfs/go-unixfs"
+	dagpb "github.com/ipld/go-codec-dagpb"
+)
+
+func collect(t *testing.T, kp simple.KeyChanFunc) *cid.Set {
+	t.Helper()
+	ch, err := kp(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := cid.NewSet()
+	for c := range ch {
+		if !set.Visit(c) {
+			t.Fatalf("%s listed twice", c)
+		}
+	}
+	return set
+}
+
+func TestStrategies(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bs := blockstore.NewBlockstore(dstore)
+	bserv := blockservice.New(bs, offline.Exchange(bs))
+	dserv := merkledag.NewDAGService(bserv)
+
+	add := func(nds ...ipld.Node) {
+		for _, nd := range nds {
+			if err := dserv.Add(ctx, nd); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	file := func(data string) *merkledag.ProtoNode {
+		return merkledag.NodeWithData(ft.FilePBData([]byte(data), uint64(len(data))))
+	}
+	dir := func(children ...ipld.Node) *merkledag.ProtoNode {
+		nd := ft.EmptyDirNode()
+		for i, c := range children {
+			if err := nd.AddNodeLink(string(rune('a'+i)), c); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nd
+	}
+
+	// a pinned directory with a raw leaf, and a pin excluded from providing
+	pinnedLeaf := merkledag.NewRawNode([]byte("pinned"))
+	pinned := dir(pinnedLeaf)
+	excludedLeaf := file("excluded")
+	excluded := dir(excludedLeaf)
+	// a directly pinned directory, whose child is only reachable from MFS
+	directLeaf := file("direct")
+	direct := dir(directLeaf)
+	// MFS holds a file, the pinned directory, and a directory whose child
+	// was never fetched
+	mfsFile := file("mfs")
+	missing := file("missing")
+	partial := dir(missing)
+	add(pinnedLeaf, pinned, excludedLeaf, excluded, directLeaf, direct, mfsFile, partial)
+
+	pinner, err := dspinner.New(ctx, dstore, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, nd := range []ipld.Node{pinned, excluded} {
+		if err := pinner.Pin(ctx, nd, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pinner.Pin(ctx, direct, false); err != nil {
+		t.Fatal(err)
+	}
+	exclusions := NewExclusions(dstore)
+	if err := exclusions.Add(ctx, excluded.Cid()); err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := mfs.NewRoot(ctx, dserv, ft.EmptyDirNode(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, nd := range map[string]ipld.Node{"/file": mfsFile, "/partial": partial, "/pinned": pinned, "/direct": direct} {
+		if err := mfs.PutNode(root, name, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rootNode, err := root.GetDirectory().GetNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fetchConfig := bsfetcher.NewFetcherConfig(bserv)
+	fetchConfig.PrototypeChooser = dagpb.AddSupportToChooser(bsfetcher.DefaultPrototypeChooser)
+
+	expect := func(name string, set *cid.Set, want []ipld.Node, notWant []ipld.Node) {
+		t.Helper()
+		for _, nd := range want {
+			if !set.Has(nd.Cid()) {
+				t.Errorf("%s: expected %s to be provided", name, nd.Cid())
+			}
+		}
+		for _, nd := range notWant {
+			if set.Has(nd.Cid()) {
+				t.Errorf("%s: expected %s not to be provided", name, nd.Cid())
+			}
+		}
+		if set.Len() != len(want) {
+			t.Errorf("%s: expected %d keys, got %d", name, len(want), set.Len())
+		}
+	}
+
+	expect("pinned", collect(t, NewPinnedProvider(false, pinner, fetchConfig, exclusions)),
+		[]ipld.Node{pinned, pinnedLeaf, direct}, []ipld.Node{excluded, excludedLeaf, directLeaf})
+	expect("roots", collect(t, NewPinnedProvider(true, pinner, fetchConfig, exclusions)),
+		[]ipld.Node{pinned, direct}, []ipld.Node{excluded})
+	expect("mfs", collect(t, NewMFSProvider(root, bs)),
+		[]ipld.Node{rootNode, mfsFile, partial, pinned, pinnedLeaf, direct, directLeaf}, []ipld.Node{missing, excluded})
+	expect("pinned+mfs", collect(t, NewPinnedMFSProvider(pinner, fetchConfig, exclusions, root, bs)),
+		[]ipld.Node{pinned, pinnedLeaf, direct, directLeaf, rootNode, mfsFile, partial}, []ipld.Node{missing, excluded})
+
+	// pins are provided again once no longer excluded
+	if err := exclusions.Remove(ctx, excluded.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	expect("pinned", collect(t, NewPinnedProvider(false, pinner, fetchConfig, exclusions)),
+		[]ipld.Node{pinned, pinnedLeaf, direct, excluded, excludedLeaf}, nil)
+}
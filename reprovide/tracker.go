@@ -0,0 +1,386 @@
+// Package reprovide tracks the progress of the reprovide cycles of the
+// provider systems, so it can be reported and resumed after a restart.
+package reprovide
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-ipfs-provider/simple"
+	logging "github.com/ipfs/go-log"
+	irouting "github.com/ipfs/kubo/routing"
+	"github.com/libp2p/go-libp2p-core/routing"
+	"github.com/multiformats/go-multihash"
+)
+
+var log = logging.Logger("reprovide")
+
+var cursorKey = datastore.NewKey("/provider/reprovide/cursor")
+
+// cursorSaveInterval is how often the cursor is persisted while a cycle runs.
+const cursorSaveInterval = 10 * time.Second
+
+// cursor is the persisted state of the reprovide cycles.
+type cursor struct {
+	// Started is when the current cycle started, zero when none is running.
+	Started time.Time
+
+	// Position is the number of keys of the current cycle provided so far.
+	Position uint64 `json:",omitempty"`
+
+	LastKeys     uint64
+	LastDuration time.Duration
+	LastEnd      time.Time
+}
+
+// Progress reports the current and last reprovide cycles.
+type Progress struct {
+	Running bool
+
+	// CycleStarted is when the current cycle started, possibly before a
+	// restart it resumed from.
+	CycleStarted time.Time
+	// Provided is the number of keys of the current cycle provided so far.
+	Provided uint64
+	// Resumed is the number of keys skipped because they were provided
+	// before a restart.
+	Resumed uint64
+	// Total is the number of keys of the current cycle, estimated from the
+	// last cycle until all keys were listed. Zero when unknown.
+	Total         uint64
+	KeysPerSecond float64
+	ETA           time.Duration
+
+	LastCycleKeys     uint64
+	LastCycleDuration time.Duration
+	LastCycleEnd      time.Time
+
+	// Failures is the number of keys that failed to be provided, retries
+	// included.
+	Failures    uint64
+	LastFailure string `json:",omitempty"`
+
+	// Prioritized is the number of new roots provided ahead of a running
+	// cycle.
+	Prioritized uint64
+
+	Interval time.Duration
+}
+
+// Tracker tracks the reprovide cycles, persisting their progress to the
+// datastore.
+type Tracker struct {
+	ds       datastore.Datastore
+	interval time.Duration
+	now      func() time.Time
+
+	mu  sync.Mutex
+	cur cursor
+
+	// resume is the position to resume the next cycle from
+	resume uint64
+
+	cycle      uint64 // incremented with every cycle, to ignore stale streams
+	running    bool
+	listed     bool   // all keys of the running cycle were listed
+	skipped    uint64 // keys skipped when resuming
+	emitted    uint64 // keys handed to the reprovider after the skipped ones
+	localStart time.Time
+	startPos   uint64
+
+	failures    uint64
+	lastFailure string
+	prioritized uint64
+	lastSave    time.Time
+}
+
+// NewTracker returns a tracker for cycles run every interval, loading the
+// cursor of an interrupted cycle from ds.
+func NewTracker(ctx context.Context, ds datastore.Datastore, interval time.Duration) (*Tracker, error) {
+	t := &Tracker{
+		ds:       ds,
+		interval: interval,
+		now:      time.Now,
+	}
+
+	val, err := ds.Get(ctx, cursorKey)
+	switch {
+	case errors.Is(err, datastore.ErrNotFound):
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(val, &t.cur); err != nil {
+			// not worth failing over, the next cycle starts from scratch
+			log.Errorf("could not decode reprovide cursor: %s", err)
+			t.cur = cursor{}
+		}
+	}
+	if !t.cur.Started.IsZero() {
+		t.resume = t.cur.Position
+	}
+	return t, nil
+}
+
+// Keys wraps the key provider of a reprovider, so each call starts a tracked
+// cycle. A cycle interrupted by a restart resumes after the keys it provided,
+// assuming the keys are listed in the same order.
+func (t *Tracker) Keys(keys simple.KeyChanFunc) simple.KeyChanFunc {
+	return func(ctx context.Context) (<-chan cid.Cid, error) {
+		ch, err := keys(ctx)
+		if err != nil {
+			return nil, err
+		}
+		cycle, skip := t.startCycle()
+
+		out := make(chan cid.Cid)
+		go func() {
+			defer close(out)
+
+			var n uint64
+			for c := range ch {
+				n++
+				if n <= skip {
+					continue
+				}
+				// counted first, the key may be provided before the send returns
+				t.emitted1(cycle)
+				select {
+				case out <- c:
+				case <-ctx.Done():
+					t.interrupted(cycle)
+					return
+				}
+			}
+			if ctx.Err() != nil {
+				t.interrupted(cycle)
+				return
+			}
+			t.listedAll(cycle)
+		}()
+		return out, nil
+	}
+}
+
+func (t *Tracker) startCycle() (uint64, uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	if t.running {
+		// the previous cycle was aborted, or some of its keys failed
+		t.finish(now)
+	}
+
+	t.cycle++
+	t.running = true
+	t.listed = false
+	t.emitted = 0
+	t.skipped = t.resume
+	t.resume = 0
+	if t.skipped > 0 {
+		log.Infof("resuming reprovide cycle started at %s after %d keys", t.cur.Started.Format(time.RFC3339), t.skipped)
+	} else {
+		t.cur.Started = now
+		t.cur.Position = 0
+	}
+	t.localStart = now
+	t.startPos = t.cur.Position
+	t.save(now, true)
+	return t.cycle, t.skipped
+}
+
+func (t *Tracker) emitted1(cycle uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if cycle == t.cycle {
+		t.emitted++
+	}
+}
+
+func (t *Tracker) listedAll(cycle uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if cycle != t.cycle || !t.running {
+		return
+	}
+	t.listed = true
+	t.maybeFinish()
+}
+
+// interrupted persists the cursor of a cycle stopped before all its keys were
+// listed, usually on shutdown, so the next cycle resumes from it.
+func (t *Tracker) interrupted(cycle uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if cycle == t.cycle && t.running {
+		t.save(t.now(), true)
+	}
+}
+
+// provided records n keys provided by the reprovider.
+func (t *Tracker) provided(n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.running {
+		return
+	}
+
+	// batches may include keys provided for the first time, never count
+	// more than what the cycle handed out
+	t.cur.Position += uint64(n)
+	if max := t.skipped + t.emitted; t.cur.Position > max {
+		t.cur.Position = max
+	}
+	if !t.maybeFinish() {
+		t.save(t.now(), false)
+	}
+}
+
+// failed records n keys that failed to be provided.
+func (t *Tracker) failed(n int, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.failures += uint64(n)
+	t.lastFailure = err.Error()
+}
+
+// maybeFinish finishes the running cycle if all its keys were provided, t.mu
+// must be held.
+func (t *Tracker) maybeFinish() bool {
+	if !t.listed || t.cur.Position < t.skipped+t.emitted {
+		return false
+	}
+	t.finish(t.now())
+	return true
+}
+
+// finish ends the running cycle, t.mu must be held.
+func (t *Tracker) finish(now time.Time) {
+	t.cur.LastKeys = t.cur.Position
+	t.cur.LastDuration = now.Sub(t.cur.Started)
+	t.cur.LastEnd = now
+	t.cur.Started = time.Time{}
+	t.cur.Position = 0
+	t.running = false
+	t.save(now, true)
+}
+
+// save persists the cursor, at most every cursorSaveInterval unless forced.
+// t.mu must be held.
+func (t *Tracker) save(now time.Time, force bool) {
+	if !force && now.Sub(t.lastSave) < cursorSaveInterval {
+		return
+	}
+	t.lastSave = now
+
+	val, err := json.Marshal(t.cur)
+	if err != nil {
+		log.Errorf("could not encode reprovide cursor: %s", err)
+		return
+	}
+	ctx := context.Background()
+	if err := t.ds.Put(ctx, cursorKey, val); err != nil {
+		log.Errorf("could not store reprovide cursor: %s", err)
+		return
+	}
+	if err := t.ds.Sync(ctx, cursorKey); err != nil {
+		log.Errorf("could not sync reprovide cursor: %s", err)
+	}
+}
+
+// Running returns whether a reprovide cycle is running.
+func (t *Tracker) Running() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.running
+}
+
+// Progress returns the progress of the reprovide cycles.
+func (t *Tracker) Progress() Progress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p := Progress{
+		Running:           t.running,
+		LastCycleKeys:     t.cur.LastKeys,
+		LastCycleDuration: t.cur.LastDuration,
+		LastCycleEnd:      t.cur.LastEnd,
+		Failures:          t.failures,
+		LastFailure:       t.lastFailure,
+		Prioritized:       t.prioritized,
+		Interval:          t.interval,
+	}
+	if !t.running {
+		return p
+	}
+
+	p.CycleStarted = t.cur.Started
+	p.Provided = t.cur.Position
+	p.Resumed = t.skipped
+	if t.listed {
+		p.Total = t.skipped + t.emitted
+	} else if t.cur.LastKeys > t.cur.Position {
+		p.Total = t.cur.LastKeys
+	}
+
+	if elapsed := t.now().Sub(t.localStart); elapsed > 0 {
+		p.KeysPerSecond = float64(t.cur.Position-t.startPos) / elapsed.Seconds()
+	}
+	if p.Total > p.Provided && p.KeysPerSecond > 0 {
+		p.ETA = time.Duration(float64(p.Total-p.Provided) / p.KeysPerSecond * float64(time.Second))
+	}
+	return p
+}
+
+// ContentRouting wraps the router a simple reprovider provides through, to
+// track its progress.
+func (t *Tracker) ContentRouting(r routing.ContentRouting) routing.ContentRouting {
+	return &trackedRouting{ContentRouting: r, tracker: t}
+}
+
+type trackedRouting struct {
+	routing.ContentRouting
+	tracker *Tracker
+}
+
+func (r *trackedRouting) Provide(ctx context.Context, c cid.Cid, announce bool) error {
+	err := r.ContentRouting.Provide(ctx, c, announce)
+	switch {
+	case err == nil:
+		r.tracker.provided(1)
+	case ctx.Err() == nil:
+		r.tracker.failed(1, err)
+	}
+	return err
+}
+
+// ProvideMany wraps the router a batched provider system provides through, to
+// track its progress.
+func (t *Tracker) ProvideMany(pm irouting.ProvideMany) irouting.ProvideMany {
+	return &trackedProvideMany{pm: pm, tracker: t}
+}
+
+type trackedProvideMany struct {
+	pm      irouting.ProvideMany
+	tracker *Tracker
+}
+
+func (pm *trackedProvideMany) ProvideMany(ctx context.Context, keys []multihash.Multihash) error {
+	err := pm.pm.ProvideMany(ctx, keys)
+	switch {
+	case err == nil:
+		pm.tracker.provided(len(keys))
+	case ctx.Err() == nil:
+		pm.tracker.failed(len(keys), err)
+	}
+	return err
+}
+
+func (pm *trackedProvideMany) Ready() bool {
+	return pm.pm.Ready()
+}
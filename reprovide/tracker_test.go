@@ -0,0 +1,187 @@
+package reprovide
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	provider "github.com/ipfs/go-ipfs-provider"
+	"github.com/ipfs/go-ipfs-provider/simple"
+	"github.com/libp2p/go-libp2p-core/routing"
+	"github.com/multiformats/go-multihash"
+)
+
+func testKeys(n int) []cid.Cid {
+	keys := make([]cid.Cid, n)
+	for i := range keys {
+		keys[i] = blocks.NewBlock([]byte{byte(i)}).Cid()
+	}
+	return keys
+}
+
+func keyChan(keys []cid.Cid) simple.KeyChanFunc {
+	return func(ctx context.Context) (<-chan cid.Cid, error) {
+		ch := make(chan cid.Cid)
+		go func() {
+			defer close(ch)
+			for _, k := range keys {
+				select {
+				case ch <- k:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		return ch, nil
+	}
+}
+
+type fakeRouting struct {
+	routing.ContentRouting
+	provided []cid.Cid
+	fail     bool
+}
+
+func (r *fakeRouting) Provide(_ context.Context, c cid.Cid, _ bool) error {
+	if r.fail {
+		return errors.New("no peers")
+	}
+	r.provided = append(r.provided, c)
+	return nil
+}
+
+// reprovide provides the first n keys of a cycle like the simple reprovider,
+// then stops it.
+func reprovide(t *testing.T, tr *Tracker, keys []cid.Cid, r routing.ContentRouting, n int) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch, err := tr.Keys(keyChan(keys))(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cr := tr.ContentRouting(r)
+	for i := 0; i < n; i++ {
+		c, ok := <-ch
+		if !ok {
+			return
+		}
+		_ = cr.Provide(ctx, c, true)
+	}
+	cancel()
+	for range ch {
+	}
+}
+
+func TestTrackerCycle(t *testing.T) {
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	tr, err := NewTracker(context.Background(), dstore, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := testKeys(5)
+	r := &fakeRouting{}
+	reprovide(t, tr, keys, r, len(keys)+1)
+
+	p := tr.Progress()
+	if p.Running || p.LastCycleKeys != 5 || p.LastCycleEnd.IsZero() || len(r.provided) != 5 {
+		t.Fatalf("expected a finished cycle of 5 keys, got %+v", p)
+	}
+
+	r.fail = true
+	reprovide(t, tr, keys, r, 2)
+	p = tr.Progress()
+	if !p.Running || p.Provided != 0 || p.Total != 5 || p.Failures != 2 || p.LastFailure != "no peers" {
+		t.Fatalf("expected a running cycle with failures, got %+v", p)
+	}
+}
+
+func TestTrackerResume(t *testing.T) {
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	keys := testKeys(5)
+
+	tr, err := NewTracker(context.Background(), dstore, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reprovide(t, tr, keys, &fakeRouting{}, 3)
+
+	// a restart resumes after the provided keys
+	tr, err = NewTracker(context.Background(), dstore, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &fakeRouting{}
+	reprovide(t, tr, keys, r, len(keys)+1)
+	if len(r.provided) != 2 || !r.provided[0].Equals(keys[3]) {
+		t.Fatalf("expected the last 2 keys to be provided, got %v", r.provided)
+	}
+	if p := tr.Progress(); p.Running || p.LastCycleKeys != 5 {
+		t.Fatalf("expected the resumed cycle to finish with 5 keys, got %+v", p)
+	}
+
+	// and the next one starts from scratch
+	r = &fakeRouting{}
+	reprovide(t, tr, keys, r, len(keys)+1)
+	if len(r.provided) != 5 {
+		t.Fatalf("expected all keys to be provided, got %d", len(r.provided))
+	}
+}
+
+type fakeSystem struct {
+	provider.System
+	provided chan cid.Cid
+}
+
+func (s *fakeSystem) Provide(c cid.Cid) error {
+	s.provided <- c
+	return nil
+}
+
+type fakeProvideMany chan []multihash.Multihash
+
+func (pm fakeProvideMany) ProvideMany(_ context.Context, keys []multihash.Multihash) error {
+	pm <- keys
+	return nil
+}
+
+func (pm fakeProvideMany) Ready() bool { return true }
+
+func TestPrioritized(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tr, err := NewTracker(ctx, dssync.MutexWrap(ds.NewMapDatastore()), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sys := &fakeSystem{provided: make(chan cid.Cid, 1)}
+	pm := make(fakeProvideMany, 1)
+	p := tr.Prioritized(ctx, sys, pm)
+
+	keys := testKeys(2)
+	if err := p.Provide(keys[0]); err != nil {
+		t.Fatal(err)
+	}
+	if c := <-sys.provided; !c.Equals(keys[0]) {
+		t.Fatal("expected the key to be queued without a running cycle")
+	}
+
+	// start a cycle
+	if _, err := tr.Keys(keyChan(testKeys(10)))(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Provide(keys[1]); err != nil {
+		t.Fatal(err)
+	}
+	if mhs := <-pm; len(mhs) != 1 || string(mhs[0]) != string(keys[1].Hash()) {
+		t.Fatal("expected the key to be provided ahead of the cycle")
+	}
+}
@@ -0,0 +1,471 @@
+// Package scrub checks the hashes of the blocks of a repo in the background,
+// and repairs the corrupted ones from the network.
+package scrub
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	bserv "github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	ipld "github.com/ipfs/go-ipld-format"
+	logging "github.com/ipfs/go-log"
+	dag "github.com/ipfs/go-merkledag"
+	mfs "github.com/ipfs/go-mfs"
+	"github.com/ipfs/kubo/repo"
+)
+
+var log = logging.Logger("scrub")
+
+const (
+	// number of corrupted blocks listed in the status
+	maxBlocks = 100
+	// blocks checked between two saves of the status
+	scrubSaveInterval = 1000
+	// time given to the exchange to refetch a block
+	scrubFetchTimeout = time.Minute
+	// time between two repairs during a pass, the DAGs being walked by each
+	scrubRepairInterval = time.Hour
+)
+
+var (
+	scrubStatusKey     = datastore.NewKey("/local/scrub/status")
+	scrubQuarantineKey = datastore.NewKey("/local/scrub/quarantine")
+)
+
+// Block is a corrupted block found by the scrubber.
+type Block struct {
+	Cid   cid.Cid
+	Found time.Time
+	// Quarantine is the datastore key of the corrupted data. It is removed
+	// once the block is repaired.
+	Quarantine string `json:",omitempty"`
+	Repaired   bool
+	// Error tells why the block was not repaired.
+	Error string `json:",omitempty"`
+}
+
+// Status is the progress and the results of the scrubber.
+type Status struct {
+	// Running is true while a pass over the blocks is in progress.
+	Running bool
+	// Started is the start of the current or of the last pass.
+	Started time.Time
+	// Finished is the end of the last complete pass.
+	Finished time.Time
+	// Checked and CheckedSize count the blocks of the current or of the
+	// last pass.
+	Checked     uint64
+	CheckedSize uint64
+	// Corrupted and Repaired count the blocks of all the passes.
+	Corrupted uint64
+	Repaired  uint64
+	// Blocks are the last corrupted blocks, the most recent last.
+	Blocks []Block
+}
+
+// Fetcher stores the block c, fetched from the network.
+type Fetcher func(ctx context.Context, c cid.Cid) error
+
+// Scrubber checks the hashes of all the blocks of a repo at a limited rate.
+// Corrupted blocks are moved to a quarantine, and the pinned ones and the
+// ones reachable from the MFS root are fetched again through the exchange at
+// the end of the pass, and every hour during a long pass. Blocks left
+// unrepaired are tried again when the scrubber starts.
+type Scrubber struct {
+	ds       repo.Datastore
+	bs       bstore.Blockstore
+	pinning  pin.Pinner
+	files    *mfs.Root
+	interval time.Duration
+	// bytes checked per second
+	rate  uint64
+	fetch Fetcher
+
+	lk     sync.Mutex
+	status Status
+}
+
+// New returns a scrubber of the blocks of d, with the status of its last
+// pass. Corrupted blocks are removed through bs, and repaired with fetch
+// when pinned by pinning or reachable from files. A pass starts every
+// interval, reading rate bytes per second.
+func New(ctx context.Context, d repo.Datastore, bs bstore.Blockstore, pinning pin.Pinner, files *mfs.Root, fetch Fetcher, interval time.Duration, rate uint64) (*Scrubber, error) {
+	status, err := LoadStatus(ctx, d)
+	if err != nil {
+		return nil, err
+	}
+	return &Scrubber{
+		ds:       d,
+		bs:       bs,
+		pinning:  pinning,
+		files:    files,
+		interval: interval,
+		rate:     rate,
+		fetch:    fetch,
+		status:   status,
+	}, nil
+}
+
+// LoadStatus returns the status saved by the last scrubber of the datastore
+// d. Running is set if its pass was interrupted.
+func LoadStatus(ctx context.Context, d datastore.Datastore) (Status, error) {
+	var status Status
+	data, err := d.Get(ctx, scrubStatusKey)
+	if err == datastore.ErrNotFound {
+		return status, nil
+	}
+	if err != nil {
+		return status, err
+	}
+	if err := json.Unmarshal(data, &status); err != nil {
+		return status, fmt.Errorf("invalid scrub status: %w", err)
+	}
+	return status, nil
+}
+
+// Run scrubs the blocks every interval, until ctx is done. An interrupted
+// pass starts over right away.
+func (s *Scrubber) Run(ctx context.Context) {
+	if err := s.retry(ctx); err != nil && ctx.Err() == nil {
+		log.Errorf("cannot repair the quarantined blocks: %s", err)
+	}
+
+	status := s.Status()
+	next := status.Finished.Add(s.interval)
+	if status.Running {
+		next = time.Now()
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+		if err := s.Scrub(ctx); err != nil && ctx.Err() == nil {
+			log.Errorf("scrubbing failed: %s", err)
+		}
+		next = time.Now().Add(s.interval)
+	}
+}
+
+// Status returns the progress and the results of the scrubber.
+func (s *Scrubber) Status() Status {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	status := s.status
+	status.Blocks = append([]Block(nil), s.status.Blocks...)
+	return status
+}
+
+func (s *Scrubber) save(ctx context.Context) error {
+	s.lk.Lock()
+	data, err := json.Marshal(s.status)
+	s.lk.Unlock()
+	if err != nil {
+		return err
+	}
+	return s.ds.Put(ctx, scrubStatusKey, data)
+}
+
+// Scrub checks all the blocks once. Corrupted blocks are repaired at the end
+// of the pass, or when the last repair is scrubRepairInterval old.
+func (s *Scrubber) Scrub(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	s.lk.Lock()
+	s.status.Running = true
+	s.status.Started = time.Now()
+	s.status.Checked = 0
+	s.status.CheckedSize = 0
+	s.lk.Unlock()
+	if err := s.save(ctx); err != nil {
+		return err
+	}
+
+	// blocks are read without the caches and the hash verification of the
+	// node, the data of corrupted blocks is kept
+	bs := bstore.NewBlockstore(s.ds)
+	keys, err := bs.AllKeysChan(ctx)
+	if err != nil {
+		return err
+	}
+
+	// the corrupted blocks found since the last repair
+	var corrupted []cid.Cid
+	lastRepair := time.Now()
+	next := time.Now()
+	for c := range keys {
+		blk, err := bs.Get(ctx, c)
+		if ipld.IsNotFound(err) {
+			// removed since listed
+			continue
+		}
+		if err != nil {
+			log.Errorf("cannot read block %s: %s", c, err)
+			continue
+		}
+		data := blk.RawData()
+
+		if !validBlock(c, data) {
+			if err := s.quarantine(ctx, c, data); err != nil {
+				return err
+			}
+			corrupted = append(corrupted, c)
+		}
+		if len(corrupted) != 0 && time.Since(lastRepair) >= scrubRepairInterval {
+			if err := s.repairFound(ctx, corrupted); err != nil {
+				return err
+			}
+			corrupted = nil
+			lastRepair = time.Now()
+		}
+
+		s.lk.Lock()
+		s.status.Checked++
+		s.status.CheckedSize += uint64(len(data))
+		checked := s.status.Checked
+		s.lk.Unlock()
+		if checked%scrubSaveInterval == 0 {
+			if err := s.save(ctx); err != nil {
+				return err
+			}
+		}
+
+		now := time.Now()
+		if next.Before(now) {
+			next = now
+		}
+		next = next.Add(time.Duration(float64(len(data)) / float64(s.rate) * float64(time.Second)))
+		if wait := time.Until(next); wait > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+		}
+	}
+	// the keys are closed early when ctx is done
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(corrupted) != 0 {
+		if err := s.repairFound(ctx, corrupted); err != nil {
+			return err
+		}
+	}
+
+	s.lk.Lock()
+	s.status.Running = false
+	s.status.Finished = time.Now()
+	s.lk.Unlock()
+	return s.save(ctx)
+}
+
+// repairFound repairs the corrupted blocks found during a pass. The blocks
+// below them may only be reachable once they are repaired, so the earlier
+// unrepaired blocks are tried again.
+func (s *Scrubber) repairFound(ctx context.Context, corrupted []cid.Cid) error {
+	s.repair(ctx, append(corrupted, s.pending()...))
+	return s.save(ctx)
+}
+
+// validBlock returns false if data does not hash to c. Blocks hashed with an
+// unsupported function cannot be checked, and are valid.
+func validBlock(c cid.Cid, data []byte) bool {
+	chk, err := c.Prefix().Sum(data)
+	if err != nil {
+		return true
+	}
+	return bytes.Equal(chk.Hash(), c.Hash())
+}
+
+func quarantineKey(c cid.Cid) datastore.Key {
+	return scrubQuarantineKey.Child(dshelp.MultihashToDsKey(c.Hash()))
+}
+
+// quarantine moves the corrupted data of the block c out of the blockstore.
+func (s *Scrubber) quarantine(ctx context.Context, c cid.Cid, data []byte) error {
+	log.Warnf("block %s is corrupted, moving it to the quarantine", c)
+	key := quarantineKey(c)
+	if err := s.ds.Put(ctx, key, data); err != nil {
+		return err
+	}
+	// the blockstore of the node updates its caches
+	if err := s.bs.DeleteBlock(ctx, c); err != nil {
+		return err
+	}
+
+	s.lk.Lock()
+	s.status.Corrupted++
+	s.status.Blocks = append(s.status.Blocks, Block{
+		Cid:        c,
+		Found:      time.Now(),
+		Quarantine: key.String(),
+	})
+	if len(s.status.Blocks) > maxBlocks {
+		s.status.Blocks = s.status.Blocks[len(s.status.Blocks)-maxBlocks:]
+	}
+	s.lk.Unlock()
+	return s.save(ctx)
+}
+
+// pending returns the corrupted blocks of the status that are not repaired
+// and still in the quarantine.
+func (s *Scrubber) pending() []cid.Cid {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	var pending []cid.Cid
+	for _, b := range s.status.Blocks {
+		if !b.Repaired && b.Quarantine != "" {
+			pending = append(pending, b.Cid)
+		}
+	}
+	return pending
+}
+
+// retry repairs the blocks left unrepaired by the last scrubber of the repo,
+// for instance when the node was stopped during a fetch.
+func (s *Scrubber) retry(ctx context.Context) error {
+	var pending []cid.Cid
+	for _, c := range s.pending() {
+		has, err := s.ds.Has(ctx, quarantineKey(c))
+		if err != nil {
+			return err
+		}
+		if has {
+			pending = append(pending, c)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	s.repair(ctx, pending)
+	return s.save(ctx)
+}
+
+// repair fetches again the corrupted blocks that are pinned or reachable from
+// the MFS root. The blocks below a corrupted block are only found once it is
+// repaired, so the DAGs are walked again as long as blocks are repaired.
+func (s *Scrubber) repair(ctx context.Context, corrupted []cid.Cid) {
+	// by multihash, the codec of a block is unknown to the blockstore
+	missing := make(map[string]cid.Cid, len(corrupted))
+	for _, c := range corrupted {
+		missing[string(c.Hash())] = c
+	}
+
+	for len(missing) != 0 {
+		found, err := s.reachable(ctx, missing)
+		if err != nil {
+			log.Errorf("cannot list the pinned blocks to repair: %s", err)
+			break
+		}
+		var repaired bool
+		for h, c := range found {
+			fctx, cancel := context.WithTimeout(ctx, scrubFetchTimeout)
+			err := s.fetch(fctx, c)
+			cancel()
+			if err == nil {
+				log.Infof("corrupted block %s was repaired", c)
+				repaired = true
+				err = s.ds.Delete(ctx, quarantineKey(c))
+				if err != nil {
+					log.Errorf("cannot remove block %s from the quarantine: %s", c, err)
+				}
+			} else {
+				log.Errorf("cannot repair corrupted block %s: %s", c, err)
+			}
+			s.setResult(c, err)
+			delete(missing, h)
+		}
+		if !repaired {
+			break
+		}
+	}
+
+	for _, c := range missing {
+		s.setResult(c, fmt.Errorf("block is not pinned nor in MFS"))
+	}
+}
+
+// reachable returns the CIDs linking to the multihashes of missing in the
+// pinned DAGs and in MFS.
+func (s *Scrubber) reachable(ctx context.Context, missing map[string]cid.Cid) (map[string]cid.Cid, error) {
+	ng := dag.NewDAGService(bserv.New(s.bs, offline.Exchange(s.bs)))
+
+	found := make(map[string]cid.Cid)
+	visited := cid.NewSet()
+	visit := func(c cid.Cid) bool {
+		if _, ok := missing[string(c.Hash())]; ok {
+			found[string(c.Hash())] = c
+		}
+		return visited.Visit(c)
+	}
+	getLinks := func(ctx context.Context, c cid.Cid) ([]*ipld.Link, error) {
+		links, err := ipld.GetLinks(ctx, ng, c)
+		if err != nil {
+			// corrupted and missing blocks have no links
+			return nil, nil
+		}
+		return links, nil
+	}
+
+	roots, err := s.pinning.RecursiveKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	mfsRoot, err := s.files.GetDirectory().GetNode()
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range append(roots, mfsRoot.Cid()) {
+		if err := dag.Walk(ctx, getLinks, c, visit); err != nil {
+			return nil, err
+		}
+	}
+
+	direct, err := s.pinning.DirectKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range direct {
+		visit(c)
+	}
+	return found, ctx.Err()
+}
+
+// setResult records the result of the repair of the block c.
+func (s *Scrubber) setResult(c cid.Cid, err error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	if err == nil {
+		s.status.Repaired++
+	}
+	for i := len(s.status.Blocks) - 1; i >= 0; i-- {
+		b := &s.status.Blocks[i]
+		if !bytes.Equal(b.Cid.Hash(), c.Hash()) {
+			continue
+		}
+		// the CIDs listed by the blockstore have the raw codec, c is
+		// the one linked to when the block was found in a DAG
+		b.Cid = c
+		if err == nil {
+			b.Repaired = true
+			b.Quarantine = ""
+			b.Error = ""
+		} else {
+			b.Error = err.Error()
+		}
+		return
+	}
+}
@@ -0,0 +1,131 @@
+package scrub
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	bserv "github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	"github.com/ipfs/go-ipfs-pinner/dspinner"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-mfs"
+	ft "github.com/ipfs/go-unixfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScrub(t *testing.T) {
+	ctx := context.Background()
+	d := dssync.MutexWrap(datastore.NewMapDatastore())
+	bs := bstore.NewBlockstore(d)
+	dags := dag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
+	pinning, err := dspinner.New(ctx, d, dags)
+	require.NoError(t, err)
+	files, err := mfs.NewRoot(ctx, dags, ft.EmptyDirNode(), nil)
+	require.NoError(t, err)
+	newScrubber := func() *Scrubber {
+		s, err := New(ctx, d, bs, pinning, files, nil, time.Hour, 1<<30)
+		require.NoError(t, err)
+		return s
+	}
+
+	child := dag.NodeWithData(ft.FilePBData([]byte("child"), 5))
+	root := dag.NodeWithData(ft.FilePBData(nil, 5))
+	require.NoError(t, root.AddNodeLink("child", child))
+	unpinned := dag.NodeWithData(ft.FilePBData([]byte("unpinned"), 8))
+	valid := dag.NodeWithData(ft.FilePBData([]byte("valid"), 5))
+	nodes := []ipld.Node{root, child, unpinned, valid}
+	require.NoError(t, dags.AddMany(ctx, nodes))
+	require.NoError(t, pinning.Pin(ctx, root, true))
+	require.NoError(t, pinning.Flush(ctx))
+
+	blockKey := func(c cid.Cid) datastore.Key {
+		return datastore.NewKey("/blocks").Child(dshelp.MultihashToDsKey(c.Hash()))
+	}
+	for _, nd := range []ipld.Node{root, child, unpinned} {
+		require.NoError(t, d.Put(ctx, blockKey(nd.Cid()), []byte("corrupted")))
+	}
+
+	s := newScrubber()
+	var fetched []cid.Cid
+	s.fetch = func(ctx context.Context, c cid.Cid) error {
+		fetched = append(fetched, c)
+		for _, nd := range nodes {
+			if nd.Cid().Equals(c) {
+				return bs.Put(ctx, nd)
+			}
+		}
+		return ipld.ErrNotFound{Cid: c}
+	}
+	require.NoError(t, s.Scrub(ctx))
+
+	// the child is found once the root is repaired
+	require.Equal(t, []cid.Cid{root.Cid(), child.Cid()}, fetched)
+	for _, nd := range []ipld.Node{root, child, valid} {
+		got, err := bs.Get(ctx, nd.Cid())
+		require.NoError(t, err)
+		require.Equal(t, nd.RawData(), got.RawData())
+	}
+	has, err := bs.Has(ctx, unpinned.Cid())
+	require.NoError(t, err)
+	require.False(t, has)
+	data, err := d.Get(ctx, quarantineKey(unpinned.Cid()))
+	require.NoError(t, err)
+	require.Equal(t, []byte("corrupted"), data)
+	has, err = d.Has(ctx, quarantineKey(root.Cid()))
+	require.NoError(t, err)
+	require.False(t, has)
+
+	status, err := LoadStatus(ctx, d)
+	require.NoError(t, err)
+	require.False(t, status.Running)
+	require.False(t, status.Finished.IsZero())
+	require.Equal(t, uint64(3), status.Corrupted)
+	require.Equal(t, uint64(2), status.Repaired)
+	require.Len(t, status.Blocks, 3)
+	for _, b := range status.Blocks {
+		if bytes.Equal(b.Cid.Hash(), unpinned.Cid().Hash()) {
+			require.False(t, b.Repaired)
+			require.Equal(t, quarantineKey(unpinned.Cid()).String(), b.Quarantine)
+			require.NotEmpty(t, b.Error)
+		} else {
+			require.True(t, b.Repaired)
+			require.Empty(t, b.Quarantine)
+		}
+	}
+
+	// a second pass finds nothing new
+	require.NoError(t, s.Scrub(ctx))
+	status = s.Status()
+	require.Equal(t, uint64(3), status.Corrupted)
+	require.Equal(t, uint64(4), status.Checked)
+
+	// the quarantined block is repaired by the next scrubber once pinned
+	require.NoError(t, pinning.Pin(ctx, unpinned, false))
+	require.NoError(t, pinning.Flush(ctx))
+	require.NoError(t, bs.DeleteBlock(ctx, unpinned.Cid()))
+	s = newScrubber()
+	fetched = nil
+	s.fetch = func(ctx context.Context, c cid.Cid) error {
+		fetched = append(fetched, c)
+		return bs.Put(ctx, unpinned)
+	}
+	require.NoError(t, s.retry(ctx))
+	require.Equal(t, []cid.Cid{unpinned.Cid()}, fetched)
+	has, err = d.Has(ctx, quarantineKey(unpinned.Cid()))
+	require.NoError(t, err)
+	require.False(t, has)
+	status, err = LoadStatus(ctx, d)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), status.Repaired)
+	for _, b := range status.Blocks {
+		require.True(t, b.Repaired)
+	}
+}
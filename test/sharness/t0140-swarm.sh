@@ -145,8 +145,8 @@ test_expect_success "'ipfs swarm peering add' adds a peering" '
 
 test_expect_success 'a peering is added' '
   ipfs swarm peering ls > peeringadd &&
-  test_should_contain ${peeringID} peeringadd &&
-  test_should_contain ${peeringID2} peeringadd
+  test_should_contain "${peeringID} (manual)" peeringadd &&
+  test_should_contain "${peeringID2} (manual)" peeringadd
 '
 
 test_expect_success "'swarm peering rm' removes a peering" '
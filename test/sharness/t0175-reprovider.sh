@@ -104,6 +104,73 @@ test_expect_success 'Stop iptb' '
   iptb stop
 '
 
+# Test 'mfs' strategy
+init_strategy 'mfs'
+
+test_expect_success 'prepare test files' '
+  echo foo > f1 &&
+  echo bar > f2
+'
+
+test_expect_success 'add test objects' '
+  HASH_FOO=$(ipfsi 0 add -q --offline f1) &&
+  HASH_BAR=$(ipfsi 0 add -q --offline --pin=false f2) &&
+  ipfsi 0 files cp /ipfs/$HASH_BAR /bar
+'
+
+findprovs_empty '$HASH_FOO'
+findprovs_empty '$HASH_BAR'
+
+reprovide
+
+findprovs_empty '$HASH_FOO'
+findprovs_expect '$HASH_BAR' '$PEERID_0'
+
+test_expect_success 'Stop iptb' '
+  iptb stop
+'
+
+# Test 'pinned+mfs' strategy, and pins excluded from providing
+init_strategy 'pinned+mfs'
+
+test_expect_success 'prepare test files' '
+  echo foo > f1 &&
+  echo bar > f2 &&
+  echo baz > f3 &&
+  echo qux > f4
+'
+
+test_expect_success 'add test objects' '
+  HASH_FOO=$(ipfsi 0 add -q --offline --pin=false f1) &&
+  HASH_BAR=$(ipfsi 0 add -q --offline f2) &&
+  HASH_BAZ=$(ipfsi 0 add -q --offline --pin=false f3) &&
+  ipfsi 0 files cp /ipfs/$HASH_BAZ /baz &&
+  HASH_QUX=$(ipfsi 0 add -q --offline --pin=false f4) &&
+  ipfsi 0 pin add --no-provide $HASH_QUX
+'
+
+findprovs_empty '$HASH_FOO'
+findprovs_empty '$HASH_BAR'
+findprovs_empty '$HASH_BAZ'
+findprovs_empty '$HASH_QUX'
+
+reprovide
+
+findprovs_empty '$HASH_FOO'
+findprovs_expect '$HASH_BAR' '$PEERID_0'
+findprovs_expect '$HASH_BAZ' '$PEERID_0'
+findprovs_empty '$HASH_QUX'
+
+test_expect_success 'pinning without --no-provide provides again' '
+  ipfsi 0 pin add $HASH_QUX
+'
+
+findprovs_expect '$HASH_QUX' '$PEERID_0'
+
+test_expect_success 'Stop iptb' '
+  iptb stop
+'
+
 # Test reprovider working with ticking disabled
 test_expect_success 'init iptb' '
   iptb testbed create -type localipfs -force -count $NUM_NODES -init
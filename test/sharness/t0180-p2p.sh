@@ -371,6 +371,59 @@ test_expect_success 'C->S Close local listener' '
 
 check_test_ports
 
+test_expect_success 'persist p2p listener and forward' '
+  ipfsi 0 p2p listen --persist /x/p2p-persist /ip4/127.0.0.1/tcp/10101 &&
+  ipfsi 1 p2p forward --persist /x/p2p-persist /ip4/127.0.0.1/tcp/10102 /p2p/$PEERID_0
+'
+
+test_expect_success "'ipfs p2p ls --persisted' lists persisted listeners" '
+  echo "/x/p2p-persist /p2p/$PEERID_0 /ip4/127.0.0.1/tcp/10101" > expected &&
+  ipfsi 0 p2p ls --persisted > actual &&
+  test_cmp expected actual &&
+  echo "/x/p2p-persist /ip4/127.0.0.1/tcp/10102 /p2p/$PEERID_0" > expected &&
+  ipfsi 1 p2p ls --persisted > actual &&
+  test_cmp expected actual
+'
+
+test_expect_success 'restart nodes with persisted p2p config' '
+  iptb stop 0 && iptb stop 1 &&
+  iptb start -wait 0 && iptb start -wait 1 &&
+  iptb connect 0 1
+'
+
+test_expect_success 'persisted p2p listeners are registered on startup' '
+  echo "/x/p2p-persist /p2p/$PEERID_0 /ip4/127.0.0.1/tcp/10101" > expected &&
+  ipfsi 0 p2p ls > actual &&
+  test_cmp expected actual &&
+  echo "/x/p2p-persist /ip4/127.0.0.1/tcp/10102 /p2p/$PEERID_0" > expected &&
+  ipfsi 1 p2p ls > actual &&
+  test_cmp expected actual
+'
+
+spawn_sending_server
+
+test_server_to_client
+
+test_expect_success "'ipfs p2p close --persist' removes persisted listeners" '
+  ipfsi 0 p2p close --persist -p /x/p2p-persist &&
+  ipfsi 1 p2p close --persist -p /x/p2p-persist &&
+  ipfsi 0 p2p ls --persisted > actual &&
+  test_must_be_empty actual &&
+  ipfsi 1 p2p ls --persisted > actual &&
+  test_must_be_empty actual
+'
+
+test_expect_success 'daemon refuses invalid persisted p2p config' '
+  iptb stop 2 &&
+  ipfsi 2 config --json P2P.Listeners "[{\"Protocol\": \"/not-x\", \"TargetAddress\": \"/ip4/127.0.0.1/tcp/10101\"}]" &&
+  test_must_fail ipfsi 2 daemon > daemon_out 2> daemon_err &&
+  grep "invalid P2P.Listeners entry 0" daemon_err &&
+  ipfsi 2 config --json P2P.Listeners "[]" &&
+  iptb start -wait 2
+'
+
+check_test_ports
+
 test_expect_success 'stop iptb' '
   iptb stop
 '